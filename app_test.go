@@ -10,8 +10,8 @@ import (
 )
 
 func TestAppStartupSetsServiceContexts(t *testing.T) {
-	networkService := network.NewNetworkService()
 	cryptoService := crypto.NewCryptoService()
+	networkService := network.NewNetworkService(cryptoService)
 	otherService := other.NewOtherService(cryptoService)
 	app := NewApp(networkService, cryptoService, otherService)
 
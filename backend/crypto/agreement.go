@@ -0,0 +1,402 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/sm3"
+	"golang.org/x/crypto/hkdf"
+)
+
+const defaultSM2UID = "1234567812345678"
+
+// agreementKDF turns a raw shared secret into keying material for the
+// derive/exchange operations.
+type agreementKDF struct {
+	name    string
+	kind    string // none, x963, hkdf, concat
+	newHash func() hash.Hash
+}
+
+// resolveAgreementKDF parses names such as "none", "x963-sha256", "hkdf-sha384",
+// "concat-sha256" or "sm3". A bare hash name selects X9.63 with that hash.
+func resolveAgreementKDF(name, fallback string) (agreementKDF, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = fallback
+	}
+	switch name {
+	case "none", "raw":
+		return agreementKDF{name: "none", kind: "none"}, nil
+	case "sm3", "sm3-kdf":
+		return agreementKDF{name: "sm3-kdf", kind: "x963", newHash: sm3.New}, nil
+	}
+	kind, hashName := "x963", name
+	if prefix, rest, ok := strings.Cut(name, "-"); ok {
+		switch prefix {
+		case "x963", "hkdf", "concat":
+			kind, hashName = prefix, rest
+		}
+	}
+	newHash, err := kdfHashFunc(hashName)
+	if err != nil {
+		return agreementKDF{}, err
+	}
+	return agreementKDF{name: kind + "-" + hashName, kind: kind, newHash: newHash}, nil
+}
+
+func kdfHashFunc(name string) (func() hash.Hash, error) {
+	if name == "sm3" {
+		return sm3.New, nil
+	}
+	h, err := digestHash(name, "sha256")
+	if err != nil {
+		return nil, err
+	}
+	return h.newHash, nil
+}
+
+// derive applies the KDF. length <= 0 keeps the raw secret size for "none"
+// and selects 32 bytes otherwise.
+func (k agreementKDF) derive(secret []byte, length int, sharedInfo, salt []byte) ([]byte, error) {
+	if k.kind == "none" {
+		return secret, nil
+	}
+	if length <= 0 {
+		length = 32
+	}
+	switch k.kind {
+	case "hkdf":
+		out := make([]byte, length)
+		if _, err := hkdf.New(k.newHash, secret, salt, sharedInfo).Read(out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	case "concat":
+		// NIST SP 800-56A single-step KDF: H(counter || Z || OtherInfo).
+		return counterHashKDF(k.newHash, length, func(h hash.Hash, counter []byte) {
+			h.Write(counter)
+			h.Write(secret)
+			h.Write(sharedInfo)
+		}), nil
+	default:
+		// ANSI X9.63 / GM/T 0003 KDF: H(Z || counter || SharedInfo).
+		return counterHashKDF(k.newHash, length, func(h hash.Hash, counter []byte) {
+			h.Write(secret)
+			h.Write(counter)
+			h.Write(sharedInfo)
+		}), nil
+	}
+}
+
+func counterHashKDF(newHash func() hash.Hash, length int, write func(h hash.Hash, counter []byte)) []byte {
+	out := make([]byte, 0, length+64)
+	var counter [4]byte
+	for i := uint32(1); len(out) < length; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		h := newHash()
+		write(h, counter[:])
+		out = h.Sum(out)
+	}
+	return out[:length]
+}
+
+// deriveECDH runs ECDH between a private key and the peer public key on any
+// registered curve and passes the x-coordinate through the selected KDF.
+func (c *CryptoService) deriveECDH(req AsymmetricRequest, priv *ecdsa.PrivateKey, loadPeer func(keyMaterial) (*ecdsa.PublicKey, error)) (OperationResult, error) {
+	peer, err := c.resolveECPeer(req, priv.Curve, loadPeer)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	secret, err := ecdhSharedSecret(priv, peer)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	kdf, err := resolveAgreementKDF(req.KDF, "none")
+	if err != nil {
+		return OperationResult{}, err
+	}
+	derived, err := deriveAgreementKey(kdf, secret, req)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	curve := priv.Curve.Params().Name
+	if info, ok := describeCurve(priv.Curve); ok {
+		curve = info.Display
+	}
+	details := map[string]string{
+		"base64": encodeBase64(derived),
+		"curve":  curve,
+		"kdf":    kdf.name,
+		"length": fmt.Sprintf("%d", len(derived)),
+	}
+	if kdf.kind != "none" {
+		details["sharedSecret"] = strings.ToUpper(hex.EncodeToString(secret))
+	}
+	return OperationResult{
+		Output:  encodeOutputBytes(derived, normalizeOutputFormat(req.OutputFormat)),
+		Details: details,
+	}, nil
+}
+
+func ecdhSharedSecret(priv *ecdsa.PrivateKey, peer *ecdsa.PublicKey) ([]byte, error) {
+	if !priv.Curve.IsOnCurve(peer.X, peer.Y) {
+		return nil, errors.New("peer public key is not on the private key's curve")
+	}
+	if key, err := priv.ECDH(); err == nil {
+		if pub, err := peer.ECDH(); err == nil {
+			return key.ECDH(pub)
+		}
+	}
+	size := (priv.Curve.Params().BitSize + 7) / 8
+	x, y := priv.Curve.ScalarMult(peer.X, peer.Y, priv.D.FillBytes(make([]byte, (priv.Curve.Params().N.BitLen()+7)/8)))
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, errors.New("ECDH result is the point at infinity")
+	}
+	return x.FillBytes(make([]byte, size)), nil
+}
+
+// resolveECPeer loads the peer public key from PeerKeyID, a PEM in
+// PeerKeyData or a hex encoded point (04||X||Y or X||Y).
+func (c *CryptoService) resolveECPeer(req AsymmetricRequest, curve elliptic.Curve, loadPeer func(keyMaterial) (*ecdsa.PublicKey, error)) (*ecdsa.PublicKey, error) {
+	if strings.TrimSpace(req.PeerKeyID) != "" {
+		key, err := c.findKey(req.PeerKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return loadPeer(keyMaterial{stored: key, privatePEM: key.PrivatePEM, publicPEM: key.PublicPEM})
+	}
+	data := strings.TrimSpace(req.PeerKeyData)
+	if data == "" {
+		return nil, errors.New("peer public key required")
+	}
+	if strings.Contains(data, "BEGIN") {
+		return loadPeer(keyMaterial{publicPEM: data})
+	}
+	raw, err := decodeData(firstNonEmpty(req.KeyFormat, "hex"), data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeECPublicPoint(curve, raw)
+}
+
+func decodeECPublicPoint(curve elliptic.Curve, raw []byte) (*ecdsa.PublicKey, error) {
+	size := (curve.Params().BitSize + 7) / 8
+	if len(raw) == 2*size {
+		raw = append([]byte{0x04}, raw...)
+	}
+	x, y, err := decodeECPoint(curve, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func deriveAgreementKey(kdf agreementKDF, secret []byte, req AsymmetricRequest) ([]byte, error) {
+	sharedInfo, err := decodeOptionalHex(req.SharedInfo, "shared info")
+	if err != nil {
+		return nil, err
+	}
+	salt, err := decodeOptionalHex(req.Salt, "salt")
+	if err != nil {
+		return nil, err
+	}
+	return kdf.derive(secret, req.KeyLength, sharedInfo, salt)
+}
+
+func decodeOptionalHex(value, name string) ([]byte, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), " ", "")
+	if value == "" {
+		return nil, nil
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be hex encoded", name)
+	}
+	return decoded, nil
+}
+
+// runSM2Exchange implements the GM/T 0003.3 key exchange for one party.
+//
+// The initiator first calls without PeerEphemeral to obtain R_A (and the
+// generated r_A), then again with r_A and the responder's R_B. The responder
+// answers R_A in a single call. Both sides report the confirmation values:
+// the initiator sends S_A and checks S_1 against S_B, the responder sends S_B
+// and checks S_2 against S_A.
+func (c *CryptoService) runSM2Exchange(req AsymmetricRequest, mat keyMaterial) (OperationResult, error) {
+	priv, err := parseSM2Private(mat.privatePEM)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	curve := priv.Curve
+	n := curve.Params().N
+	size := (curve.Params().BitSize + 7) / 8
+	role := strings.ToLower(strings.TrimSpace(req.Role))
+	if role == "" {
+		role = "initiator"
+	}
+	if role != "initiator" && role != "responder" {
+		return OperationResult{}, fmt.Errorf("unsupported SM2 exchange role: %s", req.Role)
+	}
+
+	details := map[string]string{"role": role}
+	var r *big.Int
+	if strings.TrimSpace(req.EphemeralKey) != "" {
+		raw, err := decodeOptionalHex(req.EphemeralKey, "ephemeral key")
+		if err != nil {
+			return OperationResult{}, err
+		}
+		r = new(big.Int).SetBytes(raw)
+		if r.Sign() <= 0 || r.Cmp(n) >= 0 {
+			return OperationResult{}, errors.New("ephemeral private key out of range")
+		}
+	} else {
+		ephemeral, err := sm2.GenerateKey(rand.Reader)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		r = ephemeral.D
+		details["ephemeralPrivate"] = strings.ToUpper(hex.EncodeToString(r.FillBytes(make([]byte, size))))
+	}
+	rx, ry := curve.ScalarBaseMult(r.FillBytes(make([]byte, size)))
+	ownR := encodeECPoint(curve, rx, ry)
+	details["ephemeralPublic"] = strings.ToUpper(hex.EncodeToString(ownR))
+
+	if strings.TrimSpace(req.PeerEphemeral) == "" {
+		if role == "responder" {
+			return OperationResult{}, errors.New("responder needs the initiator's ephemeral public key")
+		}
+		details["step"] = "A1-A3"
+		return OperationResult{
+			Output:  encodeOutputBytes(ownR, normalizeOutputFormat(req.OutputFormat)),
+			Details: details,
+		}, nil
+	}
+	peerRRaw, err := decodeOptionalHex(req.PeerEphemeral, "peer ephemeral key")
+	if err != nil {
+		return OperationResult{}, err
+	}
+	peerR, err := decodeECPublicPoint(curve, peerRRaw)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid peer ephemeral key: %w", err)
+	}
+	peerPub, err := c.resolveECPeer(req, curve, ensureSM2Public)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	if !curve.IsOnCurve(peerPub.X, peerPub.Y) {
+		return OperationResult{}, errors.New("peer public key is not on the SM2 curve")
+	}
+
+	// x̄ = 2^w + (x & (2^w - 1)) with w = ceil(ceil(log2 n)/2) - 1.
+	w := uint((n.BitLen()+1)/2 - 1)
+	twoW := new(big.Int).Lsh(big.NewInt(1), w)
+	mask := new(big.Int).Sub(twoW, big.NewInt(1))
+	avf := func(x *big.Int) *big.Int {
+		return new(big.Int).Add(twoW, new(big.Int).And(x, mask))
+	}
+	t := new(big.Int).Mul(avf(rx), r)
+	t.Add(t, priv.D)
+	t.Mod(t, n)
+	px, py := curve.ScalarMult(peerR.X, peerR.Y, avf(peerR.X).Bytes())
+	px, py = curve.Add(peerPub.X, peerPub.Y, px, py)
+	vx, vy := curve.ScalarMult(px, py, t.Bytes())
+	if vx.Sign() == 0 && vy.Sign() == 0 {
+		return OperationResult{}, errors.New("SM2 key exchange failed: shared point is at infinity")
+	}
+
+	uid := []byte(firstNonEmpty(req.UID, defaultSM2UID))
+	peerUID := []byte(firstNonEmpty(req.PeerUID, defaultSM2UID))
+	ownZ, err := sm2.CalculateZA(&priv.PublicKey, uid)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	peerZ, err := sm2.CalculateZA(peerPub, peerUID)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	zA, zB := ownZ, peerZ
+	r1, r2 := ownR[1:], peerRRaw
+	if len(r2) == 2*size+1 {
+		r2 = r2[1:]
+	}
+	if role == "responder" {
+		zA, zB = peerZ, ownZ
+		r1, r2 = r2, r1
+	}
+	xV := vx.FillBytes(make([]byte, size))
+	yV := vy.FillBytes(make([]byte, size))
+
+	kdf, err := resolveAgreementKDF(req.KDF, "sm3")
+	if err != nil {
+		return OperationResult{}, err
+	}
+	if req.KeyLength <= 0 {
+		req.KeyLength = 16
+	}
+	key, err := deriveAgreementKey(kdf, concatBytes(xV, yV, zA, zB), req)
+	if err != nil {
+		return OperationResult{}, err
+	}
+
+	inner := sm3Sum(xV, zA, zB, r1, r2)
+	s02 := sm3Sum([]byte{0x02}, yV, inner)
+	s03 := sm3Sum([]byte{0x03}, yV, inner)
+	expect := s02
+	if role == "initiator" {
+		details["sA"] = strings.ToUpper(hex.EncodeToString(s03))
+		details["s1"] = strings.ToUpper(hex.EncodeToString(s02))
+	} else {
+		expect = s03
+		details["sB"] = strings.ToUpper(hex.EncodeToString(s02))
+		details["s2"] = strings.ToUpper(hex.EncodeToString(s03))
+	}
+	details["zA"] = strings.ToUpper(hex.EncodeToString(zA))
+	details["zB"] = strings.ToUpper(hex.EncodeToString(zB))
+	details["kdf"] = kdf.name
+	details["length"] = fmt.Sprintf("%d", len(key))
+	details["base64"] = encodeBase64(key)
+
+	result := OperationResult{
+		Output:  encodeOutputBytes(key, normalizeOutputFormat(req.OutputFormat)),
+		Details: details,
+	}
+	if confirmation := strings.TrimSpace(req.PeerConfirm); confirmation != "" {
+		peerS, err := decodeOptionalHex(confirmation, "peer confirmation")
+		if err != nil {
+			return OperationResult{}, err
+		}
+		result.Verified = subtle.ConstantTimeCompare(peerS, expect) == 1
+		details["confirmation"] = "mismatch"
+		if result.Verified {
+			details["confirmation"] = "ok"
+		}
+	}
+	return result, nil
+}
+
+func sm3Sum(parts ...[]byte) []byte {
+	h := sm3.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
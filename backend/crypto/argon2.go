@@ -0,0 +1,245 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Argon2 version 1.3 (RFC 9106). golang.org/x/crypto/argon2 only exposes
+// Argon2i and Argon2id without the secret and associated data inputs, so the
+// three variants are implemented here with all parameters.
+
+const (
+	argon2d  = 0
+	argon2i  = 1
+	argon2id = 2
+
+	argon2Version    = 0x13
+	argon2BlockWords = 128
+	argon2SyncPoints = 4
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+// argon2Key derives keyLen bytes. memory is in KiB; H0 commits to it as
+// given while the matrix is rounded down to a multiple of 4*threads blocks,
+// with a minimum of 8*threads.
+func argon2Key(mode int, password, salt, secret, data []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	lanes := uint32(threads)
+	h0 := argon2InitHash(mode, password, salt, secret, data, time, memory, lanes, keyLen)
+	memory = memory / (argon2SyncPoints * lanes) * (argon2SyncPoints * lanes)
+	if memory < 2*argon2SyncPoints*lanes {
+		memory = 2 * argon2SyncPoints * lanes
+	}
+	blocks := argon2InitBlocks(&h0, memory, lanes)
+	argon2Process(mode, blocks, time, memory, lanes)
+	return argon2Extract(blocks, memory, lanes, keyLen)
+}
+
+func argon2InitHash(mode int, password, salt, secret, data []byte, time, memory, lanes, keyLen uint32) [blake2b.Size + 8]byte {
+	var h0 [blake2b.Size + 8]byte
+	var params [24]byte
+	var tmp [4]byte
+	b2, _ := blake2b.New512(nil)
+	binary.LittleEndian.PutUint32(params[0:4], lanes)
+	binary.LittleEndian.PutUint32(params[4:8], keyLen)
+	binary.LittleEndian.PutUint32(params[8:12], memory)
+	binary.LittleEndian.PutUint32(params[12:16], time)
+	binary.LittleEndian.PutUint32(params[16:20], argon2Version)
+	binary.LittleEndian.PutUint32(params[20:24], uint32(mode))
+	b2.Write(params[:])
+	for _, field := range [][]byte{password, salt, secret, data} {
+		binary.LittleEndian.PutUint32(tmp[:], uint32(len(field)))
+		b2.Write(tmp[:])
+		b2.Write(field)
+	}
+	b2.Sum(h0[:0])
+	return h0
+}
+
+func argon2InitBlocks(h0 *[blake2b.Size + 8]byte, memory, lanes uint32) []argon2Block {
+	var buf [1024]byte
+	blocks := make([]argon2Block, memory)
+	laneLen := memory / lanes
+	for lane := uint32(0); lane < lanes; lane++ {
+		j := lane * laneLen
+		binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(h0[blake2b.Size:], i)
+			argon2Hash(buf[:], h0[:])
+			for k := range blocks[j+i] {
+				blocks[j+i][k] = binary.LittleEndian.Uint64(buf[k*8:])
+			}
+		}
+	}
+	return blocks
+}
+
+func argon2Process(mode int, blocks []argon2Block, time, memory, lanes uint32) {
+	laneLen := memory / lanes
+	segLen := laneLen / argon2SyncPoints
+	for n := uint32(0); n < time; n++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			for lane := uint32(0); lane < lanes; lane++ {
+				var addresses, in, zero argon2Block
+				independent := mode == argon2i || (mode == argon2id && n == 0 && slice < argon2SyncPoints/2)
+				if independent {
+					in[0], in[1], in[2] = uint64(n), uint64(lane), uint64(slice)
+					in[3], in[4], in[5] = uint64(memory), uint64(time), uint64(mode)
+				}
+				index := uint32(0)
+				if n == 0 && slice == 0 {
+					index = 2 // the first two blocks of each lane come from H0
+					if independent {
+						in[6]++
+						argon2Compress(&addresses, &in, &zero, false)
+						argon2Compress(&addresses, &addresses, &zero, false)
+					}
+				}
+				offset := lane*laneLen + slice*segLen + index
+				for ; index < segLen; index, offset = index+1, offset+1 {
+					prev := offset - 1
+					if index == 0 && slice == 0 {
+						prev += laneLen
+					}
+					var random uint64
+					if independent {
+						if index%argon2BlockWords == 0 {
+							in[6]++
+							argon2Compress(&addresses, &in, &zero, false)
+							argon2Compress(&addresses, &addresses, &zero, false)
+						}
+						random = addresses[index%argon2BlockWords]
+					} else {
+						random = blocks[prev][0]
+					}
+					ref := argon2RefIndex(random, laneLen, segLen, lanes, n, slice, lane, index)
+					argon2Compress(&blocks[offset], &blocks[prev], &blocks[ref], true)
+				}
+			}
+		}
+	}
+}
+
+// argon2RefIndex maps the pseudo-random value onto the reference block
+// (RFC 9106 section 3.4.1.2).
+func argon2RefIndex(random uint64, laneLen, segLen, lanes, n, slice, lane, index uint32) uint32 {
+	refLane := uint32(random>>32) % lanes
+	if n == 0 && slice == 0 {
+		refLane = lane
+	}
+	area, start := 3*segLen, ((slice+1)%argon2SyncPoints)*segLen
+	if lane == refLane {
+		area += index
+	}
+	if n == 0 {
+		area, start = slice*segLen, 0
+		if slice == 0 || lane == refLane {
+			area += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		area--
+	}
+	x := random & 0xFFFFFFFF
+	x = x * x >> 32
+	x = x * uint64(area) >> 32
+	return refLane*laneLen + uint32((uint64(start)+uint64(area)-(x+1))%uint64(laneLen))
+}
+
+func argon2Extract(blocks []argon2Block, memory, lanes, keyLen uint32) []byte {
+	laneLen := memory / lanes
+	final := blocks[laneLen-1]
+	for lane := uint32(1); lane < lanes; lane++ {
+		last := &blocks[lane*laneLen+laneLen-1]
+		for i := range final {
+			final[i] ^= last[i]
+		}
+	}
+	var buf [1024]byte
+	for i, v := range final {
+		binary.LittleEndian.PutUint64(buf[i*8:], v)
+	}
+	out := make([]byte, keyLen)
+	argon2Hash(out, buf[:])
+	return out
+}
+
+// argon2Compress is the compression function G. With xor set the result is
+// XORed into out, as version 1.3 does when overwriting blocks.
+func argon2Compress(out, in1, in2 *argon2Block, xor bool) {
+	var t argon2Block
+	for i := range t {
+		t[i] = in1[i] ^ in2[i]
+	}
+	for i := 0; i < argon2BlockWords; i += 16 {
+		argon2Permute(&t[i], &t[i+1], &t[i+2], &t[i+3], &t[i+4], &t[i+5], &t[i+6], &t[i+7],
+			&t[i+8], &t[i+9], &t[i+10], &t[i+11], &t[i+12], &t[i+13], &t[i+14], &t[i+15])
+	}
+	for i := 0; i < argon2BlockWords/8; i += 2 {
+		argon2Permute(&t[i], &t[i+1], &t[16+i], &t[16+i+1], &t[32+i], &t[32+i+1], &t[48+i], &t[48+i+1],
+			&t[64+i], &t[64+i+1], &t[80+i], &t[80+i+1], &t[96+i], &t[96+i+1], &t[112+i], &t[112+i+1])
+	}
+	for i := range t {
+		v := t[i] ^ in1[i] ^ in2[i]
+		if xor {
+			out[i] ^= v
+		} else {
+			out[i] = v
+		}
+	}
+}
+
+func argon2Permute(t00, t01, t02, t03, t04, t05, t06, t07, t08, t09, t10, t11, t12, t13, t14, t15 *uint64) {
+	argon2GB(t00, t04, t08, t12)
+	argon2GB(t01, t05, t09, t13)
+	argon2GB(t02, t06, t10, t14)
+	argon2GB(t03, t07, t11, t15)
+	argon2GB(t00, t05, t10, t15)
+	argon2GB(t01, t06, t11, t12)
+	argon2GB(t02, t07, t08, t13)
+	argon2GB(t03, t04, t09, t14)
+}
+
+// argon2GB is the BlaMka variant of the BLAKE2b round function, which adds
+// 2*lo(a)*lo(b) to each addition.
+func argon2GB(a, b, c, d *uint64) {
+	va, vb, vc, vd := *a, *b, *c, *d
+	va += vb + 2*uint64(uint32(va))*uint64(uint32(vb))
+	vd = bits.RotateLeft64(vd^va, -32)
+	vc += vd + 2*uint64(uint32(vc))*uint64(uint32(vd))
+	vb = bits.RotateLeft64(vb^vc, -24)
+	va += vb + 2*uint64(uint32(va))*uint64(uint32(vb))
+	vd = bits.RotateLeft64(vd^va, -16)
+	vc += vd + 2*uint64(uint32(vc))*uint64(uint32(vd))
+	vb = bits.RotateLeft64(vb^vc, -63)
+	*a, *b, *c, *d = va, vb, vc, vd
+}
+
+// argon2Hash is the variable-length hash H' built on BLAKE2b.
+func argon2Hash(out, in []byte) {
+	var prefix [4]byte
+	binary.LittleEndian.PutUint32(prefix[:], uint32(len(out)))
+	if len(out) <= blake2b.Size {
+		b2, _ := blake2b.New(len(out), nil)
+		b2.Write(prefix[:])
+		b2.Write(in)
+		b2.Sum(out[:0])
+		return
+	}
+	b2, _ := blake2b.New512(nil)
+	b2.Write(prefix[:])
+	b2.Write(in)
+	var v [blake2b.Size]byte
+	b2.Sum(v[:0])
+	pos := copy(out, v[:32])
+	for len(out)-pos > blake2b.Size {
+		v = blake2b.Sum512(v[:])
+		pos += copy(out[pos:], v[:32])
+	}
+	b2, _ = blake2b.New(len(out)-pos, nil)
+	b2.Write(v[:])
+	b2.Sum(out[pos:pos])
+}
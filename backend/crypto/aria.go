@@ -0,0 +1,189 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"strconv"
+)
+
+// ARIA block cipher (RFC 5794, KS X 1213), the Korean standard cipher used by
+// the payment and TLS profiles there. It is an involutional SPN with 12, 14
+// or 16 rounds for 128, 192 and 256-bit keys.
+//
+// SB1 is the AES S-box and SB3 its inverse; SB2 is tabulated and SB4 is its
+// inverse.
+
+var ariaSB2 = [256]byte{
+	0xe2, 0x4e, 0x54, 0xfc, 0x94, 0xc2, 0x4a, 0xcc, 0x62, 0x0d, 0x6a, 0x46, 0x3c, 0x4d, 0x8b, 0xd1,
+	0x5e, 0xfa, 0x64, 0xcb, 0xb4, 0x97, 0xbe, 0x2b, 0xbc, 0x77, 0x2e, 0x03, 0xd3, 0x19, 0x59, 0xc1,
+	0x1d, 0x06, 0x41, 0x6b, 0x55, 0xf0, 0x99, 0x69, 0xea, 0x9c, 0x18, 0xae, 0x63, 0xdf, 0xe7, 0xbb,
+	0x00, 0x73, 0x66, 0xfb, 0x96, 0x4c, 0x85, 0xe4, 0x3a, 0x09, 0x45, 0xaa, 0x0f, 0xee, 0x10, 0xeb,
+	0x2d, 0x7f, 0xf4, 0x29, 0xac, 0xcf, 0xad, 0x91, 0x8d, 0x78, 0xc8, 0x95, 0xf9, 0x2f, 0xce, 0xcd,
+	0x08, 0x7a, 0x88, 0x38, 0x5c, 0x83, 0x2a, 0x28, 0x47, 0xdb, 0xb8, 0xc7, 0x93, 0xa4, 0x12, 0x53,
+	0xff, 0x87, 0x0e, 0x31, 0x36, 0x21, 0x58, 0x48, 0x01, 0x8e, 0x37, 0x74, 0x32, 0xca, 0xe9, 0xb1,
+	0xb7, 0xab, 0x0c, 0xd7, 0xc4, 0x56, 0x42, 0x26, 0x07, 0x98, 0x60, 0xd9, 0xb6, 0xb9, 0x11, 0x40,
+	0xec, 0x20, 0x8c, 0xbd, 0xa0, 0xc9, 0x84, 0x04, 0x49, 0x23, 0xf1, 0x4f, 0x50, 0x1f, 0x13, 0xdc,
+	0xd8, 0xc0, 0x9e, 0x57, 0xe3, 0xc3, 0x7b, 0x65, 0x3b, 0x02, 0x8f, 0x3e, 0xe8, 0x25, 0x92, 0xe5,
+	0x15, 0xdd, 0xfd, 0x17, 0xa9, 0xbf, 0xd4, 0x9a, 0x7e, 0xc5, 0x39, 0x67, 0xfe, 0x76, 0x9d, 0x43,
+	0xa7, 0xe1, 0xd0, 0xf5, 0x68, 0xf2, 0x1b, 0x34, 0x70, 0x05, 0xa3, 0x8a, 0xd5, 0x79, 0x86, 0xa8,
+	0x30, 0xc6, 0x51, 0x4b, 0x1e, 0xa6, 0x27, 0xf6, 0x35, 0xd2, 0x6e, 0x24, 0x16, 0x82, 0x5f, 0xda,
+	0xe6, 0x75, 0xa2, 0xef, 0x2c, 0xb2, 0x1c, 0x9f, 0x5d, 0x6f, 0x80, 0x0a, 0x72, 0x44, 0x9b, 0x6c,
+	0x90, 0x0b, 0x5b, 0x33, 0x7d, 0x5a, 0x52, 0xf3, 0x61, 0xa1, 0xf7, 0xb0, 0xd6, 0x3f, 0x7c, 0x6d,
+	0xed, 0x14, 0xe0, 0xa5, 0x3d, 0x22, 0xb3, 0xf8, 0x89, 0xde, 0x71, 0x1a, 0xaf, 0xba, 0xb5, 0x81,
+}
+
+var ariaSB1, ariaSB3, ariaSB4 = ariaSBoxes()
+
+func ariaSBoxes() (sb1, sb3, sb4 [256]byte) {
+	for x := 0; x < 256; x++ {
+		// Multiplicative inverse in GF(2^8), then the AES affine map.
+		var inv byte
+		for y := 1; x != 0 && y < 256; y++ {
+			if gfMulSlow(byte(x), byte(y)) == 1 {
+				inv = byte(y)
+				break
+			}
+		}
+		s := inv ^ rotl8(inv, 1) ^ rotl8(inv, 2) ^ rotl8(inv, 3) ^ rotl8(inv, 4) ^ 0x63
+		sb1[x] = s
+		sb3[s] = byte(x)
+		sb4[ariaSB2[x]] = byte(x)
+	}
+	return sb1, sb3, sb4
+}
+
+func rotl8(b byte, n uint) byte { return b<<n | b>>(8-n) }
+
+var ariaC = [3][16]byte{
+	{0x51, 0x7c, 0xc1, 0xb7, 0x27, 0x22, 0x0a, 0x94, 0xfe, 0x13, 0xab, 0xe8, 0xfa, 0x9a, 0x6e, 0xe0},
+	{0x6d, 0xb1, 0x4a, 0xcc, 0x9e, 0x21, 0xc8, 0x20, 0xff, 0x28, 0xb1, 0xd5, 0xef, 0x5d, 0xe2, 0xb0},
+	{0xdb, 0x92, 0x37, 0x1d, 0x21, 0x26, 0xe9, 0x70, 0x03, 0x24, 0x97, 0x75, 0x04, 0xe8, 0xc9, 0x0e},
+}
+
+type ariaKeySizeError int
+
+func (k ariaKeySizeError) Error() string {
+	return "aria: invalid key size " + strconv.Itoa(int(k))
+}
+
+type ariaCipher struct {
+	ek, dk [][16]byte // rounds+1 round keys
+}
+
+func newARIACipher(key []byte) (cipher.Block, error) {
+	var ck [3][16]byte
+	switch len(key) {
+	case 16:
+		ck = [3][16]byte{ariaC[0], ariaC[1], ariaC[2]}
+	case 24:
+		ck = [3][16]byte{ariaC[1], ariaC[2], ariaC[0]}
+	case 32:
+		ck = [3][16]byte{ariaC[2], ariaC[0], ariaC[1]}
+	default:
+		return nil, ariaKeySizeError(len(key))
+	}
+	var kl, kr [16]byte
+	copy(kl[:], key)
+	copy(kr[:], key[16:])
+
+	var w [4][16]byte
+	w[0] = kl
+	w[1] = ariaXor(ariaFO(w[0], ck[0]), kr)
+	w[2] = ariaXor(ariaFE(w[1], ck[1]), w[0])
+	w[3] = ariaXor(ariaFO(w[2], ck[2]), w[1])
+
+	rounds := 12 + (len(key)-16)/4
+	c := &ariaCipher{ek: make([][16]byte, rounds+1), dk: make([][16]byte, rounds+1)}
+	// ek1-16 pair W_i with W_(i+1) rotated right by 19, 31, -61 and -31 bits;
+	// ek17 starts the cycle again with a rotation of -19.
+	shifts := [5]int{19, 31, 128 - 61, 128 - 31, 128 - 19}
+	for i := range c.ek {
+		j := i % 4
+		c.ek[i] = ariaXor(w[j], ariaRotR(w[(j+1)%4], shifts[i/4]))
+	}
+	c.dk[0] = c.ek[rounds]
+	for i := 1; i < rounds; i++ {
+		c.dk[i] = ariaA(c.ek[rounds-i])
+	}
+	c.dk[rounds] = c.ek[0]
+	return c, nil
+}
+
+func ariaXor(a, b [16]byte) [16]byte {
+	for i := range a {
+		a[i] ^= b[i]
+	}
+	return a
+}
+
+// ariaRotR rotates a 128-bit value right by n bits.
+func ariaRotR(x [16]byte, n int) [16]byte {
+	var out [16]byte
+	bytesShift, bitShift := n/8, uint(n%8)
+	for i := range out {
+		hi := x[(i-bytesShift+16)%16]
+		lo := x[(i-bytesShift+15)%16]
+		out[i] = hi>>bitShift | lo<<(8-bitShift)
+	}
+	return out
+}
+
+func ariaSL1(x [16]byte) [16]byte {
+	for i := 0; i < 16; i += 4 {
+		x[i], x[i+1], x[i+2], x[i+3] = ariaSB1[x[i]], ariaSB2[x[i+1]], ariaSB3[x[i+2]], ariaSB4[x[i+3]]
+	}
+	return x
+}
+
+func ariaSL2(x [16]byte) [16]byte {
+	for i := 0; i < 16; i += 4 {
+		x[i], x[i+1], x[i+2], x[i+3] = ariaSB3[x[i]], ariaSB4[x[i+1]], ariaSB1[x[i+2]], ariaSB2[x[i+3]]
+	}
+	return x
+}
+
+// ariaA is the involutional diffusion layer.
+func ariaA(x [16]byte) [16]byte {
+	return [16]byte{
+		x[3] ^ x[4] ^ x[6] ^ x[8] ^ x[9] ^ x[13] ^ x[14],
+		x[2] ^ x[5] ^ x[7] ^ x[8] ^ x[9] ^ x[12] ^ x[15],
+		x[1] ^ x[4] ^ x[6] ^ x[10] ^ x[11] ^ x[12] ^ x[15],
+		x[0] ^ x[5] ^ x[7] ^ x[10] ^ x[11] ^ x[13] ^ x[14],
+		x[0] ^ x[2] ^ x[5] ^ x[8] ^ x[11] ^ x[14] ^ x[15],
+		x[1] ^ x[3] ^ x[4] ^ x[9] ^ x[10] ^ x[14] ^ x[15],
+		x[0] ^ x[2] ^ x[7] ^ x[9] ^ x[10] ^ x[12] ^ x[13],
+		x[1] ^ x[3] ^ x[6] ^ x[8] ^ x[11] ^ x[12] ^ x[13],
+		x[0] ^ x[1] ^ x[4] ^ x[7] ^ x[10] ^ x[13] ^ x[15],
+		x[0] ^ x[1] ^ x[5] ^ x[6] ^ x[11] ^ x[12] ^ x[14],
+		x[2] ^ x[3] ^ x[5] ^ x[6] ^ x[8] ^ x[13] ^ x[15],
+		x[2] ^ x[3] ^ x[4] ^ x[7] ^ x[9] ^ x[12] ^ x[14],
+		x[1] ^ x[2] ^ x[6] ^ x[7] ^ x[9] ^ x[11] ^ x[12],
+		x[0] ^ x[3] ^ x[6] ^ x[7] ^ x[8] ^ x[10] ^ x[13],
+		x[0] ^ x[3] ^ x[4] ^ x[5] ^ x[9] ^ x[11] ^ x[14],
+		x[1] ^ x[2] ^ x[4] ^ x[5] ^ x[8] ^ x[10] ^ x[15],
+	}
+}
+
+func ariaFO(d, rk [16]byte) [16]byte { return ariaA(ariaSL1(ariaXor(d, rk))) }
+
+func ariaFE(d, rk [16]byte) [16]byte { return ariaA(ariaSL2(ariaXor(d, rk))) }
+
+func (c *ariaCipher) BlockSize() int { return 16 }
+
+func (c *ariaCipher) Encrypt(dst, src []byte) { ariaCrypt(dst, src, c.ek) }
+
+func (c *ariaCipher) Decrypt(dst, src []byte) { ariaCrypt(dst, src, c.dk) }
+
+func ariaCrypt(dst, src []byte, keys [][16]byte) {
+	var p [16]byte
+	copy(p[:], src)
+	rounds := len(keys) - 1
+	for i := 0; i < rounds-1; i++ {
+		if i%2 == 0 {
+			p = ariaFO(p, keys[i])
+		} else {
+			p = ariaFE(p, keys[i])
+		}
+	}
+	p = ariaXor(ariaSL2(ariaXor(p, keys[rounds-1])), keys[rounds])
+	copy(dst, p[:])
+}
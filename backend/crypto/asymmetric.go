@@ -39,8 +39,8 @@ type eccOptions struct {
 	mode      string
 	symmetric string
 	mac       string
-	kdf       stdcrypto.Hash
-	macHash   stdcrypto.Hash
+	kdf       hashSpec
+	macHash   hashSpec
 }
 
 // RunAsymmetric performs an asymmetric cryptographic operation based on the request.
@@ -48,11 +48,16 @@ type eccOptions struct {
 // req: The AsymmetricRequest containing algorithm, operation, and parameters.
 // Returns an OperationResult with the output or an error.
 func (c *CryptoService) RunAsymmetric(req AsymmetricRequest) (OperationResult, error) {
+	if uri, ok := c.pkcs11KeyURI(req.KeyID); ok {
+		return c.runPKCS11Operation(req, uri)
+	}
 	switch strings.ToLower(req.Algorithm) {
 	case "rsa":
 		return c.runRSAOperation(req)
 	case "ecc":
 		return c.runECCOperation(req)
+	case "eddsa":
+		return c.runEdDSAOperation(req)
 	case "sm2":
 		return c.runSM2Operation(req)
 	case "sm9":
@@ -85,11 +90,11 @@ func (c *CryptoService) runRSAOperation(req AsymmetricRequest) (OperationResult,
 	if padding == "data" {
 		padding = "pkcs1"
 	}
-	oaepHash, err := resolveHashAlgorithm(req.OAEPHash, stdcrypto.SHA256)
+	oaepHash, err := rsaHash(req.OAEPHash, "sha256")
 	if err != nil {
 		return OperationResult{}, err
 	}
-	mgfHash, err := resolveHashAlgorithm(req.MGF1Hash, oaepHash)
+	mgfHash, err := rsaHash(req.MGF1Hash, oaepHash.name)
 	if err != nil {
 		return OperationResult{}, err
 	}
@@ -123,7 +128,7 @@ func (c *CryptoService) runRSAOperation(req AsymmetricRequest) (OperationResult,
 			ciphertext, err = rsaRawEncrypt(pubKey, payload)
 		default:
 			padding = "oaep"
-			ciphertext, err = encryptRSAOAEP(pubKey, payload, nil, oaepHash, mgfHash)
+			ciphertext, err = encryptRSAOAEP(pubKey, payload, nil, oaepHash.id, mgfHash.id)
 		}
 		if err != nil {
 			return OperationResult{}, err
@@ -154,8 +159,8 @@ func (c *CryptoService) runRSAOperation(req AsymmetricRequest) (OperationResult,
 		default:
 			padding = "oaep"
 			plaintext, err = priv.Decrypt(rand.Reader, payload, &rsa.OAEPOptions{
-				Hash:    oaepHash,
-				MGFHash: mgfHash,
+				Hash:    oaepHash.id,
+				MGFHash: mgfHash.id,
 			})
 		}
 		if err != nil {
@@ -176,19 +181,22 @@ func (c *CryptoService) runRSAOperation(req AsymmetricRequest) (OperationResult,
 		if err != nil {
 			return OperationResult{}, err
 		}
+		sigHash, err := rsaHash(req.Hash, "sha256")
+		if err != nil {
+			return OperationResult{}, err
+		}
 		var sig []byte
 		digest := payload
 		if padding != "none" && !payloadIsHash {
-			sum := sha256.Sum256(payload)
-			digest = sum[:]
+			digest = sigHash.sum(payload)
 		}
 		switch padding {
 		case "none":
 			sig, err = rsa.SignPKCS1v15(rand.Reader, priv, stdcrypto.Hash(0), payload)
 		case "pss":
-			sig, err = rsa.SignPSS(rand.Reader, priv, stdcrypto.SHA256, digest, nil)
+			sig, err = rsa.SignPSS(rand.Reader, priv, sigHash.id, digest, nil)
 		default:
-			sig, err = rsa.SignPKCS1v15(rand.Reader, priv, stdcrypto.SHA256, digest)
+			sig, err = rsa.SignPKCS1v15(rand.Reader, priv, sigHash.id, digest)
 		}
 		if err != nil {
 			return OperationResult{}, err
@@ -220,10 +228,13 @@ func (c *CryptoService) runRSAOperation(req AsymmetricRequest) (OperationResult,
 		if err != nil {
 			return OperationResult{}, err
 		}
+		sigHash, err := rsaHash(req.Hash, "sha256")
+		if err != nil {
+			return OperationResult{}, err
+		}
 		digest := payload
 		if padding != "none" && !payloadIsHash {
-			sum := sha256.Sum256(payload)
-			digest = sum[:]
+			digest = sigHash.sum(payload)
 		}
 		switch padding {
 		case "none":
@@ -231,11 +242,11 @@ func (c *CryptoService) runRSAOperation(req AsymmetricRequest) (OperationResult,
 				return OperationResult{Verified: false}, nil
 			}
 		case "pss":
-			if err := rsa.VerifyPSS(pubKey, stdcrypto.SHA256, digest, signature, nil); err != nil {
+			if err := rsa.VerifyPSS(pubKey, sigHash.id, digest, signature, nil); err != nil {
 				return OperationResult{Verified: false}, nil
 			}
 		default:
-			if err := rsa.VerifyPKCS1v15(pubKey, stdcrypto.SHA256, digest, signature); err != nil {
+			if err := rsa.VerifyPKCS1v15(pubKey, sigHash.id, digest, signature); err != nil {
 				return OperationResult{Verified: false}, nil
 			}
 		}
@@ -250,11 +261,25 @@ func (c *CryptoService) runECCOperation(req AsymmetricRequest) (OperationResult,
 	if err != nil {
 		return OperationResult{}, err
 	}
+	op := strings.ToLower(req.Operation)
+	if op == "derive" || op == "exchange" {
+		priv, err := parseECCPrivate(mat.privatePEM)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		return c.deriveECDH(req, priv, ensureECCPublic)
+	}
+	if _, ok := eciesProfileName(req.EccMode); ok && (op == "encrypt" || op == "decrypt") {
+		payload, err := decodeBlob(req.Payload, req.PayloadFormat)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		return runECIESProfile(op, mat, req, payload)
+	}
 	opts, err := resolveECCOptions(req)
 	if err != nil {
 		return OperationResult{}, err
 	}
-	op := strings.ToLower(req.Operation)
 	payload, err := decodeBlob(req.Payload, req.PayloadFormat)
 	if err != nil {
 		return OperationResult{}, err
@@ -335,7 +360,7 @@ func (c *CryptoService) runECCOperation(req AsymmetricRequest) (OperationResult,
 		if err != nil {
 			return OperationResult{}, err
 		}
-		ok := ecdsa.VerifyASN1(pub, digest, signature)
+		ok := ecdsa.VerifyASN1(pub, digest, normalizeVerifySignature(signature, pub.Curve))
 		return OperationResult{Verified: ok}, nil
 	default:
 		return OperationResult{}, fmt.Errorf("unsupported ECC operation: %s", req.Operation)
@@ -354,6 +379,10 @@ func (c *CryptoService) runSM2Operation(req AsymmetricRequest) (OperationResult,
 	}
 	payloadIsHash := req.PayloadIsHash
 	outputFormat := normalizeOutputFormat(req.OutputFormat)
+	sdfOrder, err := resolveSDFByteOrder(req.SDFByteOrder)
+	if err != nil {
+		return OperationResult{}, err
+	}
 
 	switch op {
 	case "encrypt":
@@ -361,21 +390,48 @@ func (c *CryptoService) runSM2Operation(req AsymmetricRequest) (OperationResult,
 		if err != nil {
 			return OperationResult{}, err
 		}
-		ct, err := sm2.Encrypt(rand.Reader, pub, payload, nil)
+		layout, err := normalizeSM2Layout(req.EccMode)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		if layout == "" {
+			layout = sm2LayoutC1C3C2
+		}
+		plain, err := sm2.Encrypt(rand.Reader, pub, payload, nil)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		parts, _, err := parseSM2Ciphertext(plain, sm2LayoutC1C3C2)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		ct, err := parts.encodeLayout(layout, sdfOrder)
 		if err != nil {
 			return OperationResult{}, err
 		}
+		details := map[string]string{"base64": encodeBase64(ct), "layout": layout}
+		if err := addSM2CipherDetails(details, parts, sdfOrder); err != nil {
+			return OperationResult{}, err
+		}
 		return OperationResult{
-			Output: encodeOutputBytes(ct, outputFormat),
-			Details: map[string]string{
-				"base64": encodeBase64(ct),
-			},
+			Output:  encodeOutputBytes(ct, outputFormat),
+			Details: details,
 		}, nil
 	case "decrypt":
 		priv, err := parseSM2Private(mat.privatePEM)
 		if err != nil {
 			return OperationResult{}, err
 		}
+		// EccMode names the input layout; left empty the layout is detected.
+		layout, err := normalizeSM2Layout(req.EccMode)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		parts, _, err := parseSM2Ciphertext(payload, layout)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		payload = parts.c1c3c2()
 		plaintext, err := sm2.Decrypt(priv, payload)
 		if err != nil {
 			return OperationResult{}, err
@@ -406,11 +462,21 @@ func (c *CryptoService) runSM2Operation(req AsymmetricRequest) (OperationResult,
 		if err != nil {
 			return OperationResult{}, err
 		}
+		sdfSig, err := sdfSignatureFromDER(sig)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		details := map[string]string{
+			"base64":       encodeBase64(sig),
+			"sdfSignature": strings.ToUpper(hex.EncodeToString(sdfSig)),
+		}
+		if strings.EqualFold(req.EccMode, "sdf") {
+			sig = sdfSig
+			details["base64"] = encodeBase64(sig)
+		}
 		return OperationResult{
-			Output: encodeOutputBytes(sig, outputFormat),
-			Details: map[string]string{
-				"base64": encodeBase64(sig),
-			},
+			Output:  encodeOutputBytes(sig, outputFormat),
+			Details: details,
 		}, nil
 	case "verify":
 		pub, err := ensureSM2Public(mat)
@@ -421,6 +487,11 @@ func (c *CryptoService) runSM2Operation(req AsymmetricRequest) (OperationResult,
 		if err != nil {
 			return OperationResult{}, err
 		}
+		// ECCSignature input from a cipher card and raw r||s are accepted as well as ASN.1.
+		if signature, _, err = sdfSignatureToDER(signature); err != nil {
+			return OperationResult{}, err
+		}
+		signature = normalizeVerifySignature(signature, pub.Curve)
 		var ok bool
 		if payloadIsHash {
 			ok = sm2.VerifyASN1(pub, payload, signature)
@@ -432,6 +503,14 @@ func (c *CryptoService) runSM2Operation(req AsymmetricRequest) (OperationResult,
 			ok = sm2.VerifyASN1WithSM2(pub, uid, payload, signature)
 		}
 		return OperationResult{Verified: ok}, nil
+	case "derive":
+		priv, err := parseSM2Private(mat.privatePEM)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		return c.deriveECDH(req, &priv.PrivateKey, ensureSM2Public)
+	case "exchange":
+		return c.runSM2Exchange(req, mat)
 	default:
 		return OperationResult{}, fmt.Errorf("unsupported SM2 operation: %s", req.Operation)
 	}
@@ -614,7 +693,7 @@ func resolveECCOptions(req AsymmetricRequest) (eccOptions, error) {
 	if opts.symmetric == "aes-256-cbc" && opts.mac == "" {
 		opts.mac = "hmac-sha256"
 	}
-	kdf, err := resolveHashAlgorithm(req.KDF, stdcrypto.SHA256)
+	kdf, err := digestHash(req.KDF, "sha256")
 	if err != nil {
 		return eccOptions{}, err
 	}
@@ -685,20 +764,7 @@ func parseECCPrivate(p string) (*ecdsa.PrivateKey, error) {
 	if block == nil {
 		return nil, errors.New("invalid EC private key")
 	}
-	switch block.Type {
-	case "EC PRIVATE KEY":
-		return x509.ParseECPrivateKey(block.Bytes)
-	default:
-		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-		if err != nil {
-			return nil, err
-		}
-		ecKey, ok := key.(*ecdsa.PrivateKey)
-		if !ok {
-			return nil, errors.New("not an EC private key")
-		}
-		return ecKey, nil
-	}
+	return parseECPrivateKeyDER(block.Bytes)
 }
 
 func ensureECCPublic(mat keyMaterial) (*ecdsa.PublicKey, error) {
@@ -707,15 +773,7 @@ func ensureECCPublic(mat keyMaterial) (*ecdsa.PublicKey, error) {
 		if block == nil {
 			return nil, errors.New("invalid EC public key")
 		}
-		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-		if err != nil {
-			return nil, err
-		}
-		ec, ok := pub.(*ecdsa.PublicKey)
-		if !ok {
-			return nil, errors.New("not an EC public key")
-		}
-		return ec, nil
+		return parseECPublicKeyDER(block.Bytes)
 	}
 	if mat.privatePEM == "" {
 		return nil, errors.New("missing EC public key")
@@ -828,7 +886,7 @@ func eccDecrypt(priv *ecdsa.PrivateKey, payload []byte, opts eccOptions) ([]byte
 	shared := append(sharedX.Bytes(), sharedY.Bytes()...)
 	switch opts.symmetric {
 	case "aes-256-cbc":
-		if opts.macHash == 0 {
+		if opts.macHash.newHash == nil {
 			return nil, errors.New("hmac algorithm required for CBC mode")
 		}
 		keyMaterial, err := deriveECCKeys(shared, opts.kdf, 64, opts.symmetric)
@@ -842,7 +900,7 @@ func eccDecrypt(priv *ecdsa.PrivateKey, payload []byte, opts eccOptions) ([]byte
 			return nil, err
 		}
 		blockSize := block.BlockSize()
-		macSize := opts.macHash.Size()
+		macSize := opts.macHash.size()
 		if len(payload) <= ephemeralLen+blockSize+macSize {
 			return nil, errors.New("invalid ECC ciphertext")
 		}
@@ -895,15 +953,11 @@ func eccDecrypt(priv *ecdsa.PrivateKey, payload []byte, opts eccOptions) ([]byte
 	}
 }
 
-func deriveECCKeys(shared []byte, hashAlg stdcrypto.Hash, length int, info string) ([]byte, error) {
-	if hashAlg == 0 {
-		hashAlg = stdcrypto.SHA256
-	}
-	fn, err := newHashFunc(hashAlg)
-	if err != nil {
-		return nil, err
+func deriveECCKeys(shared []byte, hashAlg hashSpec, length int, info string) ([]byte, error) {
+	if hashAlg.newHash == nil {
+		return nil, errors.New("missing KDF hash")
 	}
-	reader := hkdf.New(fn, shared, nil, []byte(info))
+	reader := hkdf.New(hashAlg.newHash, shared, nil, []byte(info))
 	buf := make([]byte, length)
 	if _, err := io.ReadFull(reader, buf); err != nil {
 		return nil, err
@@ -911,11 +965,11 @@ func deriveECCKeys(shared []byte, hashAlg stdcrypto.Hash, length int, info strin
 	return buf, nil
 }
 
-func computeHMAC(hashType stdcrypto.Hash, key, message []byte) ([]byte, error) {
-	if hashType == 0 {
+func computeHMAC(hashType hashSpec, key, message []byte) ([]byte, error) {
+	if hashType.newHash == nil {
 		return nil, errors.New("missing mac hash")
 	}
-	mac := hmac.New(hashType.New, key)
+	mac := hmac.New(hashType.newHash, key)
 	_, err := mac.Write(message)
 	if err != nil {
 		return nil, err
@@ -1047,53 +1101,14 @@ func deriveSM9EncryptPublic(mat keyMaterial) (*sm9.EncryptMasterPublicKey, error
 	return nil, errors.New("missing SM9 encrypt public key")
 }
 
-func resolveHashAlgorithm(name string, fallback stdcrypto.Hash) (stdcrypto.Hash, error) {
-	if name == "" {
-		if fallback != 0 {
-			return fallback, nil
-		}
-		return stdcrypto.SHA256, nil
-	}
-	switch strings.ToLower(name) {
-	case "sha1":
-		return stdcrypto.SHA1, nil
-	case "sha224":
-		return stdcrypto.SHA224, nil
-	case "sha256":
-		return stdcrypto.SHA256, nil
-	case "sha384":
-		return stdcrypto.SHA384, nil
-	case "sha512":
-		return stdcrypto.SHA512, nil
-	case "sha512/256", "sha512-256":
-		return stdcrypto.SHA512_256, nil
-	case "md5":
-		return stdcrypto.MD5, nil
-	default:
-		return 0, fmt.Errorf("unsupported hash: %s", name)
-	}
-}
-
-func resolveMACAlgorithm(name string) (stdcrypto.Hash, error) {
-	switch strings.ToLower(name) {
-	case "", "hmac-sha256":
-		return stdcrypto.SHA256, nil
-	case "hmac-sha384":
-		return stdcrypto.SHA384, nil
-	case "hmac-sha512":
-		return stdcrypto.SHA512, nil
-	default:
-		return 0, fmt.Errorf("unsupported mac algorithm: %s", name)
-	}
-}
-
-func newHashFunc(h stdcrypto.Hash) (func() hash.Hash, error) {
-	if !h.Available() {
-		return nil, fmt.Errorf("hash %v unavailable", h)
-	}
-	return func() hash.Hash {
-		return h.New()
-	}, nil
+// resolveMACAlgorithm maps an ECIES MAC name such as hmac-sha384 onto the
+// hash registry; an empty name selects HMAC-SHA256.
+func resolveMACAlgorithm(name string) (hashSpec, error) {
+	hashName, ok := strings.CutPrefix(strings.ToLower(name), "hmac-")
+	if !ok && name != "" {
+		return hashSpec{}, fmt.Errorf("unsupported mac algorithm: %s", name)
+	}
+	return digestHash(hashName, "sha256")
 }
 
 func encryptRSAOAEP(pub *rsa.PublicKey, msg, label []byte, hashAlg, mgfHash stdcrypto.Hash) ([]byte, error) {
@@ -0,0 +1,334 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	backupFormat  = "ctools-backup"
+	backupVersion = 1
+	backupAAD     = "ctools-backup"
+)
+
+// errBackupConflicts aborts the key store update of an import that found
+// conflicts and has no strategy to resolve them.
+var errBackupConflicts = errors.New("backup conflicts need a strategy")
+
+// backupArchive is the on-disk layout of a toolbox backup. Either Contents or,
+// when a passphrase is used, the sealed Payload is present.
+type backupArchive struct {
+	Format    string          `json:"format"`
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Encrypted bool            `json:"encrypted"`
+	KDF       *keyStoreKDF    `json:"kdf,omitempty"`
+	Cipher    string          `json:"cipher,omitempty"`
+	Payload   string          `json:"payload,omitempty"`
+	Contents  *backupContents `json:"contents,omitempty"`
+}
+
+type backupContents struct {
+	Keys    []StoredKey  `json:"keys"`
+	Certs   []CertRecord `json:"certs"`
+	CACerts []CertRecord `json:"caCerts"`
+}
+
+// ExportBackup writes keys, certificates and the CA store into one versioned archive.
+//
+// req: The BackupExportRequest; a non-empty Passphrase encrypts the archive.
+// Returns the archive as JSON text or an error if the key store is locked.
+func (c *CryptoService) ExportBackup(req BackupExportRequest) (BackupExportResult, error) {
+	keys, locked := c.readKeyStore()
+	if locked {
+		return BackupExportResult{}, errKeyStoreLocked
+	}
+	contents := backupContents{Keys: keys, Certs: c.readCerts(), CACerts: c.readCACerts()}
+	archive := backupArchive{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now()}
+	if req.Passphrase == "" {
+		archive.Contents = &contents
+	} else {
+		cipherName := normalizeStoreCipher(req.Cipher)
+		kdf, err := newKeyStoreKDF(req.KDF)
+		if err != nil {
+			return BackupExportResult{}, err
+		}
+		plain, err := json.Marshal(contents)
+		if err != nil {
+			return BackupExportResult{}, err
+		}
+		sealed, err := sealWithPassphrase(kdf, cipherName, req.Passphrase, plain, []byte(backupAAD))
+		if err != nil {
+			return BackupExportResult{}, err
+		}
+		archive.Encrypted = true
+		archive.KDF = &kdf
+		archive.Cipher = cipherName
+		archive.Payload = sealed
+	}
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return BackupExportResult{}, fmt.Errorf("unable to marshal backup: %w", err)
+	}
+	return BackupExportResult{
+		Data:      string(data),
+		Version:   backupVersion,
+		Encrypted: archive.Encrypted,
+		Keys:      len(contents.Keys),
+		Certs:     len(contents.Certs),
+		CACerts:   len(contents.CACerts),
+	}, nil
+}
+
+// ImportBackup restores a backup archive into the local stores.
+//
+// req: The BackupImportRequest with the archive, passphrase and conflict strategy.
+// Returns a BackupImportResult. When IDs clash and no strategy is given, nothing is
+// written and the conflicts are listed so the caller can pick merge, skip or overwrite.
+func (c *CryptoService) ImportBackup(req BackupImportRequest) (BackupImportResult, error) {
+	contents, version, err := decodeBackup(req.Data, req.Passphrase)
+	if err != nil {
+		return BackupImportResult{}, err
+	}
+	strategy := strings.ToLower(strings.TrimSpace(req.Strategy))
+	switch strategy {
+	case "", "merge", "skip", "overwrite":
+	default:
+		return BackupImportResult{}, fmt.Errorf("unsupported conflict strategy: %s", req.Strategy)
+	}
+
+	keys, locked := c.readKeyStore()
+	if locked {
+		return BackupImportResult{}, errKeyStoreLocked
+	}
+
+	result := BackupImportResult{Version: version, Strategy: strategy, Conflicts: []BackupConflict{}}
+	if req.DryRun || strategy == "" {
+		result.Conflicts = append(append(append(result.Conflicts, keyConflicts(keys, contents.Keys)...),
+			certConflicts("cert", c.readCerts(), contents.Certs)...),
+			certConflicts("ca", c.readCACerts(), contents.CACerts)...)
+		if req.DryRun || len(result.Conflicts) > 0 {
+			return result, nil
+		}
+	}
+
+	// Each store is checked again inside the update that merges it, so an ID
+	// saved since the snapshot above is still caught; without a strategy the
+	// import then stops at that store. Keys that are renamed during a merge get
+	// a new ID; certificates follow them.
+	merge := strategy
+	if merge == "" {
+		merge = "skip"
+	}
+	remap := map[string]string{}
+	err = c.updateKeyStore(func(keys []StoredKey) ([]StoredKey, error) {
+		conflicts := keyConflicts(keys, contents.Keys)
+		result.Conflicts = append(result.Conflicts, conflicts...)
+		if strategy == "" && len(conflicts) > 0 {
+			return nil, errBackupConflicts
+		}
+		return mergeBackupKeys(keys, contents.Keys, merge, remap, &result), nil
+	})
+	mergeCerts := func(kind string, incoming []CertRecord) func([]CertRecord) ([]CertRecord, error) {
+		return func(certs []CertRecord) ([]CertRecord, error) {
+			conflicts := certConflicts(kind, certs, incoming)
+			result.Conflicts = append(result.Conflicts, conflicts...)
+			if strategy == "" && len(conflicts) > 0 {
+				return nil, errBackupConflicts
+			}
+			return mergeBackupCerts(certs, incoming, merge, &result), nil
+		}
+	}
+	if err == nil {
+		for i := range contents.Certs {
+			if id, ok := remap[contents.Certs[i].KeyID]; ok {
+				contents.Certs[i].KeyID = id
+			}
+		}
+		err = c.updateCerts(storeCerts, mergeCerts("cert", contents.Certs))
+	}
+	if err == nil {
+		err = c.updateCerts(storeCA, mergeCerts("ca", contents.CACerts))
+	}
+	if errors.Is(err, errBackupConflicts) {
+		return result, nil
+	}
+	if err != nil {
+		return BackupImportResult{}, err
+	}
+	result.Strategy = merge
+	result.Applied = true
+	return result, nil
+}
+
+func decodeBackup(data, passphrase string) (backupContents, int, error) {
+	var archive backupArchive
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &archive); err != nil {
+		return backupContents{}, 0, fmt.Errorf("invalid backup archive: %w", err)
+	}
+	if archive.Format != backupFormat {
+		return backupContents{}, 0, errors.New("not a ctools backup archive")
+	}
+	if archive.Version < 1 || archive.Version > backupVersion {
+		return backupContents{}, 0, fmt.Errorf("unsupported backup version: %d", archive.Version)
+	}
+	if !archive.Encrypted {
+		if archive.Contents == nil {
+			return backupContents{}, 0, errors.New("backup archive is empty")
+		}
+		return *archive.Contents, archive.Version, nil
+	}
+	if passphrase == "" {
+		return backupContents{}, 0, errors.New("encrypted backup requires a passphrase")
+	}
+	if archive.KDF == nil {
+		return backupContents{}, 0, errors.New("encrypted backup is missing KDF parameters")
+	}
+	plain, err := openWithPassphrase(*archive.KDF, archive.Cipher, passphrase, archive.Payload, []byte(backupAAD))
+	if err != nil {
+		return backupContents{}, 0, errors.New("incorrect backup passphrase")
+	}
+	var contents backupContents
+	if err := json.Unmarshal(plain, &contents); err != nil {
+		return backupContents{}, 0, fmt.Errorf("invalid backup contents: %w", err)
+	}
+	return contents, archive.Version, nil
+}
+
+func keyConflicts(existing, incoming []StoredKey) []BackupConflict {
+	index := make(map[string]StoredKey, len(existing))
+	for _, k := range existing {
+		index[k.ID] = k
+	}
+	var out []BackupConflict
+	for _, k := range incoming {
+		if cur, ok := index[k.ID]; ok {
+			out = append(out, BackupConflict{
+				Kind:         "key",
+				ID:           k.ID,
+				Name:         k.Name,
+				ExistingName: cur.Name,
+				SameMaterial: sameKeyMaterial(cur, k),
+			})
+		}
+	}
+	return out
+}
+
+func certConflicts(kind string, existing, incoming []CertRecord) []BackupConflict {
+	index := make(map[string]CertRecord, len(existing))
+	for _, cert := range existing {
+		index[cert.ID] = cert
+	}
+	var out []BackupConflict
+	for _, cert := range incoming {
+		if cur, ok := index[cert.ID]; ok {
+			out = append(out, BackupConflict{
+				Kind:         kind,
+				ID:           cert.ID,
+				Name:         cert.Name,
+				ExistingName: cur.Name,
+				SameMaterial: strings.TrimSpace(cur.CertPEM) == strings.TrimSpace(cert.CertPEM),
+			})
+		}
+	}
+	return out
+}
+
+func sameKeyMaterial(a, b StoredKey) bool {
+	if a.PublicPEM != "" || b.PublicPEM != "" {
+		return strings.TrimSpace(a.PublicPEM) == strings.TrimSpace(b.PublicPEM)
+	}
+	return strings.TrimSpace(a.PrivatePEM) == strings.TrimSpace(b.PrivatePEM)
+}
+
+// mergeBackupKeys applies the strategy to incoming keys. Merge combines metadata
+// of identical keys and imports differing keys under a new ID.
+func mergeBackupKeys(keys, incoming []StoredKey, strategy string, remap map[string]string, result *BackupImportResult) []StoredKey {
+	index := make(map[string]int, len(keys))
+	for i, k := range keys {
+		index[k.ID] = i
+	}
+	for _, k := range incoming {
+		i, clash := index[k.ID]
+		switch {
+		case !clash:
+			index[k.ID] = len(keys)
+			keys = append(keys, k)
+			result.Added++
+		case strategy == "skip":
+			result.Skipped++
+		case strategy == "overwrite":
+			keys[i] = k
+			result.Overwritten++
+		case sameKeyMaterial(keys[i], k):
+			keys[i] = mergeKeyMetadata(keys[i], k)
+			result.Merged++
+		default:
+			newID := uuid.New().String()
+			remap[k.ID] = newID
+			k.ID = newID
+			index[newID] = len(keys)
+			keys = append(keys, k)
+			result.Renamed++
+		}
+	}
+	return keys
+}
+
+func mergeKeyMetadata(cur, in StoredKey) StoredKey {
+	if cur.PrivatePEM == "" && in.PrivatePEM != "" {
+		cur.PrivatePEM = in.PrivatePEM
+		cur.KeyType = in.KeyType
+	}
+	cur.Usage = normalizeLabels(append(append([]string{}, cur.Usage...), in.Usage...))
+	cur.Tags = normalizeLabels(append(append([]string{}, cur.Tags...), in.Tags...))
+	if len(in.Extra) > 0 {
+		extra := make(map[string]string, len(cur.Extra)+len(in.Extra))
+		for k, v := range in.Extra {
+			extra[k] = v
+		}
+		for k, v := range cur.Extra {
+			extra[k] = v
+		}
+		cur.Extra = extra
+	}
+	return cur
+}
+
+func mergeBackupCerts(certs, incoming []CertRecord, strategy string, result *BackupImportResult) []CertRecord {
+	index := make(map[string]int, len(certs))
+	for i, cert := range certs {
+		index[cert.ID] = i
+	}
+	for _, cert := range incoming {
+		i, clash := index[cert.ID]
+		switch {
+		case !clash:
+			index[cert.ID] = len(certs)
+			certs = append(certs, cert)
+			result.Added++
+		case strategy == "skip":
+			result.Skipped++
+		case strategy == "overwrite":
+			certs[i] = cert
+			result.Overwritten++
+		case strings.TrimSpace(certs[i].CertPEM) == strings.TrimSpace(cert.CertPEM):
+			if certs[i].KeyID == "" {
+				certs[i].KeyID = cert.KeyID
+			}
+			result.Merged++
+		default:
+			cert.ID = uuid.New().String()
+			index[cert.ID] = len(certs)
+			certs = append(certs, cert)
+			result.Renamed++
+		}
+	}
+	return certs
+}
@@ -0,0 +1,654 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	smcipher "github.com/emmansun/gmsm/cipher"
+	"github.com/emmansun/gmsm/sm4"
+	"golang.org/x/crypto/blowfish"
+)
+
+// blockCipherSpec describes a block cipher that RunSymmetric can drive in
+// every mode. XTS and SIV take two keys of one of keySizes each. Ciphers
+// with a variable key length list no sizes and set minKey and maxKey.
+type blockCipherSpec struct {
+	name           string
+	blockSize      int
+	keySizes       []int
+	minKey, maxKey int
+	newCipher      func([]byte) (cipher.Block, error)
+}
+
+var (
+	aesCipherSpec      = blockCipherSpec{name: "AES", blockSize: 16, keySizes: []int{16, 24, 32}, newCipher: aes.NewCipher}
+	sm4CipherSpec      = blockCipherSpec{name: "SM4", blockSize: 16, keySizes: []int{16}, newCipher: sm4.NewCipher}
+	desCipherSpec      = blockCipherSpec{name: "DES", blockSize: 8, keySizes: []int{8}, newCipher: des.NewCipher}
+	tripleDESSpec      = blockCipherSpec{name: "3DES", blockSize: 8, keySizes: []int{16, 24}, newCipher: newTripleDESCipher}
+	camelliaCipherSpec = blockCipherSpec{name: "Camellia", blockSize: 16, keySizes: []int{16, 24, 32}, newCipher: newCamelliaCipher}
+	ariaCipherSpec     = blockCipherSpec{name: "ARIA", blockSize: 16, keySizes: []int{16, 24, 32}, newCipher: newARIACipher}
+	blowfishSpec       = blockCipherSpec{name: "Blowfish", blockSize: 8, minKey: 4, maxKey: 56, newCipher: newBlowfishCipher}
+)
+
+// lookupBlockCipher resolves an algorithm name to its block cipher.
+func lookupBlockCipher(algo string) (blockCipherSpec, bool) {
+	switch strings.ToLower(strings.TrimSpace(algo)) {
+	case "aes":
+		return aesCipherSpec, true
+	case "sm4":
+		return sm4CipherSpec, true
+	case "des":
+		return desCipherSpec, true
+	case "3des", "des3", "triple-des", "tdes", "tdea", "des-ede", "des-ede3":
+		return tripleDESSpec, true
+	case "camellia":
+		return camelliaCipherSpec, true
+	case "aria":
+		return ariaCipherSpec, true
+	case "blowfish", "bf":
+		return blowfishSpec, true
+	default:
+		return blockCipherSpec{}, false
+	}
+}
+
+// newTripleDESCipher accepts two-key (K1 K2, run as K1 K2 K1) and three-key
+// TDEA keys.
+func newTripleDESCipher(key []byte) (cipher.Block, error) {
+	if len(key) == 16 {
+		key = concatBytes(key, key[:8])
+	}
+	return des.NewTripleDESCipher(key)
+}
+
+func newBlowfishCipher(key []byte) (cipher.Block, error) {
+	return blowfish.NewCipher(key)
+}
+
+// checkKey validates a key made of parts concatenated cipher keys.
+func (s blockCipherSpec) checkKey(key []byte, parts int) error {
+	name := s.name
+	if parts > 1 {
+		name += " double"
+	}
+	if len(s.keySizes) == 0 {
+		if len(key)%parts == 0 && len(key) >= parts*s.minKey && len(key) <= parts*s.maxKey {
+			return nil
+		}
+		return fmt.Errorf("%s key must be %d-%d bytes", name, parts*s.minKey, parts*s.maxKey)
+	}
+	for _, size := range s.keySizes {
+		if len(key) == parts*size {
+			return nil
+		}
+	}
+	sizes := make([]string, len(s.keySizes))
+	for i, size := range s.keySizes {
+		sizes[i] = strconv.Itoa(parts * size)
+	}
+	return fmt.Errorf("%s key must be %s bytes", name, strings.Join(sizes, "/"))
+}
+
+// newBlock checks the key length and creates the cipher.
+func (s blockCipherSpec) newBlock(key []byte) (cipher.Block, error) {
+	if err := s.checkKey(key, 1); err != nil {
+		return nil, err
+	}
+	return s.newCipher(key)
+}
+
+// normalizeBlockMode maps mode spellings onto cbc, ecb, ctr, ofb, cfb<bits>,
+// gcm, ccm, xts, xts-gb, siv and gcm-siv. CFB without a size uses full-block
+// segments.
+func normalizeBlockMode(mode string, blockSize int) (string, int, error) {
+	norm := strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(mode)))
+	switch norm {
+	case "", "cbc":
+		return "cbc", 0, nil
+	case "ecb", "ctr", "ofb", "gcm", "ccm", "xts", "siv":
+		return norm, 0, nil
+	case "xtsgb", "gbxts":
+		return "xts-gb", 0, nil
+	case "gcmsiv":
+		return "gcm-siv", 0, nil
+	case "aessiv", "s2v":
+		return "siv", 0, nil
+	}
+	if strings.HasPrefix(norm, "cfb") {
+		bits := 8 * blockSize
+		if norm != "cfb" {
+			n, err := strconv.Atoi(norm[3:])
+			if err != nil || (n != 1 && n%8 != 0) || n > 8*blockSize || n <= 0 {
+				return "", 0, fmt.Errorf("unsupported CFB segment size: %s", norm[3:])
+			}
+			bits = n
+		}
+		return "cfb", bits, nil
+	}
+	return "", 0, fmt.Errorf("unsupported mode: %s", mode)
+}
+
+// runBlockMode runs a block cipher in the requested mode.
+func runBlockMode(op, mode, padding string, spec blockCipherSpec, key, input []byte, req SymmetricRequest) (OperationResult, error) {
+	mode, segmentBits, err := normalizeBlockMode(mode, spec.blockSize)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	switch mode {
+	case "xts", "xts-gb":
+		if err := spec.checkKey(key, 2); err != nil {
+			return OperationResult{}, err
+		}
+		return runXTS(op, mode == "xts-gb", spec, key, input, req)
+	case "siv":
+		if err := spec.checkKey(key, 2); err != nil {
+			return OperationResult{}, err
+		}
+		return runAEADMode(op, input, req, func(nonceSize int) (cipher.AEAD, error) {
+			return newSIV(spec, key, nonceSize)
+		})
+	}
+
+	block, err := spec.newBlock(key)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	switch mode {
+	case "ecb":
+		return runECB(op, block, input, padding, req)
+	case "ctr":
+		return runStreamBlock(op, cipher.NewCTR, block, input, req)
+	case "ofb":
+		return runStreamBlock(op, cipher.NewOFB, block, input, req)
+	case "cfb":
+		return runCFB(op, block, segmentBits, input, req)
+	case "gcm":
+		return runBlockGCM(op, block, input, req)
+	case "ccm":
+		tagSize := req.TagLength
+		if tagSize == 0 {
+			tagSize = 16
+		}
+		return runAEADMode(op, input, req, func(nonceSize int) (cipher.AEAD, error) {
+			return smcipher.NewCCMWithNonceAndTagSize(block, nonceSize, tagSize)
+		})
+	case "gcm-siv":
+		return runAEADMode(op, input, req, func(nonceSize int) (cipher.AEAD, error) {
+			return newGCMSIV(spec, key, nonceSize)
+		})
+	default:
+		return runCBC(op, block, input, padding, req)
+	}
+}
+
+// symmetricOutput wraps the result of a cipher operation the way every mode
+// reports it: decryption adds the text form of the plaintext.
+func symmetricOutput(op string, out []byte, req SymmetricRequest) OperationResult {
+	details := map[string]string{"base64": encodeBase64(out)}
+	if op != "encrypt" {
+		details["text"] = string(out)
+	}
+	return OperationResult{Output: encodeOutputBytes(out, req.OutputFormat), Details: details}
+}
+
+// runAEADMode seals or opens with an AEAD built for the nonce length given.
+// Ciphertexts are written as ciphertext||tag, except SIV which puts the
+// synthetic IV first as RFC 5297 does.
+func runAEADMode(op string, input []byte, req SymmetricRequest, newAEAD func(nonceSize int) (cipher.AEAD, error)) (OperationResult, error) {
+	nonce, err := decodeBlob(req.Nonce, req.NonceFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid nonce: %w", err)
+	}
+	aead, err := newAEAD(len(nonce))
+	if err != nil {
+		return OperationResult{}, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return OperationResult{}, fmt.Errorf("nonce must be %d bytes", aead.NonceSize())
+	}
+	ad, err := decodeBlob(req.Additional, req.AdditionalFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid additional data: %w", err)
+	}
+	if op == "encrypt" {
+		var out []byte
+		if g, ok := aead.(*gcmSIV); ok {
+			if out, err = g.seal(nil, nonce, input, ad); err != nil {
+				return OperationResult{}, err
+			}
+		} else {
+			out = aead.Seal(nil, nonce, input, ad)
+		}
+		result := symmetricOutput(op, out, req)
+		tag := out[len(out)-aead.Overhead():]
+		if _, ok := aead.(*sivAEAD); ok {
+			tag = out[:aead.Overhead()]
+		}
+		result.Details["tag"] = strings.ToUpper(hex.EncodeToString(tag))
+		return result, nil
+	}
+	if len(input) < aead.Overhead() {
+		return OperationResult{}, errors.New("ciphertext is shorter than the tag")
+	}
+	plain, err := aead.Open(nil, nonce, input, ad)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	return symmetricOutput(op, plain, req), nil
+}
+
+// runBlockGCM runs GCM with the nonce length supplied and TagLength bytes of
+// tag (16 by default; 4, 8 and 12-16 are accepted).
+func runBlockGCM(op string, block cipher.Block, input []byte, req SymmetricRequest) (OperationResult, error) {
+	tagSize := req.TagLength
+	if tagSize == 0 {
+		tagSize = 16
+	}
+	if tagSize != 4 && tagSize != 8 && (tagSize < 12 || tagSize > 16) {
+		return OperationResult{}, fmt.Errorf("unsupported GCM tag length: %d", tagSize)
+	}
+	return runAEADMode(op, input, req, func(nonceSize int) (cipher.AEAD, error) {
+		if nonceSize == 0 {
+			return nil, errors.New("nonce must not be empty")
+		}
+		aead, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+		if err != nil || tagSize == aead.Overhead() {
+			return aead, err
+		}
+		return truncatedGCM{full: aead, tagSize: tagSize}, nil
+	})
+}
+
+// truncatedGCM shortens the GCM tag, which is defined as the leading bytes of
+// the full tag. The standard library only combines short tags with 12-byte
+// nonces, so the full-tag AEAD is used and the tag cut or recomputed: GCM
+// encrypts by XOR with a keystream that does not depend on the data, so
+// sealing the ciphertext yields the plaintext.
+type truncatedGCM struct {
+	full    cipher.AEAD
+	tagSize int
+}
+
+func (g truncatedGCM) NonceSize() int { return g.full.NonceSize() }
+func (g truncatedGCM) Overhead() int  { return g.tagSize }
+
+func (g truncatedGCM) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	out := g.full.Seal(nil, nonce, plaintext, additionalData)
+	return append(dst, out[:len(plaintext)+g.tagSize]...)
+}
+
+func (g truncatedGCM) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < g.tagSize {
+		return nil, errors.New("cipher: message authentication failed")
+	}
+	body, tag := ciphertext[:len(ciphertext)-g.tagSize], ciphertext[len(ciphertext)-g.tagSize:]
+	plain := g.full.Seal(nil, nonce, body, nil)[:len(body)]
+	expected := g.full.Seal(nil, nonce, plain, additionalData)[len(plain):]
+	if subtle.ConstantTimeCompare(expected[:g.tagSize], tag) != 1 {
+		return nil, errors.New("cipher: message authentication failed")
+	}
+	return append(dst, plain...), nil
+}
+
+// runCFB runs CFB with segmentBits-bit segments (SP 800-38A): 1, 8 or any
+// multiple of 8 up to the block size. Full-block CFB accepts a short final
+// segment, like a stream cipher.
+func runCFB(op string, block cipher.Block, segmentBits int, input []byte, req SymmetricRequest) (OperationResult, error) {
+	iv, err := decodeBlob(req.IV, req.IVFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid IV: %w", err)
+	}
+	bs := block.BlockSize()
+	if len(iv) != bs {
+		return OperationResult{}, fmt.Errorf("IV must be %d bytes", bs)
+	}
+	decrypt := op != "encrypt"
+	register := append([]byte(nil), iv...)
+	keystream := make([]byte, bs)
+	out := make([]byte, len(input))
+
+	if segmentBits == 1 {
+		for i, b := range input {
+			var o byte
+			for bit := 7; bit >= 0; bit-- {
+				block.Encrypt(keystream, register)
+				in := (b >> bit) & 1
+				res := in ^ keystream[0]>>7
+				o |= res << bit
+				fed := res
+				if decrypt {
+					fed = in
+				}
+				shiftLeftOneBit(register, fed)
+			}
+			out[i] = o
+		}
+		return symmetricOutput(op, out, req), nil
+	}
+
+	seg := segmentBits / 8
+	if seg < bs && len(input)%seg != 0 {
+		return OperationResult{}, fmt.Errorf("CFB-%d input must be a multiple of %d bytes", segmentBits, seg)
+	}
+	for pos := 0; pos < len(input); pos += seg {
+		end := min(pos+seg, len(input))
+		block.Encrypt(keystream, register)
+		xorBytes(out[pos:end], input[pos:end], keystream)
+		fed := out[pos:end]
+		if decrypt {
+			fed = input[pos:end]
+		}
+		copy(register, register[seg:])
+		copy(register[bs-seg:], fed)
+	}
+	return symmetricOutput(op, out, req), nil
+}
+
+func shiftLeftOneBit(register []byte, in byte) {
+	for i := 0; i < len(register)-1; i++ {
+		register[i] = register[i]<<1 | register[i+1]>>7
+	}
+	register[len(register)-1] = register[len(register)-1]<<1 | in
+}
+
+// runXTS runs IEEE 1619 XTS (or the GB/T 17964-2021 variant) with ciphertext
+// stealing. The tweak is the IV when given, otherwise the little-endian
+// sector number. With SectorSize set the input is split into data units that
+// use consecutive sector numbers, as a disk image would.
+func runXTS(op string, gb bool, spec blockCipherSpec, key, input []byte, req SymmetricRequest) (OperationResult, error) {
+	half := len(key) / 2
+	dataKey, tweakKey := key[:half], key[half:]
+	tweak, err := decodeBlob(req.IV, req.IVFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid IV: %w", err)
+	}
+	if len(tweak) != 0 && len(tweak) != 16 {
+		return OperationResult{}, errors.New("XTS tweak must be 16 bytes")
+	}
+	unit := req.SectorSize
+	if unit == 0 {
+		unit = len(input)
+	}
+	if unit < 16 || len(input) == 0 {
+		return OperationResult{}, errors.New("XTS data units must be at least 16 bytes")
+	}
+	if len(tweak) != 0 && unit != len(input) {
+		return OperationResult{}, errors.New("an explicit tweak covers one data unit; use the sector number for multiple sectors")
+	}
+	out := make([]byte, len(input))
+	sector := req.Sector
+	for pos := 0; pos < len(input); pos += unit {
+		end := min(pos+unit, len(input))
+		if end-pos < 16 {
+			return OperationResult{}, errors.New("the last XTS data unit is shorter than 16 bytes")
+		}
+		unitTweak := tweak
+		if len(unitTweak) == 0 {
+			unitTweak = make([]byte, 16)
+			binary.LittleEndian.PutUint64(unitTweak, sector)
+		}
+		var mode cipher.BlockMode
+		switch {
+		case op == "encrypt" && gb:
+			mode, err = smcipher.NewGBXTSEncrypter(spec.newCipher, dataKey, tweakKey, unitTweak)
+		case op == "encrypt":
+			mode, err = smcipher.NewXTSEncrypter(spec.newCipher, dataKey, tweakKey, unitTweak)
+		case gb:
+			mode, err = smcipher.NewGBXTSDecrypter(spec.newCipher, dataKey, tweakKey, unitTweak)
+		default:
+			mode, err = smcipher.NewXTSDecrypter(spec.newCipher, dataKey, tweakKey, unitTweak)
+		}
+		if err != nil {
+			return OperationResult{}, err
+		}
+		mode.CryptBlocks(out[pos:end], input[pos:end])
+		sector++
+	}
+	return symmetricOutput(op, out, req), nil
+}
+
+// sivAEAD is SIV mode (RFC 5297): S2V over CMAC authenticates the additional
+// data, the optional nonce and the plaintext, and the resulting synthetic IV
+// drives CTR. The first key half is the MAC key, the second the CTR key.
+type sivAEAD struct {
+	mac, ctr  cipher.Block
+	nonceSize int
+}
+
+func newSIV(spec blockCipherSpec, key []byte, nonceSize int) (cipher.AEAD, error) {
+	half := len(key) / 2
+	mac, err := spec.newCipher(key[:half])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := spec.newCipher(key[half:])
+	if err != nil {
+		return nil, err
+	}
+	if mac.BlockSize() != 16 {
+		return nil, errors.New("SIV requires a 128-bit block cipher")
+	}
+	return &sivAEAD{mac: mac, ctr: ctr, nonceSize: nonceSize}, nil
+}
+
+func (s *sivAEAD) NonceSize() int { return s.nonceSize }
+func (s *sivAEAD) Overhead() int  { return 16 }
+
+func (s *sivAEAD) s2v(additionalData, nonce, plaintext []byte) []byte {
+	d, _ := computeCMAC(s.mac, make([]byte, 16))
+	components := [][]byte{additionalData}
+	if s.nonceSize > 0 {
+		components = append(components, nonce)
+	}
+	for _, part := range components {
+		mac, _ := computeCMAC(s.mac, part)
+		d = cmacDouble(d, 0x87)
+		xorBytes(d, d, mac)
+	}
+	var t []byte
+	if len(plaintext) >= 16 {
+		t = append([]byte(nil), plaintext...)
+		tail := t[len(t)-16:]
+		xorBytes(tail, tail, d)
+	} else {
+		t = make([]byte, 16)
+		copy(t, plaintext)
+		t[len(plaintext)] = 0x80
+		xorBytes(t, t, cmacDouble(d, 0x87))
+	}
+	v, _ := computeCMAC(s.mac, t)
+	return v
+}
+
+func (s *sivAEAD) crypt(v, in []byte) []byte {
+	q := append([]byte(nil), v...)
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	out := make([]byte, len(in))
+	cipher.NewCTR(s.ctr, q).XORKeyStream(out, in)
+	return out
+}
+
+func (s *sivAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	v := s.s2v(additionalData, nonce, plaintext)
+	return append(append(dst, v...), s.crypt(v, plaintext)...)
+}
+
+func (s *sivAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < 16 {
+		return nil, errors.New("cipher: message authentication failed")
+	}
+	v := ciphertext[:16]
+	plain := s.crypt(v, ciphertext[16:])
+	if subtle.ConstantTimeCompare(s.s2v(additionalData, nonce, plain), v) != 1 {
+		return nil, errors.New("cipher: message authentication failed")
+	}
+	return append(dst, plain...), nil
+}
+
+// gcmSIV is AES-GCM-SIV (RFC 8452). Per-nonce authentication and encryption
+// keys are derived from the key-generating key; the tag is computed over the
+// plaintext with POLYVAL and then used as the initial CTR block. The same
+// construction is applied to SM4 with its 128-bit key.
+type gcmSIV struct {
+	spec blockCipherSpec
+	key  cipher.Block
+	size int // key length, which is also the derived encryption key length
+}
+
+func newGCMSIV(spec blockCipherSpec, key []byte, nonceSize int) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, errors.New("GCM-SIV key must be 16 or 32 bytes")
+	}
+	block, err := spec.newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if block.BlockSize() != 16 {
+		return nil, errors.New("GCM-SIV requires a 128-bit block cipher")
+	}
+	return &gcmSIV{spec: spec, key: block, size: len(key)}, nil
+}
+
+func (g *gcmSIV) NonceSize() int { return 12 }
+func (g *gcmSIV) Overhead() int  { return 16 }
+
+// deriveKeys returns the POLYVAL key and the encryption block cipher for nonce.
+func (g *gcmSIV) deriveKeys(nonce []byte) ([]byte, cipher.Block, error) {
+	derived := make([]byte, 0, 16+g.size)
+	in, out := make([]byte, 16), make([]byte, 16)
+	copy(in[4:], nonce)
+	for i := uint32(0); len(derived) < 16+g.size; i++ {
+		binary.LittleEndian.PutUint32(in, i)
+		g.key.Encrypt(out, in)
+		derived = append(derived, out[:8]...)
+	}
+	enc, err := g.spec.newCipher(derived[16:])
+	return derived[:16], enc, err
+}
+
+func (g *gcmSIV) tag(authKey []byte, enc cipher.Block, nonce, plaintext, additionalData []byte) []byte {
+	lengths := make([]byte, 16)
+	binary.LittleEndian.PutUint64(lengths, uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+	s := polyval(authKey, additionalData, plaintext, lengths)
+	xorBytes(s[:12], s[:12], nonce)
+	s[15] &= 0x7f
+	tag := make([]byte, 16)
+	enc.Encrypt(tag, s)
+	return tag
+}
+
+func (g *gcmSIV) crypt(enc cipher.Block, tag, in []byte) []byte {
+	counter := append([]byte(nil), tag...)
+	counter[15] |= 0x80
+	out := make([]byte, len(in))
+	keystream := make([]byte, 16)
+	for pos := 0; pos < len(in); pos += 16 {
+		enc.Encrypt(keystream, counter)
+		end := min(pos+16, len(in))
+		xorBytes(out[pos:end], in[pos:end], keystream)
+		binary.LittleEndian.PutUint32(counter, binary.LittleEndian.Uint32(counter)+1)
+	}
+	return out
+}
+
+// seal is Seal with the key derivation error returned; runAEADMode uses it
+// so that the failure is reported rather than lost.
+func (g *gcmSIV) seal(dst, nonce, plaintext, additionalData []byte) ([]byte, error) {
+	authKey, enc, err := g.deriveKeys(nonce)
+	if err != nil {
+		return nil, err
+	}
+	tag := g.tag(authKey, enc, nonce, plaintext, additionalData)
+	return append(append(dst, g.crypt(enc, tag, plaintext)...), tag...), nil
+}
+
+// Seal satisfies cipher.AEAD, which has no error return; it returns nil if
+// the per-nonce keys cannot be derived.
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	out, err := g.seal(dst, nonce, plaintext, additionalData)
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < 16 {
+		return nil, errors.New("cipher: message authentication failed")
+	}
+	authKey, enc, err := g.deriveKeys(nonce)
+	if err != nil {
+		return nil, err
+	}
+	body, tag := ciphertext[:len(ciphertext)-16], ciphertext[len(ciphertext)-16:]
+	plain := g.crypt(enc, tag, body)
+	if subtle.ConstantTimeCompare(g.tag(authKey, enc, nonce, plain, additionalData), tag) != 1 {
+		return nil, errors.New("cipher: message authentication failed")
+	}
+	return append(dst, plain...), nil
+}
+
+// polyval computes POLYVAL over the zero-padded inputs through the GHASH
+// identity of RFC 8452 Appendix A:
+// POLYVAL(H, X) = rev(GHASH(mulX(rev(H)), rev(X_1), ..., rev(X_n))).
+func polyval(key []byte, inputs ...[]byte) []byte {
+	rk := reverse16(key)
+	h := ghashMulX(rk[:])
+	var y [16]byte
+	block := make([]byte, 16)
+	for _, data := range inputs {
+		for pos := 0; pos < len(data); pos += 16 {
+			clear(block)
+			copy(block, data[pos:min(pos+16, len(data))])
+			x := reverse16(block)
+			xorBytes(y[:], y[:], x[:])
+			y = ghashMul(y, h)
+		}
+	}
+	out := reverse16(y[:])
+	return out[:]
+}
+
+func reverse16(in []byte) [16]byte {
+	var out [16]byte
+	for i := range out {
+		out[i] = in[15-i]
+	}
+	return out
+}
+
+// ghashMul multiplies in GF(2^128) with GCM's bit order (SP 800-38D, Algorithm 1).
+func ghashMul(x, y [16]byte) [16]byte {
+	var z [16]byte
+	v := y
+	for i := 0; i < 128; i++ {
+		if x[i/8]&(0x80>>(i%8)) != 0 {
+			xorBytes(z[:], z[:], v[:])
+		}
+		v = ghashMulX(v[:])
+	}
+	return z
+}
+
+func ghashMulX(in []byte) [16]byte {
+	var v [16]byte
+	lsb := in[15] & 1
+	for i := 15; i > 0; i-- {
+		v[i] = in[i]>>1 | in[i-1]<<7
+	}
+	v[0] = in[0] >> 1
+	if lsb != 0 {
+		v[0] ^= 0xE1
+	}
+	return v
+}
@@ -0,0 +1,193 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"math/bits"
+	"strconv"
+)
+
+// Camellia block cipher (RFC 3713), used by Japanese and ISO/IEC 18033-3
+// profiles. Keys are 128, 192 or 256 bits; 128-bit keys run 18 Feistel
+// rounds, longer keys 24, with FL/FL^-1 layers every six rounds.
+
+var camelliaSigma = [6]uint64{
+	0xA09E667F3BCC908B, 0xB67AE8584CAA73B2, 0xC6EF372FE94F82BE,
+	0x54FF53A5F1D36F1C, 0x10E527FADE682D1D, 0xB05688C2B3E6C1FD,
+}
+
+// camelliaSBox1 is SBOX1; SBOX2-4 are derived from it by rotations.
+var camelliaSBox1 = [256]byte{
+	0x70, 0x82, 0x2c, 0xec, 0xb3, 0x27, 0xc0, 0xe5, 0xe4, 0x85, 0x57, 0x35, 0xea, 0x0c, 0xae, 0x41,
+	0x23, 0xef, 0x6b, 0x93, 0x45, 0x19, 0xa5, 0x21, 0xed, 0x0e, 0x4f, 0x4e, 0x1d, 0x65, 0x92, 0xbd,
+	0x86, 0xb8, 0xaf, 0x8f, 0x7c, 0xeb, 0x1f, 0xce, 0x3e, 0x30, 0xdc, 0x5f, 0x5e, 0xc5, 0x0b, 0x1a,
+	0xa6, 0xe1, 0x39, 0xca, 0xd5, 0x47, 0x5d, 0x3d, 0xd9, 0x01, 0x5a, 0xd6, 0x51, 0x56, 0x6c, 0x4d,
+	0x8b, 0x0d, 0x9a, 0x66, 0xfb, 0xcc, 0xb0, 0x2d, 0x74, 0x12, 0x2b, 0x20, 0xf0, 0xb1, 0x84, 0x99,
+	0xdf, 0x4c, 0xcb, 0xc2, 0x34, 0x7e, 0x76, 0x05, 0x6d, 0xb7, 0xa9, 0x31, 0xd1, 0x17, 0x04, 0xd7,
+	0x14, 0x58, 0x3a, 0x61, 0xde, 0x1b, 0x11, 0x1c, 0x32, 0x0f, 0x9c, 0x16, 0x53, 0x18, 0xf2, 0x22,
+	0xfe, 0x44, 0xcf, 0xb2, 0xc3, 0xb5, 0x7a, 0x91, 0x24, 0x08, 0xe8, 0xa8, 0x60, 0xfc, 0x69, 0x50,
+	0xaa, 0xd0, 0xa0, 0x7d, 0xa1, 0x89, 0x62, 0x97, 0x54, 0x5b, 0x1e, 0x95, 0xe0, 0xff, 0x64, 0xd2,
+	0x10, 0xc4, 0x00, 0x48, 0xa3, 0xf7, 0x75, 0xdb, 0x8a, 0x03, 0xe6, 0xda, 0x09, 0x3f, 0xdd, 0x94,
+	0x87, 0x5c, 0x83, 0x02, 0xcd, 0x4a, 0x90, 0x33, 0x73, 0x67, 0xf6, 0xf3, 0x9d, 0x7f, 0xbf, 0xe2,
+	0x52, 0x9b, 0xd8, 0x26, 0xc8, 0x37, 0xc6, 0x3b, 0x81, 0x96, 0x6f, 0x4b, 0x13, 0xbe, 0x63, 0x2e,
+	0xe9, 0x79, 0xa7, 0x8c, 0x9f, 0x6e, 0xbc, 0x8e, 0x29, 0xf5, 0xf9, 0xb6, 0x2f, 0xfd, 0xb4, 0x59,
+	0x78, 0x98, 0x06, 0x6a, 0xe7, 0x46, 0x71, 0xba, 0xd4, 0x25, 0xab, 0x42, 0x88, 0xa2, 0x8d, 0xfa,
+	0x72, 0x07, 0xb9, 0x55, 0xf8, 0xee, 0xac, 0x0a, 0x36, 0x49, 0x2a, 0x68, 0x3c, 0x38, 0xf1, 0xa4,
+	0x40, 0x28, 0xd3, 0x7b, 0xbb, 0xc9, 0x43, 0xc1, 0x15, 0xe3, 0xad, 0xf4, 0x77, 0xc7, 0x80, 0x9e,
+}
+
+type camelliaKeySizeError int
+
+func (k camelliaKeySizeError) Error() string {
+	return "camellia: invalid key size " + strconv.Itoa(int(k))
+}
+
+type camelliaCipher struct {
+	kw [4]uint64
+	k  []uint64
+	ke []uint64
+	// Decryption subkeys: the same schedule in reverse order.
+	dkw [4]uint64
+	dk  []uint64
+	dke []uint64
+}
+
+func newCamelliaCipher(key []byte) (cipher.Block, error) {
+	var kl, kr [2]uint64
+	switch len(key) {
+	case 16:
+		kl = [2]uint64{binary.BigEndian.Uint64(key), binary.BigEndian.Uint64(key[8:])}
+	case 24:
+		kl = [2]uint64{binary.BigEndian.Uint64(key), binary.BigEndian.Uint64(key[8:])}
+		right := binary.BigEndian.Uint64(key[16:])
+		kr = [2]uint64{right, ^right}
+	case 32:
+		kl = [2]uint64{binary.BigEndian.Uint64(key), binary.BigEndian.Uint64(key[8:])}
+		kr = [2]uint64{binary.BigEndian.Uint64(key[16:]), binary.BigEndian.Uint64(key[24:])}
+	default:
+		return nil, camelliaKeySizeError(len(key))
+	}
+
+	d1, d2 := kl[0]^kr[0], kl[1]^kr[1]
+	d2 ^= camelliaF(d1, camelliaSigma[0])
+	d1 ^= camelliaF(d2, camelliaSigma[1])
+	d1 ^= kl[0]
+	d2 ^= kl[1]
+	d2 ^= camelliaF(d1, camelliaSigma[2])
+	d1 ^= camelliaF(d2, camelliaSigma[3])
+	ka := [2]uint64{d1, d2}
+
+	c := &camelliaCipher{}
+	if len(key) == 16 {
+		c.kw = [4]uint64{kl[0], kl[1], camelliaRot(ka, 111, 0), camelliaRot(ka, 111, 1)}
+		c.k = []uint64{
+			ka[0], ka[1], camelliaRot(kl, 15, 0), camelliaRot(kl, 15, 1), camelliaRot(ka, 15, 0), camelliaRot(ka, 15, 1),
+			camelliaRot(kl, 45, 0), camelliaRot(kl, 45, 1), camelliaRot(ka, 45, 0), camelliaRot(kl, 60, 1), camelliaRot(ka, 60, 0), camelliaRot(ka, 60, 1),
+			camelliaRot(kl, 94, 0), camelliaRot(kl, 94, 1), camelliaRot(ka, 94, 0), camelliaRot(ka, 94, 1), camelliaRot(kl, 111, 0), camelliaRot(kl, 111, 1),
+		}
+		c.ke = []uint64{camelliaRot(ka, 30, 0), camelliaRot(ka, 30, 1), camelliaRot(kl, 77, 0), camelliaRot(kl, 77, 1)}
+	} else {
+		d1, d2 = ka[0]^kr[0], ka[1]^kr[1]
+		d2 ^= camelliaF(d1, camelliaSigma[4])
+		d1 ^= camelliaF(d2, camelliaSigma[5])
+		kb := [2]uint64{d1, d2}
+		c.kw = [4]uint64{kl[0], kl[1], camelliaRot(kb, 111, 0), camelliaRot(kb, 111, 1)}
+		c.k = []uint64{
+			kb[0], kb[1], camelliaRot(kr, 15, 0), camelliaRot(kr, 15, 1), camelliaRot(ka, 15, 0), camelliaRot(ka, 15, 1),
+			camelliaRot(kb, 30, 0), camelliaRot(kb, 30, 1), camelliaRot(kl, 45, 0), camelliaRot(kl, 45, 1), camelliaRot(ka, 45, 0), camelliaRot(ka, 45, 1),
+			camelliaRot(kr, 60, 0), camelliaRot(kr, 60, 1), camelliaRot(kb, 60, 0), camelliaRot(kb, 60, 1), camelliaRot(kl, 77, 0), camelliaRot(kl, 77, 1),
+			camelliaRot(kr, 94, 0), camelliaRot(kr, 94, 1), camelliaRot(ka, 94, 0), camelliaRot(ka, 94, 1), camelliaRot(kl, 111, 0), camelliaRot(kl, 111, 1),
+		}
+		c.ke = []uint64{
+			camelliaRot(kr, 30, 0), camelliaRot(kr, 30, 1), camelliaRot(kl, 60, 0), camelliaRot(kl, 60, 1),
+			camelliaRot(ka, 77, 0), camelliaRot(ka, 77, 1),
+		}
+	}
+	c.dkw = [4]uint64{c.kw[2], c.kw[3], c.kw[0], c.kw[1]}
+	c.dk = make([]uint64, len(c.k))
+	for i, k := range c.k {
+		c.dk[len(c.k)-1-i] = k
+	}
+	c.dke = make([]uint64, len(c.ke))
+	for i, k := range c.ke {
+		c.dke[len(c.ke)-1-i] = k
+	}
+	return c, nil
+}
+
+// camelliaRot returns the high (half 0) or low (half 1) 64 bits of the
+// 128-bit value v rotated left by n bits.
+func camelliaRot(v [2]uint64, n uint, half int) uint64 {
+	hi, lo := v[0], v[1]
+	if n >= 64 {
+		hi, lo = lo, hi
+		n -= 64
+	}
+	if n > 0 {
+		hi, lo = hi<<n|lo>>(64-n), lo<<n|hi>>(64-n)
+	}
+	if half == 0 {
+		return hi
+	}
+	return lo
+}
+
+func camelliaF(in, key uint64) uint64 {
+	x := in ^ key
+	s := camelliaSBox1
+	t1 := s[byte(x>>56)]
+	t2 := bits.RotateLeft8(s[byte(x>>48)], 1)
+	t3 := bits.RotateLeft8(s[byte(x>>40)], 7)
+	t4 := s[bits.RotateLeft8(byte(x>>32), 1)]
+	t5 := bits.RotateLeft8(s[byte(x>>24)], 1)
+	t6 := bits.RotateLeft8(s[byte(x>>16)], 7)
+	t7 := s[bits.RotateLeft8(byte(x>>8), 1)]
+	t8 := s[byte(x)]
+	y1 := t1 ^ t3 ^ t4 ^ t6 ^ t7 ^ t8
+	y2 := t1 ^ t2 ^ t4 ^ t5 ^ t7 ^ t8
+	y3 := t1 ^ t2 ^ t3 ^ t5 ^ t6 ^ t8
+	y4 := t2 ^ t3 ^ t4 ^ t5 ^ t6 ^ t7
+	y5 := t1 ^ t2 ^ t6 ^ t7 ^ t8
+	y6 := t2 ^ t3 ^ t5 ^ t7 ^ t8
+	y7 := t3 ^ t4 ^ t5 ^ t6 ^ t8
+	y8 := t1 ^ t4 ^ t5 ^ t6 ^ t7
+	return uint64(y1)<<56 | uint64(y2)<<48 | uint64(y3)<<40 | uint64(y4)<<32 |
+		uint64(y5)<<24 | uint64(y6)<<16 | uint64(y7)<<8 | uint64(y8)
+}
+
+func camelliaFL(in, key uint64) uint64 {
+	x1, x2 := uint32(in>>32), uint32(in)
+	k1, k2 := uint32(key>>32), uint32(key)
+	x2 ^= bits.RotateLeft32(x1&k1, 1)
+	x1 ^= x2 | k2
+	return uint64(x1)<<32 | uint64(x2)
+}
+
+func camelliaFLInv(in, key uint64) uint64 {
+	y1, y2 := uint32(in>>32), uint32(in)
+	k1, k2 := uint32(key>>32), uint32(key)
+	y1 ^= y2 | k2
+	y2 ^= bits.RotateLeft32(y1&k1, 1)
+	return uint64(y1)<<32 | uint64(y2)
+}
+
+func (c *camelliaCipher) BlockSize() int { return 16 }
+
+func (c *camelliaCipher) Encrypt(dst, src []byte) { camelliaCrypt(dst, src, c.kw, c.k, c.ke) }
+
+func (c *camelliaCipher) Decrypt(dst, src []byte) { camelliaCrypt(dst, src, c.dkw, c.dk, c.dke) }
+
+func camelliaCrypt(dst, src []byte, kw [4]uint64, k, ke []uint64) {
+	d1 := binary.BigEndian.Uint64(src) ^ kw[0]
+	d2 := binary.BigEndian.Uint64(src[8:]) ^ kw[1]
+	for i := 0; i < len(k); i += 2 {
+		if i > 0 && i%6 == 0 {
+			d1 = camelliaFL(d1, ke[i/3-2])
+			d2 = camelliaFLInv(d2, ke[i/3-1])
+		}
+		d2 ^= camelliaF(d1, k[i])
+		d1 ^= camelliaF(d2, k[i+1])
+	}
+	binary.BigEndian.PutUint64(dst, d2^kw[2])
+	binary.BigEndian.PutUint64(dst[8:], d1^kw[3])
+}
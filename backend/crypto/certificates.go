@@ -32,15 +32,20 @@ func (c *CryptoService) ListCertificates() []CertRecord {
 // id: The unique identifier of the certificate.
 // Returns the updated list of certificates.
 func (c *CryptoService) DeleteCertificate(id string) []CertRecord {
-	certs := c.readCerts()
-	out := make([]CertRecord, 0, len(certs))
-	for _, crt := range certs {
-		if crt.ID == id {
-			continue
+	var out []CertRecord
+	err := c.updateCerts(storeCerts, func(certs []CertRecord) ([]CertRecord, error) {
+		out = make([]CertRecord, 0, len(certs))
+		for _, crt := range certs {
+			if crt.ID == id {
+				continue
+			}
+			out = append(out, crt)
 		}
-		out = append(out, crt)
+		return out, nil
+	})
+	if err != nil {
+		return c.readCerts()
 	}
-	c.writeCerts(out)
 	return out
 }
 
@@ -67,6 +72,9 @@ func (c *CryptoService) ParseCertificate(req CertParseRequest) (CertParseResult,
 // req: The CertIssueRequest containing parameters like algorithm and common name.
 // Returns a CertIssueResult with the issued certificate and keys, or an error.
 func (c *CryptoService) IssueCertificate(req CertIssueRequest) (CertIssueResult, error) {
+	if c.keyStoreLocked() {
+		return CertIssueResult{}, errKeyStoreLocked
+	}
 	switch strings.ToLower(req.Algorithm) {
 	case "rsa":
 		return c.issueRSACertificate(req)
@@ -111,7 +119,7 @@ func (c *CryptoService) issueRSACertificate(req CertIssueRequest) (CertIssueResu
 	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: must(x509.MarshalPKIXPublicKey(&leafKey.PublicKey))})
 	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(leafKey)})
 
-	storedKey := c.saveKey(StoredKey{
+	storedKey, err := c.saveKey(StoredKey{
 		ID:         uuidString(),
 		Name:       fmt.Sprintf("%s-key", req.CommonName),
 		Algorithm:  "RSA",
@@ -122,8 +130,11 @@ func (c *CryptoService) issueRSACertificate(req CertIssueRequest) (CertIssueResu
 		PublicPEM:  string(pubPEM),
 		CreatedAt:  time.Now(),
 	})
+	if err != nil {
+		return CertIssueResult{}, err
+	}
 
-	record := c.appendCertificate(CertRecord{
+	record, err := c.appendCertificate(CertRecord{
 		ID:        uuidString(),
 		Name:      req.CommonName,
 		Algorithm: "RSA",
@@ -137,6 +148,9 @@ func (c *CryptoService) issueRSACertificate(req CertIssueRequest) (CertIssueResu
 		Issuer:    map[string]string{"CN": rootCert.Subject.CommonName},
 		CreatedAt: time.Now(),
 	})
+	if err != nil {
+		return CertIssueResult{}, err
+	}
 
 	result := CertIssueResult{
 		Keys:         []*StoredKey{&storedKey},
@@ -197,7 +211,7 @@ func (c *CryptoService) issueSM2Certificate(req CertIssueRequest) (CertIssueResu
 	encPrivPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: must(smx509.MarshalSM2PrivateKey(encKey))})
 	encPubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: must(smx509.MarshalPKIXPublicKey(&encKey.PublicKey))})
 
-	signStored := c.saveKey(StoredKey{
+	signStored, err := c.saveKey(StoredKey{
 		ID:         uuidString(),
 		Name:       fmt.Sprintf("%s-sign", req.CommonName),
 		Algorithm:  "SM2",
@@ -209,7 +223,10 @@ func (c *CryptoService) issueSM2Certificate(req CertIssueRequest) (CertIssueResu
 		CreatedAt:  time.Now(),
 		Extra:      map[string]string{"variant": "sign"},
 	})
-	encStored := c.saveKey(StoredKey{
+	if err != nil {
+		return CertIssueResult{}, err
+	}
+	encStored, err := c.saveKey(StoredKey{
 		ID:         uuidString(),
 		Name:       fmt.Sprintf("%s-enc", req.CommonName),
 		Algorithm:  "SM2",
@@ -221,8 +238,11 @@ func (c *CryptoService) issueSM2Certificate(req CertIssueRequest) (CertIssueResu
 		CreatedAt:  time.Now(),
 		Extra:      map[string]string{"variant": "encrypt"},
 	})
+	if err != nil {
+		return CertIssueResult{}, err
+	}
 
-	signRecord := c.appendCertificate(CertRecord{
+	signRecord, err := c.appendCertificate(CertRecord{
 		ID:        uuidString(),
 		Name:      fmt.Sprintf("%s (签名)", req.CommonName),
 		Algorithm: "SM2",
@@ -236,8 +256,11 @@ func (c *CryptoService) issueSM2Certificate(req CertIssueRequest) (CertIssueResu
 		Issuer:    map[string]string{"CN": rootCert.Subject.CommonName},
 		CreatedAt: time.Now(),
 	})
+	if err != nil {
+		return CertIssueResult{}, err
+	}
 
-	encRecord := c.appendCertificate(CertRecord{
+	encRecord, err := c.appendCertificate(CertRecord{
 		ID:        uuidString(),
 		Name:      fmt.Sprintf("%s (加密)", req.CommonName),
 		Algorithm: "SM2",
@@ -251,6 +274,9 @@ func (c *CryptoService) issueSM2Certificate(req CertIssueRequest) (CertIssueResu
 		Issuer:    map[string]string{"CN": rootCert.Subject.CommonName},
 		CreatedAt: time.Now(),
 	})
+	if err != nil {
+		return CertIssueResult{}, err
+	}
 
 	result := CertIssueResult{
 		Keys:         []*StoredKey{&signStored, &encStored},
@@ -313,7 +339,7 @@ func (c *CryptoService) ensureRSARoot() (*rsa.PrivateKey, *x509.Certificate, *Ce
 	}
 	privPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
 	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: must(x509.MarshalPKIXPublicKey(&priv.PublicKey))})
-	stored := c.saveKey(StoredKey{
+	stored, err := c.saveKey(StoredKey{
 		ID:         uuidString(),
 		Name:       "RSA Root",
 		Algorithm:  "RSA",
@@ -324,6 +350,9 @@ func (c *CryptoService) ensureRSARoot() (*rsa.PrivateKey, *x509.Certificate, *Ce
 		PublicPEM:  string(pubPEM),
 		CreatedAt:  time.Now(),
 	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	record := CertRecord{
 		ID:        uuidString(),
 		Name:      "RSA Root CA",
@@ -338,7 +367,9 @@ func (c *CryptoService) ensureRSARoot() (*rsa.PrivateKey, *x509.Certificate, *Ce
 		Issuer:    map[string]string{"CN": template.Subject.CommonName},
 		CreatedAt: time.Now(),
 	}
-	record = c.appendCertificate(record)
+	if record, err = c.appendCertificate(record); err != nil {
+		return nil, nil, nil, err
+	}
 	parsed, err := x509.ParseCertificate(der)
 	if err != nil {
 		return nil, nil, nil, err
@@ -397,7 +428,7 @@ func (c *CryptoService) ensureSM2Root() (*sm2.PrivateKey, *smx509.Certificate, *
 	}
 	privPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: must(smx509.MarshalSM2PrivateKey(priv))})
 	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: must(smx509.MarshalPKIXPublicKey(&priv.PublicKey))})
-	stored := c.saveKey(StoredKey{
+	stored, err := c.saveKey(StoredKey{
 		ID:         uuidString(),
 		Name:       "SM2 Root",
 		Algorithm:  "SM2",
@@ -409,6 +440,9 @@ func (c *CryptoService) ensureSM2Root() (*sm2.PrivateKey, *smx509.Certificate, *
 		CreatedAt:  time.Now(),
 		Extra:      map[string]string{"variant": "sign"},
 	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	record := CertRecord{
 		ID:        uuidString(),
 		Name:      "SM2 Root CA",
@@ -423,7 +457,9 @@ func (c *CryptoService) ensureSM2Root() (*sm2.PrivateKey, *smx509.Certificate, *
 		Issuer:    map[string]string{"CN": template.Subject.CommonName},
 		CreatedAt: time.Now(),
 	}
-	record = c.appendCertificate(record)
+	if record, err = c.appendCertificate(record); err != nil {
+		return nil, nil, nil, err
+	}
 	parsed, err := smx509.ParseCertificate(der)
 	if err != nil {
 		return nil, nil, nil, err
@@ -431,11 +467,11 @@ func (c *CryptoService) ensureSM2Root() (*sm2.PrivateKey, *smx509.Certificate, *
 	return priv, parsed, &record, nil
 }
 
-func (c *CryptoService) appendCertificate(record CertRecord) CertRecord {
-	certs := c.readCerts()
-	certs = append(certs, record)
-	c.writeCerts(certs)
-	return record
+func (c *CryptoService) appendCertificate(record CertRecord) (CertRecord, error) {
+	err := c.updateCerts(storeCerts, func(certs []CertRecord) ([]CertRecord, error) {
+		return append(certs, record), nil
+	})
+	return record, err
 }
 
 func buildCertResult(subject pkix.Name, issuer pkix.Name, serial *big.Int, notBefore, notAfter time.Time, dns, emails []string, ips []net.IP, uris []*url.URL, keyUsage x509.KeyUsage, ext []x509.ExtKeyUsage, raw []byte, sigAlg x509.SignatureAlgorithm, pubAlg x509.PublicKeyAlgorithm) CertParseResult {
@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"crypto/elliptic"
+	"encoding/asn1"
 	"fmt"
 	"strings"
 
@@ -14,6 +15,7 @@ type eccCurveInfo struct {
 	Display    string
 	Family     string
 	Curve      elliptic.Curve
+	OID        asn1.ObjectIdentifier
 }
 
 var eccCurveRegistry = map[string]eccCurveInfo{}
@@ -24,6 +26,7 @@ func init() {
 		Display:    "NIST P-224",
 		Family:     "NIST P",
 		Curve:      elliptic.P224(),
+		OID:        asn1.ObjectIdentifier{1, 3, 132, 0, 33},
 	}, "p-224", "p224", "secp224r1", "nistp224")
 
 	registerECCCurve(eccCurveInfo{
@@ -31,6 +34,7 @@ func init() {
 		Display:    "NIST P-256",
 		Family:     "NIST P",
 		Curve:      elliptic.P256(),
+		OID:        asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7},
 	}, "p-256", "p256", "prime256v1", "secp256r1", "nistp256")
 
 	registerECCCurve(eccCurveInfo{
@@ -38,6 +42,7 @@ func init() {
 		Display:    "NIST P-384",
 		Family:     "NIST P",
 		Curve:      elliptic.P384(),
+		OID:        asn1.ObjectIdentifier{1, 3, 132, 0, 34},
 	}, "p-384", "p384", "secp384r1", "nistp384")
 
 	registerECCCurve(eccCurveInfo{
@@ -45,6 +50,7 @@ func init() {
 		Display:    "NIST P-521",
 		Family:     "NIST P",
 		Curve:      elliptic.P521(),
+		OID:        asn1.ObjectIdentifier{1, 3, 132, 0, 35},
 	}, "p-521", "p521", "secp521r1", "nistp521")
 
 	registerECCCurve(eccCurveInfo{
@@ -52,6 +58,7 @@ func init() {
 		Display:    "SECG secp256k1",
 		Family:     "SECG",
 		Curve:      bitcurves.S256(),
+		OID:        asn1.ObjectIdentifier{1, 3, 132, 0, 10},
 	}, "k-256", "nistk256")
 
 	registerECCCurve(eccCurveInfo{
@@ -59,6 +66,7 @@ func init() {
 		Display:    "SECG secp224k1",
 		Family:     "SECG",
 		Curve:      bitcurves.S224(),
+		OID:        asn1.ObjectIdentifier{1, 3, 132, 0, 32},
 	}, "k-224", "nistk224")
 
 	registerECCCurve(eccCurveInfo{
@@ -66,6 +74,7 @@ func init() {
 		Display:    "SECG secp192k1",
 		Family:     "SECG",
 		Curve:      bitcurves.S192(),
+		OID:        asn1.ObjectIdentifier{1, 3, 132, 0, 31},
 	}, "k-192", "nistk192")
 
 	registerECCCurve(eccCurveInfo{
@@ -73,6 +82,7 @@ func init() {
 		Display:    "Brainpool P256r1",
 		Family:     "Brainpool",
 		Curve:      brainpool.P256r1(),
+		OID:        asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 7},
 	}, "brainpoolp256r1")
 
 	registerECCCurve(eccCurveInfo{
@@ -80,6 +90,7 @@ func init() {
 		Display:    "Brainpool P384r1",
 		Family:     "Brainpool",
 		Curve:      brainpool.P384r1(),
+		OID:        asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 11},
 	}, "brainpoolp384r1")
 
 	registerECCCurve(eccCurveInfo{
@@ -87,6 +98,7 @@ func init() {
 		Display:    "Brainpool P512r1",
 		Family:     "Brainpool",
 		Curve:      brainpool.P512r1(),
+		OID:        asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 13},
 	}, "brainpoolp512r1")
 
 	registerECCCurve(eccCurveInfo{
@@ -94,6 +106,7 @@ func init() {
 		Display:    "Brainpool P256t1",
 		Family:     "Brainpool",
 		Curve:      brainpool.P256t1(),
+		OID:        asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 8},
 	}, "brainpoolp256t1")
 
 	registerECCCurve(eccCurveInfo{
@@ -101,6 +114,7 @@ func init() {
 		Display:    "Brainpool P384t1",
 		Family:     "Brainpool",
 		Curve:      brainpool.P384t1(),
+		OID:        asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 12},
 	}, "brainpoolp384t1")
 
 	registerECCCurve(eccCurveInfo{
@@ -108,6 +122,7 @@ func init() {
 		Display:    "Brainpool P512t1",
 		Family:     "Brainpool",
 		Curve:      brainpool.P512t1(),
+		OID:        asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 14},
 	}, "brainpoolp512t1")
 
 	// X9.62 aliases that map to NIST curves.
@@ -171,3 +186,21 @@ func describeCurveByParamsName(paramsName string) (eccCurveInfo, bool) {
 	info, ok := eccCurveRegistry[normalizeCurveName(paramsName)]
 	return info, ok
 }
+
+func describeCurveByOID(oid asn1.ObjectIdentifier) (eccCurveInfo, bool) {
+	for _, info := range eccCurveRegistry {
+		if info.OID.Equal(oid) {
+			return info, true
+		}
+	}
+	return eccCurveInfo{}, false
+}
+
+func describeCurve(curve elliptic.Curve) (eccCurveInfo, bool) {
+	for _, info := range eccCurveRegistry {
+		if info.Curve == curve {
+			return info, true
+		}
+	}
+	return describeCurveByParamsName(curve.Params().Name)
+}
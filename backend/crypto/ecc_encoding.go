@@ -0,0 +1,236 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+// crypto/x509 only knows the NIST curves. The helpers below fall back to
+// encoding SEC 1 / PKIX structures by hand for the other registered curves
+// (secp256k1, brainpool, ...), using the OIDs from eccCurveRegistry.
+
+var oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+type sec1ECPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+type pkcs8PrivateKeyInfo struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// marshalECPrivateKey encodes an EC private key as SEC 1 DER.
+func marshalECPrivateKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	if der, err := x509.MarshalECPrivateKey(key); err == nil {
+		return der, nil
+	}
+	info, ok := describeCurve(key.Curve)
+	if !ok || info.OID == nil {
+		return nil, errors.New("unsupported elliptic curve")
+	}
+	size := (key.Curve.Params().N.BitLen() + 7) / 8
+	point := encodeECPoint(key.Curve, key.X, key.Y)
+	return asn1.Marshal(sec1ECPrivateKey{
+		Version:       1,
+		PrivateKey:    key.D.FillBytes(make([]byte, size)),
+		NamedCurveOID: info.OID,
+		PublicKey:     asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+}
+
+// marshalECPublicKey encodes an EC public key as a PKIX SubjectPublicKeyInfo.
+func marshalECPublicKey(pub *ecdsa.PublicKey) ([]byte, error) {
+	if der, err := x509.MarshalPKIXPublicKey(pub); err == nil {
+		return der, nil
+	}
+	info, ok := describeCurve(pub.Curve)
+	if !ok || info.OID == nil {
+		return nil, errors.New("unsupported elliptic curve")
+	}
+	params, err := asn1.Marshal(info.OID)
+	if err != nil {
+		return nil, err
+	}
+	point := encodeECPoint(pub.Curve, pub.X, pub.Y)
+	return asn1.Marshal(subjectPublicKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+}
+
+// parseECPrivateKeyDER parses a SEC 1 or PKCS#8 EC private key on any registered curve.
+func parseECPrivateKeyDER(der []byte) (*ecdsa.PrivateKey, error) {
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if parsed, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		key, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not an EC private key")
+		}
+		return key, nil
+	}
+	var p8 pkcs8PrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &p8); err == nil {
+		if !p8.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+			return nil, errors.New("not an EC private key")
+		}
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(p8.Algo.Parameters.FullBytes, &oid); err != nil {
+			return nil, errors.New("invalid EC curve parameters")
+		}
+		return parseSEC1PrivateKey(oid, p8.PrivateKey)
+	}
+	return parseSEC1PrivateKey(nil, der)
+}
+
+func parseSEC1PrivateKey(oid asn1.ObjectIdentifier, der []byte) (*ecdsa.PrivateKey, error) {
+	var sec1 sec1ECPrivateKey
+	if _, err := asn1.Unmarshal(der, &sec1); err != nil {
+		return nil, errors.New("invalid EC private key")
+	}
+	if len(sec1.NamedCurveOID) > 0 {
+		oid = sec1.NamedCurveOID
+	}
+	info, ok := describeCurveByOID(oid)
+	if !ok {
+		return nil, errors.New("unsupported elliptic curve")
+	}
+	d := new(big.Int).SetBytes(sec1.PrivateKey)
+	n := info.Curve.Params().N
+	if d.Sign() <= 0 || d.Cmp(n) >= 0 {
+		return nil, errors.New("EC private key out of range")
+	}
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.Curve = info.Curve
+	priv.X, priv.Y = info.Curve.ScalarBaseMult(d.FillBytes(make([]byte, (n.BitLen()+7)/8)))
+	return priv, nil
+}
+
+// parseECPublicKeyDER parses a PKIX EC public key on any registered curve.
+func parseECPublicKeyDER(der []byte) (*ecdsa.PublicKey, error) {
+	if parsed, err := x509.ParsePKIXPublicKey(der); err == nil {
+		key, ok := parsed.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.New("not an EC public key")
+		}
+		return key, nil
+	}
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, errors.New("invalid EC public key")
+	}
+	if !spki.Algorithm.Algorithm.Equal(oidPublicKeyECDSA) {
+		return nil, errors.New("not an EC public key")
+	}
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(spki.Algorithm.Parameters.FullBytes, &oid); err != nil {
+		return nil, errors.New("invalid EC curve parameters")
+	}
+	info, ok := describeCurveByOID(oid)
+	if !ok {
+		return nil, errors.New("unsupported elliptic curve")
+	}
+	x, y, err := decodeECPoint(info.Curve, spki.PublicKey.RightAlign())
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: info.Curve, X: x, Y: y}, nil
+}
+
+// encodeECPoint returns the uncompressed SEC 1 encoding 04||X||Y.
+func encodeECPoint(curve elliptic.Curve, x, y *big.Int) []byte {
+	size := (curve.Params().BitSize + 7) / 8
+	out := make([]byte, 1+2*size)
+	out[0] = 0x04
+	x.FillBytes(out[1 : 1+size])
+	y.FillBytes(out[1+size:])
+	return out
+}
+
+// encodeECPointCompressed returns the compressed SEC 1 encoding 02/03||X.
+func encodeECPointCompressed(curve elliptic.Curve, x, y *big.Int) []byte {
+	size := (curve.Params().BitSize + 7) / 8
+	out := make([]byte, 1+size)
+	out[0] = 0x02 | byte(y.Bit(0))
+	x.FillBytes(out[1:])
+	return out
+}
+
+// decodeECPoint parses an uncompressed or compressed SEC 1 point and checks
+// it is on the curve.
+func decodeECPoint(curve elliptic.Curve, data []byte) (*big.Int, *big.Int, error) {
+	size := (curve.Params().BitSize + 7) / 8
+	var x, y *big.Int
+	switch {
+	case len(data) == 1+2*size && data[0] == 0x04:
+		x = new(big.Int).SetBytes(data[1 : 1+size])
+		y = new(big.Int).SetBytes(data[1+size:])
+	case len(data) == 1+size && (data[0] == 0x02 || data[0] == 0x03):
+		x = new(big.Int).SetBytes(data[1:])
+		y = decompressECPoint(curve, x, uint(data[0]&1))
+		if y == nil {
+			return nil, nil, errors.New("EC point is not on the curve")
+		}
+	default:
+		return nil, nil, errors.New("unsupported EC point encoding")
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, nil, errors.New("EC point is not on the curve")
+	}
+	return x, y, nil
+}
+
+// decompressECPoint solves y^2 = x^3 + ax + b for the root with the given
+// parity.
+func decompressECPoint(curve elliptic.Curve, x *big.Int, parity uint) *big.Int {
+	p := curve.Params().P
+	if x.Cmp(p) >= 0 {
+		return nil
+	}
+	a, b := curveCoefficients(curve)
+	rhs := new(big.Int).Exp(x, big.NewInt(3), p)
+	rhs.Add(rhs, new(big.Int).Mul(a, x)).Add(rhs, b).Mod(rhs, p)
+	y := new(big.Int).ModSqrt(rhs, p)
+	if y == nil {
+		return nil
+	}
+	if y.Bit(0) != parity {
+		y.Sub(p, y)
+	}
+	return y
+}
+
+// curveCoefficients returns a and b. CurveParams has no a (crypto/elliptic
+// assumes -3) and the secp256k1 and brainpool implementations leave B unset,
+// so both are solved from the points G and 2G.
+func curveCoefficients(curve elliptic.Curve) (*big.Int, *big.Int) {
+	params := curve.Params()
+	p := params.P
+	x2, y2 := curve.Double(params.Gx, params.Gy)
+	rhs := func(x, y *big.Int) *big.Int { // y^2 - x^3 = ax + b
+		v := new(big.Int).Mul(y, y)
+		return v.Sub(v, new(big.Int).Exp(x, big.NewInt(3), p))
+	}
+	r1, r2 := rhs(params.Gx, params.Gy), rhs(x2, y2)
+	dx := new(big.Int).Sub(params.Gx, x2)
+	a := new(big.Int).Sub(r1, r2)
+	a.Mul(a, dx.ModInverse(dx.Mod(dx, p), p)).Mod(a, p)
+	b := new(big.Int).Sub(r1, new(big.Int).Mul(a, params.Gx))
+	return a, b.Mod(b, p)
+}
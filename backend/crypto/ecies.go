@@ -0,0 +1,533 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ECIES profiles that interoperate with other implementations, next to the
+// ad hoc dhaes/ecies modes of eccEncrypt. Every profile writes the ephemeral
+// public key first, uncompressed unless PointFormat asks for compressed.
+//
+//   - sec1: SEC 1 v2 section 5.1 in non-DHAES mode. X9.63 KDF over the
+//     x-coordinate with SharedInfo as SharedInfo1, XOR, AES-CBC or AES-CTR
+//     with a zero IV, HMAC over the ciphertext. R || EM || D.
+//   - iso18033-2: ECIES-KEM (KDF2 over C0 || PEH, no cofactor or single hash
+//     mode) with DEM3, a one-time pad and HMAC over the ciphertext with an
+//     empty label. C0 || c || T.
+//   - bouncycastle: the IESEngine behind Bouncy Castle's "ECIES" cipher with
+//     no IESParameterSpec: KDF2-SHA1 over V || Z, a 16-byte HMAC-SHA1 key
+//     taken before the XOR key stream, HMAC over C || L2 where L2 is the
+//     8-byte bit length of the absent encoding parameter P2. V || C || T.
+//   - eciespy: eciespy/eciesgo. HKDF-SHA256 over the uncompressed ephemeral
+//     and shared points, AES-256-GCM with a 16-byte nonce. R || nonce || tag || c.
+//   - eth-ecies: go-ethereum crypto/ecies as used by devp2p, with the
+//     parameters of its ParamsFromCurve: AES-128 and SHA-256 on secp256k1 and
+//     P-256, AES-192 and SHA-384 on P-384, AES-256 and SHA-512 on P-521. SP
+//     800-56 concat KDF, AES-CTR with a random IV and HMAC keyed with the hash
+//     of the MAC key. R || IV || c || tag.
+
+// eciesProfile describes the KDF/XOR-or-block-cipher/HMAC family shared by
+// the SEC 1, ISO 18033-2 and Bouncy Castle profiles.
+type eciesProfile struct {
+	name       string
+	compressed bool
+	kdfOverR   bool // KDF input is R || Z rather than Z
+	macFirst   bool // key material is the MAC key followed by the encryption key
+	macLength  bool // MAC input ends with the 8-byte length of P2
+	symmetric  string
+	kdfHash    hashSpec
+	macHash    hashSpec
+	macKeyLen  int
+	sharedInfo []byte
+}
+
+// eciesProfileName maps an EccMode onto an interoperable profile, if any.
+func eciesProfileName(mode string) (string, bool) {
+	switch strings.ReplaceAll(strings.ToLower(strings.TrimSpace(mode)), "_", "-") {
+	case "sec1", "sec1-ecies", "secg":
+		return "sec1", true
+	case "iso18033-2", "iso-18033-2", "ecies-kem", "iso":
+		return "iso18033-2", true
+	case "bouncycastle", "bc", "bc-ies", "bouncy-castle":
+		return "bouncycastle", true
+	case "eciespy", "ecies-py", "eciesgo", "ecies-go":
+		return "eciespy", true
+	case "eth-ecies", "eth", "geth", "devp2p", "ethereum":
+		return "eth-ecies", true
+	default:
+		return "", false
+	}
+}
+
+func resolveECIESProfile(req AsymmetricRequest) (eciesProfile, error) {
+	name, _ := eciesProfileName(req.EccMode)
+	p := eciesProfile{name: name}
+	switch strings.ToLower(strings.TrimSpace(req.PointFormat)) {
+	case "", "uncompressed":
+	case "compressed":
+		p.compressed = true
+	default:
+		return eciesProfile{}, fmt.Errorf("unsupported point format: %s", req.PointFormat)
+	}
+	sharedInfo, err := decodeOptionalHex(req.SharedInfo, "shared info")
+	if err != nil {
+		return eciesProfile{}, err
+	}
+	p.sharedInfo = sharedInfo
+	if len(sharedInfo) > 0 && (name == "iso18033-2" || name == "eciespy") {
+		return eciesProfile{}, fmt.Errorf("%s profile takes no shared info", name)
+	}
+	if name == "eciespy" || name == "eth-ecies" {
+		return p, nil
+	}
+
+	kdfDefault, macDefault, symDefault := "sha256", "hmac-sha256", "xor"
+	switch name {
+	case "sec1":
+		symDefault = "aes-128-ctr"
+	case "iso18033-2":
+		p.kdfOverR = true
+	case "bouncycastle":
+		p.kdfOverR, p.macFirst, p.macLength = true, true, true
+		kdfDefault, macDefault, p.macKeyLen = "sha1", "hmac-sha1", 16
+	}
+	if p.kdfHash, err = digestHash(req.KDF, kdfDefault); err != nil {
+		return eciesProfile{}, err
+	}
+	mac := req.MacAlgorithm
+	if strings.TrimSpace(mac) == "" {
+		mac = macDefault
+	}
+	if p.macHash, err = resolveMACAlgorithm(mac); err != nil {
+		return eciesProfile{}, err
+	}
+	if p.macKeyLen == 0 {
+		p.macKeyLen = p.macHash.size()
+	}
+	p.symmetric = strings.ToLower(strings.TrimSpace(req.SymmetricCipher))
+	if p.symmetric == "" {
+		p.symmetric = symDefault
+	}
+	if p.symmetric == "xor" {
+		return p, nil
+	}
+	if name != "sec1" {
+		return eciesProfile{}, fmt.Errorf("%s profile only supports the XOR cipher", name)
+	}
+	if _, err := eciesAESKeyLen(p.symmetric); err != nil {
+		return eciesProfile{}, err
+	}
+	return p, nil
+}
+
+// eciesAESKeyLen parses aes-128-cbc, aes-256-ctr and the like.
+func eciesAESKeyLen(name string) (int, error) {
+	switch name {
+	case "aes-128-cbc", "aes-128-ctr":
+		return 16, nil
+	case "aes-192-cbc", "aes-192-ctr":
+		return 24, nil
+	case "aes-256-cbc", "aes-256-ctr":
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("unsupported ECIES cipher: %s", name)
+	}
+}
+
+func (p eciesProfile) encodePoint(pub *ecdsa.PublicKey) []byte {
+	if p.compressed {
+		return encodeECPointCompressed(pub.Curve, pub.X, pub.Y)
+	}
+	return encodeECPoint(pub.Curve, pub.X, pub.Y)
+}
+
+// splitECIESCiphertext separates the leading ephemeral point, compressed or
+// not, from the rest of the message.
+func splitECIESCiphertext(priv *ecdsa.PrivateKey, data []byte) (*ecdsa.PublicKey, []byte, []byte, error) {
+	size := (priv.Curve.Params().BitSize + 7) / 8
+	if len(data) == 0 {
+		return nil, nil, nil, errors.New("invalid ECIES ciphertext")
+	}
+	n := 1 + 2*size
+	if data[0] == 0x02 || data[0] == 0x03 {
+		n = 1 + size
+	}
+	if len(data) < n {
+		return nil, nil, nil, errors.New("invalid ECIES ciphertext")
+	}
+	x, y, err := decodeECPoint(priv.Curve, data[:n])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid ECIES ephemeral key: %w", err)
+	}
+	return &ecdsa.PublicKey{Curve: priv.Curve, X: x, Y: y}, data[:n], data[n:], nil
+}
+
+// runECIESProfile encrypts or decrypts with one of the interoperable profiles.
+func runECIESProfile(op string, mat keyMaterial, req AsymmetricRequest, payload []byte) (OperationResult, error) {
+	p, err := resolveECIESProfile(req)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	var pub *ecdsa.PublicKey
+	var priv *ecdsa.PrivateKey
+	if op == "encrypt" {
+		pub, err = ensureECCPublic(mat)
+	} else if priv, err = parseECCPrivate(mat.privatePEM); err == nil {
+		pub = &priv.PublicKey
+	}
+	if err != nil {
+		return OperationResult{}, err
+	}
+	outputFormat := normalizeOutputFormat(req.OutputFormat)
+	details := map[string]string{"mode": p.name}
+	switch p.name {
+	case "eciespy":
+		details["cipher"], details["kdf"] = "aes-256-gcm", "hkdf-sha256"
+	case "eth-ecies":
+		params, err := ethECIESParamsFor(pub.Curve)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		details["cipher"] = fmt.Sprintf("aes-%d-ctr", params.keyLen*8)
+		details["kdf"], details["mac"] = "concat-"+params.hash.name, "hmac-"+params.hash.name
+	default:
+		details["cipher"], details["kdf"], details["mac"] = p.symmetric, p.kdfHash.name, "hmac-"+p.macHash.name
+	}
+	if op == "encrypt" {
+		output, err := eciesProfileEncrypt(pub, payload, p)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		details["base64"] = encodeBase64(output)
+		details["pointFormat"] = "uncompressed"
+		if p.compressed {
+			details["pointFormat"] = "compressed"
+		}
+		return OperationResult{Output: encodeOutputBytes(output, outputFormat), Details: details}, nil
+	}
+	plaintext, err := eciesProfileDecrypt(priv, payload, p)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	details["text"] = string(plaintext)
+	details["base64"] = encodeBase64(plaintext)
+	return OperationResult{Output: encodeOutputBytes(plaintext, outputFormat), Details: details}, nil
+}
+
+func eciesProfileEncrypt(pub *ecdsa.PublicKey, plaintext []byte, p eciesProfile) ([]byte, error) {
+	ephemeral, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	z, err := ecdhSharedSecret(ephemeral, pub)
+	if err != nil {
+		return nil, err
+	}
+	r := p.encodePoint(&ephemeral.PublicKey)
+	var body []byte
+	switch p.name {
+	case "eciespy":
+		body, err = eciesPyEncrypt(ephemeral, pub, plaintext)
+	case "eth-ecies":
+		body, err = ethECIESEncrypt(pub.Curve, z, plaintext, p.sharedInfo)
+	default:
+		body, err = p.encrypt(r, z, plaintext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return concatBytes(r, body), nil
+}
+
+func eciesProfileDecrypt(priv *ecdsa.PrivateKey, ciphertext []byte, p eciesProfile) ([]byte, error) {
+	ephemeral, r, body, err := splitECIESCiphertext(priv, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	z, err := ecdhSharedSecret(priv, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	switch p.name {
+	case "eciespy":
+		return eciesPyDecrypt(priv, ephemeral, body)
+	case "eth-ecies":
+		return ethECIESDecrypt(priv.Curve, z, body, p.sharedInfo)
+	default:
+		return p.decrypt(r, z, body)
+	}
+}
+
+// keys runs the KDF and splits the result into encryption and MAC keys.
+func (p eciesProfile) keys(r, z []byte, encLen int) (encKey, macKey []byte, err error) {
+	input := z
+	if p.kdfOverR {
+		input = concatBytes(r, z)
+	}
+	// SEC 1 SharedInfo1 and Bouncy Castle's derivation vector P1 both follow
+	// the counter in the hash input.
+	kdf := agreementKDF{kind: "x963", newHash: p.kdfHash.newHash}
+	k, err := kdf.derive(input, encLen+p.macKeyLen, p.sharedInfo, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.macFirst {
+		return k[p.macKeyLen:], k[:p.macKeyLen], nil
+	}
+	return k[:encLen], k[encLen:], nil
+}
+
+// tag computes the HMAC over the ciphertext. Bouncy Castle appends L2, the
+// 8-byte big-endian bit length of P2, which is all zeros as P2 is never set.
+func (p eciesProfile) tag(macKey, c []byte) ([]byte, error) {
+	if p.macLength {
+		c = concatBytes(c, make([]byte, 8))
+	}
+	return computeHMAC(p.macHash, macKey, c)
+}
+
+func (p eciesProfile) encrypt(r, z, plaintext []byte) ([]byte, error) {
+	if p.symmetric == "xor" {
+		encKey, macKey, err := p.keys(r, z, len(plaintext))
+		if err != nil {
+			return nil, err
+		}
+		c := make([]byte, len(plaintext))
+		xorBytes(c, plaintext, encKey)
+		tag, err := p.tag(macKey, c)
+		return concatBytes(c, tag), err
+	}
+	keyLen, _ := eciesAESKeyLen(p.symmetric)
+	encKey, macKey, err := p.keys(r, z, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	var c []byte
+	if strings.HasSuffix(p.symmetric, "-cbc") {
+		c = applyPadding(plaintext, aes.BlockSize, "pkcs7")
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(c, c)
+	} else {
+		c = make([]byte, len(plaintext))
+		cipher.NewCTR(block, iv).XORKeyStream(c, plaintext)
+	}
+	tag, err := p.tag(macKey, c)
+	return concatBytes(c, tag), err
+}
+
+func (p eciesProfile) decrypt(r, z, body []byte) ([]byte, error) {
+	tagLen := p.macHash.size()
+	if len(body) < tagLen {
+		return nil, errors.New("invalid ECIES ciphertext")
+	}
+	c, tag := body[:len(body)-tagLen], body[len(body)-tagLen:]
+	encLen := len(c)
+	if p.symmetric != "xor" {
+		encLen, _ = eciesAESKeyLen(p.symmetric)
+	}
+	encKey, macKey, err := p.keys(r, z, encLen)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := p.tag(macKey, c)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(expected, tag) {
+		return nil, errors.New("invalid MAC for ECIES message")
+	}
+	plain := make([]byte, len(c))
+	if p.symmetric == "xor" {
+		xorBytes(plain, c, encKey)
+		return plain, nil
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if strings.HasSuffix(p.symmetric, "-ctr") {
+		cipher.NewCTR(block, iv).XORKeyStream(plain, c)
+		return plain, nil
+	}
+	if len(c) == 0 || len(c)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is not block aligned")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, c)
+	return removePadding(plain, aes.BlockSize, "pkcs7")
+}
+
+const eciesPyNonceSize = 16
+
+// eciesPyKey is HKDF-SHA256 with no salt or info over the uncompressed
+// ephemeral public key and shared point, whatever the wire format.
+func eciesPyKey(ephemeral, shared *ecdsa.PublicKey) ([]byte, error) {
+	master := concatBytes(encodeECPoint(ephemeral.Curve, ephemeral.X, ephemeral.Y), encodeECPoint(shared.Curve, shared.X, shared.Y))
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, nil, nil), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func eciesPyShared(priv *ecdsa.PrivateKey, peer *ecdsa.PublicKey) *ecdsa.PublicKey {
+	x, y := priv.Curve.ScalarMult(peer.X, peer.Y, priv.D.Bytes())
+	return &ecdsa.PublicKey{Curve: priv.Curve, X: x, Y: y}
+}
+
+func eciesPyGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, eciesPyNonceSize)
+}
+
+func eciesPyEncrypt(ephemeral *ecdsa.PrivateKey, pub *ecdsa.PublicKey, plaintext []byte) ([]byte, error) {
+	key, err := eciesPyKey(&ephemeral.PublicKey, eciesPyShared(ephemeral, pub))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := eciesPyGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, eciesPyNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nil, nonce, plaintext, nil)
+	c, tag := sealed[:len(plaintext)], sealed[len(plaintext):]
+	return concatBytes(nonce, tag, c), nil
+}
+
+func eciesPyDecrypt(priv *ecdsa.PrivateKey, ephemeral *ecdsa.PublicKey, body []byte) ([]byte, error) {
+	key, err := eciesPyKey(ephemeral, eciesPyShared(priv, ephemeral))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := eciesPyGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < eciesPyNonceSize+gcm.Overhead() {
+		return nil, errors.New("invalid ECIES ciphertext")
+	}
+	nonce, tag, c := body[:eciesPyNonceSize], body[eciesPyNonceSize:eciesPyNonceSize+gcm.Overhead()], body[eciesPyNonceSize+gcm.Overhead():]
+	return gcm.Open(nil, nonce, concatBytes(c, tag), nil)
+}
+
+// ethECIESParams is the symmetric key length and hash go-ethereum's
+// ParamsFromCurve picks for a curve.
+type ethECIESParams struct {
+	keyLen int
+	hash   hashSpec
+}
+
+func ethECIESParamsFor(curve elliptic.Curve) (ethECIESParams, error) {
+	info, _ := describeCurve(curve)
+	var params ethECIESParams
+	hashName := ""
+	switch info.Identifier {
+	case "secp256k1", "nistp256":
+		params.keyLen, hashName = 16, "sha256"
+	case "nistp384":
+		params.keyLen, hashName = 24, "sha384"
+	case "nistp521":
+		params.keyLen, hashName = 32, "sha512"
+	default:
+		return ethECIESParams{}, fmt.Errorf("eth-ecies profile does not support curve %s", curve.Params().Name)
+	}
+	var err error
+	params.hash, err = digestHash(hashName, "")
+	return params, err
+}
+
+// ethECIESKeys derives the AES key and the HMAC key, the hash of the second
+// half of the KDF output. go-ethereum writes the shared x-coordinate into
+// twice the key length, which is shorter than the field on P-521.
+func ethECIESKeys(params ethECIESParams, z, s1 []byte) (encKey, macKey []byte, err error) {
+	if n := 2 * params.keyLen; len(z) > n {
+		if !isZero(z[:len(z)-n]) {
+			return nil, nil, errors.New("shared secret is too long for the eth-ecies parameters")
+		}
+		z = z[len(z)-n:]
+	}
+	kdf := agreementKDF{kind: "concat", newHash: params.hash.newHash}
+	k, err := kdf.derive(z, 2*params.keyLen, s1, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := params.hash.newHash()
+	h.Write(k[params.keyLen:])
+	return k[:params.keyLen], h.Sum(nil), nil
+}
+
+func ethECIESEncrypt(curve elliptic.Curve, z, plaintext, s1 []byte) ([]byte, error) {
+	params, err := ethECIESParamsFor(curve)
+	if err != nil {
+		return nil, err
+	}
+	encKey, macKey, err := ethECIESKeys(params, z, s1)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	em := make([]byte, aes.BlockSize+len(plaintext))
+	if _, err := rand.Read(em[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	cipher.NewCTR(block, em[:aes.BlockSize]).XORKeyStream(em[aes.BlockSize:], plaintext)
+	return concatBytes(em, ethECIESTag(params, macKey, em)), nil
+}
+
+func ethECIESTag(params ethECIESParams, macKey, em []byte) []byte {
+	mac := hmac.New(params.hash.newHash, macKey)
+	mac.Write(em)
+	return mac.Sum(nil)
+}
+
+func ethECIESDecrypt(curve elliptic.Curve, z, body, s1 []byte) ([]byte, error) {
+	params, err := ethECIESParamsFor(curve)
+	if err != nil {
+		return nil, err
+	}
+	tagLen := params.hash.size()
+	if len(body) < aes.BlockSize+tagLen {
+		return nil, errors.New("invalid ECIES ciphertext")
+	}
+	em, tag := body[:len(body)-tagLen], body[len(body)-tagLen:]
+	encKey, macKey, err := ethECIESKeys(params, z, s1)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(ethECIESTag(params, macKey, em), tag) {
+		return nil, errors.New("invalid MAC for ECIES message")
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(em)-aes.BlockSize)
+	cipher.NewCTR(block, em[:aes.BlockSize]).XORKeyStream(plain, em[aes.BlockSize:])
+	return plain, nil
+}
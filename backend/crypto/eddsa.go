@@ -0,0 +1,757 @@
+package crypto
+
+import (
+	"bytes"
+	stdcrypto "crypto"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/circl/dh/x448"
+	"github.com/cloudflare/circl/sign/ed448"
+	"github.com/google/uuid"
+)
+
+// crypto/x509 handles Ed25519 and X25519; the Curve448 keys are encoded by
+// hand with the RFC 8410 OIDs.
+var (
+	oidX448  = asn1.ObjectIdentifier{1, 3, 101, 111}
+	oidEd448 = asn1.ObjectIdentifier{1, 3, 101, 113}
+)
+
+// x448PrivateKey holds a raw X448 scalar; circl only exposes a byte array type.
+type x448PrivateKey []byte
+
+// x448PublicKey holds a raw X448 u-coordinate.
+type x448PublicKey []byte
+
+// Public returns the X448 public key for the scalar.
+func (k x448PrivateKey) Public() stdcrypto.PublicKey {
+	var secret, public x448.Key
+	copy(secret[:], k)
+	x448.KeyGen(&public, &secret)
+	return x448PublicKey(public[:])
+}
+
+// parseEdDSAKey parses PKCS#8 / PKIX encoded or raw Edwards and Montgomery keys.
+func (c *CryptoService) parseEdDSAKey(req KeyParseRequest) (KeyParseResult, error) {
+	result := KeyParseResult{Summary: map[string]string{}}
+	block, der, err := extractPEMOrDER(req.Data, req.Format)
+	if err != nil {
+		return result, err
+	}
+	der, encryption, err := decryptPKCS8(block, der, req.Passphrase)
+	if err != nil {
+		return result, err
+	}
+	if encryption != "" {
+		result.Summary["encryption"] = encryption
+	}
+
+	var priv, pub any
+	if parsed, err := parseEdDSAPrivateDER(der); err == nil {
+		priv = parsed
+	} else if parsed, err := parseEdDSAPublicDER(der); err == nil {
+		pub = parsed
+	} else if block == nil {
+		curve, public := parseEdDSAVariant(req.Variant)
+		if public {
+			pub, err = rawEdDSAPublic(der, curve)
+		} else {
+			priv, err = rawEdDSAPrivate(der, curve)
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Summary["encoding"] = "raw"
+	}
+	if priv == nil && pub == nil {
+		return result, errors.New("failed to parse EdDSA key material")
+	}
+	if priv != nil {
+		algorithm, privPEM, pubPEM, extra, err := marshalPrivateKeyPEM(priv)
+		if err != nil {
+			return result, err
+		}
+		if algorithm != "EdDSA" {
+			return result, fmt.Errorf("expected an EdDSA key, got %s", algorithm)
+		}
+		result.PrivatePEM = privPEM
+		result.PublicPEM = pubPEM
+		result.Summary["curve"] = extra["curve"]
+		result.Summary["type"] = "private"
+		pub = priv.(interface{ Public() stdcrypto.PublicKey }).Public()
+	} else {
+		curve, err := eddsaCurveName(pub)
+		if err != nil {
+			return result, err
+		}
+		pubPEM, err := marshalPublicKeyPEM(pub)
+		if err != nil {
+			return result, err
+		}
+		result.PublicPEM = pubPEM
+		result.Summary["curve"] = curve
+		result.Summary["type"] = "public"
+	}
+	result.Summary["publicHex"] = strings.ToUpper(hex.EncodeToString(eddsaPublicBytes(pub)))
+
+	if req.Save {
+		stored, err := c.saveKey(StoredKey{
+			ID:         uuid.New().String(),
+			Name:       fallbackName(req.Name, result.Summary["curve"]),
+			Algorithm:  "EdDSA",
+			KeyType:    result.Summary["type"],
+			Format:     formatLabel(req.Format),
+			Usage:      req.Usage,
+			PrivatePEM: result.PrivatePEM,
+			PublicPEM:  result.PublicPEM,
+			Extra: map[string]string{
+				"curve": result.Summary["curve"],
+			},
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Stored = true
+		result.Key = &stored
+	}
+	return result, nil
+}
+
+// generateEdDSA creates an Ed25519, Ed448, X25519 or X448 key pair, selected by
+// req.Curve (Ed25519 when empty), and stores it under the EdDSA algorithm.
+func (c *CryptoService) generateEdDSA(req KeyGenRequest) (KeyParseResult, error) {
+	priv, err := generateEdDSAKey(req.Curve)
+	if err != nil {
+		return KeyParseResult{}, err
+	}
+	_, privPEM, pubPEM, extra, err := marshalPrivateKeyPEM(priv)
+	if err != nil {
+		return KeyParseResult{}, err
+	}
+	result := KeyParseResult{
+		PrivatePEM: privPEM,
+		PublicPEM:  pubPEM,
+		Summary: map[string]string{
+			"type":      "private",
+			"curve":     extra["curve"],
+			"publicHex": strings.ToUpper(hex.EncodeToString(eddsaPublicBytes(priv.Public()))),
+		},
+	}
+	if req.Save {
+		stored, err := c.saveKey(StoredKey{
+			ID:         uuid.New().String(),
+			Name:       fallbackName(req.Name, extra["curve"]),
+			Algorithm:  "EdDSA",
+			KeyType:    "private",
+			Format:     "generated",
+			Usage:      req.Usage,
+			PrivatePEM: privPEM,
+			PublicPEM:  pubPEM,
+			Extra:      extra,
+			CreatedAt:  time.Now(),
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Stored = true
+		result.Key = &stored
+	}
+	return result, nil
+}
+
+// generateEdDSAKey creates a private key on the named Edwards or Montgomery curve.
+func generateEdDSAKey(name string) (interface{ Public() stdcrypto.PublicKey }, error) {
+	curve, err := normalizeEdDSACurve(name)
+	if err != nil {
+		return nil, err
+	}
+	switch curve {
+	case "X25519":
+		return ecdh.X25519().GenerateKey(rand.Reader)
+	case "Ed448":
+		_, priv, err := ed448.GenerateKey(rand.Reader)
+		return priv, err
+	case "X448":
+		secret := make([]byte, x448.Size)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return x448PrivateKey(secret), nil
+	default:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+}
+
+func (c *CryptoService) runEdDSAOperation(req AsymmetricRequest) (OperationResult, error) {
+	mat, err := c.resolveKeyMaterial(req.KeyID, req.KeyData, req.KeyFormat)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	op := strings.ToLower(req.Operation)
+	outputFormat := normalizeOutputFormat(req.OutputFormat)
+	rawInline := mat.stored == nil && !strings.Contains(req.KeyData, "BEGIN")
+
+	switch op {
+	case "sign":
+		priv, err := resolveEdDSAPrivate(mat, rawInline, "")
+		if err != nil {
+			return OperationResult{}, err
+		}
+		payload, err := decodeBlob(req.Payload, req.PayloadFormat)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		context, err := decodeEdDSAContext(req.Context)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		sig, variant, err := eddsaSign(priv, payload, context, req.Variant, req.PayloadIsHash)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		curve, _ := eddsaCurveName(priv)
+		return OperationResult{
+			Output: encodeOutputBytes(sig, outputFormat),
+			Details: map[string]string{
+				"base64":  encodeBase64(sig),
+				"curve":   curve,
+				"variant": variant,
+			},
+		}, nil
+	case "verify":
+		pub, err := resolveEdDSAPublic(mat, rawInline, req.KeyData, req.KeyFormat)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		payload, err := decodeBlob(req.Payload, req.PayloadFormat)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		signature, err := decodeBlob(req.Signature, req.SignatureFmt)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		context, err := decodeEdDSAContext(req.Context)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		ok, variant, err := eddsaVerify(pub, payload, signature, context, req.Variant, req.PayloadIsHash)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		return OperationResult{Verified: ok, Details: map[string]string{"variant": variant}}, nil
+	case "derive", "exchange":
+		priv, err := resolveEdDSAPrivate(mat, rawInline, "X25519")
+		if err != nil {
+			return OperationResult{}, err
+		}
+		peer, err := c.resolveEdDSAPeer(req)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		shared, err := eddsaSharedSecret(priv, peer)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		kdf, err := resolveAgreementKDF(req.KDF, "none")
+		if err != nil {
+			return OperationResult{}, err
+		}
+		derived, err := deriveAgreementKey(kdf, shared, req)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		curve, _ := eddsaCurveName(priv)
+		details := map[string]string{
+			"base64": encodeBase64(derived),
+			"curve":  curve,
+			"kdf":    kdf.name,
+			"length": fmt.Sprintf("%d", len(derived)),
+		}
+		if kdf.kind != "none" {
+			details["sharedSecret"] = strings.ToUpper(hex.EncodeToString(shared))
+		}
+		return OperationResult{
+			Output:  encodeOutputBytes(derived, outputFormat),
+			Details: details,
+		}, nil
+	default:
+		return OperationResult{}, fmt.Errorf("unsupported EdDSA operation: %s", req.Operation)
+	}
+}
+
+// eddsaSign signs with the pure, prehash (ph) or context (ctx) variant.
+func eddsaSign(priv any, payload, context []byte, variant string, payloadIsHash bool) ([]byte, string, error) {
+	variant = strings.ToLower(strings.TrimSpace(variant))
+	switch k := priv.(type) {
+	case ed25519.PrivateKey:
+		opts, message, label, err := ed25519Options(payload, context, variant, payloadIsHash)
+		if err != nil {
+			return nil, "", err
+		}
+		sig, err := k.Sign(nil, message, opts)
+		return sig, label, err
+	case ed448.PrivateKey:
+		if payloadIsHash {
+			return nil, "", errors.New("Ed448 signs the message itself; pass the message, not a digest")
+		}
+		switch variant {
+		case "", "pure", "ctx":
+			return ed448.Sign(k, payload, string(context)), "Ed448", nil
+		case "ph":
+			return ed448.SignPh(k, payload, string(context)), "Ed448ph", nil
+		default:
+			return nil, "", fmt.Errorf("unsupported EdDSA variant: %s", variant)
+		}
+	default:
+		return nil, "", errors.New("key cannot sign; X25519/X448 keys are for key agreement")
+	}
+}
+
+func eddsaVerify(pub any, payload, signature, context []byte, variant string, payloadIsHash bool) (bool, string, error) {
+	variant = strings.ToLower(strings.TrimSpace(variant))
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		opts, message, label, err := ed25519Options(payload, context, variant, payloadIsHash)
+		if err != nil {
+			return false, "", err
+		}
+		return ed25519.VerifyWithOptions(k, message, signature, opts) == nil, label, nil
+	case ed448.PublicKey:
+		if payloadIsHash {
+			return false, "", errors.New("Ed448 verification needs the message, not a digest")
+		}
+		switch variant {
+		case "", "pure", "ctx":
+			return ed448.Verify(k, payload, signature, string(context)), "Ed448", nil
+		case "ph":
+			return ed448.VerifyPh(k, payload, signature, string(context)), "Ed448ph", nil
+		default:
+			return false, "", fmt.Errorf("unsupported EdDSA variant: %s", variant)
+		}
+	default:
+		return false, "", errors.New("key cannot verify; X25519/X448 keys are for key agreement")
+	}
+}
+
+// ed25519Options maps a variant to RFC 8032 Ed25519, Ed25519ctx or Ed25519ph.
+func ed25519Options(payload, context []byte, variant string, payloadIsHash bool) (*ed25519.Options, []byte, string, error) {
+	switch variant {
+	case "", "pure":
+		if len(context) > 0 {
+			return nil, nil, "", errors.New("pure Ed25519 takes no context; use the ctx variant")
+		}
+		if payloadIsHash {
+			return nil, nil, "", errors.New("pure Ed25519 signs the message itself; use the ph variant for prehashed input")
+		}
+		return &ed25519.Options{}, payload, "Ed25519", nil
+	case "ctx":
+		if len(context) == 0 {
+			return nil, nil, "", errors.New("Ed25519ctx requires a non-empty context")
+		}
+		if payloadIsHash {
+			return nil, nil, "", errors.New("Ed25519ctx signs the message itself; use the ph variant for prehashed input")
+		}
+		return &ed25519.Options{Context: string(context)}, payload, "Ed25519ctx", nil
+	case "ph":
+		digest := payload
+		if !payloadIsHash {
+			sum := sha512.Sum512(payload)
+			digest = sum[:]
+		} else if len(digest) != sha512.Size {
+			return nil, nil, "", errors.New("Ed25519ph expects a 64-byte SHA-512 digest")
+		}
+		return &ed25519.Options{Hash: stdcrypto.SHA512, Context: string(context)}, digest, "Ed25519ph", nil
+	default:
+		return nil, nil, "", fmt.Errorf("unsupported EdDSA variant: %s", variant)
+	}
+}
+
+// eddsaSharedSecret runs X25519 or X448 between a private and a peer public key.
+func eddsaSharedSecret(priv, peer any) ([]byte, error) {
+	switch k := priv.(type) {
+	case *ecdh.PrivateKey:
+		pub, ok := peer.(*ecdh.PublicKey)
+		if !ok || pub.Curve() != k.Curve() {
+			return nil, errors.New("peer key is not an X25519 public key")
+		}
+		return k.ECDH(pub)
+	case x448PrivateKey:
+		pub, ok := peer.(x448PublicKey)
+		if !ok {
+			return nil, errors.New("peer key is not an X448 public key")
+		}
+		var secret, public, shared x448.Key
+		copy(secret[:], k)
+		copy(public[:], pub)
+		if !x448.Shared(&shared, &secret, &public) {
+			return nil, errors.New("X448 shared secret is all zero")
+		}
+		return shared[:], nil
+	default:
+		return nil, errors.New("key agreement needs an X25519 or X448 private key")
+	}
+}
+
+// resolveEdDSAPrivate parses the private key of an operation. Inline keys that
+// are not PEM are taken as raw seeds or scalars; rawCurve decides what a
+// 32-byte value means.
+func resolveEdDSAPrivate(mat keyMaterial, rawInline bool, rawCurve string) (any, error) {
+	if mat.privatePEM == "" {
+		return nil, errors.New("private key required")
+	}
+	if rawInline {
+		block, _ := pem.Decode([]byte(mat.privatePEM))
+		if block != nil {
+			if key, err := parseEdDSAPrivateDER(block.Bytes); err == nil {
+				return key, nil
+			}
+			if len(block.Bytes) != ed25519.SeedSize {
+				rawCurve = ""
+			}
+			return rawEdDSAPrivate(block.Bytes, rawCurve)
+		}
+	}
+	return parseEdDSAPrivate(mat.privatePEM)
+}
+
+// resolveEdDSAPublic returns the verification key; inline non-PEM data is
+// read as a raw public key.
+func resolveEdDSAPublic(mat keyMaterial, rawInline bool, keyData, keyFormat string) (any, error) {
+	if rawInline {
+		raw, err := decodeData(keyFormat, strings.TrimSpace(keyData))
+		if err != nil {
+			return nil, err
+		}
+		if key, err := parseEdDSAPublicDER(raw); err == nil {
+			return key, nil
+		}
+		return rawEdDSAPublic(raw, "")
+	}
+	if mat.publicPEM != "" {
+		return parseEdDSAPublic(mat.publicPEM)
+	}
+	priv, err := parseEdDSAPrivate(mat.privatePEM)
+	if err != nil {
+		return nil, err
+	}
+	return priv.(interface{ Public() stdcrypto.PublicKey }).Public(), nil
+}
+
+// resolveEdDSAPeer loads the peer public key from PeerKeyID or PeerKeyData.
+func (c *CryptoService) resolveEdDSAPeer(req AsymmetricRequest) (any, error) {
+	if strings.TrimSpace(req.PeerKeyID) != "" {
+		key, err := c.findKey(req.PeerKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return parseStoredPublicKey(key)
+	}
+	data := strings.TrimSpace(req.PeerKeyData)
+	if data == "" {
+		return nil, errors.New("peer public key required")
+	}
+	if strings.Contains(data, "BEGIN") {
+		return parseEdDSAPublic(data)
+	}
+	raw, err := decodeData(firstNonEmpty(req.KeyFormat, "hex"), data)
+	if err != nil {
+		return nil, err
+	}
+	curve := ""
+	if len(raw) == 32 {
+		curve = "X25519"
+	}
+	return rawEdDSAPublic(raw, curve)
+}
+
+func decodeEdDSAContext(context string) ([]byte, error) {
+	context = strings.TrimSpace(context)
+	if context == "" {
+		return nil, nil
+	}
+	decoded, err := hex.DecodeString(context)
+	if err != nil {
+		return nil, errors.New("EdDSA context must be hex encoded")
+	}
+	if len(decoded) > 255 {
+		return nil, errors.New("EdDSA context must be at most 255 bytes")
+	}
+	return decoded, nil
+}
+
+// normalizeEdDSACurve maps user input onto the canonical curve names.
+func normalizeEdDSACurve(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "ed25519":
+		return "Ed25519", nil
+	case "x25519", "curve25519":
+		return "X25519", nil
+	case "ed448":
+		return "Ed448", nil
+	case "x448", "curve448":
+		return "X448", nil
+	default:
+		return "", fmt.Errorf("unsupported EdDSA curve: %s", name)
+	}
+}
+
+// parseEdDSAVariant splits a parse variant such as "x25519" or "ed448-public".
+func parseEdDSAVariant(variant string) (string, bool) {
+	variant = strings.ToLower(strings.TrimSpace(variant))
+	for _, suffix := range []string{"-public", "-pub"} {
+		if strings.HasSuffix(variant, suffix) {
+			return strings.TrimSuffix(variant, suffix), true
+		}
+	}
+	if variant == "public" {
+		return "", true
+	}
+	return variant, false
+}
+
+// rawEdDSAPrivate builds a private key from a raw seed or scalar. Without a
+// curve the length decides, and 32 bytes mean an Ed25519 seed.
+func rawEdDSAPrivate(raw []byte, curve string) (any, error) {
+	if curve == "" {
+		switch len(raw) {
+		case ed25519.SeedSize, ed25519.PrivateKeySize:
+			curve = "Ed25519"
+		case ed448.SeedSize, ed448.PrivateKeySize:
+			curve = "Ed448"
+		case x448.Size:
+			curve = "X448"
+		}
+	}
+	name, err := normalizeEdDSACurve(curve)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "Ed25519":
+		switch len(raw) {
+		case ed25519.SeedSize:
+			return ed25519.NewKeyFromSeed(raw), nil
+		case ed25519.PrivateKeySize:
+			key := ed25519.NewKeyFromSeed(raw[:ed25519.SeedSize])
+			if !bytes.Equal(key, raw) {
+				return nil, errors.New("Ed25519 private key does not match its public half")
+			}
+			return key, nil
+		}
+	case "X25519":
+		return ecdh.X25519().NewPrivateKey(raw)
+	case "Ed448":
+		switch len(raw) {
+		case ed448.SeedSize:
+			return ed448.NewKeyFromSeed(raw), nil
+		case ed448.PrivateKeySize:
+			key := ed448.NewKeyFromSeed(raw[:ed448.SeedSize])
+			if !bytes.Equal(key, raw) {
+				return nil, errors.New("Ed448 private key does not match its public half")
+			}
+			return key, nil
+		}
+	case "X448":
+		if len(raw) == x448.Size {
+			return x448PrivateKey(bytes.Clone(raw)), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s private key length %d", name, len(raw))
+}
+
+// rawEdDSAPublic builds a public key from its raw encoding.
+func rawEdDSAPublic(raw []byte, curve string) (any, error) {
+	if curve == "" {
+		switch len(raw) {
+		case ed25519.PublicKeySize:
+			curve = "Ed25519"
+		case ed448.PublicKeySize:
+			curve = "Ed448"
+		case x448.Size:
+			curve = "X448"
+		}
+	}
+	name, err := normalizeEdDSACurve(curve)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "Ed25519":
+		if len(raw) == ed25519.PublicKeySize {
+			return ed25519.PublicKey(bytes.Clone(raw)), nil
+		}
+	case "X25519":
+		return ecdh.X25519().NewPublicKey(raw)
+	case "Ed448":
+		if len(raw) == ed448.PublicKeySize {
+			return ed448.PublicKey(bytes.Clone(raw)), nil
+		}
+	case "X448":
+		if len(raw) == x448.Size {
+			return x448PublicKey(bytes.Clone(raw)), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s public key length %d", name, len(raw))
+}
+
+// parseEdDSAPrivate parses a PKCS#8 Edwards or Montgomery private key PEM.
+func parseEdDSAPrivate(p string) (any, error) {
+	block, _ := pem.Decode([]byte(p))
+	if block == nil {
+		return nil, errors.New("invalid EdDSA private key")
+	}
+	return parseEdDSAPrivateDER(block.Bytes)
+}
+
+// parseEdDSAPublic parses a PKIX Edwards or Montgomery public key PEM.
+func parseEdDSAPublic(p string) (any, error) {
+	block, _ := pem.Decode([]byte(p))
+	if block == nil {
+		return nil, errors.New("invalid EdDSA public key")
+	}
+	return parseEdDSAPublicDER(block.Bytes)
+}
+
+func parseEdDSAPrivateDER(der []byte) (any, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return parseCurve448PrivateKey(der)
+	}
+	if _, err := eddsaCurveName(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func parseEdDSAPublicDER(der []byte) (any, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return parseCurve448PublicKey(der)
+	}
+	if _, err := eddsaCurveName(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func parseCurve448PrivateKey(der []byte) (any, error) {
+	var info pkcs8PrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, errors.New("invalid PKCS#8 private key")
+	}
+	var secret []byte
+	if _, err := asn1.Unmarshal(info.PrivateKey, &secret); err != nil {
+		return nil, errors.New("invalid Curve448 private key")
+	}
+	switch {
+	case info.Algo.Algorithm.Equal(oidEd448) && len(secret) == ed448.SeedSize:
+		return ed448.NewKeyFromSeed(secret), nil
+	case info.Algo.Algorithm.Equal(oidX448) && len(secret) == x448.Size:
+		return x448PrivateKey(secret), nil
+	default:
+		return nil, errors.New("unsupported EdDSA private key")
+	}
+}
+
+func parseCurve448PublicKey(der []byte) (any, error) {
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, errors.New("invalid PKIX public key")
+	}
+	raw := spki.PublicKey.RightAlign()
+	switch {
+	case spki.Algorithm.Algorithm.Equal(oidEd448) && len(raw) == ed448.PublicKeySize:
+		return ed448.PublicKey(raw), nil
+	case spki.Algorithm.Algorithm.Equal(oidX448) && len(raw) == x448.Size:
+		return x448PublicKey(raw), nil
+	default:
+		return nil, errors.New("unsupported EdDSA public key")
+	}
+}
+
+// marshalEdDSAPrivateKey encodes an Edwards or Montgomery private key as PKCS#8.
+func marshalEdDSAPrivateKey(priv any) ([]byte, error) {
+	var oid asn1.ObjectIdentifier
+	var secret []byte
+	switch k := priv.(type) {
+	case ed448.PrivateKey:
+		oid, secret = oidEd448, k.Seed()
+	case x448PrivateKey:
+		oid, secret = oidX448, k
+	default:
+		return x509.MarshalPKCS8PrivateKey(priv)
+	}
+	inner, err := asn1.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8PrivateKeyInfo{Algo: pkix.AlgorithmIdentifier{Algorithm: oid}, PrivateKey: inner})
+}
+
+// marshalEdDSAPublicKey encodes an Edwards or Montgomery public key as PKIX.
+func marshalEdDSAPublicKey(pub any) ([]byte, error) {
+	var oid asn1.ObjectIdentifier
+	var raw []byte
+	switch k := pub.(type) {
+	case ed448.PublicKey:
+		oid, raw = oidEd448, k
+	case x448PublicKey:
+		oid, raw = oidX448, k
+	default:
+		return x509.MarshalPKIXPublicKey(pub)
+	}
+	return asn1.Marshal(subjectPublicKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid},
+		PublicKey: asn1.BitString{Bytes: raw, BitLength: 8 * len(raw)},
+	})
+}
+
+func eddsaCurveName(key any) (string, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "Ed25519", nil
+	case ed448.PrivateKey, ed448.PublicKey:
+		return "Ed448", nil
+	case x448PrivateKey, x448PublicKey:
+		return "X448", nil
+	case *ecdh.PrivateKey:
+		if k.Curve() == ecdh.X25519() {
+			return "X25519", nil
+		}
+	case *ecdh.PublicKey:
+		if k.Curve() == ecdh.X25519() {
+			return "X25519", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported EdDSA key type %T", key)
+}
+
+func eddsaPublicBytes(pub any) []byte {
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return k
+	case ed448.PublicKey:
+		return k
+	case x448PublicKey:
+		return k
+	case *ecdh.PublicKey:
+		return k.Bytes()
+	default:
+		return nil
+	}
+}
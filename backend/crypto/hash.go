@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	stdcrypto "crypto"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/sha1"
@@ -9,12 +10,18 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"math/bits"
+	"strconv"
 	"strings"
 
 	"github.com/emmansun/gmsm/sm3"
 	"golang.org/x/crypto/blake2b"
 	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/ripemd160"
 	"golang.org/x/crypto/sha3"
+	"lukechampine.com/blake3"
 )
 
 // RunHash performs a hash or HMAC operation.
@@ -31,22 +38,31 @@ func (c *CryptoService) RunHash(req HashRequest) (OperationResult, error) {
 	if err != nil {
 		return OperationResult{}, fmt.Errorf("invalid input: %w", err)
 	}
-	hashFunc, err := selectHashFunc(algo)
+	key, err := decodeBlob(req.Key, req.KeyFormat)
 	if err != nil {
-		return OperationResult{}, err
+		return OperationResult{}, fmt.Errorf("invalid key: %w", err)
 	}
+	var spec hashSpec
 	var digest []byte
 	switch mode {
 	case "hash":
-		h := hashFunc()
+		spec, err = lookupHash(algo, hashOptions{
+			size:          req.OutputLength,
+			key:           key,
+			functionName:  []byte(req.FunctionName),
+			customization: []byte(req.Customization),
+		})
+		if err != nil {
+			return OperationResult{}, err
+		}
+		h := spec.newHash()
 		h.Write(input)
 		digest = h.Sum(nil)
 	case "hmac":
-		key, err := decodeBlob(req.Key, req.KeyFormat)
-		if err != nil {
-			return OperationResult{}, fmt.Errorf("invalid HMAC key: %w", err)
+		if spec, err = digestHash(algo, ""); err != nil {
+			return OperationResult{}, err
 		}
-		h := hmac.New(hashFunc, key)
+		h := hmac.New(spec.newHash, key)
 		h.Write(input)
 		digest = h.Sum(nil)
 	default:
@@ -55,43 +71,411 @@ func (c *CryptoService) RunHash(req HashRequest) (OperationResult, error) {
 	return OperationResult{
 		Output: encodeOutputBytes(digest, req.OutputFormat),
 		Details: map[string]string{
-			"base64": encodeBase64(digest),
-			"hex":    strings.ToUpper(fmt.Sprintf("%x", digest)),
+			"algorithm": spec.name,
+			"base64":    encodeBase64(digest),
+			"hex":       strings.ToUpper(fmt.Sprintf("%x", digest)),
 		},
 	}, nil
 }
 
-func selectHashFunc(name string) (func() hash.Hash, error) {
-	switch strings.ToLower(name) {
-	case "sha1":
-		return sha1.New, nil
-	case "sha256":
-		return sha256.New, nil
-	case "sha512":
-		return sha512.New, nil
-	case "sha3", "sha3-256":
-		return sha3.New256, nil
-	case "sha3-224":
-		return sha3.New224, nil
-	case "sha3-384":
-		return sha3.New384, nil
-	case "sha3-512":
-		return sha3.New512, nil
-	case "md5":
-		return md5.New, nil
-	case "sm3":
-		return sm3.New, nil
-	case "blake2b", "blake2b-256":
-		return func() hash.Hash {
-			h, _ := blake2b.New256(nil)
-			return h
-		}, nil
-	case "blake2s", "blake2s-256":
-		return func() hash.Hash {
-			h, _ := blake2s.New256(nil)
-			return h
-		}, nil
-	default:
-		return nil, fmt.Errorf("unsupported hash algorithm: %s", name)
+// The hash registry. Every part of the service that takes a hash by name
+// (RunHash, file hashing, HMAC, the KDFs, RSA OAEP/PSS and ECIES) resolves it
+// here so that the same names work everywhere.
+
+type hashKind int
+
+const (
+	hashDigest   hashKind = iota // fixed-output cryptographic hash
+	hashXOF                      // SHAKE and cSHAKE with a chosen output length
+	hashKeyed                    // KMAC and keyed BLAKE2/BLAKE3
+	hashChecksum                 // CRC and Adler-32, not collision resistant
+)
+
+// hashOptions carries the parameters of the configurable algorithms.
+type hashOptions struct {
+	size          int    // output bytes for XOFs, KMAC, BLAKE2 and BLAKE3; 0 keeps the default
+	key           []byte // BLAKE2, BLAKE3 and KMAC key; ignored by unkeyed algorithms
+	functionName  []byte // cSHAKE N
+	customization []byte // cSHAKE and KMAC S
+}
+
+type hashSpec struct {
+	name    string
+	id      stdcrypto.Hash // standard library identifier, 0 when there is none
+	kind    hashKind
+	newHash func() hash.Hash
+}
+
+func (s hashSpec) size() int {
+	return s.newHash().Size()
+}
+
+func (s hashSpec) sum(data []byte) []byte {
+	h := s.newHash()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+var fixedHashes = map[string]hashSpec{
+	"md5":        {name: "md5", id: stdcrypto.MD5, newHash: md5.New},
+	"sha1":       {name: "sha1", id: stdcrypto.SHA1, newHash: sha1.New},
+	"sha224":     {name: "sha224", id: stdcrypto.SHA224, newHash: sha256.New224},
+	"sha256":     {name: "sha256", id: stdcrypto.SHA256, newHash: sha256.New},
+	"sha384":     {name: "sha384", id: stdcrypto.SHA384, newHash: sha512.New384},
+	"sha512":     {name: "sha512", id: stdcrypto.SHA512, newHash: sha512.New},
+	"sha512/224": {name: "sha512/224", id: stdcrypto.SHA512_224, newHash: sha512.New512_224},
+	"sha512/256": {name: "sha512/256", id: stdcrypto.SHA512_256, newHash: sha512.New512_256},
+	"sha3-224":   {name: "sha3-224", id: stdcrypto.SHA3_224, newHash: sha3.New224},
+	"sha3-256":   {name: "sha3-256", id: stdcrypto.SHA3_256, newHash: sha3.New256},
+	"sha3-384":   {name: "sha3-384", id: stdcrypto.SHA3_384, newHash: sha3.New384},
+	"sha3-512":   {name: "sha3-512", id: stdcrypto.SHA3_512, newHash: sha3.New512},
+	"keccak256":  {name: "keccak256", newHash: sha3.NewLegacyKeccak256},
+	"keccak512":  {name: "keccak512", newHash: sha3.NewLegacyKeccak512},
+	"sm3":        {name: "sm3", newHash: sm3.New},
+	"ripemd160":  {name: "ripemd160", id: stdcrypto.RIPEMD160, newHash: ripemd160.New},
+
+	"crc32":   {name: "crc32", kind: hashChecksum, newHash: func() hash.Hash { return crc32.NewIEEE() }},
+	"crc32c":  {name: "crc32c", kind: hashChecksum, newHash: func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }},
+	"adler32": {name: "adler32", kind: hashChecksum, newHash: func() hash.Hash { return adler32.New() }},
+}
+
+var hashAliases = map[string]string{
+	"sha3":             "sha3-256",
+	"sha512-224":       "sha512/224",
+	"sha512-256":       "sha512/256",
+	"sha512t224":       "sha512/224",
+	"sha512t256":       "sha512/256",
+	"keccak":           "keccak256",
+	"keccak-256":       "keccak256",
+	"keccak-512":       "keccak512",
+	"ripemd-160":       "ripemd160",
+	"rmd160":           "ripemd160",
+	"crc32-ieee":       "crc32",
+	"crc-32":           "crc32",
+	"crc32-castagnoli": "crc32c",
+	"adler-32":         "adler32",
+	"crc16":            "crc16-arc",
+	"crc-16":           "crc16-arc",
+	"crc16-ibm":        "crc16-arc",
+	"crc16-ccitt":      "crc16-ccitt-false",
+}
+
+func normalizeHashName(name string) string {
+	name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "_", "-")
+	if rest, ok := strings.CutPrefix(name, "sha-"); ok {
+		name = "sha" + rest
+	}
+	if alias, ok := hashAliases[name]; ok {
+		name = alias
 	}
+	return name
 }
+
+// lookupHash resolves a hash, XOF, keyed hash or checksum by name.
+// BLAKE2b, BLAKE2s and BLAKE3 accept a bit length suffix such as blake2b-384.
+func lookupHash(name string, opts hashOptions) (hashSpec, error) {
+	name = normalizeHashName(name)
+	if spec, ok := fixedHashes[name]; ok {
+		return spec, nil
+	}
+	if params, ok := crc16Variants[name]; ok {
+		return hashSpec{name: name, kind: hashChecksum, newHash: func() hash.Hash { return newCRC16(params) }}, nil
+	}
+	family, bitsText, _ := strings.Cut(name, "-")
+	size := opts.size
+	if bitsText != "" && size == 0 {
+		n, err := strconv.Atoi(bitsText)
+		if err != nil || n <= 0 || n%8 != 0 {
+			return hashSpec{}, fmt.Errorf("unsupported hash algorithm: %s", name)
+		}
+		size = n / 8
+	}
+	switch family {
+	case "blake2b":
+		return blake2Spec("blake2b", size, 32, blake2b.Size, opts.key, func(size int, key []byte) (hash.Hash, error) {
+			return blake2b.New(size, key)
+		})
+	case "blake2s":
+		return blake2Spec("blake2s", size, 32, blake2s.Size, opts.key, func(size int, key []byte) (hash.Hash, error) {
+			switch size {
+			case blake2s.Size:
+				return blake2s.New256(key)
+			case blake2s.Size128:
+				return blake2s.New128(key)
+			default:
+				return nil, errors.New("BLAKE2s output must be 128 (keyed) or 256 bits")
+			}
+		})
+	case "blake3":
+		if size == 0 {
+			size = 32
+		}
+		if size > maxKDFLength {
+			return hashSpec{}, errors.New("BLAKE3 output length is too large")
+		}
+		spec := hashSpec{name: fmt.Sprintf("blake3-%d", size*8), newHash: func() hash.Hash { return blake3.New(size, nil) }}
+		if len(opts.key) > 0 {
+			if len(opts.key) != 32 {
+				return hashSpec{}, errors.New("BLAKE3 keyed mode needs a 32-byte key")
+			}
+			key := append([]byte(nil), opts.key...)
+			spec.kind, spec.newHash = hashKeyed, func() hash.Hash { return blake3.New(size, key) }
+		}
+		return spec, nil
+	}
+	if bitsText != "" {
+		// SHAKE, cSHAKE and KMAC take the output length from the options.
+		return hashSpec{}, fmt.Errorf("unsupported hash algorithm: %s", name)
+	}
+	switch name {
+	case "shake128", "shake256", "cshake128", "cshake256":
+		rate := 128
+		if strings.HasSuffix(name, "256") {
+			rate = 256
+		}
+		if size == 0 {
+			size = rate / 4
+		}
+		if size > maxKDFLength {
+			return hashSpec{}, errors.New("XOF output length is too large")
+		}
+		fn, custom := append([]byte(nil), opts.functionName...), append([]byte(nil), opts.customization...)
+		newXOF := func() sha3.ShakeHash {
+			switch {
+			case name == "shake128":
+				return sha3.NewShake128()
+			case name == "shake256":
+				return sha3.NewShake256()
+			case rate == 128:
+				return sha3.NewCShake128(fn, custom)
+			default:
+				return sha3.NewCShake256(fn, custom)
+			}
+		}
+		return hashSpec{name: name, kind: hashXOF, newHash: func() hash.Hash { return &xofHash{ShakeHash: newXOF(), size: size} }}, nil
+	case "kmac128", "kmac256":
+		if len(opts.key) == 0 {
+			return hashSpec{}, errors.New("KMAC requires a key")
+		}
+		rate, security := 168, 16
+		if name == "kmac256" {
+			rate, security = 136, 32
+		}
+		if size == 0 {
+			size = 2 * security
+		}
+		if size > maxKDFLength {
+			return hashSpec{}, errors.New("KMAC output length is too large")
+		}
+		key, custom := append([]byte(nil), opts.key...), append([]byte(nil), opts.customization...)
+		return hashSpec{name: name, kind: hashKeyed, newHash: func() hash.Hash { return newKMAC(rate, key, custom, size) }}, nil
+	}
+	return hashSpec{}, fmt.Errorf("unsupported hash algorithm: %s", name)
+}
+
+// blake2Spec builds a BLAKE2 spec. The bare name keeps its historic 256-bit
+// output; unkeyed outputs with a standard identifier report it so they can be
+// used for RSA.
+func blake2Spec(family string, size, defaultSize, maxSize int, key []byte, newHash func(int, []byte) (hash.Hash, error)) (hashSpec, error) {
+	if size == 0 {
+		size = defaultSize
+	}
+	if size > maxSize || len(key) > maxSize {
+		return hashSpec{}, fmt.Errorf("%s output and key are limited to %d bytes", strings.ToUpper(family), maxSize)
+	}
+	key = append([]byte(nil), key...)
+	if _, err := newHash(size, key); err != nil {
+		return hashSpec{}, err
+	}
+	spec := hashSpec{name: fmt.Sprintf("%s-%d", family, size*8), newHash: func() hash.Hash {
+		h, _ := newHash(size, key)
+		return h
+	}}
+	if len(key) > 0 {
+		spec.kind = hashKeyed
+		return spec, nil
+	}
+	spec.id = map[string]stdcrypto.Hash{
+		"blake2b-256": stdcrypto.BLAKE2b_256,
+		"blake2b-384": stdcrypto.BLAKE2b_384,
+		"blake2b-512": stdcrypto.BLAKE2b_512,
+		"blake2s-256": stdcrypto.BLAKE2s_256,
+	}[spec.name]
+	return spec, nil
+}
+
+// digestHash resolves a fixed-output cryptographic hash for HMAC, the KDFs
+// and public key schemes. An empty name selects fallback.
+func digestHash(name, fallback string) (hashSpec, error) {
+	if strings.TrimSpace(name) == "" {
+		name = fallback
+	}
+	spec, err := lookupHash(name, hashOptions{})
+	if err != nil {
+		return hashSpec{}, err
+	}
+	if spec.kind != hashDigest {
+		return hashSpec{}, fmt.Errorf("%s is not a fixed-output hash and cannot be used here", spec.name)
+	}
+	return spec, nil
+}
+
+// rsaHash resolves a hash usable with crypto/rsa, which identifies hashes by
+// their standard library constant.
+func rsaHash(name, fallback string) (hashSpec, error) {
+	spec, err := digestHash(name, fallback)
+	if err != nil {
+		return hashSpec{}, err
+	}
+	if spec.id == 0 || !spec.id.Available() {
+		return hashSpec{}, fmt.Errorf("%s cannot be used with RSA", spec.name)
+	}
+	return spec, nil
+}
+
+// xofHash exposes SHAKE or cSHAKE as a hash.Hash with a fixed output length.
+type xofHash struct {
+	sha3.ShakeHash
+	size int
+}
+
+func (h *xofHash) Size() int { return h.size }
+
+func (h *xofHash) Sum(b []byte) []byte {
+	out := make([]byte, h.size)
+	h.ShakeHash.Clone().Read(out)
+	return append(b, out...)
+}
+
+// kmacHash is KMAC128/KMAC256 (NIST SP 800-185): cSHAKE with N = "KMAC" over
+// bytepad(encode_string(K)) || X || right_encode(L).
+type kmacHash struct {
+	xofHash
+	keyBlock []byte
+}
+
+func newKMAC(rate int, key, customization []byte, size int) *kmacHash {
+	var xof sha3.ShakeHash
+	if rate == 168 {
+		xof = sha3.NewCShake128([]byte("KMAC"), customization)
+	} else {
+		xof = sha3.NewCShake256([]byte("KMAC"), customization)
+	}
+	h := &kmacHash{xofHash: xofHash{ShakeHash: xof, size: size}, keyBlock: sp800185BytePad(sp800185EncodeString(key), rate)}
+	h.ShakeHash.Write(h.keyBlock)
+	return h
+}
+
+func (h *kmacHash) Reset() {
+	h.ShakeHash.Reset()
+	h.ShakeHash.Write(h.keyBlock)
+}
+
+func (h *kmacHash) Sum(b []byte) []byte {
+	state := h.ShakeHash.Clone()
+	state.Write(sp800185RightEncode(uint64(h.size) * 8))
+	out := make([]byte, h.size)
+	state.Read(out)
+	return append(b, out...)
+}
+
+func sp800185LeftEncode(x uint64) []byte {
+	enc := sp800185RightEncode(x)
+	n := enc[len(enc)-1]
+	return append([]byte{n}, enc[:n]...)
+}
+
+func sp800185RightEncode(x uint64) []byte {
+	var buf [9]byte
+	n := 1
+	for v := x >> 8; v > 0; v >>= 8 {
+		n++
+	}
+	for i := 0; i < n; i++ {
+		buf[n-1-i] = byte(x >> (8 * i))
+	}
+	buf[n] = byte(n)
+	return buf[:n+1]
+}
+
+func sp800185EncodeString(s []byte) []byte {
+	return append(sp800185LeftEncode(uint64(len(s))*8), s...)
+}
+
+func sp800185BytePad(data []byte, rate int) []byte {
+	out := append(sp800185LeftEncode(uint64(rate)), data...)
+	if rem := len(out) % rate; rem != 0 {
+		out = append(out, make([]byte, rate-rem)...)
+	}
+	return out
+}
+
+// CRC-16 variants from the CRC RevEng catalogue. All of them reflect input and
+// output together, or neither.
+type crc16Params struct {
+	poly, init, xorOut uint16
+	reflected          bool
+}
+
+var crc16Variants = map[string]crc16Params{
+	"crc16-arc":         {poly: 0x8005, reflected: true},
+	"crc16-modbus":      {poly: 0x8005, init: 0xffff, reflected: true},
+	"crc16-ccitt-false": {poly: 0x1021, init: 0xffff},
+	"crc16-xmodem":      {poly: 0x1021},
+	"crc16-kermit":      {poly: 0x1021, reflected: true},
+	"crc16-x25":         {poly: 0x1021, init: 0xffff, xorOut: 0xffff, reflected: true},
+}
+
+type crc16Hash struct {
+	params crc16Params
+	table  [256]uint16
+	crc    uint16
+}
+
+func newCRC16(p crc16Params) *crc16Hash {
+	h := &crc16Hash{params: p, crc: p.init}
+	for i := range h.table {
+		if p.reflected {
+			poly, c := bits.Reverse16(p.poly), uint16(i)
+			for range 8 {
+				if c&1 != 0 {
+					c = c>>1 ^ poly
+				} else {
+					c >>= 1
+				}
+			}
+			h.table[i] = c
+		} else {
+			c := uint16(i) << 8
+			for range 8 {
+				if c&0x8000 != 0 {
+					c = c<<1 ^ p.poly
+				} else {
+					c <<= 1
+				}
+			}
+			h.table[i] = c
+		}
+	}
+	return h
+}
+
+func (h *crc16Hash) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if h.params.reflected {
+			h.crc = h.table[byte(h.crc)^b] ^ h.crc>>8
+		} else {
+			h.crc = h.table[byte(h.crc>>8)^b] ^ h.crc<<8
+		}
+	}
+	return len(p), nil
+}
+
+func (h *crc16Hash) Sum(b []byte) []byte {
+	v := h.crc ^ h.params.xorOut
+	return append(b, byte(v>>8), byte(v))
+}
+
+func (h *crc16Hash) Reset()         { h.crc = h.params.init }
+func (h *crc16Hash) Size() int      { return 2 }
+func (h *crc16Hash) BlockSize() int { return 1 }
@@ -0,0 +1,318 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	hashProgressEvent    = "crypto:hash-progress"
+	hashProgressInterval = 200 * time.Millisecond
+	fileHashBufferSize   = 1 << 20
+)
+
+// emitHashProgress reports progress to the frontend. The context is only set
+// once Wails has started, so callers outside the app (tests, CLI) skip it.
+func (c *CryptoService) emitHashProgress(p HashProgress) {
+	if c.ctx == nil {
+		return
+	}
+	runtime.EventsEmit(c.ctx, hashProgressEvent, p)
+}
+
+// progressWriter counts hashed bytes and emits throttled progress events.
+type progressWriter struct {
+	svc      *CryptoService
+	progress HashProgress
+	last     time.Time
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.progress.Processed += int64(len(p))
+	if now := time.Now(); now.Sub(w.last) >= hashProgressInterval {
+		w.last = now
+		w.svc.emitHashProgress(w.progress)
+	}
+	return len(p), nil
+}
+
+// hashFile streams a file through every hash at once and returns the digests
+// in the order of names.
+func (c *CryptoService) hashFile(path string, names []string, progress HashProgress) ([][]byte, int64, error) {
+	hashes := make([]hash.Hash, len(names))
+	writers := make([]io.Writer, 0, len(names)+1)
+	for i, name := range names {
+		spec, err := lookupHash(name, hashOptions{})
+		if err != nil {
+			return nil, 0, err
+		}
+		hashes[i] = spec.newHash()
+		writers = append(writers, hashes[i])
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.IsDir() {
+		return nil, 0, fmt.Errorf("%s is a directory", path)
+	}
+	progress.Path, progress.Total = path, info.Size()
+	pw := &progressWriter{svc: c, progress: progress, last: time.Now()}
+	writers = append(writers, pw)
+	size, err := io.CopyBuffer(io.MultiWriter(writers...), f, make([]byte, fileHashBufferSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	pw.progress.Done = true
+	c.emitHashProgress(pw.progress)
+	digests := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		digests[i] = h.Sum(nil)
+	}
+	return digests, size, nil
+}
+
+// HashFile computes one or more digests of a file without loading it into
+// memory.
+//
+// req: The FileHashRequest with the path, the algorithms and an optional expected digest.
+// Returns a FileHashResult with a digest per algorithm. An expected digest is
+// compared with ExpectedAlgorithm's digest, or with every digest of its length;
+// it is an error when no computed digest can be compared with it.
+func (c *CryptoService) HashFile(req FileHashRequest) (FileHashResult, error) {
+	if strings.TrimSpace(req.Path) == "" {
+		return FileHashResult{}, errors.New("file path is required")
+	}
+	names := make([]string, 0, len(req.Algorithms))
+	seen := map[string]bool{}
+	for _, name := range req.Algorithms {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		names = []string{"sha256"}
+	}
+	digests, size, err := c.hashFile(req.Path, names, HashProgress{JobID: req.JobID, File: 1, Files: 1})
+	if err != nil {
+		return FileHashResult{}, err
+	}
+	result := FileHashResult{Path: req.Path, Size: size, Digests: make(map[string]string, len(names))}
+	for i, name := range names {
+		result.Digests[name] = encodeOutputBytes(digests[i], req.OutputFormat)
+	}
+	if expected := strings.TrimSpace(req.Expected); expected != "" {
+		want, err := decodeExpectedDigest(expected)
+		if err != nil {
+			return FileHashResult{}, err
+		}
+		if result.Matched, err = matchExpectedDigest(want, req.ExpectedAlgorithm, names, digests); err != nil {
+			return FileHashResult{}, err
+		}
+		result.Verified = result.Matched != ""
+	}
+	return result, nil
+}
+
+// matchExpectedDigest returns the algorithm whose digest equals want, or "" when
+// none does. Only algorithm is considered when it is set; otherwise every
+// digest of the same length is.
+func matchExpectedDigest(want []byte, algorithm string, names []string, digests [][]byte) (string, error) {
+	algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+	candidates := 0
+	for i, name := range names {
+		if (algorithm != "" && name != algorithm) || len(digests[i]) != len(want) {
+			continue
+		}
+		candidates++
+		if subtle.ConstantTimeCompare(want, digests[i]) == 1 {
+			return name, nil
+		}
+	}
+	if candidates > 0 {
+		return "", nil
+	}
+	if algorithm != "" && !slices.Contains(names, algorithm) {
+		return "", fmt.Errorf("expected algorithm %s is not among the computed digests", algorithm)
+	}
+	return "", fmt.Errorf("expected digest length %d matches none of the computed digests", len(want))
+}
+
+// decodeExpectedDigest accepts a hex or base64 digest.
+func decodeExpectedDigest(text string) ([]byte, error) {
+	if b, err := hex.DecodeString(text); err == nil {
+		return b, nil
+	}
+	b, err := decodeBlob(text, "base64")
+	if err != nil {
+		return nil, errors.New("expected digest must be hex or base64")
+	}
+	return b, nil
+}
+
+type checksumEntry struct {
+	algorithm string
+	digest    string
+	path      string
+}
+
+var (
+	// GNU coreutils: "<digest>  <name>" or "<digest> *<name>" in binary mode.
+	gnuChecksumLine = regexp.MustCompile(`^\\?([0-9A-Fa-f]+) [ *](.+)$`)
+	// BSD/tagged output: "SHA256 (<name>) = <digest>".
+	bsdChecksumLine = regexp.MustCompile(`^([A-Za-z0-9-]+) ?\((.+)\) ?= ?([0-9A-Fa-f]+)$`)
+)
+
+// checksumFileAlgorithm guesses the algorithm from names such as SHA256SUMS,
+// sha512sum.txt, SM3SUMS or image.iso.sha256.
+func checksumFileAlgorithm(path string) string {
+	base := strings.ToLower(filepath.Base(path))
+	for _, name := range []string{"sha3-512", "sha3-384", "sha3-256", "sha3-224", "sha512", "sha384", "sha256", "sha224", "sha1", "md5", "sm3", "blake2b", "blake2s", "blake3"} {
+		if strings.Contains(base, name) {
+			return checksumAlgorithm(name)
+		}
+	}
+	return ""
+}
+
+// checksumAlgorithm follows b2sum, whose bare BLAKE2b is the 512-bit variant
+// rather than the registry's 256-bit default.
+func checksumAlgorithm(name string) string {
+	if name == "blake2b" {
+		return "blake2b-512"
+	}
+	return name
+}
+
+// digestLengthAlgorithm falls back to the usual algorithm for a hex length.
+func digestLengthAlgorithm(hexLen int) string {
+	switch hexLen {
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 56:
+		return "sha224"
+	case 64:
+		return "sha256"
+	case 96:
+		return "sha384"
+	case 128:
+		return "sha512"
+	default:
+		return ""
+	}
+}
+
+func parseChecksumFile(r io.Reader, algorithm string) ([]checksumEntry, error) {
+	var entries []checksumEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var entry checksumEntry
+		if m := bsdChecksumLine.FindStringSubmatch(text); m != nil {
+			entry = checksumEntry{algorithm: checksumAlgorithm(strings.ToLower(m[1])), path: m[2], digest: m[3]}
+		} else if m := gnuChecksumLine.FindStringSubmatch(text); m != nil {
+			entry = checksumEntry{algorithm: algorithm, digest: m[1], path: m[2]}
+			if strings.HasPrefix(text, `\`) {
+				// Names with a backslash or newline are escaped.
+				entry.path = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(entry.path)
+			}
+		} else {
+			return nil, fmt.Errorf("line %d is not a checksum entry", line)
+		}
+		if entry.algorithm == "" {
+			entry.algorithm = digestLengthAlgorithm(len(entry.digest))
+		}
+		if entry.algorithm == "" {
+			return nil, fmt.Errorf("line %d: cannot tell the hash algorithm, please specify it", line)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no checksum entries found")
+	}
+	return entries, nil
+}
+
+// VerifyChecksumFile checks every file listed in a sha256sum, BSD tagged or
+// SM3SUMS style checksum file.
+//
+// req: The ChecksumVerifyRequest with the checksum file and an optional algorithm and base directory.
+// Returns the status of each entry.
+func (c *CryptoService) VerifyChecksumFile(req ChecksumVerifyRequest) (ChecksumVerifyResult, error) {
+	f, err := os.Open(req.ChecksumFile)
+	if err != nil {
+		return ChecksumVerifyResult{}, err
+	}
+	defer f.Close()
+	algorithm := strings.ToLower(strings.TrimSpace(req.Algorithm))
+	if algorithm == "" {
+		algorithm = checksumFileAlgorithm(req.ChecksumFile)
+	}
+	entries, err := parseChecksumFile(f, algorithm)
+	if err != nil {
+		return ChecksumVerifyResult{}, err
+	}
+	baseDir := req.BaseDir
+	if baseDir == "" {
+		baseDir = filepath.Dir(req.ChecksumFile)
+	}
+
+	result := ChecksumVerifyResult{Entries: make([]ChecksumEntryResult, 0, len(entries))}
+	for i, entry := range entries {
+		status := ChecksumEntryResult{Path: entry.path, Algorithm: entry.algorithm, Expected: strings.ToLower(entry.digest)}
+		path := entry.path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, filepath.FromSlash(path))
+		}
+		digests, _, err := c.hashFile(path, []string{entry.algorithm}, HashProgress{JobID: req.JobID, File: i + 1, Files: len(entries)})
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			status.Status = "missing"
+		case err != nil:
+			status.Status, status.Error = "error", err.Error()
+		default:
+			status.Actual = hex.EncodeToString(digests[0])
+			status.Status = "failed"
+			if subtle.ConstantTimeCompare([]byte(status.Actual), []byte(status.Expected)) == 1 {
+				status.Status = "ok"
+			}
+		}
+		if status.Status == "ok" {
+			result.Passed++
+		} else {
+			result.Failed++
+		}
+		result.Entries = append(result.Entries, status)
+	}
+	result.Verified = result.Failed == 0
+	return result, nil
+}
@@ -0,0 +1,341 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Hierarchical deterministic derivation. secp256k1 follows BIP32; P-256 and
+// Ed25519 follow SLIP-10, which is BIP32 with a per-curve master key and, for
+// Ed25519, hardened-only children. On secp256k1 the two agree except for the
+// ~2^-127 case of an invalid child, where SLIP-10's retry rule is used.
+const hdHardened = uint32(1) << 31
+
+// hdCurve describes one SLIP-10 curve.
+type hdCurve struct {
+	name      string // display name
+	seedKey   string // HMAC key for the master node
+	curve     elliptic.Curve
+	scheme    string // bip32, slip10
+	xprvBytes uint32 // version bytes for extended key serialisation, 0 if none
+	xpubBytes uint32
+}
+
+// hdNode is one node of the derivation tree.
+type hdNode struct {
+	key         []byte // private key (32 bytes)
+	chainCode   []byte
+	depth       byte
+	parentPrint []byte
+	index       uint32
+}
+
+func resolveHDCurve(name string) (hdCurve, error) {
+	switch normalizeCurveName(name) {
+	case "", "secp256k1", "bip32", "bitcoin":
+		info, err := resolveECCurve("secp256k1")
+		if err != nil {
+			return hdCurve{}, err
+		}
+		return hdCurve{name: info.Display, seedKey: "Bitcoin seed", curve: info.Curve, scheme: "bip32", xprvBytes: 0x0488ADE4, xpubBytes: 0x0488B21E}, nil
+	case "p256", "nistp256", "nist256p1", "secp256r1", "prime256v1":
+		return hdCurve{name: "NIST P-256", seedKey: "Nist256p1 seed", curve: elliptic.P256(), scheme: "slip10"}, nil
+	case "ed25519":
+		return hdCurve{name: "Ed25519", seedKey: "ed25519 seed", scheme: "slip10"}, nil
+	default:
+		return hdCurve{}, fmt.Errorf("unsupported HD derivation curve: %s", name)
+	}
+}
+
+// parseHDPath reads m/44'/0'/0'/0/0. Hardened indexes may be marked with ',
+// h or H.
+func parseHDPath(path string) ([]uint32, error) {
+	path = strings.TrimSpace(path)
+	if path == "" || path == "m" || path == "M" {
+		return nil, nil
+	}
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" && parts[0] != "M" {
+		return nil, errors.New("derivation path must start with m")
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") || strings.HasSuffix(part, "H")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+		value, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(value) >= hdHardened {
+			return nil, fmt.Errorf("invalid path component: %s", part)
+		}
+		index := uint32(value)
+		if hardened {
+			index |= hdHardened
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+func formatHDPath(indexes []uint32) string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, index := range indexes {
+		if index >= hdHardened {
+			fmt.Fprintf(&b, "/%d'", index-hdHardened)
+		} else {
+			fmt.Fprintf(&b, "/%d", index)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA512(key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
+
+// validScalar reports whether k is in [1, n-1].
+func (hc hdCurve) validScalar(k *big.Int) bool {
+	return k.Sign() > 0 && k.Cmp(hc.curve.Params().N) < 0
+}
+
+func (hc hdCurve) master(seed []byte) hdNode {
+	i := hmacSHA512([]byte(hc.seedKey), seed)
+	if hc.curve != nil {
+		// SLIP-10: an invalid master key is re-hashed until it is valid.
+		for !hc.validScalar(new(big.Int).SetBytes(i[:32])) {
+			i = hmacSHA512([]byte(hc.seedKey), i)
+		}
+	}
+	return hdNode{key: i[:32], chainCode: i[32:], parentPrint: make([]byte, 4)}
+}
+
+// publicKey returns the compressed point for Weierstrass curves and
+// 0x00||A for Ed25519, the form SLIP-10 hashes for fingerprints.
+func (hc hdCurve) publicKey(key []byte) []byte {
+	if hc.curve == nil {
+		return append([]byte{0}, ed25519.NewKeyFromSeed(key).Public().(ed25519.PublicKey)...)
+	}
+	x, y := hc.curve.ScalarBaseMult(key)
+	return elliptic.MarshalCompressed(hc.curve, x, y)
+}
+
+func (hc hdCurve) child(parent hdNode, index uint32) (hdNode, error) {
+	var data []byte
+	if index >= hdHardened {
+		data = concatBytes([]byte{0}, parent.key)
+	} else if hc.curve == nil {
+		return hdNode{}, errors.New("Ed25519 derivation only supports hardened indexes")
+	} else {
+		data = hc.publicKey(parent.key)
+	}
+	ser := binary.BigEndian.AppendUint32(nil, index)
+	i := hmacSHA512(parent.chainCode, data, ser)
+	child := hdNode{depth: parent.depth + 1, parentPrint: hdFingerprint(hc.publicKey(parent.key)), index: index}
+	if hc.curve == nil {
+		child.key, child.chainCode = i[:32], i[32:]
+		return child, nil
+	}
+	n := hc.curve.Params().N
+	for {
+		il := new(big.Int).SetBytes(i[:32])
+		k := new(big.Int).Add(il, new(big.Int).SetBytes(parent.key))
+		k.Mod(k, n)
+		if il.Cmp(n) < 0 && k.Sign() != 0 {
+			child.key, child.chainCode = k.FillBytes(make([]byte, 32)), i[32:]
+			return child, nil
+		}
+		i = hmacSHA512(parent.chainCode, []byte{1}, i[32:], ser)
+	}
+}
+
+// hdFingerprint is the first four bytes of HASH160 of a public key.
+func hdFingerprint(pub []byte) []byte {
+	sum := sha256.Sum256(pub)
+	h := ripemd160.New()
+	h.Write(sum[:])
+	return h.Sum(nil)[:4]
+}
+
+// serialize encodes a node as a BIP32 extended key (xprv/xpub).
+func (n hdNode) serialize(version uint32, keyData []byte) string {
+	out := binary.BigEndian.AppendUint32(nil, version)
+	out = append(out, n.depth)
+	out = append(out, n.parentPrint...)
+	out = binary.BigEndian.AppendUint32(out, n.index)
+	out = append(out, n.chainCode...)
+	out = append(out, keyData...)
+	first := sha256.Sum256(out)
+	second := sha256.Sum256(first[:])
+	return base58Encode(append(out, second[:4]...))
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Encode(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	radix, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// GenerateMnemonic creates a new BIP39 English mnemonic.
+//
+// words: The number of words: 12, 15, 18, 21 or 24 (0 means 24).
+// Returns the mnemonic sentence.
+func (c *CryptoService) GenerateMnemonic(words int) (string, error) {
+	if words == 0 {
+		words = 24
+	}
+	if words%3 != 0 || words < 12 || words > 24 {
+		return "", fmt.Errorf("unsupported mnemonic length: %d words", words)
+	}
+	entropy, err := bip39.NewEntropy(words / 3 * 32)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// DeriveHDKey derives a key from a BIP39 mnemonic or raw seed along a BIP32
+// (secp256k1) or SLIP-10 (P-256, Ed25519) path.
+//
+// req: The HDDeriveRequest with the mnemonic or seed, curve and path.
+// Returns a KeyParseResult with the derived key; the summary holds the seed,
+// chain code, fingerprints and, for secp256k1, the xprv/xpub encodings.
+func (c *CryptoService) DeriveHDKey(req HDDeriveRequest) (KeyParseResult, error) {
+	hc, err := resolveHDCurve(req.Curve)
+	if err != nil {
+		return KeyParseResult{}, err
+	}
+	indexes, err := parseHDPath(req.Path)
+	if err != nil {
+		return KeyParseResult{}, err
+	}
+	var seed []byte
+	switch {
+	case strings.TrimSpace(req.Mnemonic) != "":
+		mnemonic := strings.Join(strings.Fields(strings.ToLower(req.Mnemonic)), " ")
+		if seed, err = bip39.NewSeedWithErrorChecking(mnemonic, req.Passphrase); err != nil {
+			return KeyParseResult{}, fmt.Errorf("invalid mnemonic: %w", err)
+		}
+	case strings.TrimSpace(req.Seed) != "":
+		if seed, err = hex.DecodeString(strings.TrimSpace(req.Seed)); err != nil {
+			return KeyParseResult{}, errors.New("seed must be hex")
+		}
+		if len(seed) < 16 || len(seed) > 64 {
+			return KeyParseResult{}, errors.New("seed must be 16 to 64 bytes")
+		}
+	default:
+		return KeyParseResult{}, errors.New("missing mnemonic or seed")
+	}
+
+	node := hc.master(seed)
+	masterPrint := hdFingerprint(hc.publicKey(node.key))
+	for _, index := range indexes {
+		if node, err = hc.child(node, index); err != nil {
+			return KeyParseResult{}, err
+		}
+	}
+
+	var priv any
+	if hc.curve == nil {
+		priv = ed25519.NewKeyFromSeed(node.key)
+	} else {
+		ecKey := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(node.key)}
+		ecKey.Curve = hc.curve
+		ecKey.X, ecKey.Y = hc.curve.ScalarBaseMult(node.key)
+		priv = ecKey
+	}
+	algorithm, privPEM, pubPEM, extra, err := marshalPrivateKeyPEM(priv)
+	if err != nil {
+		return KeyParseResult{}, err
+	}
+	path := formatHDPath(indexes)
+	pub := hc.publicKey(node.key)
+	summary := map[string]string{
+		"type":              "private",
+		"curve":             hc.name,
+		"scheme":            hc.scheme,
+		"path":              path,
+		"seed":              strings.ToUpper(hex.EncodeToString(seed)),
+		"chainCode":         strings.ToUpper(hex.EncodeToString(node.chainCode)),
+		"privateKey":        strings.ToUpper(hex.EncodeToString(node.key)),
+		"publicKey":         strings.ToUpper(hex.EncodeToString(pub)),
+		"masterFingerprint": strings.ToUpper(hex.EncodeToString(masterPrint)),
+		"parentFingerprint": strings.ToUpper(hex.EncodeToString(node.parentPrint)),
+		"depth":             strconv.Itoa(int(node.depth)),
+	}
+	if hc.curve == nil {
+		summary["publicKey"] = strings.ToUpper(hex.EncodeToString(pub[1:]))
+	}
+	if hc.xprvBytes != 0 {
+		summary["xprv"] = node.serialize(hc.xprvBytes, concatBytes([]byte{0}, node.key))
+		summary["xpub"] = node.serialize(hc.xpubBytes, pub)
+	}
+	result := KeyParseResult{PrivatePEM: privPEM, PublicPEM: pubPEM, Summary: summary}
+	if req.Save {
+		// The chain code and seed stay out of Extra: with the public key they
+		// would allow deriving the non-hardened siblings.
+		extra["derivationPath"] = path
+		extra["derivationScheme"] = hc.scheme
+		extra["masterFingerprint"] = summary["masterFingerprint"]
+		if hc.curve != nil {
+			if info, ok := describeCurve(hc.curve); ok {
+				extra["curveFamily"] = info.Family
+			}
+		}
+		stored, err := c.saveKey(StoredKey{
+			ID:         uuid.New().String(),
+			Name:       fallbackName(req.Name, hc.name+" "+path),
+			Algorithm:  algorithm,
+			KeyType:    "private",
+			Format:     "derived",
+			Usage:      req.Usage,
+			PrivatePEM: privPEM,
+			PublicPEM:  pubPEM,
+			Extra:      extra,
+			CreatedAt:  time.Now(),
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Stored = true
+		result.Key = &stored
+	}
+	return result, nil
+}
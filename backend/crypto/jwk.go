@@ -0,0 +1,601 @@
+package crypto
+
+import (
+	"bytes"
+	stdcrypto "crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/circl/sign/ed448"
+	"github.com/google/uuid"
+)
+
+// jsonWebKey is the RFC 7517 representation of a key. Members are declared
+// in the order they are written on export.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+	P   string `json:"p,omitempty"`
+	Q   string `json:"q,omitempty"`
+	DP  string `json:"dp,omitempty"`
+	DQ  string `json:"dq,omitempty"`
+	QI  string `json:"qi,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// jwkCurveNames maps registry identifiers to JOSE "crv" names.
+var jwkCurveNames = map[string]string{
+	"nistp256":  "P-256",
+	"nistp384":  "P-384",
+	"nistp521":  "P-521",
+	"secp256k1": "secp256k1",
+}
+
+// ExportJWKS exports several stored keys as a JSON Web Key Set.
+//
+// req: The JWKSExportRequest listing key IDs; an empty list exports every key with a JWK form.
+// Returns a KeyExportResult containing the JWKS JSON or an error.
+func (c *CryptoService) ExportJWKS(req JWKSExportRequest) (KeyExportResult, error) {
+	if req.IncludePrivate && c.keyStoreLocked() {
+		return KeyExportResult{}, errKeyStoreLocked
+	}
+	wanted := map[string]bool{}
+	for _, id := range req.IDs {
+		wanted[id] = true
+	}
+	set := jsonWebKeySet{Keys: []jsonWebKey{}}
+	thumbprints := map[string]string{}
+	for _, key := range c.readKeys() {
+		if len(wanted) > 0 && !wanted[key.ID] {
+			continue
+		}
+		jwk, err := storedKeyJWK(&key, req.IncludePrivate)
+		if err != nil {
+			if len(wanted) > 0 {
+				return KeyExportResult{}, fmt.Errorf("%s: %w", key.Name, err)
+			}
+			continue
+		}
+		delete(wanted, key.ID)
+		set.Keys = append(set.Keys, jwk)
+		thumbprints[jwk.Kid] = jwkThumbprint(jwk)
+	}
+	for id := range wanted {
+		return KeyExportResult{}, fmt.Errorf("key not found: %s", id)
+	}
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return KeyExportResult{}, err
+	}
+	details := map[string]string{"keys": fmt.Sprintf("%d", len(set.Keys))}
+	for kid, thumbprint := range thumbprints {
+		details["thumbprint:"+kid] = thumbprint
+	}
+	return KeyExportResult{Format: "jwks", Data: string(data), Details: details}, nil
+}
+
+func exportJWK(key *StoredKey, req KeyExportRequest, asSet bool) (KeyExportResult, error) {
+	jwk, err := storedKeyJWK(key, req.IncludePrivate)
+	if err != nil {
+		return KeyExportResult{}, err
+	}
+	var value any = jwk
+	format := "jwk"
+	if asSet {
+		value = jsonWebKeySet{Keys: []jsonWebKey{jwk}}
+		format = "jwks"
+	}
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return KeyExportResult{}, err
+	}
+	return KeyExportResult{
+		Format: format,
+		Data:   string(data),
+		Details: map[string]string{
+			"kid":        jwk.Kid,
+			"thumbprint": jwkThumbprint(jwk),
+		},
+	}, nil
+}
+
+// parseJWKKey converts a JWK (or single-key JWKS) into PEM and runs it through
+// the algorithm specific parser, keeping kid/alg in the stored metadata.
+func (c *CryptoService) parseJWKKey(req KeyParseRequest) (KeyParseResult, error) {
+	jwk, err := decodeJWK(req.Data)
+	if err != nil {
+		return KeyParseResult{Summary: map[string]string{}}, err
+	}
+	key, err := jwk.key()
+	if err != nil {
+		return KeyParseResult{Summary: map[string]string{}}, err
+	}
+	var keyPEM string
+	if jwk.D != "" {
+		_, keyPEM, _, _, err = marshalPrivateKeyPEM(key)
+	} else {
+		keyPEM, err = marshalPublicKeyPEM(key)
+	}
+	if err != nil {
+		return KeyParseResult{Summary: map[string]string{}}, err
+	}
+
+	sub := req
+	sub.Data = keyPEM
+	sub.Save = false
+	var result KeyParseResult
+	var algorithm string
+	switch jwk.Kty {
+	case "RSA":
+		algorithm = "RSA"
+		result, err = c.parseRSAKey(sub)
+	case "EC":
+		algorithm = "ECC"
+		result, err = c.parseECCKey(sub)
+	case "OKP":
+		algorithm = "EdDSA"
+		result, err = c.parseEdDSAKey(sub)
+	}
+	if err != nil {
+		return result, err
+	}
+	thumbprint := jwkThumbprint(jwk)
+	result.Summary["thumbprint"] = thumbprint
+	if jwk.Kid != "" {
+		result.Summary["kid"] = jwk.Kid
+	}
+	if jwk.Alg != "" {
+		result.Summary["alg"] = jwk.Alg
+	}
+	if jwk.Use != "" {
+		result.Summary["use"] = jwk.Use
+	}
+
+	if req.Save {
+		usage := req.Usage
+		if len(usage) == 0 {
+			switch jwk.Use {
+			case "sig":
+				usage = []string{"sign"}
+			case "enc":
+				usage = []string{"encrypt"}
+			}
+		}
+		extra := map[string]string{}
+		for _, field := range []string{"curve", "kid", "alg"} {
+			if value := result.Summary[field]; value != "" {
+				extra[field] = value
+			}
+		}
+		stored, err := c.saveKey(StoredKey{
+			ID:         uuid.New().String(),
+			Name:       fallbackName(req.Name, algorithm),
+			Algorithm:  algorithm,
+			KeyType:    result.Summary["type"],
+			Format:     "jwk",
+			Usage:      usage,
+			PrivatePEM: result.PrivatePEM,
+			PublicPEM:  result.PublicPEM,
+			Extra:      extra,
+			CreatedAt:  time.Now(),
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Stored = true
+		result.Key = &stored
+	}
+	return result, nil
+}
+
+func decodeJWK(data string) (jsonWebKey, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &probe); err != nil {
+		return jsonWebKey{}, fmt.Errorf("invalid JWK JSON: %w", err)
+	}
+	if _, ok := probe["keys"]; ok {
+		var set jsonWebKeySet
+		if err := json.Unmarshal([]byte(data), &set); err != nil {
+			return jsonWebKey{}, fmt.Errorf("invalid JWKS JSON: %w", err)
+		}
+		if len(set.Keys) != 1 {
+			return jsonWebKey{}, fmt.Errorf("JWKS contains %d keys; import them one at a time", len(set.Keys))
+		}
+		return set.Keys[0], nil
+	}
+	var jwk jsonWebKey
+	if err := json.Unmarshal([]byte(data), &jwk); err != nil {
+		return jsonWebKey{}, fmt.Errorf("invalid JWK JSON: %w", err)
+	}
+	return jwk, nil
+}
+
+// key returns the private key when "d" is present, otherwise the public key.
+func (j jsonWebKey) key() (any, error) {
+	switch j.Kty {
+	case "RSA":
+		return j.rsaKey()
+	case "EC":
+		return j.ecKey()
+	case "OKP":
+		return j.okpKey()
+	case "":
+		return nil, errors.New("JWK is missing kty")
+	default:
+		return nil, fmt.Errorf("unsupported JWK kty: %s", j.Kty)
+	}
+}
+
+func (j jsonWebKey) rsaKey() (any, error) {
+	n, err := jwkInt(j.N, "n")
+	if err != nil {
+		return nil, err
+	}
+	e, err := jwkInt(j.E, "e")
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("JWK exponent is too large")
+	}
+	pub := rsa.PublicKey{N: n, E: int(e.Int64())}
+	if j.D == "" {
+		return &pub, nil
+	}
+	if j.P == "" || j.Q == "" {
+		return nil, errors.New("RSA JWK private keys must include p and q")
+	}
+	d, err := jwkInt(j.D, "d")
+	if err != nil {
+		return nil, err
+	}
+	p, err := jwkInt(j.P, "p")
+	if err != nil {
+		return nil, err
+	}
+	q, err := jwkInt(j.Q, "q")
+	if err != nil {
+		return nil, err
+	}
+	priv := &rsa.PrivateKey{PublicKey: pub, D: d, Primes: []*big.Int{p, q}}
+	if err := priv.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid RSA JWK: %w", err)
+	}
+	priv.Precompute()
+	return priv, nil
+}
+
+func (j jsonWebKey) ecKey() (any, error) {
+	var info eccCurveInfo
+	found := false
+	for id, name := range jwkCurveNames {
+		if name == j.Crv {
+			info, found = eccCurveRegistry[id]
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unsupported JWK EC curve: %s", j.Crv)
+	}
+	x, err := jwkInt(j.X, "x")
+	if err != nil {
+		return nil, err
+	}
+	y, err := jwkInt(j.Y, "y")
+	if err != nil {
+		return nil, err
+	}
+	if !info.Curve.IsOnCurve(x, y) {
+		return nil, errors.New("JWK point is not on the curve")
+	}
+	pub := ecdsa.PublicKey{Curve: info.Curve, X: x, Y: y}
+	if j.D == "" {
+		return &pub, nil
+	}
+	d, err := jwkInt(j.D, "d")
+	if err != nil {
+		return nil, err
+	}
+	size := (info.Curve.Params().N.BitLen() + 7) / 8
+	if d.Sign() <= 0 || d.Cmp(info.Curve.Params().N) >= 0 {
+		return nil, errors.New("JWK private key out of range")
+	}
+	cx, cy := info.Curve.ScalarBaseMult(d.FillBytes(make([]byte, size)))
+	if cx.Cmp(x) != 0 || cy.Cmp(y) != 0 {
+		return nil, errors.New("JWK private key does not match x/y")
+	}
+	return &ecdsa.PrivateKey{PublicKey: pub, D: d}, nil
+}
+
+func (j jsonWebKey) okpKey() (any, error) {
+	x, err := base64.RawURLEncoding.DecodeString(j.X)
+	if err != nil || len(x) == 0 {
+		return nil, errors.New("invalid JWK member x")
+	}
+	var d []byte
+	if j.D != "" {
+		if d, err = base64.RawURLEncoding.DecodeString(j.D); err != nil {
+			return nil, errors.New("invalid JWK member d")
+		}
+	}
+	switch j.Crv {
+	case "Ed25519":
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("Ed25519 JWK x must be 32 bytes")
+		}
+		if d == nil {
+			return ed25519.PublicKey(x), nil
+		}
+		if len(d) != ed25519.SeedSize {
+			return nil, errors.New("Ed25519 JWK d must be 32 bytes")
+		}
+		priv := ed25519.NewKeyFromSeed(d)
+		if !priv.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+			return nil, errors.New("JWK private key does not match x")
+		}
+		return priv, nil
+	case "X25519":
+		if d == nil {
+			return ecdh.X25519().NewPublicKey(x)
+		}
+		priv, err := ecdh.X25519().NewPrivateKey(d)
+		if err != nil {
+			return nil, err
+		}
+		if string(priv.PublicKey().Bytes()) != string(x) {
+			return nil, errors.New("JWK private key does not match x")
+		}
+		return priv, nil
+	case "Ed448", "X448":
+		if d == nil {
+			return rawEdDSAPublic(x, j.Crv)
+		}
+		priv, err := rawEdDSAPrivate(d, j.Crv)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(eddsaPublicBytes(priv.(interface{ Public() stdcrypto.PublicKey }).Public()), x) {
+			return nil, errors.New("JWK private key does not match x")
+		}
+		return priv, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWK OKP curve: %s", j.Crv)
+	}
+}
+
+// storedKeyJWK builds the JWK for a stored key, with private members only when requested.
+func storedKeyJWK(key *StoredKey, includePrivate bool) (jsonWebKey, error) {
+	var material any
+	var err error
+	if includePrivate {
+		material, err = parseStoredPrivateKey(key)
+	} else {
+		material, err = parseStoredPublicKey(key)
+	}
+	if err != nil {
+		return jsonWebKey{}, err
+	}
+	jwk, err := jwkFromKey(material)
+	if err != nil {
+		return jsonWebKey{}, err
+	}
+	jwk.Kid = key.ID
+	if kid := key.Extra["kid"]; kid != "" {
+		jwk.Kid = kid
+	}
+	jwk.Use = jwkUse(key.Usage)
+	jwk.Alg = key.Extra["alg"]
+	if jwk.Alg == "" {
+		jwk.Alg = defaultJWKAlg(jwk)
+	}
+	return jwk, nil
+}
+
+func jwkFromKey(key any) (jsonWebKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{Kty: "RSA", N: jwkEncode(k.N.Bytes()), E: jwkEncode(big.NewInt(int64(k.E)).Bytes())}, nil
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return jsonWebKey{}, errors.New("multi-prime RSA keys have no JWK form")
+		}
+		k.Precompute()
+		jwk, _ := jwkFromKey(&k.PublicKey)
+		jwk.D = jwkEncode(k.D.Bytes())
+		jwk.P = jwkEncode(k.Primes[0].Bytes())
+		jwk.Q = jwkEncode(k.Primes[1].Bytes())
+		jwk.DP = jwkEncode(k.Precomputed.Dp.Bytes())
+		jwk.DQ = jwkEncode(k.Precomputed.Dq.Bytes())
+		jwk.QI = jwkEncode(k.Precomputed.Qinv.Bytes())
+		return jwk, nil
+	case *ecdsa.PublicKey:
+		info, ok := describeCurve(k.Curve)
+		crv := jwkCurveNames[info.Identifier]
+		if !ok || crv == "" {
+			return jsonWebKey{}, fmt.Errorf("curve %s has no JWK name", k.Curve.Params().Name)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return jsonWebKey{Kty: "EC", Crv: crv, X: jwkEncode(k.X.FillBytes(make([]byte, size))), Y: jwkEncode(k.Y.FillBytes(make([]byte, size)))}, nil
+	case *ecdsa.PrivateKey:
+		jwk, err := jwkFromKey(&k.PublicKey)
+		if err != nil {
+			return jwk, err
+		}
+		jwk.D = jwkEncode(k.D.FillBytes(make([]byte, (k.Curve.Params().N.BitLen()+7)/8)))
+		return jwk, nil
+	case ed25519.PublicKey:
+		return jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: jwkEncode(k)}, nil
+	case ed25519.PrivateKey:
+		return jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: jwkEncode(k.Public().(ed25519.PublicKey)), D: jwkEncode(k.Seed())}, nil
+	case ed448.PublicKey:
+		return jsonWebKey{Kty: "OKP", Crv: "Ed448", X: jwkEncode(k)}, nil
+	case ed448.PrivateKey:
+		return jsonWebKey{Kty: "OKP", Crv: "Ed448", X: jwkEncode(k.Public().(ed448.PublicKey)), D: jwkEncode(k.Seed())}, nil
+	case x448PublicKey:
+		return jsonWebKey{Kty: "OKP", Crv: "X448", X: jwkEncode(k)}, nil
+	case x448PrivateKey:
+		return jsonWebKey{Kty: "OKP", Crv: "X448", X: jwkEncode(k.Public().(x448PublicKey)), D: jwkEncode(k)}, nil
+	case *ecdh.PublicKey:
+		if k.Curve() != ecdh.X25519() {
+			return jsonWebKey{}, errors.New("unsupported ECDH curve for JWK")
+		}
+		return jsonWebKey{Kty: "OKP", Crv: "X25519", X: jwkEncode(k.Bytes())}, nil
+	case *ecdh.PrivateKey:
+		jwk, err := jwkFromKey(k.PublicKey())
+		if err != nil {
+			return jwk, err
+		}
+		jwk.D = jwkEncode(k.Bytes())
+		return jwk, nil
+	default:
+		return jsonWebKey{}, fmt.Errorf("key type %T has no JWK form", key)
+	}
+}
+
+// jwkUse derives the JWK "use" member from free-form StoredKey usages.
+func jwkUse(usage []string) string {
+	var sig, enc bool
+	for _, u := range usage {
+		switch strings.ToLower(strings.TrimSpace(u)) {
+		case "sign", "verify", "sig", "signature", "leaf", "ca":
+			sig = true
+		case "encrypt", "decrypt", "enc", "encryption", "wrap", "unwrap", "derive", "agreement", "exchange":
+			enc = true
+		}
+	}
+	switch {
+	case sig && !enc:
+		return "sig"
+	case enc && !sig:
+		return "enc"
+	default:
+		return ""
+	}
+}
+
+func defaultJWKAlg(jwk jsonWebKey) string {
+	switch jwk.Kty {
+	case "RSA":
+		if jwk.Use == "enc" {
+			return "RSA-OAEP-256"
+		}
+		return "RS256"
+	case "EC":
+		if jwk.Use == "enc" {
+			return "ECDH-ES"
+		}
+		switch jwk.Crv {
+		case "P-256":
+			return "ES256"
+		case "P-384":
+			return "ES384"
+		case "P-521":
+			return "ES512"
+		case "secp256k1":
+			return "ES256K"
+		}
+	case "OKP":
+		if jwk.Crv == "X25519" || jwk.Crv == "X448" {
+			return "ECDH-ES"
+		}
+		return "EdDSA"
+	}
+	return ""
+}
+
+// jwkThumbprint computes the RFC 7638 SHA-256 thumbprint of a JWK.
+func jwkThumbprint(jwk jsonWebKey) string {
+	var canonical string
+	switch jwk.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	default:
+		return ""
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return jwkEncode(sum[:])
+}
+
+// parseStoredPublicKey returns the public key object held by a stored key.
+func parseStoredPublicKey(key *StoredKey) (any, error) {
+	if key.PublicPEM == "" {
+		priv, err := parseStoredPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return priv.(interface{ Public() stdcrypto.PublicKey }).Public(), nil
+	}
+	switch strings.ToUpper(key.Algorithm) {
+	case "RSA":
+		return parseRSAPublic(key.PublicPEM)
+	case "ECC":
+		return ensureECCPublic(keyMaterial{publicPEM: key.PublicPEM})
+	case "SM2":
+		return ensureSM2Public(keyMaterial{publicPEM: key.PublicPEM})
+	case "EDDSA":
+		return parseEdDSAPublic(key.PublicPEM)
+	default:
+		return nil, fmt.Errorf("%s public keys are not supported here", key.Algorithm)
+	}
+}
+
+// marshalPublicKeyPEM encodes a public key as a PKIX PEM block.
+func marshalPublicKeyPEM(pub any) (string, error) {
+	der, err := marshalPublicKeyDER(pub)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// marshalPublicKeyDER encodes a public key as SubjectPublicKeyInfo DER,
+// including the curves and Ed448/X448 keys crypto/x509 does not know.
+func marshalPublicKeyDER(pub any) ([]byte, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return marshalECPublicKey(k)
+	case ed448.PublicKey, x448PublicKey:
+		return marshalEdDSAPublicKey(k)
+	default:
+		return x509.MarshalPKIXPublicKey(pub)
+	}
+}
+
+func jwkInt(value, name string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("invalid JWK member %s", name)
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+func jwkEncode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,231 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	defaultPBKDF2Iterations = 10000
+	defaultScryptCost       = 32768
+	defaultArgon2Time       = 3
+	defaultArgon2Memory     = 64 * 1024 // KiB
+	defaultArgon2Threads    = 4
+	maxKDFLength            = 1 << 20
+)
+
+// RunKDF derives key material with a password or key derivation function.
+//
+// req: The KDFRequest with the algorithm, secret and parameters.
+// Returns an OperationResult with the derived bytes and the parameters used.
+func (c *CryptoService) RunKDF(req KDFRequest) (OperationResult, error) {
+	algo := strings.ToLower(strings.TrimSpace(req.Algorithm))
+	secret, err := decodeBlob(req.Secret, req.SecretFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid secret: %w", err)
+	}
+	salt, err := decodeBlob(req.Salt, req.SaltFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid salt: %w", err)
+	}
+	info, err := decodeBlob(req.Info, req.InfoFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid info: %w", err)
+	}
+	length := req.Length
+	if length == 0 {
+		length = 32
+	}
+	if length < 0 || length > maxKDFLength {
+		return OperationResult{}, fmt.Errorf("output length must be between 1 and %d bytes", maxKDFLength)
+	}
+	hashName := strings.ToLower(req.Hash)
+	if hashName == "" {
+		hashName = "sha256"
+	}
+	details := map[string]string{"algorithm": algo, "length": strconv.Itoa(length)}
+
+	var out []byte
+	switch algo {
+	case "pbkdf2":
+		h, err := digestHash(hashName, "")
+		if err != nil {
+			return OperationResult{}, err
+		}
+		newHash := h.newHash
+		iterations := req.Iterations
+		if iterations == 0 {
+			iterations = defaultPBKDF2Iterations
+		}
+		if err := checkPBKDF2Iterations(iterations); err != nil {
+			return OperationResult{}, err
+		}
+		out = pbkdf2.Key(secret, salt, iterations, length, newHash)
+		details["hash"], details["iterations"] = hashName, strconv.Itoa(iterations)
+	case "hkdf", "hkdf-extract", "hkdf-expand":
+		h, err := digestHash(hashName, "")
+		if err != nil {
+			return OperationResult{}, err
+		}
+		newHash := h.newHash
+		details["hash"] = hashName
+		switch algo {
+		case "hkdf-extract":
+			// The PRK always has the hash length.
+			out = hkdf.Extract(newHash, secret, salt)
+			details["length"] = strconv.Itoa(len(out))
+		case "hkdf-expand":
+			if len(secret) < newHash().Size() {
+				return OperationResult{}, errors.New("HKDF-Expand needs a PRK at least as long as the hash")
+			}
+			out, err = readKDF(hkdf.Expand(newHash, secret, info), length)
+		default:
+			out, err = readKDF(hkdf.New(newHash, secret, salt, info), length)
+		}
+		if err != nil {
+			return OperationResult{}, err
+		}
+	case "scrypt":
+		n, r, p := req.Cost, req.BlockSize, req.Parallelism
+		if n == 0 {
+			n = defaultScryptCost
+		}
+		if r == 0 {
+			r = 8
+		}
+		if p == 0 {
+			p = 1
+		}
+		if n < 2 || n&(n-1) != 0 {
+			return OperationResult{}, errors.New("scrypt N must be a power of 2 greater than 1")
+		}
+		if err := checkScryptParams(bits.TrailingZeros(uint(n)), r, p); err != nil {
+			return OperationResult{}, err
+		}
+		if out, err = scrypt.Key(secret, salt, n, r, p, length); err != nil {
+			return OperationResult{}, err
+		}
+		details["N"], details["r"], details["p"] = strconv.Itoa(n), strconv.Itoa(r), strconv.Itoa(p)
+	case "argon2", "argon2id", "argon2i", "argon2d":
+		mode := map[string]int{"argon2": argon2id, "argon2id": argon2id, "argon2i": argon2i, "argon2d": argon2d}[algo]
+		key, err := decodeBlob(req.Key, req.KeyFormat)
+		if err != nil {
+			return OperationResult{}, fmt.Errorf("invalid key: %w", err)
+		}
+		t, m, p := req.Iterations, req.Memory, req.Parallelism
+		if t == 0 {
+			t = defaultArgon2Time
+		}
+		if m == 0 {
+			m = defaultArgon2Memory
+		}
+		if p == 0 {
+			p = defaultArgon2Threads
+		}
+		if t < 0 || p < 1 || p > 255 || m < 8*p || m > 4<<20 {
+			return OperationResult{}, errors.New("argon2 needs iterations >= 1, parallelism 1-255 and memory between 8*parallelism KiB and 4 GiB")
+		}
+		if length < 4 {
+			return OperationResult{}, errors.New("argon2 output must be at least 4 bytes")
+		}
+		out = argon2Key(mode, secret, salt, key, info, uint32(t), uint32(m), uint8(p), uint32(length))
+		details["iterations"], details["memory"], details["parallelism"] = strconv.Itoa(t), strconv.Itoa(m), strconv.Itoa(p)
+	case "sm3-kdf", "sm3kdf", "x963", "x9.63", "ansi-x963":
+		name := "sm3"
+		if !strings.HasPrefix(algo, "sm3") {
+			name = "x963-" + hashName
+		}
+		kdf, err := resolveAgreementKDF(name, "")
+		if err != nil {
+			return OperationResult{}, err
+		}
+		if out, err = kdf.derive(secret, length, info, nil); err != nil {
+			return OperationResult{}, err
+		}
+		details["kdf"] = kdf.name
+	case "sp800-108", "sp800-108-counter", "kdf-ctr", "counter":
+		prf, name, err := resolveSP800108PRF(req.PRF, hashName, secret)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		label, err := decodeBlob(req.Label, req.LabelFormat)
+		if err != nil {
+			return OperationResult{}, fmt.Errorf("invalid label: %w", err)
+		}
+		context, err := decodeBlob(req.Context, req.ContextFormat)
+		if err != nil {
+			return OperationResult{}, fmt.Errorf("invalid context: %w", err)
+		}
+		out = sp800108Counter(prf, length, label, context)
+		details["prf"] = name
+		details["label"] = strings.ToUpper(hex.EncodeToString(label))
+		details["context"] = strings.ToUpper(hex.EncodeToString(context))
+	default:
+		return OperationResult{}, fmt.Errorf("unsupported KDF: %s", req.Algorithm)
+	}
+	details["base64"] = encodeBase64(out)
+	return OperationResult{Output: encodeOutputBytes(out, req.OutputFormat), Details: details}, nil
+}
+
+func readKDF(r io.Reader, length int) ([]byte, error) {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// resolveSP800108PRF selects HMAC with the request hash, or CMAC when prf is
+// "cmac-<cipher>" such as cmac-aes or cmac-sm4.
+func resolveSP800108PRF(prf, hashName string, key []byte) (func([]byte) []byte, string, error) {
+	prf = strings.ToLower(strings.TrimSpace(prf))
+	if cipherName, ok := strings.CutPrefix(prf, "cmac-"); ok {
+		block, err := resolveBlockCipher(cipherName, key)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := cmacRbConstant(block.BlockSize()); err != nil {
+			return nil, "", err
+		}
+		return func(data []byte) []byte {
+			mac, _ := computeCMAC(block, data)
+			return mac
+		}, prf, nil
+	}
+	if name, ok := strings.CutPrefix(prf, "hmac-"); ok {
+		hashName = name
+	} else if prf != "" && prf != "hmac" {
+		return nil, "", fmt.Errorf("unsupported PRF: %s", prf)
+	}
+	h, err := digestHash(hashName, "")
+	if err != nil {
+		return nil, "", err
+	}
+	return func(data []byte) []byte {
+		mac := hmac.New(h.newHash, key)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}, "hmac-" + hashName, nil
+}
+
+// sp800108Counter is the NIST SP 800-108 KDF in counter mode with a 32-bit
+// counter before the fixed input Label || 0x00 || Context || [L]_32.
+func sp800108Counter(prf func([]byte) []byte, length int, label, context []byte) []byte {
+	fixed := concatBytes(label, []byte{0}, context, binary.BigEndian.AppendUint32(nil, uint32(length)*8))
+	out := make([]byte, 0, length+64)
+	for i := uint32(1); len(out) < length; i++ {
+		out = append(out, prf(concatBytes(binary.BigEndian.AppendUint32(nil, i), fixed))...)
+	}
+	return out[:length]
+}
@@ -0,0 +1,181 @@
+package crypto
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emmansun/gmsm/pkcs"
+	"github.com/emmansun/gmsm/pkcs8"
+	"github.com/emmansun/gmsm/smx509"
+)
+
+const defaultPBES2Iterations = 100000
+
+// encryptedPrivateKeyInfo mirrors the PKCS#8 EncryptedPrivateKeyInfo structure.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// ExportKey exports a stored key in the requested format.
+//
+// req: The KeyExportRequest naming the key, target format and optional passphrase.
+// Returns a KeyExportResult with the encoded key or an error.
+func (c *CryptoService) ExportKey(req KeyExportRequest) (KeyExportResult, error) {
+	key, err := c.findKey(req.ID)
+	if err != nil {
+		return KeyExportResult{}, err
+	}
+	format := strings.ToLower(strings.TrimSpace(req.Format))
+	switch format {
+	case "", "pem":
+		data := key.PrivatePEM
+		if data == "" {
+			data = key.PublicPEM
+		}
+		return KeyExportResult{Format: "pem", Data: data}, nil
+	case "public":
+		if key.PublicPEM == "" {
+			return KeyExportResult{}, errors.New("key has no public component")
+		}
+		return KeyExportResult{Format: "public", Data: key.PublicPEM}, nil
+	case "pkcs8":
+		return exportPKCS8(key, req)
+	case "jwk", "jwks":
+		return exportJWK(key, req, format == "jwks")
+	case "openssh", "authorized_keys":
+		return exportOpenSSH(key, req, format)
+	case "ppk", "putty":
+		return exportOpenSSH(key, req, "ppk")
+	default:
+		return KeyExportResult{}, fmt.Errorf("unsupported export format: %s", req.Format)
+	}
+}
+
+func exportPKCS8(key *StoredKey, req KeyExportRequest) (KeyExportResult, error) {
+	priv, err := parseStoredPrivateKey(key)
+	if err != nil {
+		return KeyExportResult{}, err
+	}
+	if req.Passphrase == "" {
+		der, err := smx509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return KeyExportResult{}, err
+		}
+		return KeyExportResult{
+			Format: "pkcs8",
+			Data:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+			Details: map[string]string{
+				"derBase64": encodeBase64(der),
+			},
+		}, nil
+	}
+	scheme := strings.ToLower(strings.TrimSpace(req.Scheme))
+	if scheme == "" {
+		scheme = "pbes2-aes256-sha256"
+	}
+	iterations := req.Iterations
+	if iterations <= 0 {
+		iterations = defaultPBES2Iterations
+	}
+	encrypter, err := resolvePBESEncrypter(scheme, iterations)
+	if err != nil {
+		return KeyExportResult{}, err
+	}
+	der, err := pkcs8.MarshalPrivateKey(priv, []byte(req.Passphrase), encrypter)
+	if err != nil {
+		return KeyExportResult{}, err
+	}
+	return KeyExportResult{
+		Format: "pkcs8",
+		Data:   string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der})),
+		Details: map[string]string{
+			"scheme":     scheme,
+			"iterations": fmt.Sprintf("%d", iterations),
+			"derBase64":  encodeBase64(der),
+		},
+	}, nil
+}
+
+// resolvePBESEncrypter maps a scheme name to a PBES2 encrypter.
+func resolvePBESEncrypter(scheme string, iterations int) (pkcs.PBESEncrypter, error) {
+	switch scheme {
+	case "pbes2-aes256-sha256":
+		return pkcs.NewPBESEncrypter(pkcs.AES256CBC, pkcs.NewPBKDF2Opts(pkcs.SHA256, 16, iterations)), nil
+	case "pbes2-aes128-sha256":
+		return pkcs.NewPBESEncrypter(pkcs.AES128CBC, pkcs.NewPBKDF2Opts(pkcs.SHA256, 16, iterations)), nil
+	case "pbes2-aes256-sha512":
+		return pkcs.NewPBESEncrypter(pkcs.AES256CBC, pkcs.NewPBKDF2Opts(pkcs.SHA512, 16, iterations)), nil
+	case "pbes2-aes256-sha1":
+		return pkcs.NewPBESEncrypter(pkcs.AES256CBC, pkcs.NewPBKDF2Opts(pkcs.SHA1, 16, iterations)), nil
+	case "pbes2-3des-sha1":
+		return pkcs.NewPBESEncrypter(pkcs.TripleDESCBC, pkcs.NewPBKDF2Opts(pkcs.SHA1, 8, iterations)), nil
+	case "pbes2-sm4-sm3":
+		return pkcs.NewPBESEncrypter(pkcs.SM4CBC, pkcs.NewPBKDF2Opts(pkcs.SM3, 16, iterations)), nil
+	case "smpbes", "sm-pbes":
+		return pkcs.NewSMPBESEncrypter(16, iterations), nil
+	default:
+		return nil, fmt.Errorf("unsupported PBES2 scheme: %s", scheme)
+	}
+}
+
+// parseStoredPrivateKey returns the private key object held by a stored key.
+func parseStoredPrivateKey(key *StoredKey) (any, error) {
+	if key.PrivatePEM == "" {
+		return nil, errors.New("key has no private component")
+	}
+	switch strings.ToUpper(key.Algorithm) {
+	case "RSA":
+		return parseRSAPrivate(key.PrivatePEM)
+	case "ECC":
+		return parseECCPrivate(key.PrivatePEM)
+	case "SM2":
+		return parseSM2Private(key.PrivatePEM)
+	case "EDDSA":
+		return parseEdDSAPrivate(key.PrivatePEM)
+	default:
+		return nil, fmt.Errorf("export of %s private keys is not supported", key.Algorithm)
+	}
+}
+
+// decryptPKCS8 unwraps an EncryptedPrivateKeyInfo into plain PKCS#8 DER.
+// Unencrypted input is returned unchanged.
+func decryptPKCS8(block *pem.Block, der []byte, passphrase string) ([]byte, string, error) {
+	scheme, encrypted := encryptedPKCS8Scheme(block, der)
+	if !encrypted {
+		return der, "", nil
+	}
+	if passphrase == "" {
+		return nil, scheme, errors.New("encrypted private key requires a passphrase")
+	}
+	key, _, err := pkcs8.ParsePrivateKey(der, []byte(passphrase))
+	if err != nil {
+		return nil, scheme, fmt.Errorf("unable to decrypt private key: %w", err)
+	}
+	plain, err := smx509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, scheme, err
+	}
+	return plain, scheme, nil
+}
+
+func encryptedPKCS8Scheme(block *pem.Block, der []byte) (string, bool) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return "", block != nil && block.Type == "ENCRYPTED PRIVATE KEY"
+	}
+	switch {
+	case pkcs.IsSMPBES(info.Algorithm):
+		return "SM-PBES", true
+	case pkcs.IsPBES2(info.Algorithm):
+		return "PBES2", true
+	case pkcs.IsPBES1(info.Algorithm):
+		return "PBES1", true
+	default:
+		return "", block != nil && block.Type == "ENCRYPTED PRIVATE KEY"
+	}
+}
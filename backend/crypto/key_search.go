@@ -0,0 +1,282 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UpdateStoredKey edits the metadata of a stored key. Key material is never touched.
+//
+// req: The KeyUpdateRequest naming the key and the fields to change.
+// Returns the updated StoredKey or an error.
+func (c *CryptoService) UpdateStoredKey(req KeyUpdateRequest) (StoredKey, error) {
+	if strings.TrimSpace(req.ID) == "" {
+		return StoredKey{}, errors.New("missing key id")
+	}
+	var updated StoredKey
+	err := c.updateKeys(func(keys []StoredKey) ([]StoredKey, error) {
+		for i := range keys {
+			if keys[i].ID == req.ID {
+				keys[i] = applyKeyUpdate(keys[i], req)
+				updated = keys[i]
+				return keys, nil
+			}
+		}
+		return nil, errors.New("key not found")
+	})
+	if err != nil {
+		return StoredKey{}, err
+	}
+	return updated, nil
+}
+
+func applyKeyUpdate(key StoredKey, req KeyUpdateRequest) StoredKey {
+	if name := strings.TrimSpace(req.Name); name != "" {
+		key.Name = name
+	}
+	if req.Usage != nil {
+		key.Usage = normalizeLabels(req.Usage)
+	}
+	if req.Tags != nil {
+		key.Tags = normalizeLabels(req.Tags)
+	}
+	if len(req.AddTags) > 0 {
+		key.Tags = normalizeLabels(append(append([]string{}, key.Tags...), req.AddTags...))
+	}
+	if len(req.RemoveTags) > 0 {
+		key.Tags = removeLabels(key.Tags, req.RemoveTags)
+	}
+	if len(req.Extra) > 0 {
+		extra := make(map[string]string, len(key.Extra)+len(req.Extra))
+		for k, v := range key.Extra {
+			extra[k] = v
+		}
+		for k, v := range req.Extra {
+			if v == "" {
+				delete(extra, k)
+			} else {
+				extra[k] = v
+			}
+		}
+		key.Extra = extra
+	}
+	return key
+}
+
+// QueryStoredKeys filters and sorts the stored keys.
+//
+// query: The KeyQuery holding the filters and sort order. Empty filters match everything.
+// Returns the matching keys or an error for malformed dates or sort fields.
+func (c *CryptoService) QueryStoredKeys(query KeyQuery) ([]StoredKey, error) {
+	after, err := parseQueryTime(query.CreatedAfter, false)
+	if err != nil {
+		return nil, err
+	}
+	before, err := parseQueryTime(query.CreatedBefore, true)
+	if err != nil {
+		return nil, err
+	}
+	less, err := keySortFunc(query.SortBy)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := normalizeFingerprint(query.Fingerprint)
+	name := strings.ToLower(strings.TrimSpace(query.Name))
+
+	result := []StoredKey{}
+	for _, key := range c.readKeys() {
+		if query.Algorithm != "" && !strings.EqualFold(key.Algorithm, query.Algorithm) {
+			continue
+		}
+		if query.KeyType != "" && !strings.EqualFold(key.KeyType, query.KeyType) {
+			continue
+		}
+		if query.Curve != "" && !matchesCurve(key, query.Curve) {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(key.Name), name) {
+			continue
+		}
+		if !containsLabels(key.Usage, query.Usage) || !containsLabels(key.Tags, query.Tags) {
+			continue
+		}
+		if !after.IsZero() && key.CreatedAt.Before(after) {
+			continue
+		}
+		if !before.IsZero() && !key.CreatedAt.Before(before) {
+			continue
+		}
+		if fingerprint != "" && !matchesFingerprint(key, fingerprint) {
+			continue
+		}
+		result = append(result, key)
+	}
+
+	desc := !strings.EqualFold(strings.TrimSpace(query.Order), "asc")
+	sort.SliceStable(result, func(i, j int) bool {
+		if desc {
+			return less(result[j], result[i])
+		}
+		return less(result[i], result[j])
+	})
+	return result, nil
+}
+
+// keyFingerprint returns the SHA-256 of the key's SubjectPublicKeyInfo DER.
+// The public key is derived first, so PKCS#1 public keys and keys stored with
+// only a private part get the same fingerprint as their SPKI form.
+func keyFingerprint(key StoredKey) string {
+	var der []byte
+	if pub, err := parseStoredPublicKey(&key); err == nil {
+		der, _ = marshalPublicKeyDER(pub)
+	}
+	if der == nil {
+		block, _ := pem.Decode([]byte(key.PublicPEM))
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return ""
+		}
+		der = block.Bytes
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+func matchesFingerprint(key StoredKey, fingerprint string) bool {
+	if fp := keyFingerprint(key); fp != "" && strings.HasPrefix(fp, fingerprint) {
+		return true
+	}
+	// OpenSSH style "SHA256:..." fingerprints are base64 and matched verbatim.
+	ssh, ok := strings.CutPrefix(key.Extra["sshFingerprint"], "SHA256:")
+	return ok && ssh == strings.TrimPrefix(fingerprint, "sha256:")
+}
+
+func normalizeFingerprint(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	if strings.HasPrefix(strings.ToUpper(value), "SHA256:") {
+		return "sha256:" + value[len("SHA256:"):]
+	}
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(value))
+}
+
+func matchesCurve(key StoredKey, curve string) bool {
+	stored := key.Extra["curve"]
+	if stored == "" {
+		return false
+	}
+	if strings.EqualFold(stored, curve) {
+		return true
+	}
+	want, ok := lookupCurveLabel(curve)
+	if !ok {
+		return false
+	}
+	have, ok := lookupCurveLabel(stored)
+	return ok && have.Identifier == want.Identifier
+}
+
+// lookupCurveLabel resolves a registered curve by identifier, alias or display name.
+func lookupCurveLabel(name string) (eccCurveInfo, bool) {
+	if info, ok := describeCurveByParamsName(name); ok {
+		return info, true
+	}
+	for _, info := range eccCurveRegistry {
+		if strings.EqualFold(info.Display, strings.TrimSpace(name)) {
+			return info, true
+		}
+	}
+	return eccCurveInfo{}, false
+}
+
+func parseQueryTime(value string, end bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date: %s", value)
+	}
+	if end {
+		// A bare date as the upper bound includes the whole day.
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func keySortFunc(field string) (func(a, b StoredKey) bool, error) {
+	switch strings.ToLower(strings.TrimSpace(field)) {
+	case "", "createdat", "created":
+		return func(a, b StoredKey) bool { return a.CreatedAt.Before(b.CreatedAt) }, nil
+	case "name":
+		return func(a, b StoredKey) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }, nil
+	case "algorithm":
+		return func(a, b StoredKey) bool {
+			if !strings.EqualFold(a.Algorithm, b.Algorithm) {
+				return strings.ToUpper(a.Algorithm) < strings.ToUpper(b.Algorithm)
+			}
+			return a.Extra["curve"] < b.Extra["curve"]
+		}, nil
+	case "curve":
+		return func(a, b StoredKey) bool { return a.Extra["curve"] < b.Extra["curve"] }, nil
+	default:
+		return nil, fmt.Errorf("unsupported sort field: %s", field)
+	}
+}
+
+// normalizeLabels trims, lower-cases and de-duplicates usage or tag values.
+func normalizeLabels(values []string) []string {
+	out := make([]string, 0, len(values))
+	seen := map[string]bool{}
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+func removeLabels(values, remove []string) []string {
+	drop := map[string]bool{}
+	for _, v := range normalizeLabels(remove) {
+		drop[v] = true
+	}
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if !drop[strings.ToLower(v)] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// containsLabels reports whether every wanted label is present.
+func containsLabels(have, want []string) bool {
+	for _, w := range normalizeLabels(want) {
+		found := false
+		for _, h := range have {
+			if strings.EqualFold(strings.TrimSpace(h), w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
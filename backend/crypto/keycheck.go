@@ -0,0 +1,671 @@
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+)
+
+// Key check statuses, from worst to best.
+const (
+	keyCheckFail = "fail"
+	keyCheckWarn = "warn"
+	keyCheckPass = "pass"
+	keyCheckInfo = "info"
+)
+
+var oidNamedCurveSM2 = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301}
+
+// analysedKey is a key loaded for analysis. EC keys keep their raw point and
+// scalar so that values a strict parser would reject can still be reported.
+type analysedKey struct {
+	algorithm string // RSA, ECC, SM2, EdDSA
+	keyType   string // private, public, certificate
+	rsaPub    *rsa.PublicKey
+	rsaPriv   *rsa.PrivateKey
+	curve     elliptic.Curve
+	curveName string
+	x, y, d   *big.Int
+	pub       any // EdDSA public key
+}
+
+// AnalyzeKey checks a key for internal consistency and known weaknesses.
+//
+// req: The KeyAnalysisRequest naming a stored key or carrying key material,
+// plus an optional key or certificate that should pair with it.
+// Returns a KeyAnalysisReport listing every check; Passed is false when any
+// check failed.
+func (c *CryptoService) AnalyzeKey(req KeyAnalysisRequest) (KeyAnalysisReport, error) {
+	var checks []KeyCheck
+	var primary analysedKey
+	var storedID string
+	switch {
+	case req.KeyID != "":
+		stored, err := c.findKey(req.KeyID)
+		if err != nil {
+			return KeyAnalysisReport{}, err
+		}
+		if stored.Format == "pkcs11" {
+			return KeyAnalysisReport{}, errors.New("token keys cannot be analysed; analyse the exported public key instead")
+		}
+		storedID = stored.ID
+		primary, checks, err = loadStoredAnalysisKey(stored)
+		if err != nil {
+			return KeyAnalysisReport{}, err
+		}
+	case strings.TrimSpace(req.KeyData) != "":
+		var err error
+		primary, err = parseAnalysisInput(req.KeyData, req.Algorithm, req.Curve, req.Passphrase)
+		if err != nil {
+			return KeyAnalysisReport{}, err
+		}
+	default:
+		return KeyAnalysisReport{}, errors.New("missing key id or key data")
+	}
+
+	// Pairing checks against the explicit counterpart and, for stored keys,
+	// every stored certificate issued for the key.
+	if req.CompareKeyID != "" {
+		other, err := c.findKey(req.CompareKeyID)
+		if err != nil {
+			return KeyAnalysisReport{}, err
+		}
+		counterpart, _, err := loadStoredAnalysisKey(other)
+		if err != nil {
+			return KeyAnalysisReport{}, err
+		}
+		checks = append(checks, pairCheck("match:"+fallbackName(other.Name, other.ID), primary, counterpart))
+	}
+	if req.CompareCertID != "" {
+		cert, err := c.findCertRecord(req.CompareCertID)
+		if err != nil {
+			return KeyAnalysisReport{}, err
+		}
+		counterpart, err := parseAnalysisInput(cert.CertPEM, "", "", "")
+		if err != nil {
+			return KeyAnalysisReport{}, err
+		}
+		checks = append(checks, pairCheck("match:"+fallbackName(cert.Name, cert.ID), primary, counterpart))
+	}
+	if strings.TrimSpace(req.CompareData) != "" {
+		counterpart, err := parseAnalysisInput(req.CompareData, req.Algorithm, req.Curve, req.Passphrase)
+		if err != nil {
+			return KeyAnalysisReport{}, fmt.Errorf("compare data: %w", err)
+		}
+		checks = append(checks, pairCheck("match:"+counterpart.keyType, primary, counterpart))
+	}
+	if storedID != "" {
+		for _, cert := range c.readCerts() {
+			if cert.KeyID != storedID || cert.ID == req.CompareCertID {
+				continue
+			}
+			if counterpart, err := parseAnalysisInput(cert.CertPEM, "", "", ""); err == nil {
+				checks = append(checks, pairCheck("match:"+fallbackName(cert.Name, cert.ID), primary, counterpart))
+			}
+		}
+	}
+
+	report := KeyAnalysisReport{Algorithm: primary.algorithm, KeyType: primary.keyType, Curve: primary.curveName}
+	switch {
+	case primary.rsaPub != nil:
+		report.Bits = primary.rsaPub.N.BitLen()
+		checks = append(checks, rsaKeyChecks(primary, c.rsaModuliExcept(storedID))...)
+	case primary.curve != nil:
+		report.Bits = primary.curve.Params().BitSize
+		checks = append(checks, ecKeyChecks(primary)...)
+	default:
+		checks = append(checks, KeyCheck{Name: "weakness", Status: keyCheckInfo, Detail: "no weakness checks apply to " + primary.algorithm + " keys"})
+	}
+	report.Checks = checks
+	report.Passed = true
+	for _, check := range checks {
+		if check.Status == keyCheckFail {
+			report.Passed = false
+		}
+	}
+	return report, nil
+}
+
+// loadStoredAnalysisKey loads a stored key, preferring the private half, and
+// checks that its private and public PEM blocks belong together.
+func loadStoredAnalysisKey(stored *StoredKey) (analysedKey, []KeyCheck, error) {
+	var checks []KeyCheck
+	var private, public *analysedKey
+	if stored.PrivatePEM != "" {
+		key, err := parseAnalysisInput(stored.PrivatePEM, stored.Algorithm, stored.Extra["curve"], "")
+		if err != nil {
+			return analysedKey{}, nil, err
+		}
+		private = &key
+	}
+	if stored.PublicPEM != "" {
+		key, err := parseAnalysisInput(stored.PublicPEM, stored.Algorithm, stored.Extra["curve"], "")
+		if err != nil {
+			return analysedKey{}, nil, err
+		}
+		public = &key
+	}
+	switch {
+	case private != nil && public != nil:
+		checks = append(checks, pairCheck("storedPair", *private, *public))
+		return *private, checks, nil
+	case private != nil:
+		return *private, checks, nil
+	case public != nil:
+		return *public, checks, nil
+	default:
+		return analysedKey{}, nil, errors.New("key has no key material")
+	}
+}
+
+func (c *CryptoService) findCertRecord(id string) (CertRecord, error) {
+	for _, cert := range append(c.readCerts(), c.readCACerts()...) {
+		if cert.ID == id {
+			return cert, nil
+		}
+	}
+	return CertRecord{}, errors.New("certificate not found")
+}
+
+// parseAnalysisInput loads a PEM key or certificate, or a raw hex EC scalar or
+// point when algorithm and curve describe it.
+func parseAnalysisInput(text, algorithm, curveName, passphrase string) (analysedKey, error) {
+	text = strings.TrimSpace(text)
+	block, _ := pem.Decode([]byte(text))
+	if block == nil {
+		return parseRawECAnalysisInput(text, algorithm, curveName)
+	}
+	switch {
+	case block.Type == "CERTIFICATE":
+		cert, err := smx509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return analysedKey{}, fmt.Errorf("invalid certificate: %w", err)
+		}
+		key, err := analysedFromPublic(cert.PublicKey)
+		key.keyType = "certificate"
+		return key, err
+	case block.Type == "RSA PUBLIC KEY":
+		pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return analysedKey{}, err
+		}
+		return analysedFromPublic(pub)
+	case strings.Contains(block.Type, "PUBLIC KEY"):
+		return parseAnalysisPublicDER(block.Bytes)
+	case strings.Contains(block.Type, "PRIVATE KEY"):
+		der, _, err := decryptPKCS8(block, block.Bytes, passphrase)
+		if err != nil {
+			return analysedKey{}, err
+		}
+		return parseAnalysisPrivateDER(der)
+	default:
+		return analysedKey{}, fmt.Errorf("unsupported PEM block: %s", block.Type)
+	}
+}
+
+// parseAnalysisPublicDER reads a SubjectPublicKeyInfo. EC points are taken as
+// they are, without the on-curve check a normal parser applies.
+func parseAnalysisPublicDER(der []byte) (analysedKey, error) {
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(der, &spki); err == nil && spki.Algorithm.Algorithm.Equal(oidPublicKeyECDSA) {
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(spki.Algorithm.Parameters.FullBytes, &oid); err != nil {
+			return analysedKey{}, errors.New("invalid EC curve parameters")
+		}
+		key, err := analysedCurveByOID(oid)
+		if err != nil {
+			return analysedKey{}, err
+		}
+		key.keyType = "public"
+		key.x, key.y, err = splitAnalysisPoint(key.curve, spki.PublicKey.RightAlign())
+		return key, err
+	}
+	if pub, err := smx509.ParsePKIXPublicKey(der); err == nil {
+		return analysedFromPublic(pub)
+	}
+	pub, err := parseEdDSAPublicDER(der)
+	if err != nil {
+		return analysedKey{}, errors.New("unsupported public key")
+	}
+	return analysedFromPublic(pub)
+}
+
+// parseAnalysisPrivateDER reads PKCS#1, PKCS#8 or SEC 1 private keys. SEC 1
+// keys are read by hand so an out-of-range scalar is reported, not rejected.
+func parseAnalysisPrivateDER(der []byte) (analysedKey, error) {
+	if priv, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		key, _ := analysedFromPublic(&priv.PublicKey)
+		key.rsaPriv, key.keyType = priv, "private"
+		return key, nil
+	}
+	var sec1 sec1ECPrivateKey
+	var oid asn1.ObjectIdentifier
+	var p8 pkcs8PrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &p8); err == nil && p8.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		if _, err := asn1.Unmarshal(p8.Algo.Parameters.FullBytes, &oid); err != nil {
+			return analysedKey{}, errors.New("invalid EC curve parameters")
+		}
+		der = p8.PrivateKey
+	}
+	if _, err := asn1.Unmarshal(der, &sec1); err == nil && len(sec1.PrivateKey) > 0 {
+		if len(sec1.NamedCurveOID) > 0 {
+			oid = sec1.NamedCurveOID
+		}
+		key, err := analysedCurveByOID(oid)
+		if err != nil {
+			return analysedKey{}, err
+		}
+		key.keyType = "private"
+		key.d = new(big.Int).SetBytes(sec1.PrivateKey)
+		if point := sec1.PublicKey.RightAlign(); len(point) > 0 {
+			if key.x, key.y, err = splitAnalysisPoint(key.curve, point); err != nil {
+				return analysedKey{}, err
+			}
+		} else {
+			key.x, key.y = scalarBaseMultAnalysis(key.curve, key.d)
+		}
+		return key, nil
+	}
+	if parsed, err := smx509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := parsed.(interface{ Public() stdcrypto.PublicKey }); ok {
+			key, err := analysedFromPublic(signer.Public())
+			if priv, ok := parsed.(*rsa.PrivateKey); ok {
+				key.rsaPriv = priv
+			}
+			if priv, ok := parsed.(*sm2.PrivateKey); ok {
+				key.d = priv.D
+			}
+			key.keyType = "private"
+			return key, err
+		}
+	}
+	priv, err := parseEdDSAPrivateDER(der)
+	if err != nil {
+		return analysedKey{}, errors.New("unsupported private key")
+	}
+	key, err := analysedFromPublic(priv.(interface{ Public() stdcrypto.PublicKey }).Public())
+	key.keyType = "private"
+	return key, err
+}
+
+// parseRawECAnalysisInput reads a hex private scalar or public point on the
+// curve named by curveName (or SM2 when algorithm is SM2).
+func parseRawECAnalysisInput(text, algorithm, curveName string) (analysedKey, error) {
+	if strings.EqualFold(algorithm, "SM2") && curveName == "" {
+		curveName = "sm2"
+	}
+	if curveName == "" {
+		return analysedKey{}, errors.New("raw key input needs a curve")
+	}
+	curve, display, err := resolveSignatureCurve(curveName)
+	if err != nil {
+		return analysedKey{}, err
+	}
+	raw, err := hex.DecodeString(strings.Join(strings.Fields(strings.TrimPrefix(text, "0x")), ""))
+	if err != nil || len(raw) == 0 {
+		return analysedKey{}, errors.New("raw key input must be hex")
+	}
+	key := analysedKey{algorithm: "ECC", curve: curve, curveName: display}
+	if curve == sm2.P256() {
+		key.algorithm = "SM2"
+	}
+	if len(raw) <= (curve.Params().N.BitLen()+7)/8 {
+		key.keyType = "private"
+		key.d = new(big.Int).SetBytes(raw)
+		key.x, key.y = scalarBaseMultAnalysis(curve, key.d)
+		return key, nil
+	}
+	key.keyType = "public"
+	key.x, key.y, err = splitAnalysisPoint(curve, raw)
+	return key, err
+}
+
+func analysedCurveByOID(oid asn1.ObjectIdentifier) (analysedKey, error) {
+	if oid.Equal(oidNamedCurveSM2) {
+		return analysedKey{algorithm: "SM2", curve: sm2.P256(), curveName: "SM2"}, nil
+	}
+	info, ok := describeCurveByOID(oid)
+	if !ok {
+		return analysedKey{}, fmt.Errorf("unsupported elliptic curve: %s", oid)
+	}
+	return analysedKey{algorithm: "ECC", curve: info.Curve, curveName: info.Display}, nil
+}
+
+func analysedFromPublic(pub any) (analysedKey, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return analysedKey{algorithm: "RSA", keyType: "public", rsaPub: k}, nil
+	case *ecdsa.PublicKey:
+		key := analysedKey{algorithm: "ECC", keyType: "public", curve: k.Curve, x: k.X, y: k.Y}
+		if k.Curve == sm2.P256() {
+			key.algorithm, key.curveName = "SM2", "SM2"
+		} else if info, ok := describeCurve(k.Curve); ok {
+			key.curveName = info.Display
+		} else {
+			key.curveName = k.Curve.Params().Name
+		}
+		return key, nil
+	default:
+		name, err := eddsaCurveName(pub)
+		if err != nil {
+			return analysedKey{}, fmt.Errorf("unsupported key type %T", pub)
+		}
+		return analysedKey{algorithm: "EdDSA", keyType: "public", curveName: name, pub: pub}, nil
+	}
+}
+
+// splitAnalysisPoint decodes an uncompressed (with or without 0x04) or
+// compressed point without requiring it to be on the curve. Compressed points
+// carry only x, so one that is off the curve cannot be decoded.
+func splitAnalysisPoint(curve elliptic.Curve, data []byte) (*big.Int, *big.Int, error) {
+	size := (curve.Params().BitSize + 7) / 8
+	switch {
+	case len(data) == 1+2*size && data[0] == 0x04:
+		return new(big.Int).SetBytes(data[1 : 1+size]), new(big.Int).SetBytes(data[1+size:]), nil
+	case len(data) == 2*size:
+		return new(big.Int).SetBytes(data[:size]), new(big.Int).SetBytes(data[size:]), nil
+	case len(data) == 1+size && (data[0] == 0x02 || data[0] == 0x03):
+		x, y := elliptic.UnmarshalCompressed(curve, data)
+		if x == nil {
+			return nil, nil, errors.New("compressed EC point is not on the curve")
+		}
+		return x, y, nil
+	default:
+		return nil, nil, errors.New("unsupported EC point encoding")
+	}
+}
+
+// scalarBaseMultAnalysis computes d·G for d in [1, n-1]; other scalars have no
+// meaningful public point and yield nil.
+func scalarBaseMultAnalysis(curve elliptic.Curve, d *big.Int) (*big.Int, *big.Int) {
+	n := curve.Params().N
+	if d.Sign() <= 0 || d.Cmp(n) >= 0 {
+		return nil, nil
+	}
+	return curve.ScalarBaseMult(d.FillBytes(make([]byte, (n.BitLen()+7)/8)))
+}
+
+// pairCheck reports whether two loaded keys carry the same public key.
+func pairCheck(name string, a, b analysedKey) KeyCheck {
+	check := KeyCheck{Name: name, Status: keyCheckFail}
+	switch {
+	case a.algorithm != b.algorithm:
+		check.Detail = fmt.Sprintf("algorithms differ: %s vs %s", a.algorithm, b.algorithm)
+	case a.rsaPub != nil && b.rsaPub != nil:
+		if a.rsaPub.N.Cmp(b.rsaPub.N) == 0 && a.rsaPub.E == b.rsaPub.E {
+			check.Status, check.Detail = keyCheckPass, "RSA moduli and exponents match"
+		} else {
+			check.Detail = "RSA public keys differ"
+		}
+	case a.curve != nil && b.curve != nil:
+		switch {
+		case a.curve.Params().Name != b.curve.Params().Name || a.curve.Params().N.Cmp(b.curve.Params().N) != 0:
+			check.Detail = fmt.Sprintf("curves differ: %s vs %s", a.curveName, b.curveName)
+		case a.x == nil || b.x == nil:
+			check.Detail = "public point unavailable"
+		case a.x.Cmp(b.x) == 0 && a.y.Cmp(b.y) == 0:
+			check.Status, check.Detail = keyCheckPass, "public points match"
+		default:
+			check.Detail = "public points differ"
+		}
+	default:
+		if eq, ok := a.pub.(interface {
+			Equal(stdcrypto.PublicKey) bool
+		}); ok {
+			if eq.Equal(b.pub) {
+				check.Status, check.Detail = keyCheckPass, "public keys match"
+			} else {
+				check.Detail = "public keys differ"
+			}
+			break
+		}
+		pa, errA := marshalPublicKeyPEM(a.pub)
+		pb, errB := marshalPublicKeyPEM(b.pub)
+		if errA == nil && errB == nil && pa == pb {
+			check.Status, check.Detail = keyCheckPass, "public keys match"
+		} else {
+			check.Detail = "public keys differ"
+		}
+	}
+	return check
+}
+
+type namedModulus struct {
+	name string
+	n    *big.Int
+}
+
+// rsaModuliExcept collects the moduli of stored RSA keys for the shared
+// factor check, skipping the analysed key itself.
+func (c *CryptoService) rsaModuliExcept(id string) []namedModulus {
+	var out []namedModulus
+	for _, stored := range c.readKeys() {
+		if stored.ID == id || !strings.EqualFold(stored.Algorithm, "RSA") || stored.Format == "pkcs11" {
+			continue
+		}
+		pub, err := parseStoredPublicKey(&stored)
+		if err != nil {
+			continue
+		}
+		if rsaPub, ok := pub.(*rsa.PublicKey); ok {
+			out = append(out, namedModulus{name: fallbackName(stored.Name, stored.ID), n: rsaPub.N})
+		}
+	}
+	return out
+}
+
+func rsaKeyChecks(key analysedKey, others []namedModulus) []KeyCheck {
+	n, e := key.rsaPub.N, key.rsaPub.E
+	bits := n.BitLen()
+	var checks []KeyCheck
+
+	size := KeyCheck{Name: "modulusSize", Status: keyCheckPass, Detail: fmt.Sprintf("%d-bit modulus", bits)}
+	switch {
+	case bits < 1024:
+		size.Status = keyCheckFail
+	case bits < 2048:
+		size.Status, size.Detail = keyCheckWarn, size.Detail+"; 2048 bits or more is recommended"
+	}
+	checks = append(checks, size)
+
+	exponent := KeyCheck{Name: "publicExponent", Status: keyCheckPass, Detail: fmt.Sprintf("e = %d", e)}
+	switch {
+	case e < 3 || e%2 == 0:
+		exponent.Status, exponent.Detail = keyCheckFail, exponent.Detail+"; e must be odd and at least 3"
+	case e < 65537:
+		exponent.Status, exponent.Detail = keyCheckWarn, exponent.Detail+"; small exponents are fragile with weak padding"
+	case e > 65537 && big.NewInt(int64(e)).BitLen() > 32:
+		exponent.Status, exponent.Detail = keyCheckWarn, exponent.Detail+"; many implementations reject exponents above 2^32"
+	}
+	checks = append(checks, exponent)
+
+	checks = append(checks, rsaSmallFactorCheck(n), rsaFermatCheck(n), rsaSharedFactorCheck(n, others), rsaROCACheck(n))
+
+	if key.rsaPriv != nil {
+		if err := key.rsaPriv.Validate(); err != nil {
+			checks = append(checks, KeyCheck{Name: "privateKey", Status: keyCheckFail, Detail: err.Error()})
+		} else {
+			checks = append(checks, KeyCheck{Name: "privateKey", Status: keyCheckPass, Detail: "primes, exponents and CRT values are consistent"})
+		}
+	}
+	return checks
+}
+
+// rsaSmallFactorCheck looks for prime factors below 2^16 with one gcd against
+// their product.
+func rsaSmallFactorCheck(n *big.Int) KeyCheck {
+	primes := smallPrimes(1 << 16)
+	product := big.NewInt(1)
+	for _, p := range primes {
+		product.Mul(product, big.NewInt(int64(p)))
+	}
+	if new(big.Int).GCD(nil, nil, n, product).Cmp(big.NewInt(1)) == 0 {
+		return KeyCheck{Name: "smallFactors", Status: keyCheckPass, Detail: "no prime factors below 65536"}
+	}
+	mod := new(big.Int)
+	for _, p := range primes {
+		if mod.Mod(n, big.NewInt(int64(p))).Sign() == 0 {
+			return KeyCheck{Name: "smallFactors", Status: keyCheckFail, Detail: fmt.Sprintf("modulus is divisible by %d", p)}
+		}
+	}
+	return KeyCheck{Name: "smallFactors", Status: keyCheckFail, Detail: "modulus has small factors"}
+}
+
+// rsaFermatCheck runs a short Fermat factorisation, which succeeds quickly
+// when p and q are too close together.
+func rsaFermatCheck(n *big.Int) KeyCheck {
+	a := new(big.Int).Sqrt(n)
+	if new(big.Int).Mul(a, a).Cmp(n) < 0 {
+		a.Add(a, big.NewInt(1))
+	}
+	b2, b := new(big.Int), new(big.Int)
+	for i := 0; i < 1000; i++ {
+		b2.Mul(a, a).Sub(b2, n)
+		b.Sqrt(b2)
+		if new(big.Int).Mul(b, b).Cmp(b2) == 0 {
+			p := new(big.Int).Sub(a, b)
+			if p.Cmp(big.NewInt(1)) > 0 {
+				return KeyCheck{Name: "closePrimes", Status: keyCheckFail, Detail: "modulus factors by Fermat's method: p = " + strings.ToUpper(p.Text(16))}
+			}
+		}
+		a.Add(a, big.NewInt(1))
+	}
+	return KeyCheck{Name: "closePrimes", Status: keyCheckPass, Detail: "p and q are not close enough for Fermat factorisation"}
+}
+
+func rsaSharedFactorCheck(n *big.Int, others []namedModulus) KeyCheck {
+	one := big.NewInt(1)
+	var reused []string
+	for _, other := range others {
+		if other.n.Cmp(n) == 0 {
+			reused = append(reused, other.name)
+			continue
+		}
+		if g := new(big.Int).GCD(nil, nil, n, other.n); g.Cmp(one) != 0 {
+			return KeyCheck{Name: "sharedFactors", Status: keyCheckFail, Detail: "modulus shares a prime factor with " + other.name}
+		}
+	}
+	if len(reused) > 0 {
+		return KeyCheck{Name: "sharedFactors", Status: keyCheckWarn, Detail: "same modulus as " + strings.Join(reused, ", ")}
+	}
+	return KeyCheck{Name: "sharedFactors", Status: keyCheckPass, Detail: fmt.Sprintf("no common factors with %d other stored RSA keys", len(others))}
+}
+
+// rocaPrimes are the small primes used by the ROCA (CVE-2017-15361)
+// fingerprint: Infineon primes have the form k·M + 65537^a mod M, so the
+// modulus lies in the subgroup generated by 65537 modulo each of them.
+var rocaPrimes = []int64{3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131, 137, 139, 149, 151, 157, 163, 167}
+
+func rsaROCACheck(n *big.Int) KeyCheck {
+	mod := new(big.Int)
+	for _, p := range rocaPrimes {
+		residue := mod.Mod(n, big.NewInt(p)).Int64()
+		if !inGeneratedSubgroup(65537%p, residue, p) {
+			return KeyCheck{Name: "roca", Status: keyCheckPass, Detail: "modulus does not match the ROCA fingerprint"}
+		}
+	}
+	return KeyCheck{Name: "roca", Status: keyCheckFail, Detail: "modulus matches the ROCA (CVE-2017-15361) fingerprint"}
+}
+
+// inGeneratedSubgroup reports whether value is a power of g modulo p.
+func inGeneratedSubgroup(g, value, p int64) bool {
+	x := int64(1)
+	for i := int64(0); i < p; i++ {
+		if x == value {
+			return true
+		}
+		x = x * g % p
+		if x == 1 {
+			break
+		}
+	}
+	return false
+}
+
+func smallPrimes(limit int) []int {
+	composite := make([]bool, limit)
+	var primes []int
+	for i := 2; i < limit; i++ {
+		if composite[i] {
+			continue
+		}
+		primes = append(primes, i)
+		for j := i * i; j < limit; j += i {
+			composite[j] = true
+		}
+	}
+	return primes
+}
+
+// ecKeyChecks validates the public point and private scalar. Every curve in
+// the registry, and SM2, has cofactor 1, so a point on the curve is in the
+// prime-order subgroup; (n-1)·Q = -Q confirms that without touching the
+// point at infinity.
+func ecKeyChecks(key analysedKey) []KeyCheck {
+	params := key.curve.Params()
+	var checks []KeyCheck
+	if key.x == nil {
+		checks = append(checks, KeyCheck{Name: "publicPoint", Status: keyCheckFail, Detail: "no public point: the private scalar is out of range"})
+	} else {
+		inField := func(v *big.Int) bool { return v.Sign() >= 0 && v.Cmp(params.P) < 0 }
+		onCurve := false
+		switch {
+		case !inField(key.x) || !inField(key.y):
+			checks = append(checks, KeyCheck{Name: "pointOnCurve", Status: keyCheckFail, Detail: "coordinates are outside the field"})
+		case key.x.Sign() == 0 && key.y.Sign() == 0:
+			checks = append(checks, KeyCheck{Name: "pointOnCurve", Status: keyCheckFail, Detail: "point at infinity"})
+		case !key.curve.IsOnCurve(key.x, key.y):
+			checks = append(checks, KeyCheck{Name: "pointOnCurve", Status: keyCheckFail, Detail: "point is not on " + key.curveName})
+		default:
+			onCurve = true
+			checks = append(checks, KeyCheck{Name: "pointOnCurve", Status: keyCheckPass, Detail: "point is on " + key.curveName})
+		}
+		if onCurve {
+			nMinus1 := new(big.Int).Sub(params.N, big.NewInt(1))
+			x, y := key.curve.ScalarMult(key.x, key.y, nMinus1.FillBytes(make([]byte, (params.N.BitLen()+7)/8)))
+			negY := new(big.Int).Sub(params.P, key.y)
+			if x.Cmp(key.x) == 0 && y.Cmp(negY) == 0 {
+				checks = append(checks, KeyCheck{Name: "subgroup", Status: keyCheckPass, Detail: "cofactor 1; point has order n"})
+			} else {
+				checks = append(checks, KeyCheck{Name: "subgroup", Status: keyCheckFail, Detail: "point is not in the prime-order subgroup"})
+			}
+		} else {
+			checks = append(checks, KeyCheck{Name: "subgroup", Status: keyCheckInfo, Detail: "skipped for a point that is not on the curve"})
+		}
+	}
+
+	if key.d != nil {
+		limit := new(big.Int).Sub(params.N, big.NewInt(1))
+		rangeText := "[1, n-1]"
+		if key.algorithm == "SM2" {
+			// GB/T 32918.1 requires d in [1, n-2] so that 1+d is invertible.
+			limit.Sub(limit, big.NewInt(1))
+			rangeText = "[1, n-2]"
+		}
+		scalar := KeyCheck{Name: "privateRange", Status: keyCheckPass, Detail: "private scalar is in " + rangeText}
+		if key.d.Sign() <= 0 || key.d.Cmp(limit) > 0 {
+			scalar.Status, scalar.Detail = keyCheckFail, "private scalar is outside "+rangeText
+		}
+		checks = append(checks, scalar)
+		if x, y := scalarBaseMultAnalysis(key.curve, key.d); x != nil && key.x != nil {
+			if x.Cmp(key.x) == 0 && y.Cmp(key.y) == 0 {
+				checks = append(checks, KeyCheck{Name: "privatePublic", Status: keyCheckPass, Detail: "d·G equals the public point"})
+			} else {
+				checks = append(checks, KeyCheck{Name: "privatePublic", Status: keyCheckFail, Detail: "d·G does not equal the embedded public point"})
+			}
+		}
+	}
+	return checks
+}
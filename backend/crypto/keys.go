@@ -2,11 +2,15 @@ package crypto
 
 import (
 	"bytes"
+	stdcrypto "crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
@@ -15,6 +19,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cloudflare/circl/sign/ed448"
 	"github.com/emmansun/gmsm/sm2"
 	"github.com/emmansun/gmsm/sm9"
 	"github.com/emmansun/gmsm/smx509"
@@ -32,11 +37,19 @@ func (c *CryptoService) ParseKey(req KeyParseRequest) (KeyParseResult, error) {
 	if strings.TrimSpace(req.Data) == "" {
 		return result, errors.New("key material is empty")
 	}
+	if strings.EqualFold(req.Format, "jwk") {
+		return c.parseJWKKey(req)
+	}
+	if isOpenSSHFormat(req.Format) {
+		return c.parseOpenSSHKey(req)
+	}
 	switch strings.ToLower(req.Algorithm) {
 	case "rsa":
 		return c.parseRSAKey(req)
 	case "ecc":
 		return c.parseECCKey(req)
+	case "eddsa":
+		return c.parseEdDSAKey(req)
 	case "sm2":
 		return c.parseSM2Key(req)
 	case "sm9":
@@ -52,9 +65,16 @@ func (c *CryptoService) parseRSAKey(req KeyParseRequest) (KeyParseResult, error)
 	if err != nil {
 		return result, err
 	}
+	der, encryption, err := decryptPKCS8(block, der, req.Passphrase)
+	if err != nil {
+		return result, err
+	}
 	var keyPEM string
 	var pubPEM string
 	var summary = map[string]string{}
+	if encryption != "" {
+		summary["encryption"] = encryption
+	}
 
 	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
 		keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
@@ -97,7 +117,7 @@ func (c *CryptoService) parseRSAKey(req KeyParseRequest) (KeyParseResult, error)
 	result.PublicPEM = pubPEM
 	result.Summary = summary
 	if req.Save {
-		stored := c.saveKey(StoredKey{
+		stored, err := c.saveKey(StoredKey{
 			ID:         uuid.New().String(),
 			Name:       fallbackName(req.Name, "RSA"),
 			Algorithm:  "RSA",
@@ -111,6 +131,9 @@ func (c *CryptoService) parseRSAKey(req KeyParseRequest) (KeyParseResult, error)
 			},
 			CreatedAt: time.Now(),
 		})
+		if err != nil {
+			return result, err
+		}
 		result.Stored = true
 		result.Key = &stored
 	}
@@ -123,26 +146,22 @@ func (c *CryptoService) parseECCKey(req KeyParseRequest) (KeyParseResult, error)
 	if err != nil {
 		return result, err
 	}
+	der, encryption, err := decryptPKCS8(block, der, req.Passphrase)
+	if err != nil {
+		return result, err
+	}
+	if encryption != "" {
+		result.Summary["encryption"] = encryption
+	}
 
 	var priv *ecdsa.PrivateKey
 	var pub *ecdsa.PublicKey
 
-	if key, err := x509.ParseECPrivateKey(der); err == nil {
+	if key, err := parseECPrivateKeyDER(der); err == nil {
 		priv = key
-		pub = key.Public().(*ecdsa.PublicKey)
-	} else if parsed, err := x509.ParsePKCS8PrivateKey(der); err == nil {
-		if key, ok := parsed.(*ecdsa.PrivateKey); ok {
-			priv = key
-			pub = key.Public().(*ecdsa.PublicKey)
-		}
-	}
-
-	if priv == nil {
-		if parsed, err := x509.ParsePKIXPublicKey(der); err == nil {
-			if key, ok := parsed.(*ecdsa.PublicKey); ok {
-				pub = key
-			}
-		}
+		pub = &key.PublicKey
+	} else if key, err := parseECPublicKeyDER(der); err == nil {
+		pub = key
 	}
 
 	if priv == nil && pub == nil {
@@ -156,27 +175,27 @@ func (c *CryptoService) parseECCKey(req KeyParseRequest) (KeyParseResult, error)
 	var privDER []byte
 	if priv != nil {
 		var err error
-		privDER, err = x509.MarshalECPrivateKey(priv)
+		privDER, err = marshalECPrivateKey(priv)
 		if err == nil {
 			privPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privDER}))
 		}
-		pubBytes, _ := x509.MarshalPKIXPublicKey(priv.Public())
+		pubBytes, _ := marshalECPublicKey(&priv.PublicKey)
 		pubPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}))
 		result.Summary["type"] = "private"
 	} else if pub != nil {
-		pubBytes, _ := x509.MarshalPKIXPublicKey(pub)
+		pubBytes, _ := marshalECPublicKey(pub)
 		pubPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}))
 		result.Summary["type"] = "public"
 	}
 
 	if pub != nil {
 		result.Summary["curve"] = pub.Curve.Params().Name
-		if info, ok := describeCurveByParamsName(pub.Curve.Params().Name); ok {
+		if info, ok := describeCurve(pub.Curve); ok {
 			result.Summary["curve"] = info.Display
 			result.Summary["curveFamily"] = info.Family
 		}
 		result.Summary["publicRS"] = strings.ToUpper(hex.EncodeToString(encodeECCPointRS(pub)))
-		pubBytes, _ := x509.MarshalPKIXPublicKey(pub)
+		pubBytes, _ := marshalECPublicKey(pub)
 		result.Summary["publicDerHex"] = strings.ToUpper(hex.EncodeToString(pubBytes))
 	}
 
@@ -188,7 +207,7 @@ func (c *CryptoService) parseECCKey(req KeyParseRequest) (KeyParseResult, error)
 	result.PublicPEM = pubPEM
 
 	if req.Save {
-		stored := c.saveKey(StoredKey{
+		stored, err := c.saveKey(StoredKey{
 			ID:         uuid.New().String(),
 			Name:       fallbackName(req.Name, "ECC"),
 			Algorithm:  "ECC",
@@ -203,6 +222,9 @@ func (c *CryptoService) parseECCKey(req KeyParseRequest) (KeyParseResult, error)
 			},
 			CreatedAt: time.Now(),
 		})
+		if err != nil {
+			return result, err
+		}
 		result.Stored = true
 		result.Key = &stored
 	}
@@ -229,12 +251,21 @@ func (c *CryptoService) parseSM2Key(req KeyParseRequest) (KeyParseResult, error)
 		}
 	case "sdf":
 		var data []byte
-		data, err = decodeFlexible(req.Data)
-		if err == nil {
+		data, err = decodeSDFInput(req.Data, "")
+		if err == nil && len(data) == sdfPublicKeyLen {
+			pub, _, err = decodeSDFPublicKey(data)
+		} else if err == nil {
 			priv, err = parseSDFPrivateKey(data)
 		}
 	default:
 		block, der, derr = extractPEMOrDER(req.Data, req.Format)
+		if derr == nil {
+			var encryption string
+			der, encryption, derr = decryptPKCS8(block, der, req.Passphrase)
+			if encryption != "" {
+				result.Summary["encryption"] = encryption
+			}
+		}
 		if derr != nil {
 			err = derr
 		} else {
@@ -296,15 +327,17 @@ func (c *CryptoService) parseSM2Key(req KeyParseRequest) (KeyParseResult, error)
 		result.Summary["publicRS"] = strings.ToUpper(hex.EncodeToString(encodeECCPointRS(pub)))
 		pubBytes, _ := smx509.MarshalPKIXPublicKey(pub)
 		result.Summary["publicDerHex"] = strings.ToUpper(hex.EncodeToString(pubBytes))
+		result.Summary["sdfPublicKey"] = strings.ToUpper(hex.EncodeToString(encodeSDFPublicKey(pub, binary.LittleEndian)))
 	}
 	if len(privDER) > 0 {
 		result.Summary["privateDerHex"] = strings.ToUpper(hex.EncodeToString(privDER))
+		result.Summary["sdfPrivateKey"] = strings.ToUpper(hex.EncodeToString(encodeSDFPrivateKey(priv, binary.LittleEndian)))
 	}
 
 	result.PrivatePEM = privPEM
 	result.PublicPEM = pubPEM
 	if req.Save {
-		stored := c.saveKey(StoredKey{
+		stored, err := c.saveKey(StoredKey{
 			ID:         uuid.New().String(),
 			Name:       fallbackName(req.Name, "SM2"),
 			Algorithm:  "SM2",
@@ -318,6 +351,9 @@ func (c *CryptoService) parseSM2Key(req KeyParseRequest) (KeyParseResult, error)
 			},
 			CreatedAt: time.Now(),
 		})
+		if err != nil {
+			return result, err
+		}
 		result.Stored = true
 		result.Key = &stored
 	}
@@ -390,7 +426,7 @@ func (c *CryptoService) parseSM9Key(req KeyParseRequest) (KeyParseResult, error)
 	result.PrivatePEM = privPEM
 	result.Summary = summary
 	if req.Save {
-		stored := c.saveKey(StoredKey{
+		stored, err := c.saveKey(StoredKey{
 			ID:         uuid.New().String(),
 			Name:       fallbackName(req.Name, "SM9"),
 			Algorithm:  "SM9",
@@ -403,6 +439,9 @@ func (c *CryptoService) parseSM9Key(req KeyParseRequest) (KeyParseResult, error)
 			},
 			CreatedAt: time.Now(),
 		})
+		if err != nil {
+			return result, err
+		}
 		result.Stored = true
 		result.Key = &stored
 	}
@@ -414,18 +453,32 @@ func (c *CryptoService) parseSM9Key(req KeyParseRequest) (KeyParseResult, error)
 // req: The KeyGenRequest containing parameters like algorithm, size, and curve.
 // Returns a KeyParseResult containing the generated keys or an error.
 func (c *CryptoService) GenerateKeyPair(req KeyGenRequest) (KeyParseResult, error) {
+	openssh := strings.EqualFold(req.Format, "openssh")
+	if openssh {
+		if err := checkSSHKeyGen(req); err != nil {
+			return KeyParseResult{}, err
+		}
+	}
+	var result KeyParseResult
+	var err error
 	switch strings.ToLower(req.Algorithm) {
 	case "rsa":
-		return c.generateRSA(req)
+		result, err = c.generateRSA(req)
 	case "ecc":
-		return c.generateECC(req)
+		result, err = c.generateECC(req)
+	case "eddsa", "ed25519":
+		result, err = c.generateEdDSA(req)
 	case "sm2":
-		return c.generateSM2(req)
+		result, err = c.generateSM2(req)
 	case "sm9":
-		return c.generateSM9(req)
+		result, err = c.generateSM9(req)
 	default:
 		return KeyParseResult{}, fmt.Errorf("unsupported algorithm: %s", req.Algorithm)
 	}
+	if err != nil || !openssh {
+		return result, err
+	}
+	return result, attachOpenSSH(&result, req)
 }
 
 func (c *CryptoService) generateRSA(req KeyGenRequest) (KeyParseResult, error) {
@@ -454,7 +507,7 @@ func (c *CryptoService) generateRSA(req KeyGenRequest) (KeyParseResult, error) {
 		},
 	}
 	if req.Save {
-		stored := c.saveKey(StoredKey{
+		stored, err := c.saveKey(StoredKey{
 			ID:         uuid.New().String(),
 			Name:       fallbackName(req.Name, "RSA"),
 			Algorithm:  "RSA",
@@ -465,6 +518,9 @@ func (c *CryptoService) generateRSA(req KeyGenRequest) (KeyParseResult, error) {
 			PublicPEM:  string(pubPEM),
 			CreatedAt:  time.Now(),
 		})
+		if err != nil {
+			return result, err
+		}
 		result.Stored = true
 		result.Key = &stored
 	}
@@ -532,12 +588,12 @@ func (c *CryptoService) generateECC(req KeyGenRequest) (KeyParseResult, error) {
 	if err != nil {
 		return KeyParseResult{}, err
 	}
-	der, err := x509.MarshalECPrivateKey(key)
+	der, err := marshalECPrivateKey(key)
 	if err != nil {
 		return KeyParseResult{}, err
 	}
 	privPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
-	pubBytes, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pubBytes, _ := marshalECPublicKey(&key.PublicKey)
 	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
 	result := KeyParseResult{
 		PrivatePEM: string(privPEM),
@@ -549,7 +605,7 @@ func (c *CryptoService) generateECC(req KeyGenRequest) (KeyParseResult, error) {
 		},
 	}
 	if req.Save {
-		stored := c.saveKey(StoredKey{
+		stored, err := c.saveKey(StoredKey{
 			ID:         uuid.New().String(),
 			Name:       fallbackName(req.Name, "ECC"),
 			Algorithm:  "ECC",
@@ -564,6 +620,9 @@ func (c *CryptoService) generateECC(req KeyGenRequest) (KeyParseResult, error) {
 			},
 			CreatedAt: time.Now(),
 		})
+		if err != nil {
+			return result, err
+		}
 		result.Stored = true
 		result.Key = &stored
 	}
@@ -591,7 +650,7 @@ func (c *CryptoService) generateSM2(req KeyGenRequest) (KeyParseResult, error) {
 		},
 	}
 	if req.Save {
-		stored := c.saveKey(StoredKey{
+		stored, err := c.saveKey(StoredKey{
 			ID:         uuid.New().String(),
 			Name:       fallbackName(req.Name, "SM2"),
 			Algorithm:  "SM2",
@@ -602,6 +661,9 @@ func (c *CryptoService) generateSM2(req KeyGenRequest) (KeyParseResult, error) {
 			PublicPEM:  string(pubPEM),
 			CreatedAt:  time.Now(),
 		})
+		if err != nil {
+			return result, err
+		}
 		result.Stored = true
 		result.Key = &stored
 	}
@@ -647,7 +709,7 @@ func (c *CryptoService) generateSM9(req KeyGenRequest) (KeyParseResult, error) {
 		Summary:    summary,
 	}
 	if req.Save {
-		stored := c.saveKey(StoredKey{
+		stored, err := c.saveKey(StoredKey{
 			ID:         uuid.New().String(),
 			Name:       fallbackName(req.Name, "SM9"),
 			Algorithm:  "SM9",
@@ -657,6 +719,9 @@ func (c *CryptoService) generateSM9(req KeyGenRequest) (KeyParseResult, error) {
 			PrivatePEM: privPEM,
 			CreatedAt:  time.Now(),
 		})
+		if err != nil {
+			return result, err
+		}
 		result.Stored = true
 		result.Key = &stored
 	}
@@ -720,6 +785,10 @@ func extractPEMOrDER(data, format string) (*pem.Block, []byte, error) {
 }
 
 func parseSDFPrivateKey(bytes []byte) (*sm2.PrivateKey, error) {
+	if len(bytes) == sdfPrivateKeyLen {
+		priv, _, err := decodeSDFPrivateKey(bytes)
+		return priv, err
+	}
 	if len(bytes) < 40 {
 		return nil, errors.New("sdf blob too short")
 	}
@@ -745,3 +814,59 @@ func encodeSM9PEM(key any) string {
 		return ""
 	}
 }
+
+// marshalPrivateKeyPEM encodes a parsed private key the way the key store keeps
+// it and reports the store algorithm plus curve metadata.
+func marshalPrivateKeyPEM(priv any) (string, string, string, map[string]string, error) {
+	extra := map[string]string{}
+	switch key := priv.(type) {
+	case *rsa.PrivateKey:
+		privPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			return "", "", "", nil, err
+		}
+		return "RSA", string(privPEM), string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})), extra, nil
+	case *sm2.PrivateKey:
+		der, err := smx509.MarshalSM2PrivateKey(key)
+		if err != nil {
+			return "", "", "", nil, err
+		}
+		pubDER, err := smx509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			return "", "", "", nil, err
+		}
+		return "SM2", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})), extra, nil
+	case *ecdsa.PrivateKey:
+		der, err := marshalECPrivateKey(key)
+		if err != nil {
+			return "", "", "", nil, err
+		}
+		pubDER, err := marshalECPublicKey(&key.PublicKey)
+		if err != nil {
+			return "", "", "", nil, err
+		}
+		extra["curve"] = key.Curve.Params().Name
+		if info, ok := describeCurve(key.Curve); ok {
+			extra["curve"] = info.Display
+		}
+		return "ECC", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})), extra, nil
+	case ed25519.PrivateKey, *ecdh.PrivateKey, ed448.PrivateKey, x448PrivateKey:
+		curve, err := eddsaCurveName(key)
+		if err != nil {
+			return "", "", "", nil, err
+		}
+		der, err := marshalEdDSAPrivateKey(key)
+		if err != nil {
+			return "", "", "", nil, err
+		}
+		pubDER, err := marshalEdDSAPublicKey(key.(interface{ Public() stdcrypto.PublicKey }).Public())
+		if err != nil {
+			return "", "", "", nil, err
+		}
+		extra["curve"] = curve
+		return "EdDSA", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})), extra, nil
+	default:
+		return "", "", "", nil, fmt.Errorf("unsupported private key type %T", priv)
+	}
+}
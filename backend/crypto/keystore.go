@@ -0,0 +1,505 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/emmansun/gmsm/sm4"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keyStoreEnvelopeVersion = 1
+	keyStoreWrapAAD         = "ctools-keystore"
+	defaultStoreCipher      = "aes-256-gcm"
+	defaultStoreKDF         = "argon2id"
+)
+
+var (
+	errKeyStoreLocked     = errors.New("key store is locked")
+	errKeyStorePassphrase = errors.New("incorrect key store passphrase")
+)
+
+// keyStoreEnvelope is the on-disk layout of a passphrase-protected key store.
+// Key metadata and public keys stay readable; private material is sealed with
+// a random data key, which is itself wrapped by the passphrase-derived key.
+type keyStoreEnvelope struct {
+	Version    int         `json:"version"`
+	KDF        keyStoreKDF `json:"kdf"`
+	Cipher     string      `json:"cipher"`
+	WrappedKey string      `json:"wrappedKey"`
+	Keys       []sealedKey `json:"keys"`
+}
+
+// keyStoreKDF records the memory-hard KDF and its parameters.
+type keyStoreKDF struct {
+	Name    string `json:"name"`
+	Salt    string `json:"salt"`
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+	N       int    `json:"n,omitempty"`
+	R       int    `json:"r,omitempty"`
+	P       int    `json:"p,omitempty"`
+}
+
+type sealedKey struct {
+	StoredKey
+	SealedPrivate string `json:"sealedPrivate,omitempty"`
+}
+
+// GetKeyStoreStatus reports whether the key store is passphrase protected and unlocked.
+//
+// Returns a KeyStoreStatus describing the current state.
+func (c *CryptoService) GetKeyStoreStatus() KeyStoreStatus {
+	data, err := c.storage().Load(storeKeys)
+	if err != nil || !isKeyStoreEnvelope(data) {
+		return KeyStoreStatus{KeyCount: len(c.readKeys())}
+	}
+	env, err := decodeKeyStoreEnvelope(data)
+	if err != nil {
+		return KeyStoreStatus{Encrypted: true, Locked: true}
+	}
+	return KeyStoreStatus{
+		Encrypted: true,
+		Locked:    c.currentStoreKey() == nil,
+		Cipher:    env.Cipher,
+		KDF:       env.KDF.Name,
+		KeyCount:  len(env.Keys),
+	}
+}
+
+// UnlockKeyStore derives the key store key from the passphrase and keeps it in memory.
+//
+// passphrase: The master passphrase of the key store.
+// Returns the updated KeyStoreStatus or an error if the passphrase is wrong.
+func (c *CryptoService) UnlockKeyStore(passphrase string) (KeyStoreStatus, error) {
+	data, err := c.storage().Load(storeKeys)
+	if err != nil || !isKeyStoreEnvelope(data) {
+		return c.GetKeyStoreStatus(), errors.New("key store is not passphrase protected")
+	}
+	env, err := decodeKeyStoreEnvelope(data)
+	if err != nil {
+		return KeyStoreStatus{}, err
+	}
+	dataKey, err := env.unwrap(passphrase)
+	if err != nil {
+		return c.GetKeyStoreStatus(), err
+	}
+	c.setStoreKey(dataKey)
+	return c.GetKeyStoreStatus(), nil
+}
+
+// LockKeyStore discards the in-memory key store key.
+//
+// Returns the updated KeyStoreStatus.
+func (c *CryptoService) LockKeyStore() KeyStoreStatus {
+	c.setStoreKey(nil)
+	return c.GetKeyStoreStatus()
+}
+
+// ChangeKeyStorePassphrase sets, changes or removes the key store passphrase.
+//
+// req: The KeyStorePassphraseRequest; an empty NewPassphrase stores keys in plaintext again.
+// Returns the updated KeyStoreStatus or an error.
+func (c *CryptoService) ChangeKeyStorePassphrase(req KeyStorePassphraseRequest) (KeyStoreStatus, error) {
+	var newKey []byte
+	err := c.storage().Update(storeKeys, func(data []byte) ([]byte, error) {
+		keys := []StoredKey{}
+		if isKeyStoreEnvelope(data) {
+			env, err := decodeKeyStoreEnvelope(data)
+			if err != nil {
+				return nil, err
+			}
+			dataKey, err := env.unwrap(req.CurrentPassphrase)
+			if err != nil {
+				return nil, err
+			}
+			keys, err = env.open(dataKey)
+			if err != nil {
+				return nil, err
+			}
+		} else if len(data) > 0 {
+			if err := json.Unmarshal(data, &keys); err != nil {
+				return nil, fmt.Errorf("unable to read key store: %w", err)
+			}
+		}
+
+		if req.NewPassphrase == "" {
+			return json.MarshalIndent(keys, "", "  ")
+		}
+		env, dataKey, err := newKeyStoreEnvelope(req.NewPassphrase, req.Cipher, req.KDF)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.seal(keys, dataKey, nil); err != nil {
+			return nil, err
+		}
+		newKey = dataKey
+		return json.MarshalIndent(env, "", "  ")
+	})
+	if err != nil {
+		return c.GetKeyStoreStatus(), err
+	}
+	c.setStoreKey(newKey)
+	return c.GetKeyStoreStatus(), nil
+}
+
+// readKeyStore loads the key store and reports whether sealed entries could not be opened.
+func (c *CryptoService) readKeyStore() ([]StoredKey, bool) {
+	data, err := c.storage().Load(storeKeys)
+	if err != nil {
+		log.Printf("crypto: unable to read key store: %v", err)
+		return []StoredKey{}, false
+	}
+	keys, locked, err := c.decodeKeyStore(data)
+	if err != nil {
+		log.Printf("crypto: unable to read key store: %v", err)
+	}
+	return keys, locked
+}
+
+// decodeKeyStore parses a plain or passphrase-protected key store document.
+// A document that cannot be parsed is an error, so that it is never replaced.
+func (c *CryptoService) decodeKeyStore(data []byte) ([]StoredKey, bool, error) {
+	if len(data) == 0 {
+		return []StoredKey{}, false, nil
+	}
+	if !isKeyStoreEnvelope(data) {
+		var keys []StoredKey
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return []StoredKey{}, false, fmt.Errorf("invalid key store: %w", err)
+		}
+		return keys, false, nil
+	}
+	env, err := decodeKeyStoreEnvelope(data)
+	if err != nil {
+		return []StoredKey{}, true, fmt.Errorf("invalid encrypted key store: %w", err)
+	}
+	dataKey := c.currentStoreKey()
+	if dataKey == nil {
+		return env.metadata(), true, nil
+	}
+	keys, err := env.open(dataKey)
+	if err != nil {
+		log.Printf("crypto: unable to open encrypted key store: %v", err)
+		return env.metadata(), true, nil
+	}
+	return keys, false, nil
+}
+
+// updateKeyStore applies fn to the keys, sealing private material when a passphrase is set.
+func (c *CryptoService) updateKeyStore(fn func([]StoredKey) ([]StoredKey, error)) error {
+	return c.storage().Update(storeKeys, func(existing []byte) ([]byte, error) {
+		current, _, err := c.decodeKeyStore(existing)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := fn(current)
+		if err != nil {
+			return nil, err
+		}
+		if !isKeyStoreEnvelope(existing) {
+			data, err := json.MarshalIndent(keys, "", "  ")
+			if err != nil {
+				return nil, fmt.Errorf("unable to marshal key store: %w", err)
+			}
+			return data, nil
+		}
+		env, err := decodeKeyStoreEnvelope(existing)
+		if err != nil {
+			return nil, err
+		}
+		previous := make(map[string]string, len(env.Keys))
+		for _, entry := range env.Keys {
+			previous[entry.ID] = entry.SealedPrivate
+		}
+		if err := env.seal(keys, c.currentStoreKey(), previous); err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal key store: %w", err)
+		}
+		return data, nil
+	})
+}
+
+// keyStoreLocked reports whether the key store is encrypted and not unlocked.
+func (c *CryptoService) keyStoreLocked() bool {
+	_, locked := c.readKeyStore()
+	return locked
+}
+
+func (c *CryptoService) currentStoreKey() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.storeKey == nil {
+		return nil
+	}
+	return append([]byte(nil), c.storeKey...)
+}
+
+func (c *CryptoService) setStoreKey(key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.storeKey {
+		c.storeKey[i] = 0
+	}
+	c.storeKey = key
+}
+
+func isKeyStoreEnvelope(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+func decodeKeyStoreEnvelope(data []byte) (*keyStoreEnvelope, error) {
+	var env keyStoreEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key store: %w", err)
+	}
+	if env.Version != keyStoreEnvelopeVersion {
+		return nil, fmt.Errorf("unsupported key store version: %d", env.Version)
+	}
+	return &env, nil
+}
+
+func newKeyStoreEnvelope(passphrase, cipherName, kdfName string) (*keyStoreEnvelope, []byte, error) {
+	cipherName = normalizeStoreCipher(cipherName)
+	keySize, err := storeCipherKeySize(cipherName)
+	if err != nil {
+		return nil, nil, err
+	}
+	kdf, err := newKeyStoreKDF(kdfName)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := sealWithPassphrase(kdf, cipherName, passphrase, dataKey, []byte(keyStoreWrapAAD))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &keyStoreEnvelope{
+		Version:    keyStoreEnvelopeVersion,
+		KDF:        kdf,
+		Cipher:     cipherName,
+		WrappedKey: wrapped,
+	}, dataKey, nil
+}
+
+// unwrap recovers the data key, failing when the passphrase does not match.
+func (env *keyStoreEnvelope) unwrap(passphrase string) ([]byte, error) {
+	dataKey, err := openWithPassphrase(env.KDF, env.Cipher, passphrase, env.WrappedKey, []byte(keyStoreWrapAAD))
+	if err != nil {
+		return nil, errKeyStorePassphrase
+	}
+	return dataKey, nil
+}
+
+// open decrypts every sealed private key with the data key.
+func (env *keyStoreEnvelope) open(dataKey []byte) ([]StoredKey, error) {
+	aead, err := newStoreAEAD(env.Cipher, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]StoredKey, 0, len(env.Keys))
+	for _, entry := range env.Keys {
+		key := entry.StoredKey
+		if entry.SealedPrivate != "" {
+			plain, err := openSealed(aead, entry.SealedPrivate, sealedKeyAAD(key.ID))
+			if err != nil {
+				return nil, fmt.Errorf("unable to decrypt key %s: %w", key.ID, err)
+			}
+			key.PrivatePEM = string(plain)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// metadata returns the entries without private material.
+func (env *keyStoreEnvelope) metadata() []StoredKey {
+	keys := make([]StoredKey, 0, len(env.Keys))
+	for _, entry := range env.Keys {
+		key := entry.StoredKey
+		key.PrivatePEM = ""
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// seal replaces the envelope entries with keys. Entries without private material
+// keep their previously sealed value so a locked store can still drop or edit keys.
+func (env *keyStoreEnvelope) seal(keys []StoredKey, dataKey []byte, previous map[string]string) error {
+	var aead cipher.AEAD
+	if dataKey != nil {
+		var err error
+		aead, err = newStoreAEAD(env.Cipher, dataKey)
+		if err != nil {
+			return err
+		}
+	}
+	entries := make([]sealedKey, 0, len(keys))
+	for _, key := range keys {
+		entry := sealedKey{StoredKey: key}
+		entry.PrivatePEM = ""
+		switch {
+		case key.PrivatePEM != "":
+			if aead == nil {
+				return errKeyStoreLocked
+			}
+			sealed, err := sealBytes(aead, []byte(key.PrivatePEM), sealedKeyAAD(key.ID))
+			if err != nil {
+				return err
+			}
+			entry.SealedPrivate = sealed
+		case previous != nil:
+			entry.SealedPrivate = previous[key.ID]
+		}
+		entries = append(entries, entry)
+	}
+	env.Keys = entries
+	return nil
+}
+
+func sealedKeyAAD(id string) []byte {
+	return []byte("ctools-key:" + id)
+}
+
+func newKeyStoreKDF(name string) (keyStoreKDF, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return keyStoreKDF{}, err
+	}
+	switch strings.ToLower(name) {
+	case "", "argon2id", "argon2":
+		return keyStoreKDF{Name: "argon2id", Salt: encodeBase64(salt), Time: 3, Memory: 64 * 1024, Threads: 4}, nil
+	case "scrypt":
+		return keyStoreKDF{Name: "scrypt", Salt: encodeBase64(salt), N: 1 << 15, R: 8, P: 1}, nil
+	default:
+		return keyStoreKDF{}, fmt.Errorf("unsupported key store KDF: %s", name)
+	}
+}
+
+func (kdf keyStoreKDF) derive(passphrase string, size int) ([]byte, error) {
+	salt, err := base64Decode(kdf.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KDF salt: %w", err)
+	}
+	switch kdf.Name {
+	case "argon2id":
+		if kdf.Time == 0 || kdf.Memory == 0 || kdf.Threads == 0 {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+		return argon2.IDKey([]byte(passphrase), salt, kdf.Time, kdf.Memory, kdf.Threads, uint32(size)), nil
+	case "scrypt":
+		return scrypt.Key([]byte(passphrase), salt, kdf.N, kdf.R, kdf.P, size)
+	default:
+		return nil, fmt.Errorf("unsupported key store KDF: %s", kdf.Name)
+	}
+}
+
+func normalizeStoreCipher(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "aes", "aes-gcm", "aes-256-gcm":
+		return defaultStoreCipher
+	case "sm4", "sm4-gcm":
+		return "sm4-gcm"
+	default:
+		return strings.ToLower(name)
+	}
+}
+
+func storeCipherKeySize(name string) (int, error) {
+	switch name {
+	case "aes-256-gcm":
+		return 32, nil
+	case "sm4-gcm":
+		return 16, nil
+	default:
+		return 0, fmt.Errorf("unsupported key store cipher: %s", name)
+	}
+}
+
+func newStoreAEAD(name string, key []byte) (cipher.AEAD, error) {
+	var block cipher.Block
+	var err error
+	switch name {
+	case "aes-256-gcm":
+		block, err = aes.NewCipher(key)
+	case "sm4-gcm":
+		block, err = sm4.NewCipher(key)
+	default:
+		return nil, fmt.Errorf("unsupported key store cipher: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealWithPassphrase derives a key from the passphrase and seals plaintext with it.
+func sealWithPassphrase(kdf keyStoreKDF, cipherName, passphrase string, plaintext, aad []byte) (string, error) {
+	keySize, err := storeCipherKeySize(cipherName)
+	if err != nil {
+		return "", err
+	}
+	kek, err := kdf.derive(passphrase, keySize)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newStoreAEAD(cipherName, kek)
+	if err != nil {
+		return "", err
+	}
+	return sealBytes(aead, plaintext, aad)
+}
+
+// openWithPassphrase reverses sealWithPassphrase.
+func openWithPassphrase(kdf keyStoreKDF, cipherName, passphrase, sealed string, aad []byte) ([]byte, error) {
+	keySize, err := storeCipherKeySize(cipherName)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := kdf.derive(passphrase, keySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newStoreAEAD(cipherName, kek)
+	if err != nil {
+		return nil, err
+	}
+	return openSealed(aead, sealed, aad)
+}
+
+// sealBytes encrypts plaintext and returns base64(nonce || ciphertext).
+func sealBytes(aead cipher.AEAD, plaintext, aad []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encodeBase64(aead.Seal(nonce, nonce, plaintext, aad)), nil
+}
+
+func openSealed(aead cipher.AEAD, sealed string, aad []byte) ([]byte, error) {
+	raw, err := base64Decode(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < aead.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], aad)
+}
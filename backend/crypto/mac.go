@@ -0,0 +1,274 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/poly1305"
+)
+
+// RunMAC computes or verifies a message authentication code: the ISO/IEC
+// 9797-1 CBC-MAC algorithms 1 and 3, CMAC, GMAC, Poly1305 and SipHash-2-4.
+//
+// req: The MACRequest with the algorithm, key and message, and the expected MAC for verify.
+// Returns an OperationResult with the MAC, or with Verified set in verify mode.
+func (c *CryptoService) RunMAC(req MACRequest) (OperationResult, error) {
+	algo := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(req.Algorithm)), "_", "-")
+	key, err := decodeBlob(req.Key, req.KeyFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid key: %w", err)
+	}
+	input, err := decodeBlob(req.Input, req.InputFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid input: %w", err)
+	}
+	verify := false
+	switch strings.ToLower(req.Operation) {
+	case "", "generate", "mac", "sign":
+	case "verify":
+		verify = true
+	default:
+		return OperationResult{}, errors.New("operation must be generate or verify")
+	}
+	var expected []byte
+	tagLength := req.TagLength
+	if verify {
+		if expected, err = decodeBlob(req.MAC, req.MACFormat); err != nil {
+			return OperationResult{}, fmt.Errorf("invalid MAC: %w", err)
+		}
+		if len(expected) == 0 {
+			return OperationResult{}, errors.New("MAC to verify is required")
+		}
+		if tagLength == 0 {
+			tagLength = len(expected)
+		}
+	}
+
+	details := map[string]string{}
+	var tag []byte
+	switch algo {
+	case "iso9797-1", "iso9797-1-alg1", "iso9797-alg1", "cbc-mac", "cbcmac", "mac1":
+		tag, err = runISO9797MAC(1, key, input, req, details)
+	case "iso9797-3", "iso9797-1-alg3", "iso9797-alg3", "retail-mac", "x9.19", "ansi-x9.19", "mac3":
+		tag, err = runISO9797MAC(3, key, input, req, details)
+	case "cmac", "omac1":
+		var block cipher.Block
+		if block, err = macBlockCipher(req.Cipher, "aes", key, details); err == nil {
+			tag, err = computeCMAC(block, input)
+		}
+	case "gmac":
+		tag, err = runGMAC(key, input, req, details)
+	case "poly1305":
+		if len(key) != 32 {
+			return OperationResult{}, errors.New("Poly1305 key must be 32 bytes")
+		}
+		var out [16]byte
+		poly1305.Sum(&out, input, (*[32]byte)(key))
+		tag = out[:]
+	case "siphash", "siphash-2-4", "siphash24", "siphash-64":
+		tag, err = sipHash(key, input, 8)
+	case "siphash-128", "siphash-2-4-128":
+		tag, err = sipHash(key, input, 16)
+	default:
+		return OperationResult{}, fmt.Errorf("unsupported MAC algorithm: %s", req.Algorithm)
+	}
+	if err != nil {
+		return OperationResult{}, err
+	}
+
+	if tagLength != 0 {
+		if tagLength < 4 || tagLength > len(tag) {
+			return OperationResult{}, fmt.Errorf("tag length must be between 4 and %d bytes", len(tag))
+		}
+		tag = tag[:tagLength]
+	}
+	details["tagLength"] = strconv.Itoa(len(tag))
+	if verify {
+		return OperationResult{Verified: subtle.ConstantTimeCompare(tag, expected) == 1, Details: details}, nil
+	}
+	details["base64"] = encodeBase64(tag)
+	return OperationResult{Output: encodeOutputBytes(tag, req.OutputFormat), Details: details}, nil
+}
+
+// macBlockCipher creates the named block cipher, or fallback when unset.
+func macBlockCipher(name, fallback string, key []byte, details map[string]string) (cipher.Block, error) {
+	if strings.TrimSpace(name) == "" {
+		name = fallback
+	}
+	spec, ok := lookupBlockCipher(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported block cipher: %s", name)
+	}
+	details["cipher"] = spec.name
+	return spec.newBlock(key)
+}
+
+// iso9797Pad applies ISO/IEC 9797-1 padding method 1 (zero bits, one zero
+// block for empty data), 2 (a 1 bit then zeros) or 3 (a block holding the
+// bit length, then method 1 without the empty-data block).
+func iso9797Pad(data []byte, blockSize int, method string) ([]byte, error) {
+	zeroPad := func(b []byte) []byte {
+		return append(b, make([]byte, (blockSize-len(b)%blockSize)%blockSize)...)
+	}
+	switch method {
+	case "", "1", "method1", "zero":
+		if len(data) == 0 {
+			return make([]byte, blockSize), nil
+		}
+		return zeroPad(append([]byte{}, data...)), nil
+	case "2", "method2", "iso7816", "iso7816-4", "80":
+		return zeroPad(append(append([]byte{}, data...), 0x80)), nil
+	case "3", "method3":
+		length := make([]byte, blockSize)
+		binary.BigEndian.PutUint64(length[blockSize-8:], uint64(len(data))*8)
+		return zeroPad(concatBytes(length, data)), nil
+	default:
+		return nil, fmt.Errorf("unsupported ISO 9797-1 padding method: %s", method)
+	}
+}
+
+// runISO9797MAC is CBC-MAC with initial transformation 1 and output
+// transformation 1 (algorithm 1) or 3 (algorithm 3, a final decrypt with K'
+// and encrypt with K). Algorithm 3 with DES is the ANSI X9.19 retail MAC.
+func runISO9797MAC(algorithm int, key, input []byte, req MACRequest, details map[string]string) ([]byte, error) {
+	name := req.Cipher
+	if strings.TrimSpace(name) == "" {
+		name = "des"
+	}
+	spec, ok := lookupBlockCipher(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported block cipher: %s", name)
+	}
+	details["cipher"] = spec.name
+	var block, final cipher.Block
+	var err error
+	if algorithm == 3 {
+		if err = spec.checkKey(key, 2); err != nil {
+			return nil, err
+		}
+		half := len(key) / 2
+		if block, err = spec.newCipher(key[:half]); err != nil {
+			return nil, err
+		}
+		if final, err = spec.newCipher(key[half:]); err != nil {
+			return nil, err
+		}
+	} else if block, err = spec.newBlock(key); err != nil {
+		return nil, err
+	}
+
+	bs := block.BlockSize()
+	method := strings.ToLower(strings.TrimSpace(req.Padding))
+	data, err := iso9797Pad(input, bs, method)
+	if err != nil {
+		return nil, err
+	}
+	h, err := decodeBlob(req.IV, req.IVFormat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IV: %w", err)
+	}
+	if len(h) == 0 {
+		h = make([]byte, bs)
+	} else if len(h) != bs {
+		return nil, fmt.Errorf("IV must be %d bytes", bs)
+	}
+	for i := 0; i < len(data); i += bs {
+		xorBytes(h, h, data[i:i+bs])
+		block.Encrypt(h, h)
+	}
+	if final != nil {
+		final.Decrypt(h, h)
+		block.Encrypt(h, h)
+	}
+	details["algorithm"] = "ISO 9797-1 MAC algorithm " + strconv.Itoa(algorithm)
+	if method == "" {
+		method = "1"
+	}
+	details["padding"] = strings.TrimPrefix(method, "method")
+	return h, nil
+}
+
+// runGMAC is GCM over an empty plaintext with the message as additional data.
+func runGMAC(key, input []byte, req MACRequest, details map[string]string) ([]byte, error) {
+	block, err := macBlockCipher(req.Cipher, "aes", key, details)
+	if err != nil {
+		return nil, err
+	}
+	if block.BlockSize() != 16 {
+		return nil, errors.New("GMAC requires a 16-byte block cipher")
+	}
+	nonce, err := decodeBlob(req.Nonce, req.NonceFormat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %w", err)
+	}
+	if len(nonce) == 0 {
+		return nil, errors.New("GMAC nonce is required")
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, nonce, nil, input), nil
+}
+
+// sipHash is SipHash-2-4 with a 64-bit or 128-bit output (size 8 or 16),
+// written little-endian as in the reference implementation.
+func sipHash(key, msg []byte, size int) ([]byte, error) {
+	if len(key) != 16 {
+		return nil, errors.New("SipHash key must be 16 bytes")
+	}
+	k0, k1 := binary.LittleEndian.Uint64(key), binary.LittleEndian.Uint64(key[8:])
+	v0, v1, v2, v3 := k0^0x736f6d6570736575, k1^0x646f72616e646f6d, k0^0x6c7967656e657261, k1^0x7465646279746573
+	if size == 16 {
+		v1 ^= 0xee
+	}
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13) ^ v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16) ^ v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21) ^ v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17) ^ v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+	compress := func(m uint64) {
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	n := len(msg) / 8 * 8
+	for i := 0; i < n; i += 8 {
+		compress(binary.LittleEndian.Uint64(msg[i:]))
+	}
+	var last [8]byte
+	copy(last[:], msg[n:])
+	last[7] = byte(len(msg))
+	compress(binary.LittleEndian.Uint64(last[:]))
+
+	finalize := func(flag uint64) uint64 {
+		v2 ^= flag
+		for i := 0; i < 4; i++ {
+			round()
+		}
+		return v0 ^ v1 ^ v2 ^ v3
+	}
+	out := make([]byte, size)
+	if size == 8 {
+		binary.LittleEndian.PutUint64(out, finalize(0xff))
+		return out, nil
+	}
+	binary.LittleEndian.PutUint64(out, finalize(0xee))
+	v1 ^= 0xdd
+	binary.LittleEndian.PutUint64(out[8:], finalize(0))
+	return out, nil
+}
@@ -0,0 +1,996 @@
+package crypto
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Password hash strings: bcrypt, scrypt ($scrypt$ PHC and $7$), Argon2 (PHC),
+// PBKDF2 (passlib, PHC and Django), the crypt(3) MD5 and SHA-2 schemes, and
+// LDAP {SSHA}-style userPassword values. HashPassword encodes and then parses
+// its own output, so both APIs report schemes and parameters the same way.
+
+const (
+	cryptAlphabet         = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	defaultShaCryptRounds = 5000
+	maxPasswordHashLength = 1024
+	maxScryptMemory       = 1 << 30 // 128*r*N bytes
+	maxArgon2Memory       = 1 << 20 // KiB
+	maxArgon2Time         = 100
+	maxPBKDF2Iterations   = 10000000
+	maxShaCryptRounds     = 10000000
+	maxBcryptCost         = 16
+)
+
+// Current OWASP recommendations for PBKDF2.
+var defaultPBKDF2PasswordIterations = map[string]int{
+	"sha1":   1300000,
+	"sha256": 600000,
+	"sha512": 210000,
+}
+
+// HashPassword creates an encoded password hash.
+//
+// req: The PasswordHashRequest with the scheme, password and tuning parameters.
+// Returns the encoded hash with its scheme and parameters.
+func (c *CryptoService) HashPassword(req PasswordHashRequest) (PasswordHashResult, error) {
+	scheme := normalizePasswordScheme(req.Scheme)
+	password := []byte(req.Password)
+	salt, err := decodeBlob(req.Salt, req.SaltFormat)
+	if err != nil {
+		return PasswordHashResult{}, fmt.Errorf("invalid salt: %w", err)
+	}
+	if req.Length < 0 || req.Length > maxPasswordHashLength {
+		return PasswordHashResult{}, fmt.Errorf("length must be between 1 and %d bytes", maxPasswordHashLength)
+	}
+	format := strings.ToLower(req.Format)
+
+	var encoded string
+	switch {
+	case scheme == "bcrypt":
+		encoded, err = hashBcrypt(password, salt, req.Cost)
+	case scheme == "scrypt":
+		encoded, err = hashScrypt(password, salt, req, format)
+	case strings.HasPrefix(scheme, "argon2"):
+		encoded, err = hashArgon2(scheme, password, salt, req)
+	case strings.HasPrefix(scheme, "pbkdf2-"):
+		encoded, err = hashPBKDF2(strings.TrimPrefix(scheme, "pbkdf2-"), password, salt, req, format)
+	case scheme == "md5-crypt" || scheme == "apr1":
+		magic := "$1$"
+		if scheme == "apr1" {
+			magic = "$apr1$"
+		}
+		if salt, err = cryptSalt(salt, 8); err == nil {
+			encoded = magic + string(salt) + "$" + md5Crypt(password, salt, magic)
+		}
+	case scheme == "sha256-crypt" || scheme == "sha512-crypt":
+		encoded, err = hashShaCrypt(scheme, password, salt, req.Iterations)
+	case ldapSchemes[scheme].newHash != nil:
+		encoded, err = hashLDAP(scheme, password, salt)
+	default:
+		return PasswordHashResult{}, fmt.Errorf("unsupported password hash scheme: %s", req.Scheme)
+	}
+	if err != nil {
+		return PasswordHashResult{}, err
+	}
+	parsed, err := parsePasswordHash(encoded)
+	if err != nil {
+		return PasswordHashResult{}, err
+	}
+	return PasswordHashResult{Hash: encoded, Scheme: parsed.scheme, Params: parsed.params}, nil
+}
+
+// VerifyPassword checks a password against an encoded hash, detecting the
+// scheme from the string.
+//
+// req: The PasswordVerifyRequest with the candidate password and the hash.
+// Returns the detected scheme and parameters and whether the password matches.
+func (c *CryptoService) VerifyPassword(req PasswordVerifyRequest) (PasswordHashResult, error) {
+	encoded := strings.TrimSpace(req.Hash)
+	parsed, err := parsePasswordHash(encoded)
+	if err != nil {
+		return PasswordHashResult{}, err
+	}
+	ok, err := parsed.check([]byte(req.Password))
+	if err != nil {
+		return PasswordHashResult{}, err
+	}
+	return PasswordHashResult{Hash: encoded, Scheme: parsed.scheme, Params: parsed.params, Verified: ok}, nil
+}
+
+func normalizePasswordScheme(name string) string {
+	name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "_", "-")
+	name = strings.TrimPrefix(name, "ldap-")
+	name = strings.Trim(name, "{}")
+	switch name {
+	case "", "argon2":
+		return "argon2id"
+	case "pbkdf2":
+		return "pbkdf2-sha256"
+	case "md5crypt", "$1$", "crypt-md5":
+		return "md5-crypt"
+	case "$apr1$", "apr-md5":
+		return "apr1"
+	case "sha256crypt", "$5$", "crypt-sha256":
+		return "sha256-crypt"
+	case "sha512crypt", "$6$", "crypt-sha512":
+		return "sha512-crypt"
+	case "2a", "2b", "2y":
+		return "bcrypt"
+	}
+	return name
+}
+
+// parsedPassword is a decoded password hash string.
+type parsedPassword struct {
+	scheme string
+	params map[string]string
+	check  func(password []byte) (bool, error)
+}
+
+func parsePasswordHash(encoded string) (parsedPassword, error) {
+	switch {
+	case encoded == "":
+		return parsedPassword{}, errors.New("password hash is empty")
+	case strings.HasPrefix(encoded, "$2"):
+		return parseBcrypt(encoded)
+	case strings.HasPrefix(encoded, "$argon2"):
+		return parseArgon2(encoded)
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		return parseScryptPHC(encoded)
+	case strings.HasPrefix(encoded, "$7$"):
+		return parseScryptMCF(encoded)
+	case strings.HasPrefix(encoded, "$pbkdf2"):
+		return parsePBKDF2(encoded)
+	case strings.HasPrefix(encoded, "pbkdf2_"):
+		return parseDjangoPBKDF2(encoded)
+	case strings.HasPrefix(encoded, "$1$"), strings.HasPrefix(encoded, "$apr1$"):
+		return parseMD5Crypt(encoded)
+	case strings.HasPrefix(encoded, "$5$"), strings.HasPrefix(encoded, "$6$"):
+		return parseShaCrypt(encoded)
+	case strings.HasPrefix(encoded, "{"):
+		return parseLDAP(encoded)
+	default:
+		return parsedPassword{}, errors.New("unrecognised password hash format")
+	}
+}
+
+// equalCheck compares a recomputed value with the stored one in constant time.
+func equalCheck(stored []byte, derive func([]byte) ([]byte, error)) func([]byte) (bool, error) {
+	return func(password []byte) (bool, error) {
+		got, err := derive(password)
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(got, stored) == 1, nil
+	}
+}
+
+func passwordSalt(salt []byte, size int) ([]byte, error) {
+	if len(salt) > 0 {
+		return salt, nil
+	}
+	salt = make([]byte, size)
+	_, err := rand.Read(salt)
+	return salt, err
+}
+
+// cryptSalt returns the crypt(3) salt string, truncated to maxLen like the C
+// implementations, or a random one.
+func cryptSalt(salt []byte, maxLen int) ([]byte, error) {
+	if len(salt) == 0 {
+		raw := make([]byte, maxLen)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		for i, b := range raw {
+			raw[i] = cryptAlphabet[b&0x3f]
+		}
+		return raw, nil
+	}
+	if strings.ContainsAny(string(salt), "$:\n") {
+		return nil, errors.New("crypt salt cannot contain '$', ':' or newlines")
+	}
+	if len(salt) > maxLen {
+		salt = salt[:maxLen]
+	}
+	return salt, nil
+}
+
+// parsePHCParams splits "m=65536,t=3,p=4" into integers.
+func parsePHCParams(text string, names ...string) (map[string]int, error) {
+	values := map[string]int{}
+	for _, field := range strings.Split(text, ",") {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid parameter %q", field)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid parameter %q", field)
+		}
+		values[name] = n
+	}
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("missing parameter %s", name)
+		}
+	}
+	return values, nil
+}
+
+// decodePHCBase64 accepts the unpadded base64 of PHC strings, and padding for
+// tolerance.
+func decodePHCBase64(text string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(text, "="))
+}
+
+// bcrypt
+
+func hashBcrypt(password, salt []byte, cost int) (string, error) {
+	if len(salt) > 0 {
+		return "", errors.New("bcrypt generates its own salt")
+	}
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	if err := checkBcryptCost(cost); err != nil {
+		return "", err
+	}
+	out, err := bcrypt.GenerateFromPassword(password, cost)
+	return string(out), err
+}
+
+func parseBcrypt(encoded string) (parsedPassword, error) {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return parsedPassword{}, fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+	if err := checkBcryptCost(cost); err != nil {
+		return parsedPassword{}, err
+	}
+	return parsedPassword{
+		scheme: "bcrypt",
+		params: map[string]string{"version": encoded[1:3], "cost": strconv.Itoa(cost)},
+		check: func(password []byte) (bool, error) {
+			err := bcrypt.CompareHashAndPassword([]byte(encoded), password)
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, nil
+			}
+			return err == nil, err
+		},
+	}, nil
+}
+
+func checkBcryptCost(cost int) error {
+	if cost > maxBcryptCost {
+		return fmt.Errorf("bcrypt cost must not exceed %d", maxBcryptCost)
+	}
+	return nil
+}
+
+// scrypt
+
+func checkScryptParams(logN, r, p int) error {
+	if logN < 1 || logN > 30 || r < 1 || p < 1 || r*p >= 1<<30 {
+		return errors.New("scrypt needs log2(N) 1-30, r >= 1, p >= 1 and r*p < 2^30")
+	}
+	if 128*r*(1<<logN) > maxScryptMemory {
+		return errors.New("scrypt parameters need more than 1 GiB of memory")
+	}
+	return nil
+}
+
+func hashScrypt(password, salt []byte, req PasswordHashRequest, format string) (string, error) {
+	logN, r, p := req.Cost, req.BlockSize, req.Parallelism
+	if logN == 0 {
+		logN = 15
+	}
+	if r == 0 {
+		r = 8
+	}
+	if p == 0 {
+		p = 1
+	}
+	if err := checkScryptParams(logN, r, p); err != nil {
+		return "", err
+	}
+	switch format {
+	case "", "phc":
+		length := req.Length
+		if length == 0 {
+			length = 32
+		}
+		salt, err := passwordSalt(salt, 16)
+		if err != nil {
+			return "", err
+		}
+		dk, err := scrypt.Key(password, salt, 1<<logN, r, p, length)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", logN, r, p, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(dk)), nil
+	case "mcf", "7", "$7$":
+		if r >= 1<<30 || p >= 1<<30 {
+			return "", errors.New("scrypt $7$ limits r and p to 30 bits")
+		}
+		salt, err := cryptSalt(salt, 43)
+		if err != nil {
+			return "", err
+		}
+		setting := "$7$" + string(cryptAlphabet[logN]) + cryptEncodeUint30(uint32(r)) + cryptEncodeUint30(uint32(p)) + string(salt)
+		dk, err := scrypt.Key(password, salt, 1<<logN, r, p, 32)
+		if err != nil {
+			return "", err
+		}
+		return setting + "$" + cryptEncodeLE(dk), nil
+	default:
+		return "", fmt.Errorf("unsupported scrypt format: %s", format)
+	}
+}
+
+func parseScryptPHC(encoded string) (parsedPassword, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return parsedPassword{}, errors.New("invalid scrypt hash")
+	}
+	values, err := parsePHCParams(parts[2], "ln", "r", "p")
+	if err != nil {
+		return parsedPassword{}, err
+	}
+	if err := checkScryptParams(values["ln"], values["r"], values["p"]); err != nil {
+		return parsedPassword{}, err
+	}
+	salt, err := decodePHCBase64(parts[3])
+	if err != nil {
+		return parsedPassword{}, errors.New("invalid scrypt salt")
+	}
+	stored, err := decodePHCBase64(parts[4])
+	if err != nil || len(stored) == 0 {
+		return parsedPassword{}, errors.New("invalid scrypt hash value")
+	}
+	return parsedPassword{
+		scheme: "scrypt",
+		params: map[string]string{
+			"format": "phc", "ln": strconv.Itoa(values["ln"]), "N": strconv.Itoa(1 << values["ln"]),
+			"r": strconv.Itoa(values["r"]), "p": strconv.Itoa(values["p"]),
+			"saltLength": strconv.Itoa(len(salt)), "length": strconv.Itoa(len(stored)),
+		},
+		check: equalCheck(stored, func(password []byte) ([]byte, error) {
+			return scrypt.Key(password, salt, 1<<values["ln"], values["r"], values["p"], len(stored))
+		}),
+	}, nil
+}
+
+// parseScryptMCF reads the libsodium/libxcrypt "$7$" string: N as one
+// character, r and p as five characters each, the salt string and the hash.
+func parseScryptMCF(encoded string) (parsedPassword, error) {
+	cut := strings.LastIndexByte(encoded, '$')
+	if len(encoded) < 14 || cut < 14 {
+		return parsedPassword{}, errors.New("invalid scrypt $7$ hash")
+	}
+	logN := strings.IndexByte(cryptAlphabet, encoded[3])
+	r, errR := cryptDecodeUint30(encoded[4:9])
+	p, errP := cryptDecodeUint30(encoded[9:14])
+	if logN < 0 || errR != nil || errP != nil {
+		return parsedPassword{}, errors.New("invalid scrypt $7$ parameters")
+	}
+	if err := checkScryptParams(logN, int(r), int(p)); err != nil {
+		return parsedPassword{}, err
+	}
+	salt, stored := []byte(encoded[14:cut]), encoded[cut+1:]
+	return parsedPassword{
+		scheme: "scrypt",
+		params: map[string]string{
+			"format": "mcf", "ln": strconv.Itoa(logN), "N": strconv.Itoa(1 << logN),
+			"r": strconv.Itoa(int(r)), "p": strconv.Itoa(int(p)), "saltLength": strconv.Itoa(len(salt)),
+		},
+		check: equalCheck([]byte(stored), func(password []byte) ([]byte, error) {
+			dk, err := scrypt.Key(password, salt, 1<<logN, int(r), int(p), 32)
+			return []byte(cryptEncodeLE(dk)), err
+		}),
+	}, nil
+}
+
+// cryptEncodeUint30 writes 30 bits as five little-endian crypt characters.
+func cryptEncodeUint30(v uint32) string {
+	var out [5]byte
+	for i := range out {
+		out[i] = cryptAlphabet[v&0x3f]
+		v >>= 6
+	}
+	return string(out[:])
+}
+
+func cryptDecodeUint30(text string) (uint32, error) {
+	var v uint32
+	for i := len(text) - 1; i >= 0; i-- {
+		d := strings.IndexByte(cryptAlphabet, text[i])
+		if d < 0 {
+			return 0, errors.New("invalid crypt character")
+		}
+		v = v<<6 | uint32(d)
+	}
+	return v, nil
+}
+
+// cryptEncodeLE is the little-endian crypt base64 of libsodium's encode64.
+func cryptEncodeLE(src []byte) string {
+	var out strings.Builder
+	for i := 0; i < len(src); i += 3 {
+		var v uint32
+		bits := 0
+		for j := i; j < len(src) && j < i+3; j++ {
+			v |= uint32(src[j]) << bits
+			bits += 8
+		}
+		for ; bits > 0; bits -= 6 {
+			out.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	return out.String()
+}
+
+// Argon2
+
+func hashArgon2(scheme string, password, salt []byte, req PasswordHashRequest) (string, error) {
+	t, m, p, length := req.Iterations, req.Memory, req.Parallelism, req.Length
+	if t == 0 {
+		t = defaultArgon2Time
+	}
+	if m == 0 {
+		m = defaultArgon2Memory
+	}
+	if p == 0 {
+		p = defaultArgon2Threads
+	}
+	if length == 0 {
+		length = 32
+	}
+	if err := checkArgon2Params(t, m, p, length); err != nil {
+		return "", err
+	}
+	salt, err := passwordSalt(salt, 16)
+	if err != nil {
+		return "", err
+	}
+	mode := map[string]int{"argon2id": argon2id, "argon2i": argon2i, "argon2d": argon2d}[scheme]
+	dk := argon2Key(mode, password, salt, nil, nil, uint32(t), uint32(m), uint8(p), uint32(length))
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", scheme, argon2Version, m, t, p,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(dk)), nil
+}
+
+func checkArgon2Params(t, m, p, length int) error {
+	if t < 1 || t > maxArgon2Time || p < 1 || p > 255 || m < 8*p {
+		return fmt.Errorf("argon2 needs iterations 1-%d, parallelism 1-255 and at least 8*parallelism KiB of memory", maxArgon2Time)
+	}
+	if m > maxArgon2Memory {
+		return errors.New("argon2 parameters need more than 1 GiB of memory")
+	}
+	if length < 4 {
+		return errors.New("argon2 output must be at least 4 bytes")
+	}
+	return nil
+}
+
+func parseArgon2(encoded string) (parsedPassword, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		if len(parts) == 5 {
+			return parsedPassword{}, errors.New("only Argon2 version 19 (1.3) hashes are supported")
+		}
+		return parsedPassword{}, errors.New("invalid Argon2 hash")
+	}
+	mode, ok := map[string]int{"argon2id": argon2id, "argon2i": argon2i, "argon2d": argon2d}[parts[1]]
+	if !ok {
+		return parsedPassword{}, fmt.Errorf("unsupported Argon2 variant %q", parts[1])
+	}
+	if parts[2] != "v=19" {
+		return parsedPassword{}, errors.New("only Argon2 version 19 (1.3) hashes are supported")
+	}
+	values, err := parsePHCParams(parts[3], "m", "t", "p")
+	if err != nil {
+		return parsedPassword{}, err
+	}
+	salt, err := decodePHCBase64(parts[4])
+	if err != nil {
+		return parsedPassword{}, errors.New("invalid Argon2 salt")
+	}
+	stored, err := decodePHCBase64(parts[5])
+	if err != nil {
+		return parsedPassword{}, errors.New("invalid Argon2 hash value")
+	}
+	t, m, p := values["t"], values["m"], values["p"]
+	if err := checkArgon2Params(t, m, p, len(stored)); err != nil {
+		return parsedPassword{}, err
+	}
+	return parsedPassword{
+		scheme: parts[1],
+		params: map[string]string{
+			"version": "19", "m": strconv.Itoa(m), "t": strconv.Itoa(t), "p": strconv.Itoa(p),
+			"saltLength": strconv.Itoa(len(salt)), "length": strconv.Itoa(len(stored)),
+		},
+		check: equalCheck(stored, func(password []byte) ([]byte, error) {
+			return argon2Key(mode, password, salt, nil, nil, uint32(t), uint32(m), uint8(p), uint32(len(stored))), nil
+		}),
+	}, nil
+}
+
+// PBKDF2
+
+// passlib's adapted base64 uses '.' instead of '+' and no padding.
+func ab64Encode(b []byte) string {
+	return strings.ReplaceAll(base64.RawStdEncoding.EncodeToString(b), "+", ".")
+}
+
+func ab64Decode(text string) ([]byte, error) {
+	return decodePHCBase64(strings.ReplaceAll(text, ".", "+"))
+}
+
+func hashPBKDF2(hashName string, password, salt []byte, req PasswordHashRequest, format string) (string, error) {
+	h, err := digestHash(hashName, "")
+	if err != nil {
+		return "", err
+	}
+	iterations := req.Iterations
+	if iterations == 0 {
+		if iterations = defaultPBKDF2PasswordIterations[h.name]; iterations == 0 {
+			iterations = defaultPBKDF2PasswordIterations["sha256"]
+		}
+	}
+	if err := checkPBKDF2Iterations(iterations); err != nil {
+		return "", err
+	}
+	length := req.Length
+	if length == 0 {
+		length = h.size()
+	}
+	switch format {
+	case "", "mcf", "passlib":
+		if salt, err = passwordSalt(salt, 16); err != nil {
+			return "", err
+		}
+		ident := "$pbkdf2-" + h.name
+		if h.name == "sha1" {
+			ident = "$pbkdf2"
+		}
+		dk := pbkdf2.Key(password, salt, iterations, length, h.newHash)
+		return fmt.Sprintf("%s$%d$%s$%s", ident, iterations, ab64Encode(salt), ab64Encode(dk)), nil
+	case "phc":
+		if salt, err = passwordSalt(salt, 16); err != nil {
+			return "", err
+		}
+		dk := pbkdf2.Key(password, salt, iterations, length, h.newHash)
+		return fmt.Sprintf("$pbkdf2-%s$i=%d,l=%d$%s$%s", h.name, iterations, length,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(dk)), nil
+	case "django":
+		if h.name != "sha1" && h.name != "sha256" {
+			return "", errors.New("django format supports pbkdf2-sha1 and pbkdf2-sha256")
+		}
+		if salt, err = cryptSalt(salt, 22); err != nil {
+			return "", err
+		}
+		dk := pbkdf2.Key(password, salt, iterations, h.size(), h.newHash)
+		return fmt.Sprintf("pbkdf2_%s$%d$%s$%s", h.name, iterations, salt, base64.StdEncoding.EncodeToString(dk)), nil
+	default:
+		return "", fmt.Errorf("unsupported PBKDF2 format: %s", format)
+	}
+}
+
+func checkPBKDF2Iterations(iterations int) error {
+	if iterations < 1 || iterations > maxPBKDF2Iterations {
+		return fmt.Errorf("PBKDF2 iterations must be between 1 and %d", maxPBKDF2Iterations)
+	}
+	return nil
+}
+
+func pbkdf2Parsed(h hashSpec, format string, iterations int, salt, stored []byte) parsedPassword {
+	return parsedPassword{
+		scheme: "pbkdf2-" + h.name,
+		params: map[string]string{
+			"format": format, "hash": h.name, "iterations": strconv.Itoa(iterations),
+			"saltLength": strconv.Itoa(len(salt)), "length": strconv.Itoa(len(stored)),
+		},
+		check: equalCheck(stored, func(password []byte) ([]byte, error) {
+			return pbkdf2.Key(password, salt, iterations, len(stored), h.newHash), nil
+		}),
+	}
+}
+
+// parsePBKDF2 reads passlib "$pbkdf2-sha256$29000$salt$hash" strings and the
+// PHC form "$pbkdf2-sha256$i=29000,l=32$salt$hash".
+func parsePBKDF2(encoded string) (parsedPassword, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return parsedPassword{}, errors.New("invalid PBKDF2 hash")
+	}
+	hashName := "sha1"
+	if name, ok := strings.CutPrefix(parts[1], "pbkdf2-"); ok {
+		hashName = name
+	} else if parts[1] != "pbkdf2" {
+		return parsedPassword{}, errors.New("invalid PBKDF2 hash")
+	}
+	h, err := digestHash(hashName, "")
+	if err != nil {
+		return parsedPassword{}, err
+	}
+	var salt, stored []byte
+	var iterations int
+	format := "mcf"
+	if strings.Contains(parts[2], "=") {
+		format = "phc"
+		values, err := parsePHCParams(parts[2], "i")
+		if err != nil {
+			return parsedPassword{}, err
+		}
+		iterations = values["i"]
+		salt, err = decodePHCBase64(parts[3])
+		if err != nil {
+			return parsedPassword{}, errors.New("invalid PBKDF2 salt")
+		}
+		if stored, err = decodePHCBase64(parts[4]); err != nil {
+			return parsedPassword{}, errors.New("invalid PBKDF2 hash value")
+		}
+		if l, ok := values["l"]; ok && l != len(stored) {
+			return parsedPassword{}, errors.New("PBKDF2 length parameter does not match the hash")
+		}
+	} else {
+		if iterations, err = strconv.Atoi(parts[2]); err != nil {
+			return parsedPassword{}, errors.New("invalid PBKDF2 iteration count")
+		}
+		if salt, err = ab64Decode(parts[3]); err != nil {
+			return parsedPassword{}, errors.New("invalid PBKDF2 salt")
+		}
+		if stored, err = ab64Decode(parts[4]); err != nil {
+			return parsedPassword{}, errors.New("invalid PBKDF2 hash value")
+		}
+	}
+	if len(stored) == 0 {
+		return parsedPassword{}, errors.New("invalid PBKDF2 parameters")
+	}
+	if err := checkPBKDF2Iterations(iterations); err != nil {
+		return parsedPassword{}, err
+	}
+	return pbkdf2Parsed(h, format, iterations, salt, stored), nil
+}
+
+// parseDjangoPBKDF2 reads Django's "pbkdf2_sha256$iterations$salt$hash".
+func parseDjangoPBKDF2(encoded string) (parsedPassword, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 {
+		return parsedPassword{}, errors.New("invalid Django PBKDF2 hash")
+	}
+	h, err := digestHash(strings.TrimPrefix(parts[0], "pbkdf2_"), "")
+	if err != nil {
+		return parsedPassword{}, err
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return parsedPassword{}, errors.New("invalid PBKDF2 iteration count")
+	}
+	if err := checkPBKDF2Iterations(iterations); err != nil {
+		return parsedPassword{}, err
+	}
+	stored, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(stored) == 0 {
+		return parsedPassword{}, errors.New("invalid PBKDF2 hash value")
+	}
+	return pbkdf2Parsed(h, "django", iterations, []byte(parts[2]), stored), nil
+}
+
+// crypt(3): MD5-crypt (also Apache's $apr1$) and SHA-crypt.
+
+// cryptEncode24 writes n characters of the 24-bit group b2 b1 b0, least
+// significant six bits first.
+func cryptEncode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint32(b2)<<16 | uint32(b1)<<8 | uint32(b0)
+	for ; n > 0; n-- {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
+
+func md5Crypt(password, salt []byte, magic string) string {
+	alt := md5.Sum(concatBytes(password, salt, password))
+	ctx := md5.New()
+	ctx.Write(password)
+	ctx.Write([]byte(magic))
+	ctx.Write(salt)
+	for n := len(password); n > 0; n -= 16 {
+		ctx.Write(alt[:min(n, 16)])
+	}
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(password[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(password)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(password)
+		}
+		final = round.Sum(final[:0])
+	}
+	var out strings.Builder
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		cryptEncode24(&out, final[g[0]], final[g[1]], final[g[2]], 4)
+	}
+	cryptEncode24(&out, 0, 0, final[11], 2)
+	return out.String()
+}
+
+func parseMD5Crypt(encoded string) (parsedPassword, error) {
+	magic := "$1$"
+	if strings.HasPrefix(encoded, "$apr1$") {
+		magic = "$apr1$"
+	}
+	salt, digest, ok := strings.Cut(encoded[len(magic):], "$")
+	if !ok || len(digest) != 22 {
+		return parsedPassword{}, errors.New("invalid MD5-crypt hash")
+	}
+	scheme := "md5-crypt"
+	if magic == "$apr1$" {
+		scheme = "apr1"
+	}
+	return parsedPassword{
+		scheme: scheme,
+		params: map[string]string{"saltLength": strconv.Itoa(len(salt))},
+		check: equalCheck([]byte(digest), func(password []byte) ([]byte, error) {
+			return []byte(md5Crypt(password, []byte(salt), magic)), nil
+		}),
+	}, nil
+}
+
+var (
+	sha256CryptOrder = [][3]int{{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14}, {15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29}}
+	sha512CryptOrder = [][3]int{{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41}}
+)
+
+// shaCrypt is Ulrich Drepper's SHA-crypt for SHA-256 ($5$) and SHA-512 ($6$).
+func shaCrypt(newHash func() hash.Hash, password, salt []byte, rounds int) []byte {
+	size := newHash().Size()
+	b := newHash()
+	b.Write(password)
+	b.Write(salt)
+	b.Write(password)
+	bSum := b.Sum(nil)
+
+	a := newHash()
+	a.Write(password)
+	a.Write(salt)
+	n := len(password)
+	for ; n > size; n -= size {
+		a.Write(bSum)
+	}
+	a.Write(bSum[:n])
+	for n = len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(bSum)
+		} else {
+			a.Write(password)
+		}
+	}
+	cur := a.Sum(nil)
+
+	dp := newHash()
+	for range password {
+		dp.Write(password)
+	}
+	p := repeatToLength(dp.Sum(nil), len(password))
+	ds := newHash()
+	for i := 0; i < 16+int(cur[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeatToLength(ds.Sum(nil), len(salt))
+
+	for i := 0; i < rounds; i++ {
+		c := newHash()
+		if i&1 != 0 {
+			c.Write(p)
+		} else {
+			c.Write(cur)
+		}
+		if i%3 != 0 {
+			c.Write(s)
+		}
+		if i%7 != 0 {
+			c.Write(p)
+		}
+		if i&1 != 0 {
+			c.Write(cur)
+		} else {
+			c.Write(p)
+		}
+		cur = c.Sum(cur[:0])
+	}
+	return cur
+}
+
+func repeatToLength(block []byte, length int) []byte {
+	out := make([]byte, 0, length)
+	for len(out) < length {
+		out = append(out, block[:min(len(block), length-len(out))]...)
+	}
+	return out
+}
+
+func shaCryptEncode(sum []byte) string {
+	var out strings.Builder
+	if len(sum) == sha256.Size {
+		for _, g := range sha256CryptOrder {
+			cryptEncode24(&out, sum[g[0]], sum[g[1]], sum[g[2]], 4)
+		}
+		cryptEncode24(&out, 0, sum[31], sum[30], 3)
+	} else {
+		for _, g := range sha512CryptOrder {
+			cryptEncode24(&out, sum[g[0]], sum[g[1]], sum[g[2]], 4)
+		}
+		cryptEncode24(&out, 0, 0, sum[63], 2)
+	}
+	return out.String()
+}
+
+func clampShaCryptRounds(rounds int) int {
+	return max(1000, min(rounds, 999999999))
+}
+
+func checkShaCryptRounds(rounds int) error {
+	if rounds > maxShaCryptRounds {
+		return fmt.Errorf("SHA-crypt rounds must not exceed %d", maxShaCryptRounds)
+	}
+	return nil
+}
+
+func hashShaCrypt(scheme string, password, salt []byte, rounds int) (string, error) {
+	salt, err := cryptSalt(salt, 16)
+	if err != nil {
+		return "", err
+	}
+	magic, newHash := "$5$", sha256.New
+	if scheme == "sha512-crypt" {
+		magic, newHash = "$6$", sha512.New
+	}
+	setting := magic
+	if rounds != 0 {
+		rounds = clampShaCryptRounds(rounds)
+		if err := checkShaCryptRounds(rounds); err != nil {
+			return "", err
+		}
+		setting += fmt.Sprintf("rounds=%d$", rounds)
+	} else {
+		rounds = defaultShaCryptRounds
+	}
+	return setting + string(salt) + "$" + shaCryptEncode(shaCrypt(newHash, password, salt, rounds)), nil
+}
+
+func parseShaCrypt(encoded string) (parsedPassword, error) {
+	scheme, newHash := "sha256-crypt", sha256.New
+	if encoded[1] == '6' {
+		scheme, newHash = "sha512-crypt", sha512.New
+	}
+	rest := encoded[3:]
+	rounds, custom := defaultShaCryptRounds, false
+	if value, tail, ok := strings.Cut(rest, "$"); ok && strings.HasPrefix(value, "rounds=") {
+		n, err := strconv.Atoi(strings.TrimPrefix(value, "rounds="))
+		if err != nil {
+			return parsedPassword{}, errors.New("invalid SHA-crypt rounds")
+		}
+		rounds, custom, rest = clampShaCryptRounds(n), true, tail
+		if err := checkShaCryptRounds(rounds); err != nil {
+			return parsedPassword{}, err
+		}
+	}
+	salt, digest, ok := strings.Cut(rest, "$")
+	if !ok || (len(digest) != 43 && len(digest) != 86) {
+		return parsedPassword{}, errors.New("invalid SHA-crypt hash")
+	}
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	params := map[string]string{"rounds": strconv.Itoa(rounds), "saltLength": strconv.Itoa(len(salt))}
+	if !custom {
+		params["rounds"] += " (default)"
+	}
+	return parsedPassword{
+		scheme: scheme,
+		params: params,
+		check: equalCheck([]byte(digest), func(password []byte) ([]byte, error) {
+			return []byte(shaCryptEncode(shaCrypt(newHash, password, []byte(salt), rounds))), nil
+		}),
+	}, nil
+}
+
+// LDAP userPassword: {SHA}/{SSHA} and friends, base64 of digest || salt.
+
+var ldapSchemes = map[string]struct {
+	tag     string
+	salted  bool
+	newHash func() hash.Hash
+}{
+	"sha":     {"SHA", false, sha1.New},
+	"ssha":    {"SSHA", true, sha1.New},
+	"sha256":  {"SHA256", false, sha256.New},
+	"ssha256": {"SSHA256", true, sha256.New},
+	"sha512":  {"SHA512", false, sha512.New},
+	"ssha512": {"SSHA512", true, sha512.New},
+	"md5":     {"MD5", false, md5.New},
+	"smd5":    {"SMD5", true, md5.New},
+}
+
+func hashLDAP(scheme string, password, salt []byte) (string, error) {
+	spec := ldapSchemes[scheme]
+	if !spec.salted {
+		if len(salt) > 0 {
+			return "", fmt.Errorf("{%s} is unsalted, use {S%s}", spec.tag, spec.tag)
+		}
+	} else {
+		var err error
+		if salt, err = passwordSalt(salt, 8); err != nil {
+			return "", err
+		}
+	}
+	h := spec.newHash()
+	h.Write(password)
+	h.Write(salt)
+	return "{" + spec.tag + "}" + base64.StdEncoding.EncodeToString(concatBytes(h.Sum(nil), salt)), nil
+}
+
+func parseLDAP(encoded string) (parsedPassword, error) {
+	end := strings.IndexByte(encoded, '}')
+	if end < 0 {
+		return parsedPassword{}, errors.New("invalid LDAP password hash")
+	}
+	scheme := strings.ToLower(encoded[1:end])
+	spec, ok := ldapSchemes[scheme]
+	if !ok {
+		return parsedPassword{}, fmt.Errorf("unsupported LDAP password scheme {%s}", encoded[1:end])
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded[end+1:])
+	size := spec.newHash().Size()
+	if err != nil || len(raw) < size || (!spec.salted && len(raw) != size) {
+		return parsedPassword{}, errors.New("invalid LDAP password hash value")
+	}
+	stored, salt := raw[:size], raw[size:]
+	return parsedPassword{
+		scheme: scheme,
+		params: map[string]string{"format": "ldap", "saltLength": strconv.Itoa(len(salt))},
+		check: equalCheck(stored, func(password []byte) ([]byte, error) {
+			h := spec.newHash()
+			h.Write(password)
+			h.Write(salt)
+			return h.Sum(nil), nil
+		}),
+	}, nil
+}
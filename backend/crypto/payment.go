@@ -0,0 +1,757 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Payment HSM key tooling: key check values, clear component combination,
+// TR-31 key blocks and DUKPT. Keys are exchanged as hex by default, the way
+// HSM consoles and key ceremony forms present them.
+
+func decodePaymentKey(data, format string) ([]byte, error) {
+	if format == "" {
+		format = "hex"
+	}
+	return decodeBlob(data, format)
+}
+
+func upperHex(b []byte) string {
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// keyCheckValue encrypts a zero block (method "encrypt") or computes the CMAC
+// of a zero block ("cmac", X9.24-1:2017 for AES) and keeps length bytes.
+func keyCheckValue(algo string, key []byte, method string, length int) (string, error) {
+	block, err := resolveBlockCipher(algo, key)
+	if err != nil {
+		return "", err
+	}
+	zero := make([]byte, block.BlockSize())
+	var out []byte
+	switch strings.ToLower(method) {
+	case "", "encrypt", "legacy":
+		out = make([]byte, block.BlockSize())
+		block.Encrypt(out, zero)
+		if length == 0 {
+			length = 3
+		}
+	case "cmac":
+		if out, err = computeCMAC(block, zero); err != nil {
+			return "", err
+		}
+		if length == 0 {
+			length = 5
+		}
+	default:
+		return "", fmt.Errorf("unsupported KCV method: %s", method)
+	}
+	if length < 1 || length > len(out) {
+		return "", fmt.Errorf("KCV length must be between 1 and %d bytes", len(out))
+	}
+	return upperHex(out[:length]), nil
+}
+
+// ComputeKCV computes the key check value of a clear key.
+//
+// req: The KCVRequest with the algorithm, key and method.
+// Returns an OperationResult with the KCV.
+func (c *CryptoService) ComputeKCV(req KCVRequest) (OperationResult, error) {
+	key, err := decodePaymentKey(req.Key, req.KeyFormat)
+	if err != nil {
+		return OperationResult{}, fmt.Errorf("invalid key: %w", err)
+	}
+	kcv, err := keyCheckValue(req.Algorithm, key, req.Method, req.Length)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	method := strings.ToLower(req.Method)
+	if method == "" {
+		method = "encrypt"
+	}
+	return OperationResult{Output: kcv, Details: map[string]string{"method": method}}, nil
+}
+
+func isDESFamily(algo string) bool {
+	spec, ok := lookupBlockCipher(algo)
+	return ok && (spec.name == "DES" || spec.name == "3DES")
+}
+
+func hasOddParity(key []byte) bool {
+	for _, b := range key {
+		if bits.OnesCount8(b)%2 == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func setOddParity(key []byte) {
+	for i, b := range key {
+		if bits.OnesCount8(b)%2 == 0 {
+			key[i] = b ^ 1
+		}
+	}
+}
+
+// CombineKeyComponents XORs clear key components into a key, checking each
+// component against its expected KCV.
+//
+// req: The KeyComponentRequest with two or more equal-length components.
+// Returns the combined key and its KCV, plus a check per component.
+func (c *CryptoService) CombineKeyComponents(req KeyComponentRequest) (KeyComponentResult, error) {
+	if len(req.Components) < 2 {
+		return KeyComponentResult{}, errors.New("need at least two components")
+	}
+	if len(req.KCVs) > len(req.Components) {
+		return KeyComponentResult{}, errors.New("more KCVs than components")
+	}
+	desFamily := isDESFamily(req.Algorithm)
+	var combined []byte
+	result := KeyComponentResult{Verified: true}
+	for i, text := range req.Components {
+		component, err := decodePaymentKey(text, req.Format)
+		if err != nil {
+			return KeyComponentResult{}, fmt.Errorf("component %d: %w", i+1, err)
+		}
+		if combined == nil {
+			combined = make([]byte, len(component))
+		} else if len(component) != len(combined) {
+			return KeyComponentResult{}, fmt.Errorf("component %d is %d bytes, expected %d", i+1, len(component), len(combined))
+		}
+		kcv, err := keyCheckValue(req.Algorithm, component, "", 0)
+		if err != nil {
+			return KeyComponentResult{}, fmt.Errorf("component %d: %w", i+1, err)
+		}
+		check := KeyComponentCheck{Index: i + 1, KCV: kcv, Matches: true, OddParity: desFamily && hasOddParity(component)}
+		if i < len(req.KCVs) && strings.TrimSpace(req.KCVs[i]) != "" {
+			check.Expected = strings.ToUpper(strings.TrimSpace(req.KCVs[i]))
+			check.Matches = strings.HasPrefix(kcv, check.Expected) && len(check.Expected) >= 4
+			result.Verified = result.Verified && check.Matches
+		}
+		result.Components = append(result.Components, check)
+		xorBytes(combined, combined, component)
+	}
+	if !result.Verified {
+		return result, nil
+	}
+	if desFamily && req.AdjustParity {
+		setOddParity(combined)
+	}
+	kcv, err := keyCheckValue(req.Algorithm, combined, "", 0)
+	if err != nil {
+		return KeyComponentResult{}, err
+	}
+	result.Key, result.KCV = upperHex(combined), kcv
+	return result, nil
+}
+
+// TR-31 key blocks (ASC X9 TR 31-2018 / ANSI X9.143). The clear header is
+// bound to the key by a MAC. Versions A and C use key variants of a TDES KBPK
+// with a 4-byte CBC-MAC, version B derives TDES keys with CMAC and version D
+// derives AES keys with CMAC.
+
+const tr31HeaderLen = 16
+
+func (h TR31Header) encode() (string, error) {
+	if len(h.KeyUsage) != 2 || len(h.Algorithm) != 1 || len(h.ModeOfUse) != 1 || len(h.KeyVersion) != 2 || len(h.Exportability) != 1 {
+		return "", errors.New("key usage and key version take 2 characters, algorithm, mode of use and exportability 1")
+	}
+	var opt strings.Builder
+	for _, block := range h.OptionalBlocks {
+		if len(block.ID) != 2 || len(block.Data)+4 > 0xFF {
+			return "", fmt.Errorf("invalid optional block %q", block.ID)
+		}
+		fmt.Fprintf(&opt, "%s%02X%s", block.ID, len(block.Data)+4, block.Data)
+	}
+	head := fmt.Sprintf("%s%04d%s%s%s%s%s%02d00", h.Version, h.Length, h.KeyUsage, h.Algorithm, h.ModeOfUse, h.KeyVersion, h.Exportability, len(h.OptionalBlocks))
+	out := head + opt.String()
+	for _, ch := range out {
+		if ch < 0x20 || ch > 0x7e {
+			return "", errors.New("key block header must be printable ASCII")
+		}
+	}
+	return out, nil
+}
+
+func parseTR31Header(block string) (TR31Header, int, error) {
+	if len(block) < tr31HeaderLen {
+		return TR31Header{}, 0, errors.New("key block is shorter than its header")
+	}
+	length, err := strconv.Atoi(block[1:5])
+	if err != nil {
+		return TR31Header{}, 0, errors.New("invalid key block length field")
+	}
+	count, err := strconv.Atoi(block[12:14])
+	if err != nil {
+		return TR31Header{}, 0, errors.New("invalid optional block count")
+	}
+	h := TR31Header{
+		Version:       block[0:1],
+		Length:        length,
+		KeyUsage:      block[5:7],
+		Algorithm:     block[7:8],
+		ModeOfUse:     block[8:9],
+		KeyVersion:    block[9:11],
+		Exportability: block[11:12],
+	}
+	pos := tr31HeaderLen
+	for i := 0; i < count; i++ {
+		if len(block) < pos+4 {
+			return TR31Header{}, 0, errors.New("truncated optional block")
+		}
+		size, err := strconv.ParseUint(block[pos+2:pos+4], 16, 8)
+		if err != nil || size < 4 || len(block) < pos+int(size) {
+			return TR31Header{}, 0, fmt.Errorf("invalid optional block %q", block[pos:pos+2])
+		}
+		h.OptionalBlocks = append(h.OptionalBlocks, TR31OptionalBlock{ID: block[pos : pos+2], Data: block[pos+4 : pos+int(size)]})
+		pos += int(size)
+	}
+	return h, pos, nil
+}
+
+// tr31Keys derives the encryption and authentication keys from the KBPK.
+func tr31Keys(version string, kbpk []byte) (enc, mac []byte, blockSize, macLen int, err error) {
+	switch version {
+	case "A", "C":
+		if len(kbpk) != 16 && len(kbpk) != 24 {
+			return nil, nil, 0, 0, errors.New("version A/C KBPK must be a 16 or 24-byte TDES key")
+		}
+		enc, mac = make([]byte, len(kbpk)), make([]byte, len(kbpk))
+		for i, b := range kbpk {
+			enc[i], mac[i] = b^0x45, b^0x4D
+		}
+		return enc, mac, 8, 4, nil
+	case "B":
+		if len(kbpk) != 16 && len(kbpk) != 24 {
+			return nil, nil, 0, 0, errors.New("version B KBPK must be a 16 or 24-byte TDES key")
+		}
+		block, err := newTripleDESCipher(kbpk)
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+		algorithm := uint16(len(kbpk)/8 - 2) // 0000 two-key, 0001 three-key
+		return tr31Derive(block, len(kbpk), 0, algorithm), tr31Derive(block, len(kbpk), 1, algorithm), 8, 8, nil
+	case "D":
+		if len(kbpk) != 16 && len(kbpk) != 24 && len(kbpk) != 32 {
+			return nil, nil, 0, 0, errors.New("version D KBPK must be a 16, 24 or 32-byte AES key")
+		}
+		block, err := aes.NewCipher(kbpk)
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+		algorithm := uint16(len(kbpk) / 8) // 0002 AES-128, 0003 AES-192, 0004 AES-256
+		return tr31Derive(block, len(kbpk), 0, algorithm), tr31Derive(block, len(kbpk), 1, algorithm), 16, 16, nil
+	default:
+		return nil, nil, 0, 0, fmt.Errorf("unsupported key block version %q", version)
+	}
+}
+
+// tr31Derive is the CMAC counter-mode derivation of versions B and D:
+// counter(1) usage(2) 00 algorithm(2) length in bits(2).
+func tr31Derive(block cipher.Block, keyLen int, usage, algorithm uint16) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint16(data[1:], usage)
+	binary.BigEndian.PutUint16(data[4:], algorithm)
+	binary.BigEndian.PutUint16(data[6:], uint16(keyLen*8))
+	var out []byte
+	for i := byte(1); len(out) < keyLen; i++ {
+		data[0] = i
+		mac, _ := computeCMAC(block, data)
+		out = append(out, mac...)
+	}
+	return out[:keyLen]
+}
+
+func tr31Cipher(version string, key []byte) (cipher.Block, error) {
+	if version == "D" {
+		return aes.NewCipher(key)
+	}
+	return newTripleDESCipher(key)
+}
+
+// tr31MAC authenticates a key block: CMAC over header || clear key data for
+// versions B and D, CBC-MAC over header || encrypted key data for A and C.
+// The header must be a whole number of cipher blocks.
+func tr31MAC(version string, macKey []byte, header string, data []byte, macLen int) ([]byte, error) {
+	block, err := tr31Cipher(version, macKey)
+	if err != nil {
+		return nil, err
+	}
+	msg := concatBytes([]byte(header), data)
+	if version == "A" || version == "C" {
+		mac := make([]byte, 8)
+		for i := 0; i < len(msg); i += 8 {
+			xorBytes(mac, mac, msg[i:i+8])
+			block.Encrypt(mac, mac)
+		}
+		return mac[:macLen], nil
+	}
+	mac, err := computeCMAC(block, msg)
+	if err != nil {
+		return nil, err
+	}
+	return mac[:macLen], nil
+}
+
+// WrapTR31 protects a clear key in a TR-31 key block.
+//
+// req: The TR31Request with the KBPK, the key and the header fields.
+// Returns the key block and the header as encoded.
+func (c *CryptoService) WrapTR31(req TR31Request) (TR31Result, error) {
+	kbpk, err := decodePaymentKey(req.KBPK, req.KBPKFormat)
+	if err != nil {
+		return TR31Result{}, fmt.Errorf("invalid KBPK: %w", err)
+	}
+	key, err := decodePaymentKey(req.Key, req.KeyFormat)
+	if err != nil || len(key) == 0 {
+		return TR31Result{}, errors.New("invalid or missing key")
+	}
+	h := req.Header
+	h.Version = strings.ToUpper(h.Version)
+	if h.Version == "" {
+		h.Version = "B"
+		if len(kbpk) == 32 {
+			h.Version = "D"
+		}
+	}
+	if h.KeyVersion == "" {
+		h.KeyVersion = "00"
+	}
+	if h.Exportability == "" {
+		h.Exportability = "E"
+	}
+	encKey, macKey, blockSize, macLen, err := tr31Keys(h.Version, kbpk)
+	if err != nil {
+		return TR31Result{}, err
+	}
+
+	// Pad the header to the cipher block size with a PB block.
+	var optLen int
+	for _, block := range h.OptionalBlocks {
+		optLen += 4 + len(block.Data)
+	}
+	if pad := (blockSize - (tr31HeaderLen+optLen)%blockSize) % blockSize; pad != 0 {
+		if pad < 4 {
+			pad += blockSize
+		}
+		h.OptionalBlocks = append(h.OptionalBlocks, TR31OptionalBlock{ID: "PB", Data: strings.Repeat("0", pad-4)})
+		optLen += pad
+	}
+
+	// Key data: key length in bits, the key, random padding.
+	clear := binary.BigEndian.AppendUint16(nil, uint16(len(key)*8))
+	clear = append(clear, key...)
+	padding := make([]byte, (blockSize-len(clear)%blockSize)%blockSize)
+	if _, err := rand.Read(padding); err != nil {
+		return TR31Result{}, err
+	}
+	clear = append(clear, padding...)
+	h.Length = tr31HeaderLen + optLen + 2*len(clear) + 2*macLen
+	header, err := h.encode()
+	if err != nil {
+		return TR31Result{}, err
+	}
+
+	block, err := tr31Cipher(h.Version, encKey)
+	if err != nil {
+		return TR31Result{}, err
+	}
+	encrypted := make([]byte, len(clear))
+	var mac []byte
+	if h.Version == "A" || h.Version == "C" {
+		cipher.NewCBCEncrypter(block, []byte(header[:8])).CryptBlocks(encrypted, clear)
+		mac, err = tr31MAC(h.Version, macKey, header, encrypted, macLen)
+	} else {
+		if mac, err = tr31MAC(h.Version, macKey, header, clear, macLen); err == nil {
+			cipher.NewCBCEncrypter(block, mac).CryptBlocks(encrypted, clear)
+		}
+	}
+	if err != nil {
+		return TR31Result{}, err
+	}
+	return TR31Result{KeyBlock: header + upperHex(encrypted) + upperHex(mac), Header: h, Verified: true}, nil
+}
+
+// UnwrapTR31 verifies a TR-31 key block and recovers the key.
+//
+// req: The TR31Request with the KBPK and the key block.
+// Returns the parsed header, the clear key and its KCV.
+func (c *CryptoService) UnwrapTR31(req TR31Request) (TR31Result, error) {
+	kbpk, err := decodePaymentKey(req.KBPK, req.KBPKFormat)
+	if err != nil {
+		return TR31Result{}, fmt.Errorf("invalid KBPK: %w", err)
+	}
+	keyBlock := strings.TrimSpace(req.KeyBlock)
+	h, headerLen, err := parseTR31Header(keyBlock)
+	if err != nil {
+		return TR31Result{}, err
+	}
+	result := TR31Result{KeyBlock: keyBlock, Header: h}
+	if h.Length != len(keyBlock) {
+		return result, fmt.Errorf("key block length field is %d but the block has %d characters", h.Length, len(keyBlock))
+	}
+	encKey, macKey, blockSize, macLen, err := tr31Keys(h.Version, kbpk)
+	if err != nil {
+		return result, err
+	}
+	if headerLen%blockSize != 0 {
+		return result, fmt.Errorf("key block header length %d is not a multiple of %d", headerLen, blockSize)
+	}
+	body := keyBlock[headerLen:]
+	if len(body) < 2*macLen {
+		return result, errors.New("key block is too short")
+	}
+	encrypted, err := hex.DecodeString(body[:len(body)-2*macLen])
+	if err != nil || len(encrypted) == 0 || len(encrypted)%blockSize != 0 {
+		return result, errors.New("invalid encrypted key data")
+	}
+	mac, err := hex.DecodeString(body[len(body)-2*macLen:])
+	if err != nil {
+		return result, errors.New("invalid key block MAC")
+	}
+	header := keyBlock[:headerLen]
+	block, err := tr31Cipher(h.Version, encKey)
+	if err != nil {
+		return result, err
+	}
+	clear := make([]byte, len(encrypted))
+	var expected []byte
+	if h.Version == "A" || h.Version == "C" {
+		if expected, err = tr31MAC(h.Version, macKey, header, encrypted, macLen); err != nil {
+			return result, err
+		}
+		cipher.NewCBCDecrypter(block, []byte(header[:8])).CryptBlocks(clear, encrypted)
+	} else {
+		cipher.NewCBCDecrypter(block, mac).CryptBlocks(clear, encrypted)
+		if expected, err = tr31MAC(h.Version, macKey, header, clear, macLen); err != nil {
+			return result, err
+		}
+	}
+	if subtle.ConstantTimeCompare(expected, mac) != 1 {
+		return result, errors.New("key block MAC verification failed")
+	}
+	keyBits := int(binary.BigEndian.Uint16(clear))
+	if keyBits%8 != 0 || 2+keyBits/8 > len(clear) {
+		return result, errors.New("invalid key length in key block")
+	}
+	key := clear[2 : 2+keyBits/8]
+	result.Key, result.Verified = upperHex(key), true
+	if algo := tr31KCVAlgorithm(h.Algorithm); algo != "" {
+		result.KCV, _ = keyCheckValue(algo, key, "", 0)
+	}
+	return result, nil
+}
+
+func tr31KCVAlgorithm(code string) string {
+	switch code {
+	case "A":
+		return "aes"
+	case "D":
+		return "des"
+	case "T":
+		return "3des"
+	default:
+		return ""
+	}
+}
+
+// DUKPT (ANSI X9.24). The TDES variant (X9.24-1) derives an IPEK from the
+// BDK and walks the 21-bit transaction counter with the non-reversible key
+// generation process; the AES variant (X9.24-3) walks a 32-bit counter with
+// AES-ECB derivation data.
+
+var (
+	dukptBDKMask  = hexConst("C0C0C0C000000000C0C0C0C000000000")
+	dukptVariants = map[string][]byte{
+		"pin":           hexConst("00000000000000FF00000000000000FF"),
+		"mac":           hexConst("000000000000FF00000000000000FF00"),
+		"mac-request":   hexConst("000000000000FF00000000000000FF00"),
+		"mac-response":  hexConst("00000000FF00000000000000FF000000"),
+		"data":          hexConst("0000000000FF00000000000000FF0000"),
+		"data-request":  hexConst("0000000000FF00000000000000FF0000"),
+		"data-response": hexConst("000000FF00000000000000FF00000000"),
+	}
+)
+
+func hexConst(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func xorNew(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	xorBytes(out, a, b)
+	return out
+}
+
+// DeriveDUKPT derives the DUKPT initial key, the transaction key for a KSN
+// and the working key for a usage.
+//
+// req: The DUKPTRequest with the BDK (or initial key), KSN and usage.
+// Returns the keys with their KCVs.
+func (c *CryptoService) DeriveDUKPT(req DUKPTRequest) (DUKPTResult, error) {
+	ksn, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(req.KSN), " ", ""))
+	if err != nil {
+		return DUKPTResult{}, fmt.Errorf("invalid KSN: %w", err)
+	}
+	keyText, fromBDK := req.BDK, true
+	if strings.TrimSpace(req.InitialKey) != "" {
+		keyText, fromBDK = req.InitialKey, false
+	}
+	key, err := decodePaymentKey(keyText, req.KeyFormat)
+	if err != nil {
+		return DUKPTResult{}, fmt.Errorf("invalid key: %w", err)
+	}
+	scheme := strings.ToLower(req.Scheme)
+	if scheme == "" {
+		scheme = "tdes"
+		if len(ksn) == 12 {
+			scheme = "aes"
+		}
+	}
+	usage := strings.ToLower(req.Usage)
+	if usage == "" {
+		usage = "pin"
+	}
+	switch scheme {
+	case "tdes", "3des", "x9.24-1":
+		return deriveTDESDUKPT(key, fromBDK, ksn, usage)
+	case "aes", "x9.24-3":
+		return deriveAESDUKPT(key, fromBDK, ksn, usage, strings.ToLower(req.WorkingKeyType))
+	default:
+		return DUKPTResult{}, fmt.Errorf("unsupported DUKPT scheme: %s", req.Scheme)
+	}
+}
+
+func deriveTDESDUKPT(key []byte, fromBDK bool, ksn []byte, usage string) (DUKPTResult, error) {
+	if len(ksn) != 10 {
+		return DUKPTResult{}, errors.New("TDES DUKPT KSN must be 10 bytes")
+	}
+	if len(key) != 16 {
+		return DUKPTResult{}, errors.New("TDES DUKPT keys must be 16 bytes")
+	}
+	variant, ok := dukptVariants[usage]
+	if !ok {
+		return DUKPTResult{}, fmt.Errorf("unsupported TDES DUKPT usage: %s", usage)
+	}
+	counter := uint32(ksn[7]&0x1F)<<16 | uint32(ksn[8])<<8 | uint32(ksn[9])
+	ipek := key
+	if fromBDK {
+		base := append([]byte(nil), ksn[:8]...)
+		base[7] &= 0xE0
+		ipek = make([]byte, 16)
+		for i, k := range [][]byte{key, xorNew(key, dukptBDKMask)} {
+			block, err := newTripleDESCipher(k)
+			if err != nil {
+				return DUKPTResult{}, err
+			}
+			block.Encrypt(ipek[8*i:], base)
+		}
+	}
+
+	register := append([]byte(nil), ksn[2:]...)
+	register[5] &= 0xE0
+	register[6], register[7] = 0, 0
+	current := ipek
+	var err error
+	for bit := uint32(1 << 20); bit > 0; bit >>= 1 {
+		if counter&bit == 0 {
+			continue
+		}
+		register[5] |= byte(bit >> 16)
+		register[6] |= byte(bit >> 8)
+		register[7] |= byte(bit)
+		if current, err = dukptNonReversible(current, register); err != nil {
+			return DUKPTResult{}, err
+		}
+	}
+
+	working := xorNew(current, variant)
+	if strings.HasPrefix(usage, "data") {
+		// Data keys are the variant encrypted under itself.
+		block, err := newTripleDESCipher(working)
+		if err != nil {
+			return DUKPTResult{}, err
+		}
+		encrypted := make([]byte, 16)
+		block.Encrypt(encrypted, working[:8])
+		block.Encrypt(encrypted[8:], working[8:])
+		working = encrypted
+	}
+	ipekKCV, _ := keyCheckValue("3des", ipek, "", 0)
+	kcv, _ := keyCheckValue("3des", working, "", 0)
+	return DUKPTResult{
+		Scheme:         "tdes",
+		Counter:        counter,
+		InitialKey:     upperHex(ipek),
+		InitialKeyKCV:  ipekKCV,
+		TransactionKey: upperHex(current),
+		WorkingKey:     upperHex(working),
+		Usage:          usage,
+		KCV:            kcv,
+	}, nil
+}
+
+// dukptNonReversible is the X9.24-1 non-reversible key generation process.
+func dukptNonReversible(key, register []byte) ([]byte, error) {
+	half := func(k []byte) ([]byte, error) {
+		block, err := des.NewCipher(k[:8])
+		if err != nil {
+			return nil, err
+		}
+		out := xorNew(register, k[8:])
+		block.Encrypt(out, out)
+		xorBytes(out, out, k[8:])
+		return out, nil
+	}
+	right, err := half(key)
+	if err != nil {
+		return nil, err
+	}
+	left, err := half(xorNew(key, dukptBDKMask))
+	if err != nil {
+		return nil, err
+	}
+	return concatBytes(left, right), nil
+}
+
+// X9.24-3 key usage indicators and key type (algorithm) codes.
+var (
+	aesDUKPTUsages = map[string]uint16{
+		"kek":          0x0002,
+		"pin":          0x1000,
+		"mac-generate": 0x2000,
+		"mac-verify":   0x2001,
+		"mac":          0x2002,
+		"data-encrypt": 0x3000,
+		"data-decrypt": 0x3001,
+		"data":         0x3002,
+	}
+	aesDUKPTKeyTypes = map[string]struct {
+		code uint16
+		bits int
+	}{
+		"2tdea":  {0x0000, 128},
+		"3tdea":  {0x0001, 192},
+		"aes128": {0x0002, 128},
+		"aes192": {0x0003, 192},
+		"aes256": {0x0004, 256},
+	}
+)
+
+const (
+	aesDUKPTKeyDerivation        = 0x8000
+	aesDUKPTKeyDerivationInitial = 0x8001
+)
+
+func aesDUKPTKeyType(name string) (uint16, int, error) {
+	name = strings.NewReplacer("-", "", "_", "").Replace(name)
+	kt, ok := aesDUKPTKeyTypes[name]
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported DUKPT key type: %s", name)
+	}
+	return kt.code, kt.bits, nil
+}
+
+// aesDUKPTDerive runs the X9.24-3 derivation: one AES-ECB block of
+// derivation data per 128 bits of output, with the block counter in byte 1.
+func aesDUKPTDerive(key []byte, usage, keyType uint16, keyBits int, tail []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 16)
+	data[0] = 0x01
+	binary.BigEndian.PutUint16(data[2:], usage)
+	binary.BigEndian.PutUint16(data[4:], keyType)
+	binary.BigEndian.PutUint16(data[6:], uint16(keyBits))
+	copy(data[8:], tail)
+	var out []byte
+	buf := make([]byte, 16)
+	for i := byte(1); len(out)*8 < keyBits; i++ {
+		data[1] = i
+		block.Encrypt(buf, data)
+		out = append(out, buf...)
+	}
+	return out[:keyBits/8], nil
+}
+
+func deriveAESDUKPT(key []byte, fromBDK bool, ksn []byte, usage, workingType string) (DUKPTResult, error) {
+	if len(ksn) != 12 {
+		return DUKPTResult{}, errors.New("AES DUKPT KSN must be 12 bytes (initial key ID and counter)")
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return DUKPTResult{}, errors.New("AES DUKPT keys must be 16, 24 or 32 bytes")
+	}
+	usageCode, ok := aesDUKPTUsages[usage]
+	if !ok {
+		return DUKPTResult{}, fmt.Errorf("unsupported AES DUKPT usage: %s", usage)
+	}
+	deriveType, deriveBits, _ := aesDUKPTKeyType(fmt.Sprintf("aes%d", len(key)*8))
+	if workingType == "" {
+		workingType = fmt.Sprintf("aes%d", len(key)*8)
+	}
+	workType, workBits, err := aesDUKPTKeyType(workingType)
+	if err != nil {
+		return DUKPTResult{}, err
+	}
+	initialID, counter := ksn[:8], binary.BigEndian.Uint32(ksn[8:])
+	if bits.OnesCount32(counter) > 16 {
+		return DUKPTResult{}, errors.New("transaction counter has more than 16 bits set")
+	}
+	tail := func(count uint32) []byte {
+		return binary.BigEndian.AppendUint32(append([]byte(nil), initialID[4:]...), count)
+	}
+
+	initial := key
+	if fromBDK {
+		if initial, err = aesDUKPTDerive(key, aesDUKPTKeyDerivationInitial, deriveType, deriveBits, initialID); err != nil {
+			return DUKPTResult{}, err
+		}
+	}
+	current := initial
+	var working uint32
+	for mask := uint32(1 << 31); mask > 0; mask >>= 1 {
+		if counter&mask == 0 {
+			continue
+		}
+		working |= mask
+		if current, err = aesDUKPTDerive(current, aesDUKPTKeyDerivation, deriveType, deriveBits, tail(working)); err != nil {
+			return DUKPTResult{}, err
+		}
+	}
+	workingKey, err := aesDUKPTDerive(current, usageCode, workType, workBits, tail(counter))
+	if err != nil {
+		return DUKPTResult{}, err
+	}
+	kcvAlgo, kcvMethod := "aes", "cmac"
+	if strings.HasSuffix(workingType, "tdea") {
+		kcvAlgo, kcvMethod = "3des", "encrypt"
+	}
+	initialKCV, _ := keyCheckValue("aes", initial, "cmac", 0)
+	kcv, _ := keyCheckValue(kcvAlgo, workingKey, kcvMethod, 0)
+	return DUKPTResult{
+		Scheme:         "aes",
+		Counter:        counter,
+		InitialKey:     upperHex(initial),
+		InitialKeyKCV:  initialKCV,
+		TransactionKey: upperHex(current),
+		WorkingKey:     upperHex(workingKey),
+		Usage:          usage,
+		KCV:            kcv,
+	}, nil
+}
@@ -0,0 +1,703 @@
+//go:build cgo
+
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miekg/pkcs11"
+)
+
+// pkcs11Provider holds the loaded module and one logged-in session on the
+// selected token. PKCS#11 sessions are not safe for concurrent use, so every
+// call goes through mu.
+type pkcs11Provider struct {
+	mu       sync.Mutex
+	ctx      *pkcs11.Ctx
+	module   string
+	library  string
+	slot     uint
+	token    pkcs11.TokenInfo
+	session  pkcs11.SessionHandle
+	loggedIn bool
+}
+
+// ConfigurePKCS11 loads a PKCS#11 module and opens a session on one of its tokens.
+//
+// req: The PKCS11ConfigRequest with module path, slot and PIN.
+// Returns the PKCS11Status of the selected token or an error.
+func (c *CryptoService) ConfigurePKCS11(req PKCS11ConfigRequest) (PKCS11Status, error) {
+	module := strings.TrimSpace(req.ModulePath)
+	if module == "" {
+		return PKCS11Status{}, errors.New("missing PKCS#11 module path")
+	}
+	c.ClosePKCS11()
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return PKCS11Status{}, fmt.Errorf("unable to load PKCS#11 module: %s", module)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return PKCS11Status{}, fmt.Errorf("unable to initialise PKCS#11 module: %w", err)
+	}
+	p := &pkcs11Provider{ctx: ctx, module: module}
+	if info, err := ctx.GetInfo(); err == nil {
+		p.library = strings.TrimSpace(info.ManufacturerID + " " + info.LibraryDescription)
+	}
+	if err := p.openToken(strings.TrimSpace(req.Slot), req.PIN); err != nil {
+		p.close()
+		return PKCS11Status{}, err
+	}
+	c.mu.Lock()
+	c.p11 = p
+	c.mu.Unlock()
+	return p.status(), nil
+}
+
+// GetPKCS11Status reports the configured PKCS#11 module and token.
+//
+// Returns a PKCS11Status; Configured is false when no module is loaded.
+func (c *CryptoService) GetPKCS11Status() PKCS11Status {
+	p := c.pkcs11()
+	if p == nil {
+		return PKCS11Status{}
+	}
+	return p.status()
+}
+
+// ClosePKCS11 logs out, closes the session and unloads the module.
+//
+// Returns the (now empty) PKCS11Status.
+func (c *CryptoService) ClosePKCS11() PKCS11Status {
+	c.mu.Lock()
+	p := c.p11
+	c.p11 = nil
+	c.mu.Unlock()
+	if p != nil {
+		p.close()
+	}
+	return PKCS11Status{}
+}
+
+// ListPKCS11Slots lists the slots of the configured module.
+//
+// Returns the slots with their token information or an error.
+func (c *CryptoService) ListPKCS11Slots() ([]PKCS11Slot, error) {
+	p := c.pkcs11()
+	if p == nil {
+		return nil, errPKCS11NotConfigured
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids, err := p.ctx.GetSlotList(false)
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]PKCS11Slot, 0, len(ids))
+	for _, id := range ids {
+		info, err := p.ctx.GetSlotInfo(id)
+		if err != nil {
+			return nil, err
+		}
+		slot := PKCS11Slot{
+			ID:           id,
+			Description:  strings.TrimSpace(info.SlotDescription),
+			Manufacturer: strings.TrimSpace(info.ManufacturerID),
+			TokenPresent: info.Flags&pkcs11.CKF_TOKEN_PRESENT != 0,
+		}
+		if slot.TokenPresent {
+			if token, err := p.ctx.GetTokenInfo(id); err == nil {
+				slot.TokenLabel = strings.TrimSpace(token.Label)
+				slot.TokenModel = strings.TrimSpace(token.Model)
+				slot.TokenSerial = strings.TrimSpace(token.SerialNumber)
+				slot.Initialized = token.Flags&pkcs11.CKF_TOKEN_INITIALIZED != 0
+				slot.LoginNeeded = token.Flags&pkcs11.CKF_LOGIN_REQUIRED != 0
+			}
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// ListPKCS11Objects lists keys and certificates on the configured token.
+//
+// Returns the objects, each with a PKCS#11 URI usable as a key ID.
+func (c *CryptoService) ListPKCS11Objects() ([]PKCS11Object, error) {
+	p := c.pkcs11()
+	if p == nil {
+		return nil, errPKCS11NotConfigured
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	handles, err := p.findObjects(nil)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]PKCS11Object, 0, len(handles))
+	for _, h := range handles {
+		obj, err := p.describe(h)
+		if err != nil {
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// GeneratePKCS11KeyPair generates an RSA or EC key pair on the configured token.
+//
+// req: The PKCS11KeyGenRequest describing the key pair.
+// Returns the private key object or an error.
+func (c *CryptoService) GeneratePKCS11KeyPair(req PKCS11KeyGenRequest) (PKCS11Object, error) {
+	p := c.pkcs11()
+	if p == nil {
+		return PKCS11Object{}, errPKCS11NotConfigured
+	}
+	id, err := decodeOptionalHex(req.ID, "key id")
+	if err != nil {
+		return PKCS11Object{}, err
+	}
+	if len(id) == 0 {
+		id = make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return PKCS11Object{}, err
+		}
+	}
+	label := fallbackName(req.Label, "p11")
+
+	common := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	public := append([]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true)}, common...)
+	private := append([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+	}, common...)
+
+	var mech *pkcs11.Mechanism
+	algorithm := strings.ToUpper(strings.TrimSpace(req.Algorithm))
+	switch algorithm {
+	case "RSA":
+		bits := req.KeySize
+		if bits == 0 {
+			bits = 2048
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+		private = append(private, pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true))
+	case "ECC", "EC", "ECDSA":
+		algorithm = "ECC"
+		info, err := resolveECCurve(req.Curve)
+		if err != nil {
+			return PKCS11Object{}, err
+		}
+		params, err := asn1.Marshal(info.OID)
+		if err != nil {
+			return PKCS11Object{}, err
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)
+		public = append(public, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+		private = append(private, pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true))
+	default:
+		return PKCS11Object{}, fmt.Errorf("unsupported PKCS#11 key algorithm: %s", req.Algorithm)
+	}
+
+	p.mu.Lock()
+	_, privHandle, err := p.ctx.GenerateKeyPair(p.session, []*pkcs11.Mechanism{mech}, public, private)
+	if err != nil {
+		p.mu.Unlock()
+		return PKCS11Object{}, fmt.Errorf("token key generation failed: %w", err)
+	}
+	obj, err := p.describe(privHandle)
+	p.mu.Unlock()
+	if err != nil {
+		return PKCS11Object{}, err
+	}
+
+	if req.Save {
+		stored, err := c.saveKey(StoredKey{
+			ID:        uuid.New().String(),
+			Name:      label,
+			Algorithm: algorithm,
+			KeyType:   "private",
+			Format:    "pkcs11",
+			Usage:     []string{"sign", "decrypt"},
+			PublicPEM: obj.PublicPEM,
+			Extra: map[string]string{
+				"pkcs11Uri": obj.KeyID,
+				"token":     strings.TrimSpace(p.token.Label),
+				"curve":     obj.Curve,
+			},
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return obj, err
+		}
+		obj.StoredID = stored.ID
+	}
+	return obj, nil
+}
+
+func (c *CryptoService) pkcs11() *pkcs11Provider {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.p11
+}
+
+// runPKCS11Operation signs and decrypts on the token. Verify and encrypt only
+// need the public key, so they run in software against the token's public key.
+func (c *CryptoService) runPKCS11Operation(req AsymmetricRequest, uri string) (OperationResult, error) {
+	p := c.pkcs11()
+	if p == nil {
+		return OperationResult{}, errPKCS11NotConfigured
+	}
+	ref, err := parsePKCS11URI(uri)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.matchToken(ref); err != nil {
+		return OperationResult{}, err
+	}
+
+	op := strings.ToLower(req.Operation)
+	if op == "verify" || op == "encrypt" {
+		handle, err := p.findKey(ref, pkcs11.CKO_PUBLIC_KEY)
+		if err != nil {
+			handle, err = p.findKey(ref, pkcs11.CKO_PRIVATE_KEY)
+		}
+		if err != nil {
+			return OperationResult{}, err
+		}
+		obj, err := p.describe(handle)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		if obj.PublicPEM == "" {
+			return OperationResult{}, errors.New("token does not expose the public key")
+		}
+		soft := req
+		soft.KeyID = ""
+		soft.KeyData = obj.PublicPEM
+		if obj.KeyType == "RSA" {
+			return c.runRSAOperation(soft)
+		}
+		return c.runECCOperation(soft)
+	}
+
+	handle, err := p.findKey(ref, pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	keyType, err := p.ulongAttr(handle, pkcs11.CKA_KEY_TYPE)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	payload, err := decodeBlob(req.Payload, req.PayloadFormat)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	outputFormat := normalizeOutputFormat(req.OutputFormat)
+
+	var output []byte
+	details := map[string]string{"token": strings.TrimSpace(p.token.Label)}
+	switch op {
+	case "sign":
+		output, err = p.sign(handle, keyType, req, payload)
+	case "decrypt":
+		if keyType != pkcs11.CKK_RSA {
+			return OperationResult{}, errors.New("token decryption is only supported for RSA keys")
+		}
+		output, err = p.decrypt(handle, req, payload)
+		details["text"] = string(output)
+	default:
+		return OperationResult{}, fmt.Errorf("unsupported PKCS#11 operation: %s", req.Operation)
+	}
+	if err != nil {
+		return OperationResult{}, err
+	}
+	details["base64"] = encodeBase64(output)
+	return OperationResult{Output: encodeOutputBytes(output, outputFormat), Details: details}, nil
+}
+
+func (p *pkcs11Provider) sign(handle pkcs11.ObjectHandle, keyType uint, req AsymmetricRequest, payload []byte) ([]byte, error) {
+	sigHash, err := rsaHash(req.Hash, "sha256")
+	if err != nil {
+		return nil, err
+	}
+	digest := payload
+	if !req.PayloadIsHash {
+		digest = sigHash.sum(payload)
+	}
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		padding := strings.ToLower(req.Padding)
+		var mech *pkcs11.Mechanism
+		data := digest
+		if padding != "none" && len(digest) != sigHash.size() {
+			return nil, fmt.Errorf("%s digest must be %d bytes", sigHash.name, sigHash.size())
+		}
+		switch padding {
+		case "", "pss":
+			hashMech, mgf, err := pkcs11HashMechanisms(sigHash.id, sigHash.id)
+			if err != nil {
+				return nil, err
+			}
+			params := pkcs11.NewPSSParams(hashMech, mgf, uint(sigHash.size()))
+			mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params)
+		case "none":
+			mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+			data = payload
+		default:
+			// CKM_RSA_PKCS expects the DER DigestInfo, not the bare hash.
+			prefix, ok := pkcs11DigestInfoPrefixes[sigHash.id]
+			if !ok {
+				return nil, fmt.Errorf("hash %s is not supported by PKCS#11 signing", sigHash.name)
+			}
+			mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+			data = concatBytes(prefix, digest)
+		}
+		if err := p.ctx.SignInit(p.session, []*pkcs11.Mechanism{mech}, handle); err != nil {
+			return nil, fmt.Errorf("token sign failed: %w", err)
+		}
+		return p.ctx.Sign(p.session, data)
+	case pkcs11.CKK_EC:
+		mech := pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+		if err := p.ctx.SignInit(p.session, []*pkcs11.Mechanism{mech}, handle); err != nil {
+			return nil, fmt.Errorf("token sign failed: %w", err)
+		}
+		raw, err := p.ctx.Sign(p.session, digest)
+		if err != nil {
+			return nil, err
+		}
+		// PKCS#11 returns r||s; ctools reports ECDSA signatures in DER.
+		half := len(raw) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(raw[:half]),
+			new(big.Int).SetBytes(raw[half:]),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported token key type: %#x", keyType)
+	}
+}
+
+func (p *pkcs11Provider) decrypt(handle pkcs11.ObjectHandle, req AsymmetricRequest, payload []byte) ([]byte, error) {
+	var mech *pkcs11.Mechanism
+	switch strings.ToLower(req.Padding) {
+	case "pkcs1", "data":
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+	case "none":
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_X_509, nil)
+	default:
+		oaepHash, err := rsaHash(req.OAEPHash, "sha256")
+		if err != nil {
+			return nil, err
+		}
+		mgfHash, err := rsaHash(req.MGF1Hash, oaepHash.name)
+		if err != nil {
+			return nil, err
+		}
+		hashMech, mgf, err := pkcs11HashMechanisms(oaepHash.id, mgfHash.id)
+		if err != nil {
+			return nil, err
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, pkcs11.NewOAEPParams(hashMech, mgf, pkcs11.CKZ_DATA_SPECIFIED, nil))
+	}
+	if err := p.ctx.DecryptInit(p.session, []*pkcs11.Mechanism{mech}, handle); err != nil {
+		return nil, fmt.Errorf("token decrypt failed: %w", err)
+	}
+	return p.ctx.Decrypt(p.session, payload)
+}
+
+func pkcs11HashMechanisms(h, mgf stdcrypto.Hash) (uint, uint, error) {
+	hashes := map[stdcrypto.Hash][2]uint{
+		stdcrypto.SHA1:   {pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1},
+		stdcrypto.SHA224: {pkcs11.CKM_SHA224, pkcs11.CKG_MGF1_SHA224},
+		stdcrypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+		stdcrypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+		stdcrypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+	}
+	hm, ok := hashes[h]
+	if !ok {
+		return 0, 0, fmt.Errorf("hash %s is not supported by PKCS#11", h)
+	}
+	mm, ok := hashes[mgf]
+	if !ok {
+		return 0, 0, fmt.Errorf("hash %s is not supported by PKCS#11 MGF1", mgf)
+	}
+	return hm[0], mm[1], nil
+}
+
+// pkcs11DigestInfoPrefixes are the DER DigestInfo headers that precede the
+// digest for CKM_RSA_PKCS, as in crypto/rsa.
+var pkcs11DigestInfoPrefixes = map[stdcrypto.Hash][]byte{
+	stdcrypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	stdcrypto.SHA224: {0x30, 0x2d, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x04, 0x05, 0x00, 0x04, 0x1c},
+	stdcrypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	stdcrypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	stdcrypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+func (p *pkcs11Provider) openToken(slot, pin string) error {
+	ids, err := p.ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return errors.New("no PKCS#11 token present")
+	}
+	found := false
+	for _, id := range ids {
+		token, err := p.ctx.GetTokenInfo(id)
+		if err != nil {
+			continue
+		}
+		if slot == "" || slot == strconv.FormatUint(uint64(id), 10) || strings.TrimSpace(token.Label) == slot {
+			p.slot, p.token, found = id, token, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("PKCS#11 token not found: %s", slot)
+	}
+	session, err := p.ctx.OpenSession(p.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return fmt.Errorf("unable to open PKCS#11 session: %w", err)
+	}
+	p.session = session
+	if pin != "" {
+		if err := p.ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			return fmt.Errorf("PKCS#11 login failed: %w", err)
+		}
+		p.loggedIn = true
+	}
+	return nil
+}
+
+func (p *pkcs11Provider) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx == nil {
+		return
+	}
+	if p.session != 0 {
+		if p.loggedIn {
+			_ = p.ctx.Logout(p.session)
+		}
+		_ = p.ctx.CloseSession(p.session)
+	}
+	_ = p.ctx.Finalize()
+	p.ctx.Destroy()
+	p.ctx = nil
+}
+
+func (p *pkcs11Provider) status() PKCS11Status {
+	return PKCS11Status{
+		Configured:  true,
+		ModulePath:  p.module,
+		Library:     p.library,
+		SlotID:      p.slot,
+		TokenLabel:  strings.TrimSpace(p.token.Label),
+		TokenSerial: strings.TrimSpace(p.token.SerialNumber),
+		LoggedIn:    p.loggedIn,
+	}
+}
+
+func (p *pkcs11Provider) matchToken(ref pkcs11KeyRef) error {
+	if ref.Token != "" && ref.Token != strings.TrimSpace(p.token.Label) {
+		return fmt.Errorf("key is on token %q but %q is configured", ref.Token, strings.TrimSpace(p.token.Label))
+	}
+	if ref.SlotID != "" && ref.SlotID != strconv.FormatUint(uint64(p.slot), 10) {
+		return fmt.Errorf("key is in slot %s but slot %d is configured", ref.SlotID, p.slot)
+	}
+	return nil
+}
+
+func (p *pkcs11Provider) findObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if err := p.ctx.FindObjectsInit(p.session, template); err != nil {
+		return nil, err
+	}
+	defer p.ctx.FindObjectsFinal(p.session)
+	var out []pkcs11.ObjectHandle
+	for {
+		batch, _, err := p.ctx.FindObjects(p.session, 64)
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			return out, nil
+		}
+		out = append(out, batch...)
+	}
+}
+
+func (p *pkcs11Provider) findKey(ref pkcs11KeyRef, class uint) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if len(ref.ID) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, ref.ID))
+	}
+	if ref.Label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, ref.Label))
+	}
+	handles, err := p.findObjects(template)
+	if err != nil {
+		return 0, err
+	}
+	switch len(handles) {
+	case 0:
+		return 0, errors.New("PKCS#11 key not found")
+	case 1:
+		return handles[0], nil
+	default:
+		return 0, errors.New("PKCS#11 URI matches more than one key")
+	}
+}
+
+func (p *pkcs11Provider) ulongAttr(handle pkcs11.ObjectHandle, attr uint) (uint, error) {
+	attrs, err := p.ctx.GetAttributeValue(p.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(attr, nil)})
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	raw := attrs[0].Value
+	// CK_ULONG is native-endian; every supported platform is little-endian.
+	for i := len(raw) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(raw[i])
+	}
+	return uint(v), nil
+}
+
+func (p *pkcs11Provider) bytesAttr(handle pkcs11.ObjectHandle, attr uint) []byte {
+	attrs, err := p.ctx.GetAttributeValue(p.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(attr, nil)})
+	if err != nil || len(attrs) == 0 {
+		return nil
+	}
+	return attrs[0].Value
+}
+
+var pkcs11ClassNames = map[uint]string{
+	pkcs11.CKO_PRIVATE_KEY: "private",
+	pkcs11.CKO_PUBLIC_KEY:  "public",
+	pkcs11.CKO_SECRET_KEY:  "secret-key",
+	pkcs11.CKO_CERTIFICATE: "cert",
+	pkcs11.CKO_DATA:        "data",
+}
+
+var pkcs11KeyTypeNames = map[uint]string{
+	pkcs11.CKK_RSA:            "RSA",
+	pkcs11.CKK_EC:             "EC",
+	pkcs11.CKK_AES:            "AES",
+	pkcs11.CKK_DES3:           "DES3",
+	pkcs11.CKK_GENERIC_SECRET: "GENERIC",
+}
+
+// describe reads the attributes ctools reports for an object.
+func (p *pkcs11Provider) describe(handle pkcs11.ObjectHandle) (PKCS11Object, error) {
+	class, err := p.ulongAttr(handle, pkcs11.CKA_CLASS)
+	if err != nil {
+		return PKCS11Object{}, err
+	}
+	obj := PKCS11Object{
+		Handle: uint(handle),
+		Class:  pkcs11ClassNames[class],
+		Label:  string(p.bytesAttr(handle, pkcs11.CKA_LABEL)),
+	}
+	if obj.Class == "" {
+		obj.Class = fmt.Sprintf("%#x", class)
+	}
+	id := p.bytesAttr(handle, pkcs11.CKA_ID)
+	obj.ID = strings.ToUpper(hex.EncodeToString(id))
+	obj.KeyID = pkcs11KeyRef{Token: strings.TrimSpace(p.token.Label), Label: obj.Label, ID: id, Type: obj.Class}.String()
+	if class != pkcs11.CKO_PRIVATE_KEY && class != pkcs11.CKO_PUBLIC_KEY && class != pkcs11.CKO_SECRET_KEY {
+		return obj, nil
+	}
+	keyType, err := p.ulongAttr(handle, pkcs11.CKA_KEY_TYPE)
+	if err != nil {
+		return obj, nil
+	}
+	obj.KeyType = pkcs11KeyTypeNames[keyType]
+	if obj.KeyType == "" {
+		obj.KeyType = fmt.Sprintf("%#x", keyType)
+	}
+
+	var pub any
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		modulus := p.bytesAttr(handle, pkcs11.CKA_MODULUS)
+		exponent := p.bytesAttr(handle, pkcs11.CKA_PUBLIC_EXPONENT)
+		if len(modulus) > 0 && len(exponent) > 0 {
+			key := &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}
+			obj.Bits = key.N.BitLen()
+			pub = key
+		}
+	case pkcs11.CKK_EC:
+		pubHandle := handle
+		if class == pkcs11.CKO_PRIVATE_KEY && len(id) > 0 {
+			// EC private keys do not carry CKA_EC_POINT; use the matching public object.
+			if h, err := p.findKey(pkcs11KeyRef{ID: id}, pkcs11.CKO_PUBLIC_KEY); err == nil {
+				pubHandle = h
+			}
+		}
+		if key, info, ok := p.ecPublicKey(pubHandle); ok {
+			obj.Curve = info.Display
+			obj.Bits = key.Curve.Params().BitSize
+			pub = key
+		} else if info, ok := p.ecCurve(handle); ok {
+			obj.Curve = info.Display
+		}
+	}
+	if pub != nil {
+		if pemText, err := marshalPublicKeyPEM(pub); err == nil {
+			obj.PublicPEM = pemText
+		}
+	}
+	return obj, nil
+}
+
+func (p *pkcs11Provider) ecCurve(handle pkcs11.ObjectHandle) (eccCurveInfo, bool) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(p.bytesAttr(handle, pkcs11.CKA_EC_PARAMS), &oid); err != nil {
+		return eccCurveInfo{}, false
+	}
+	return describeCurveByOID(oid)
+}
+
+func (p *pkcs11Provider) ecPublicKey(handle pkcs11.ObjectHandle) (*ecdsa.PublicKey, eccCurveInfo, bool) {
+	info, ok := p.ecCurve(handle)
+	if !ok {
+		return nil, eccCurveInfo{}, false
+	}
+	point := p.bytesAttr(handle, pkcs11.CKA_EC_POINT)
+	// CKA_EC_POINT is a DER OCTET STRING, although some tokens return the raw point.
+	var inner []byte
+	if rest, err := asn1.Unmarshal(point, &inner); err == nil && len(rest) == 0 {
+		point = inner
+	}
+	x, y, err := decodeECPoint(info.Curve, point)
+	if err != nil {
+		return nil, eccCurveInfo{}, false
+	}
+	return &ecdsa.PublicKey{Curve: info.Curve, X: x, Y: y}, info, true
+}
@@ -0,0 +1,59 @@
+//go:build !cgo
+
+package crypto
+
+import "errors"
+
+// PKCS#11 modules are shared libraries loaded through cgo; builds without cgo
+// keep the API but report that tokens are unavailable.
+var errPKCS11Unsupported = errors.New("PKCS#11 support requires a cgo build")
+
+type pkcs11Provider struct{}
+
+// ConfigurePKCS11 loads a PKCS#11 module and opens a session on one of its tokens.
+//
+// req: The PKCS11ConfigRequest with module path, slot and PIN.
+// Returns an error because this build has no cgo.
+func (c *CryptoService) ConfigurePKCS11(req PKCS11ConfigRequest) (PKCS11Status, error) {
+	return PKCS11Status{}, errPKCS11Unsupported
+}
+
+// GetPKCS11Status reports the configured PKCS#11 module and token.
+//
+// Returns an empty PKCS11Status.
+func (c *CryptoService) GetPKCS11Status() PKCS11Status {
+	return PKCS11Status{}
+}
+
+// ClosePKCS11 logs out, closes the session and unloads the module.
+//
+// Returns an empty PKCS11Status.
+func (c *CryptoService) ClosePKCS11() PKCS11Status {
+	return PKCS11Status{}
+}
+
+// ListPKCS11Slots lists the slots of the configured module.
+//
+// Returns an error because this build has no cgo.
+func (c *CryptoService) ListPKCS11Slots() ([]PKCS11Slot, error) {
+	return nil, errPKCS11Unsupported
+}
+
+// ListPKCS11Objects lists keys and certificates on the configured token.
+//
+// Returns an error because this build has no cgo.
+func (c *CryptoService) ListPKCS11Objects() ([]PKCS11Object, error) {
+	return nil, errPKCS11Unsupported
+}
+
+// GeneratePKCS11KeyPair generates an RSA or EC key pair on the configured token.
+//
+// req: The PKCS11KeyGenRequest describing the key pair.
+// Returns an error because this build has no cgo.
+func (c *CryptoService) GeneratePKCS11KeyPair(req PKCS11KeyGenRequest) (PKCS11Object, error) {
+	return PKCS11Object{}, errPKCS11Unsupported
+}
+
+func (c *CryptoService) runPKCS11Operation(req AsymmetricRequest, uri string) (OperationResult, error) {
+	return OperationResult{}, errPKCS11Unsupported
+}
@@ -0,0 +1,131 @@
+//go:build cgo
+
+package crypto
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+// TestPKCS11SoftHSM runs against SoftHSM v2. Point CTOOLS_PKCS11_MODULE at
+// libsofthsm2.so to enable it; a throwaway token directory is created per run.
+func TestPKCS11SoftHSM(t *testing.T) {
+	module := os.Getenv("CTOOLS_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("CTOOLS_PKCS11_MODULE not set")
+	}
+	t.Setenv("CTOOLS_CONFIG_DIR", t.TempDir())
+	tokens := t.TempDir()
+	conf := filepath.Join(t.TempDir(), "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+tokens+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+	initSoftHSMToken(t, module, "ctools", "1234")
+
+	svc := NewCryptoService()
+	status, err := svc.ConfigurePKCS11(PKCS11ConfigRequest{ModulePath: module, Slot: "ctools", PIN: "1234"})
+	if err != nil || !status.LoggedIn || status.TokenLabel != "ctools" {
+		t.Fatalf("configure failed: %v %+v", err, status)
+	}
+	defer svc.ClosePKCS11()
+	slots, err := svc.ListPKCS11Slots()
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("list slots failed: %v", err)
+	}
+
+	rsaKey, err := svc.GeneratePKCS11KeyPair(PKCS11KeyGenRequest{Algorithm: "RSA", KeySize: 2048, Label: "rsa", Save: true})
+	if err != nil || rsaKey.Bits != 2048 || rsaKey.StoredID == "" {
+		t.Fatalf("RSA generation failed: %v %+v", err, rsaKey)
+	}
+	ecKey, err := svc.GeneratePKCS11KeyPair(PKCS11KeyGenRequest{Algorithm: "ECC", Curve: "P-256", Label: "ec"})
+	if err != nil || ecKey.Curve != "NIST P-256" || ecKey.PublicPEM == "" {
+		t.Fatalf("EC generation failed: %v %+v", err, ecKey)
+	}
+	objects, err := svc.ListPKCS11Objects()
+	if err != nil || len(objects) < 4 {
+		t.Fatalf("list objects failed: %v (%d)", err, len(objects))
+	}
+
+	for _, tc := range []struct {
+		algorithm, keyID, padding string
+	}{
+		{"RSA", rsaKey.StoredID, "pss"},
+		{"RSA", rsaKey.KeyID, "pkcs1"},
+		{"ECC", ecKey.KeyID, ""},
+	} {
+		sig, err := svc.RunAsymmetric(AsymmetricRequest{Algorithm: tc.algorithm, Operation: "sign", KeyID: tc.keyID, Padding: tc.padding, Payload: "token data", PayloadFormat: "utf8", OutputFormat: "hex"})
+		if err != nil {
+			t.Fatalf("%s %s sign failed: %v", tc.algorithm, tc.padding, err)
+		}
+		// Verification uses the exported public key in software.
+		pub := rsaKey.PublicPEM
+		if tc.algorithm == "ECC" {
+			pub = ecKey.PublicPEM
+		}
+		res, err := svc.RunAsymmetric(AsymmetricRequest{Algorithm: tc.algorithm, Operation: "verify", KeyData: pub, Padding: tc.padding, Payload: "token data", PayloadFormat: "utf8", Signature: sig.Output, SignatureFmt: "hex"})
+		if err != nil || !res.Verified {
+			t.Fatalf("%s %s verify failed: %v", tc.algorithm, tc.padding, err)
+		}
+	}
+
+	for _, padding := range []string{"oaep", "pkcs1"} {
+		enc, err := svc.RunAsymmetric(AsymmetricRequest{Algorithm: "RSA", Operation: "encrypt", KeyID: rsaKey.KeyID, Padding: padding, Payload: "secret", PayloadFormat: "utf8", OutputFormat: "base64"})
+		if err != nil {
+			t.Fatalf("%s encrypt failed: %v", padding, err)
+		}
+		dec, err := svc.RunAsymmetric(AsymmetricRequest{Algorithm: "RSA", Operation: "decrypt", KeyID: rsaKey.StoredID, Padding: padding, Payload: enc.Output, PayloadFormat: "base64"})
+		if err != nil || dec.Details["text"] != "secret" {
+			t.Fatalf("%s token decrypt failed: %v %+v", padding, err, dec)
+		}
+	}
+}
+
+func initSoftHSMToken(t *testing.T, module, label, pin string) {
+	t.Helper()
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		t.Fatalf("unable to load %s", module)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Finalize()
+	slots, err := ctx.GetSlotList(false)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("no slots: %v", err)
+	}
+	if err := ctx.InitToken(slots[0], "5678", label); err != nil {
+		t.Fatalf("init token failed: %v", err)
+	}
+	// SoftHSM moves the initialised token to a new slot; look it up by label.
+	slots, err = ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimSpace(info.Label) != label {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ctx.CloseSession(session)
+		if err := ctx.Login(session, pkcs11.CKU_SO, "5678"); err != nil {
+			t.Fatal(err)
+		}
+		defer ctx.Logout(session)
+		if err := ctx.InitPIN(session, pin); err != nil {
+			t.Fatalf("init PIN failed: %v", err)
+		}
+		return
+	}
+	t.Fatalf("token %s not found after initialisation", label)
+}
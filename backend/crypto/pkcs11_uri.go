@@ -0,0 +1,119 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const pkcs11URIScheme = "pkcs11:"
+
+var errPKCS11NotConfigured = errors.New("PKCS#11 module is not configured")
+
+// pkcs11KeyRef identifies a token object using the RFC 7512 path attributes
+// ctools understands: token, slot-id, object, id and type.
+type pkcs11KeyRef struct {
+	Token  string
+	SlotID string
+	Label  string
+	ID     []byte
+	Type   string // private, public, secret-key, cert
+}
+
+func isPKCS11URI(value string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), pkcs11URIScheme)
+}
+
+// parsePKCS11URI decodes a "pkcs11:" URI. Query attributes such as pin-value are ignored.
+func parsePKCS11URI(value string) (pkcs11KeyRef, error) {
+	value = strings.TrimSpace(value)
+	if !isPKCS11URI(value) {
+		return pkcs11KeyRef{}, fmt.Errorf("not a PKCS#11 URI: %s", value)
+	}
+	path := value[len(pkcs11URIScheme):]
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	var ref pkcs11KeyRef
+	for _, attr := range strings.Split(path, ";") {
+		if attr == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(attr, "=")
+		if !ok {
+			return pkcs11KeyRef{}, fmt.Errorf("invalid PKCS#11 URI attribute: %s", attr)
+		}
+		decoded, err := url.PathUnescape(raw)
+		if err != nil {
+			return pkcs11KeyRef{}, fmt.Errorf("invalid PKCS#11 URI attribute %s: %w", name, err)
+		}
+		switch strings.ToLower(name) {
+		case "token":
+			ref.Token = decoded
+		case "slot-id":
+			if _, err := strconv.ParseUint(decoded, 10, 64); err != nil {
+				return pkcs11KeyRef{}, fmt.Errorf("invalid PKCS#11 slot-id: %s", decoded)
+			}
+			ref.SlotID = decoded
+		case "object":
+			ref.Label = decoded
+		case "id":
+			ref.ID = []byte(decoded)
+		case "type":
+			ref.Type = strings.ToLower(decoded)
+		}
+	}
+	if ref.Label == "" && len(ref.ID) == 0 {
+		return pkcs11KeyRef{}, errors.New("PKCS#11 URI needs an object label or id")
+	}
+	return ref, nil
+}
+
+// String formats the reference as a PKCS#11 URI with the id fully percent-encoded.
+func (ref pkcs11KeyRef) String() string {
+	var parts []string
+	if ref.Token != "" {
+		parts = append(parts, "token="+pkcs11Escape(ref.Token))
+	}
+	if ref.SlotID != "" {
+		parts = append(parts, "slot-id="+ref.SlotID)
+	}
+	if ref.Label != "" {
+		parts = append(parts, "object="+pkcs11Escape(ref.Label))
+	}
+	if len(ref.ID) > 0 {
+		var b strings.Builder
+		for _, c := range ref.ID {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+		parts = append(parts, "id="+b.String())
+	}
+	if ref.Type != "" {
+		parts = append(parts, "type="+ref.Type)
+	}
+	return pkcs11URIScheme + strings.Join(parts, ";")
+}
+
+func pkcs11Escape(value string) string {
+	return strings.ReplaceAll(url.PathEscape(value), ";", "%3B")
+}
+
+// pkcs11KeyURI returns the token URI for a request key ID: either a PKCS#11
+// URI itself or a stored key that references a token object.
+func (c *CryptoService) pkcs11KeyURI(id string) (string, bool) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return "", false
+	}
+	if isPKCS11URI(id) {
+		return id, true
+	}
+	key, err := c.findKey(id)
+	if err != nil || !strings.EqualFold(key.Format, "pkcs11") || key.Extra["pkcs11Uri"] == "" {
+		return "", false
+	}
+	return key.Extra["pkcs11Uri"], true
+}
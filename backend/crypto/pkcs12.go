@@ -0,0 +1,802 @@
+package crypto
+
+import (
+	"bytes"
+	stdcrypto "crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/emmansun/gmsm/cfca"
+	"github.com/emmansun/gmsm/pkcs"
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/sm3"
+	"github.com/emmansun/gmsm/smx509"
+)
+
+const defaultPKCS12Iterations = 2048
+
+var (
+	oidPKCS7Data          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7EncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidKeyBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidShroudedKeyBag     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHA3DES     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHA2DES     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 4}
+	oidPBEWithSHA128RC2   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHA40RC2    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidDigestSHA1         = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidDigestSM3          = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 401}
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe pkcs12ContentInfo
+	MacData  pkcs12MacData `asn1:"optional"`
+}
+
+type pkcs12ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo pkcs12EncryptedContentInfo
+}
+
+type pkcs12EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type pkcs12MacData struct {
+	Mac        pkcs12DigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pkcs12DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type pkcs12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// pkcs12Entry is a key or certificate bag with its bag attributes.
+type pkcs12Entry struct {
+	der          []byte
+	localKeyID   []byte
+	friendlyName string
+}
+
+type pkcs12Bundle struct {
+	keys  []pkcs12Entry
+	certs []pkcs12Entry
+}
+
+// ImportPKCS12 imports a PKCS#12/PFX bundle into the key and certificate stores.
+//
+// req: The PKCS12ImportRequest containing the bundle and its password.
+// Returns a PKCS12ImportResult with the stored keys and certificates, or an error.
+func (c *CryptoService) ImportPKCS12(req PKCS12ImportRequest) (PKCS12ImportResult, error) {
+	result := PKCS12ImportResult{Summary: map[string]string{}}
+	format := req.Format
+	if format == "" {
+		format = "base64"
+	}
+	data, err := decodeData(format, req.Data)
+	if err != nil {
+		return result, fmt.Errorf("invalid bundle encoding: %w", err)
+	}
+	bundle, err := decodePKCS12(data, req.Password)
+	if err != nil {
+		// CFCA SADK ships SM2 key pairs in its own container; try it before giving up.
+		priv, cert, cerr := cfca.ParseSM2([]byte(req.Password), data)
+		if cerr != nil {
+			return result, err
+		}
+		keyDER, merr := smx509.MarshalPKCS8PrivateKey(priv)
+		if merr != nil {
+			return result, merr
+		}
+		bundle = &pkcs12Bundle{
+			keys:  []pkcs12Entry{{der: keyDER}},
+			certs: []pkcs12Entry{{der: cert.Raw}},
+		}
+		result.Summary["container"] = "cfca-sm2"
+	} else {
+		result.Summary["container"] = "pkcs12"
+	}
+	if len(bundle.keys) == 0 && len(bundle.certs) == 0 {
+		return result, errors.New("bundle contains no keys or certificates")
+	}
+
+	certs := make([]*smx509.Certificate, len(bundle.certs))
+	for i, entry := range bundle.certs {
+		cert, err := smx509.ParseCertificate(entry.der)
+		if err != nil {
+			return result, fmt.Errorf("unable to parse bundled certificate: %w", err)
+		}
+		certs[i] = cert
+	}
+
+	// Keys are stored first so that a locked key store aborts before any
+	// certificate is written.
+	leafKeyIDs := map[int]string{}
+	for _, entry := range bundle.keys {
+		priv, err := smx509.ParsePKCS8PrivateKey(entry.der)
+		if err != nil {
+			return result, fmt.Errorf("unable to parse bundled private key: %w", err)
+		}
+		leaf := matchPKCS12Leaf(priv, entry, bundle.certs, certs)
+		name := req.Name
+		if name == "" {
+			name = entry.friendlyName
+		}
+		if name == "" && leaf >= 0 {
+			name = certs[leaf].Subject.CommonName
+		}
+		algorithm, privPEM, pubPEM, extra, err := marshalPrivateKeyPEM(priv)
+		if err != nil {
+			return result, err
+		}
+		extra["source"] = "pkcs12"
+		stored, err := c.saveKey(StoredKey{
+			ID:         uuidString(),
+			Name:       fallbackName(name, algorithm),
+			Algorithm:  algorithm,
+			KeyType:    "private",
+			Format:     "pkcs12",
+			Usage:      req.Usage,
+			PrivatePEM: privPEM,
+			PublicPEM:  pubPEM,
+			Extra:      extra,
+			CreatedAt:  time.Now(),
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Keys = append(result.Keys, &stored)
+		if leaf >= 0 {
+			leafKeyIDs[leaf] = stored.ID
+		}
+	}
+
+	for i, cert := range certs {
+		keyID, isLeaf := leafKeyIDs[i]
+		usage := "chain"
+		if isLeaf {
+			usage = "leaf"
+		}
+		name := bundle.certs[i].friendlyName
+		if name == "" {
+			name = fallbackCommonName(cert.Subject.CommonName, "PKCS#12 certificate")
+		}
+		record, err := c.appendCertificate(CertRecord{
+			ID:        uuidString(),
+			Name:      name,
+			Algorithm: certificateAlgorithm(cert),
+			Usage:     usage,
+			CertPEM:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+			KeyID:     keyID,
+			Serial:    cert.SerialNumber.String(),
+			NotBefore: cert.NotBefore.Format(time.RFC3339),
+			NotAfter:  cert.NotAfter.Format(time.RFC3339),
+			Subject:   nameToMap(cert.Subject),
+			Issuer:    nameToMap(cert.Issuer),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Certificates = append(result.Certificates, record)
+	}
+	result.Summary["keys"] = fmt.Sprintf("%d", len(result.Keys))
+	result.Summary["certificates"] = fmt.Sprintf("%d", len(result.Certificates))
+	return result, nil
+}
+
+// ExportPKCS12 packs a stored certificate, its private key and the issuing
+// chain found in the certificate store into a password-protected PKCS#12 bundle.
+//
+// req: The PKCS12ExportRequest naming the certificate and protection scheme.
+// Returns an OperationResult with the encoded bundle or an error.
+func (c *CryptoService) ExportPKCS12(req PKCS12ExportRequest) (OperationResult, error) {
+	if req.Password == "" {
+		return OperationResult{}, errors.New("password is required")
+	}
+	if c.keyStoreLocked() {
+		return OperationResult{}, errKeyStoreLocked
+	}
+	export, err := c.ExportCertificate(req.CertID)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	if export.Key == nil || export.Key.PrivatePEM == "" {
+		return OperationResult{}, errors.New("certificate has no associated private key")
+	}
+	priv, err := parseStoredPrivateKey(export.Key)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	leaf, err := smx509.ParseCertificate(decodePEMBytes(export.Cert.CertPEM))
+	if err != nil {
+		return OperationResult{}, err
+	}
+	chain := c.issuerChain(leaf)
+	chainDER := make([][]byte, len(chain))
+	for i, cert := range chain {
+		chainDER[i] = cert.Raw
+	}
+
+	scheme := strings.ToLower(strings.TrimSpace(req.Scheme))
+	if scheme == "" {
+		scheme = "pbes2-aes256-sha256"
+		if strings.EqualFold(export.Key.Algorithm, "SM2") {
+			scheme = "pbes2-sm4-sm3"
+		}
+	}
+	iterations := req.Iterations
+	if iterations <= 0 {
+		iterations = defaultPKCS12Iterations
+	}
+	der, err := encodePKCS12(priv, leaf.Raw, chainDER, req.Password, scheme, iterations, export.Cert.Name)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	format := req.OutputFormat
+	if format == "" {
+		format = "base64"
+	}
+	return OperationResult{
+		Output: encodeOutputBytes(der, format),
+		Details: map[string]string{
+			"scheme":      scheme,
+			"iterations":  fmt.Sprintf("%d", iterations),
+			"chainLength": fmt.Sprintf("%d", len(chain)),
+			"base64":      encodeBase64(der),
+		},
+	}, nil
+}
+
+// issuerChain walks the certificate store from leaf up to its self-signed root.
+func (c *CryptoService) issuerChain(leaf *smx509.Certificate) []*smx509.Certificate {
+	var pool []*smx509.Certificate
+	for _, record := range c.readCerts() {
+		if cert, err := smx509.ParseCertificate(decodePEMBytes(record.CertPEM)); err == nil {
+			pool = append(pool, cert)
+		}
+	}
+	var chain []*smx509.Certificate
+	current := leaf
+	for depth := 0; depth < 8; depth++ {
+		if bytes.Equal(current.RawIssuer, current.RawSubject) && current.CheckSignatureFrom(current) == nil {
+			break
+		}
+		var issuer *smx509.Certificate
+		for _, candidate := range pool {
+			if bytes.Equal(candidate.RawSubject, current.RawIssuer) && current.CheckSignatureFrom(candidate) == nil {
+				issuer = candidate
+				break
+			}
+		}
+		if issuer == nil || bytes.Equal(issuer.Raw, leaf.Raw) {
+			break
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return chain
+}
+
+// matchPKCS12Leaf finds the certificate belonging to a key bag, preferring the
+// localKeyId attribute and falling back to comparing public keys.
+func matchPKCS12Leaf(priv any, key pkcs12Entry, entries []pkcs12Entry, certs []*smx509.Certificate) int {
+	if len(key.localKeyID) > 0 {
+		for i, entry := range entries {
+			if bytes.Equal(entry.localKeyID, key.localKeyID) {
+				return i
+			}
+		}
+	}
+	signer, ok := priv.(interface{ Public() stdcrypto.PublicKey })
+	if !ok {
+		return -1
+	}
+	pubDER, err := smx509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return -1
+	}
+	for i, cert := range certs {
+		if bytes.Equal(cert.RawSubjectPublicKeyInfo, pubDER) {
+			return i
+		}
+	}
+	return -1
+}
+
+// certificateAlgorithm names the public key algorithm the way CertRecord does.
+func certificateAlgorithm(cert *smx509.Certificate) string {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		if pub.Curve == sm2.P256() {
+			return "SM2"
+		}
+		return "ECC"
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
+
+// decodePKCS12 verifies the MAC of a PFX and returns its decrypted bags.
+func decodePKCS12(data []byte, password string) (*pkcs12Bundle, error) {
+	var pfx pfxPDU
+	rest, err := asn1.Unmarshal(data, &pfx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#12 structure: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("unexpected data after PKCS#12 structure")
+	}
+	if pfx.Version != 3 {
+		return nil, fmt.Errorf("unsupported PKCS#12 version %d", pfx.Version)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidPKCS7Data) {
+		return nil, errors.New("only password-integrity PKCS#12 bundles are supported")
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, err
+	}
+
+	// OpenSSL encodes an empty password as a lone BMP terminator while other
+	// tools use no bytes at all, so both are tried for the MAC.
+	bmpPassword := bmpString(password)
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) > 0 {
+		candidates := [][]byte{bmpPassword}
+		if password == "" {
+			candidates = append(candidates, nil)
+		}
+		verified := false
+		for _, candidate := range candidates {
+			ok, err := verifyPKCS12MAC(&pfx.MacData, authSafe, candidate)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				bmpPassword = candidate
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return nil, errors.New("PKCS#12 MAC verification failed: wrong password")
+		}
+	}
+
+	var contents []pkcs12ContentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, err
+	}
+	bundle := &pkcs12Bundle{}
+	for _, ci := range contents {
+		var safeContents []byte
+		switch {
+		case ci.ContentType.Equal(oidPKCS7Data):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &safeContents); err != nil {
+				return nil, err
+			}
+		case ci.ContentType.Equal(oidPKCS7EncryptedData):
+			var ed pkcs12EncryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, err
+			}
+			safeContents, err = pkcs12Decrypt(ed.EncryptedContentInfo.ContentEncryptionAlgorithm, password, bmpPassword, ed.EncryptedContentInfo.EncryptedContent)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PKCS#12 content type %s", ci.ContentType)
+		}
+		var bags []pkcs12SafeBag
+		if _, err := asn1.Unmarshal(safeContents, &bags); err != nil {
+			return nil, err
+		}
+		for _, bag := range bags {
+			entry := pkcs12Entry{}
+			for _, attr := range bag.Attributes {
+				switch {
+				case attr.ID.Equal(oidFriendlyName):
+					_, _ = asn1.Unmarshal(attr.Value.Bytes, &entry.friendlyName)
+				case attr.ID.Equal(oidLocalKeyID):
+					_, _ = asn1.Unmarshal(attr.Value.Bytes, &entry.localKeyID)
+				}
+			}
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cb pkcs12CertBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					return nil, err
+				}
+				if !cb.ID.Equal(oidX509Certificate) {
+					continue
+				}
+				entry.der = cb.Data
+				bundle.certs = append(bundle.certs, entry)
+			case bag.ID.Equal(oidKeyBag):
+				entry.der = bag.Value.Bytes
+				bundle.keys = append(bundle.keys, entry)
+			case bag.ID.Equal(oidShroudedKeyBag):
+				var info encryptedPrivateKeyInfo
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &info); err != nil {
+					return nil, err
+				}
+				entry.der, err = pkcs12Decrypt(info.Algorithm, password, bmpPassword, info.EncryptedData)
+				if err != nil {
+					return nil, err
+				}
+				bundle.keys = append(bundle.keys, entry)
+			}
+		}
+	}
+	return bundle, nil
+}
+
+// encodePKCS12 builds a PFX with the certificates in an encrypted safe and the
+// key in a shrouded key bag, both protected with the given scheme.
+func encodePKCS12(priv any, leaf []byte, chain [][]byte, password, scheme string, iterations int, friendlyName string) ([]byte, error) {
+	macOID, macHash, err := pkcs12MACAlgorithm(scheme)
+	if err != nil {
+		return nil, err
+	}
+	localKeyID := sha1.Sum(leaf)
+	attrs := []pkcs12Attribute{}
+	idAttr, err := pkcs12AttributeValue(oidLocalKeyID, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+	attrs = append(attrs, idAttr)
+	if friendlyName != "" {
+		name := bmpString(friendlyName)
+		nameAttr, err := pkcs12AttributeValue(oidFriendlyName, asn1.RawValue{Tag: asn1.TagBMPString, Bytes: name[:len(name)-2]})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, nameAttr)
+	}
+
+	var certBags []pkcs12SafeBag
+	for i, der := range append([][]byte{leaf}, chain...) {
+		value, err := asn1.Marshal(pkcs12CertBag{ID: oidX509Certificate, Data: der})
+		if err != nil {
+			return nil, err
+		}
+		bag := pkcs12SafeBag{ID: oidCertBag, Value: pkcs12Explicit(value)}
+		if i == 0 {
+			bag.Attributes = attrs
+		}
+		certBags = append(certBags, bag)
+	}
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	certAlg, certCipher, err := pkcs12Encrypt(scheme, iterations, password, certContents)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := asn1.Marshal(pkcs12EncryptedData{
+		EncryptedContentInfo: pkcs12EncryptedContentInfo{
+			ContentType:                oidPKCS7Data,
+			ContentEncryptionAlgorithm: *certAlg,
+			EncryptedContent:           certCipher,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := smx509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	keyAlg, keyCipher, err := pkcs12Encrypt(scheme, iterations, password, keyDER)
+	if err != nil {
+		return nil, err
+	}
+	shrouded, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: *keyAlg, EncryptedData: keyCipher})
+	if err != nil {
+		return nil, err
+	}
+	keyContents, err := asn1.Marshal([]pkcs12SafeBag{{ID: oidShroudedKeyBag, Value: pkcs12Explicit(shrouded), Attributes: attrs}})
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := asn1.Marshal(keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]pkcs12ContentInfo{
+		{ContentType: oidPKCS7EncryptedData, Content: pkcs12Explicit(encrypted)},
+		{ContentType: oidPKCS7Data, Content: pkcs12Explicit(keyData)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	authSafeData, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(macHash, 3, bmpString(password), salt, iterations, macHash().Size())
+	mac := hmac.New(macHash, macKey)
+	mac.Write(authSafe)
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: pkcs12ContentInfo{ContentType: oidPKCS7Data, Content: pkcs12Explicit(authSafeData)},
+		MacData: pkcs12MacData{
+			Mac: pkcs12DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: macOID, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: iterations,
+		},
+	})
+}
+
+func verifyPKCS12MAC(macData *pkcs12MacData, content, password []byte) (bool, error) {
+	newHash, err := pkcs12DigestHash(macData.Mac.Algorithm.Algorithm)
+	if err != nil {
+		return false, err
+	}
+	key := pkcs12KDF(newHash, 3, password, macData.MacSalt, macData.Iterations, newHash().Size())
+	mac := hmac.New(newHash, key)
+	mac.Write(content)
+	return hmac.Equal(mac.Sum(nil), macData.Mac.Digest), nil
+}
+
+// pkcs12MACAlgorithm picks the MAC digest that matches an encryption scheme.
+func pkcs12MACAlgorithm(scheme string) (asn1.ObjectIdentifier, func() hash.Hash, error) {
+	switch scheme {
+	case "legacy-3des", "pbes2-aes256-sha1", "pbes2-3des-sha1":
+		return oidDigestSHA1, sha1.New, nil
+	case "pbes2-sm4-sm3", "smpbes", "sm-pbes":
+		return oidDigestSM3, sm3.New, nil
+	case "pbes2-aes256-sha512":
+		return oidDigestSHA512, sha512.New, nil
+	case "pbes2-aes256-sha256", "pbes2-aes128-sha256":
+		return oidDigestSHA256, sha256.New, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported PKCS#12 scheme: %s", scheme)
+	}
+}
+
+func pkcs12DigestHash(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidDigestSHA256):
+		return sha256.New, nil
+	case oid.Equal(oidDigestSHA384):
+		return sha512.New384, nil
+	case oid.Equal(oidDigestSHA512):
+		return sha512.New, nil
+	case oid.Equal(oidDigestSM3):
+		return sm3.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#12 MAC digest %s", oid)
+	}
+}
+
+// pkcs12Encrypt protects a bag or safe with PBES2 or the legacy PKCS#12 3DES PBE.
+func pkcs12Encrypt(scheme string, iterations int, password string, plaintext []byte) (*pkix.AlgorithmIdentifier, []byte, error) {
+	if scheme != "legacy-3des" {
+		encrypter, err := resolvePBESEncrypter(scheme, iterations)
+		if err != nil {
+			return nil, nil, err
+		}
+		return encrypter.Encrypt(rand.Reader, []byte(password), plaintext)
+	}
+	params := pkcs12PBEParams{Salt: make([]byte, 8), Iterations: iterations}
+	if _, err := rand.Read(params.Salt); err != nil {
+		return nil, nil, err
+	}
+	encoded, err := asn1.Marshal(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	alg := pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHA3DES, Parameters: asn1.RawValue{FullBytes: encoded}}
+	block, iv, err := pkcs12PBECipher(alg, bmpString(password))
+	if err != nil {
+		return nil, nil, err
+	}
+	padded := applyPadding(append([]byte(nil), plaintext...), block.BlockSize(), "pkcs7")
+	out := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+	return &alg, out, nil
+}
+
+// pkcs12Decrypt opens data protected with PBES2, SM-PBES or a PKCS#12 PBE.
+// PBES2 takes the UTF-8 password while the PKCS#12 PBEs take the BMP form.
+func pkcs12Decrypt(alg pkix.AlgorithmIdentifier, password string, bmpPassword, data []byte) ([]byte, error) {
+	if pkcs.IsPBES2(alg) || pkcs.IsSMPBES(alg) {
+		var params pkcs.PBES2Params
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		plain, _, err := params.Decrypt([]byte(password), data)
+		return plain, err
+	}
+	block, iv, err := pkcs12PBECipher(alg, bmpPassword)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("PKCS#12 ciphertext not aligned to block size")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	plain, err := removePadding(out, block.BlockSize(), "pkcs7")
+	if err != nil {
+		return nil, errors.New("PKCS#12 decryption failed: wrong password")
+	}
+	return plain, nil
+}
+
+func pkcs12PBECipher(alg pkix.AlgorithmIdentifier, password []byte) (cipher.Block, []byte, error) {
+	var params pkcs12PBEParams
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+		return nil, nil, err
+	}
+	derive := func(id byte, size int) []byte {
+		return pkcs12KDF(sha1.New, id, password, params.Salt, params.Iterations, size)
+	}
+	var block cipher.Block
+	var err error
+	switch {
+	case alg.Algorithm.Equal(oidPBEWithSHA3DES):
+		block, err = des.NewTripleDESCipher(derive(1, 24))
+	case alg.Algorithm.Equal(oidPBEWithSHA2DES):
+		key := derive(1, 16)
+		block, err = des.NewTripleDESCipher(append(key, key[:8]...))
+	case alg.Algorithm.Equal(oidPBEWithSHA128RC2):
+		block = newRC2Cipher(derive(1, 16), 128)
+	case alg.Algorithm.Equal(oidPBEWithSHA40RC2):
+		block = newRC2Cipher(derive(1, 5), 40)
+	default:
+		return nil, nil, fmt.Errorf("unsupported PKCS#12 encryption algorithm %s", alg.Algorithm)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, derive(2, 8), nil
+}
+
+// pkcs12KDF implements the key derivation of RFC 7292 appendix B.2.
+func pkcs12KDF(newHash func() hash.Hash, id byte, password, salt []byte, iterations, size int) []byte {
+	h := newHash()
+	u, v := h.Size(), h.BlockSize()
+	fill := func(src []byte) []byte {
+		if len(src) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(src)+v-1)/v))
+		for i := range out {
+			out[i] = src[i%len(src)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	input := append(fill(salt), fill(password)...)
+	if iterations < 1 {
+		iterations = 1
+	}
+	var out []byte
+	for len(out) < size {
+		h.Reset()
+		h.Write(d)
+		h.Write(input)
+		a := h.Sum(nil)
+		for j := 1; j < iterations; j++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+		if len(out) >= size {
+			break
+		}
+		b := make([]byte, v)
+		for i := range b {
+			b[i] = a[i%u]
+		}
+		for start := 0; start < len(input); start += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[start+k]) + int(b[k]) + carry
+				input[start+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+func pkcs12AttributeValue(id asn1.ObjectIdentifier, value any) (pkcs12Attribute, error) {
+	encoded, err := asn1.Marshal(value)
+	if err != nil {
+		return pkcs12Attribute{}, err
+	}
+	return pkcs12Attribute{
+		ID:    id,
+		Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: encoded},
+	}, nil
+}
+
+func pkcs12Explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// bmpString encodes a password as a NUL-terminated big-endian UTF-16 string.
+func bmpString(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, 0, 2*len(units)+2)
+	for _, unit := range units {
+		out = append(out, byte(unit>>8), byte(unit))
+	}
+	return append(out, 0, 0)
+}
@@ -0,0 +1,455 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/sm3"
+	"github.com/emmansun/gmsm/smx509"
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// GM/T 0018 sizes the ECC structures for 512-bit curves; SM2 values are
+// right-aligned in the 64-byte fields with the leading 32 bytes zero.
+const (
+	sdfECCMaxBits = 512
+	sdfECCMaxLen  = sdfECCMaxBits / 8
+	sdfSM2Bits    = 256
+	sdfSM2Len     = sdfSM2Bits / 8
+
+	sdfPublicKeyLen  = 4 + 2*sdfECCMaxLen // ULONG bits; BYTE x[64]; BYTE y[64]
+	sdfPrivateKeyLen = 4 + sdfECCMaxLen   // ULONG bits; BYTE K[64]
+	sdfSignatureLen  = 2 * sdfECCMaxLen   // BYTE r[64]; BYTE s[64]
+	sdfCipherHeader  = 2*sdfECCMaxLen + sm3.Size + 4
+)
+
+// sdfCipher is an ECCCipher: BYTE x[64]; BYTE y[64]; BYTE M[32]; ULONG L;
+// BYTE C[L]. It carries the same parts as sm2Ciphertext.
+type sdfCipher sm2Ciphertext
+
+// resolveSDFByteOrder picks the ULONG byte order. Cards are mostly driven from
+// x86 hosts, so little-endian is the default.
+func resolveSDFByteOrder(name string) (binary.ByteOrder, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "little", "le", "little-endian":
+		return binary.LittleEndian, nil
+	case "big", "be", "big-endian":
+		return binary.BigEndian, nil
+	default:
+		return nil, fmt.Errorf("unsupported byte order: %s", name)
+	}
+}
+
+// readSDFUlong reads a ULONG in either byte order, preferring the one that
+// yields a value accepted by valid.
+func readSDFUlong(b []byte, valid func(uint32) bool) (uint32, binary.ByteOrder, error) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if v := order.Uint32(b); valid(v) {
+			return v, order, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("invalid ULONG field: %X", b[:4])
+}
+
+func validSDFBits(v uint32) bool { return v > 0 && v <= sdfECCMaxBits }
+
+func putSDFField(dst []byte, v *big.Int) {
+	v.FillBytes(dst[sdfECCMaxLen-sdfSM2Len : sdfECCMaxLen])
+}
+
+// sdfField returns the SM2 value of a 64-byte field, rejecting non-zero padding.
+func sdfField(src []byte) (*big.Int, error) {
+	if !isZero(src[:sdfECCMaxLen-sdfSM2Len]) {
+		return nil, errors.New("SDF field exceeds 256 bits")
+	}
+	return new(big.Int).SetBytes(src[sdfECCMaxLen-sdfSM2Len : sdfECCMaxLen]), nil
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeSDFPublicKey(pub *ecdsa.PublicKey, order binary.ByteOrder) []byte {
+	out := make([]byte, sdfPublicKeyLen)
+	order.PutUint32(out, sdfSM2Bits)
+	putSDFField(out[4:], pub.X)
+	putSDFField(out[4+sdfECCMaxLen:], pub.Y)
+	return out
+}
+
+func decodeSDFPublicKey(data []byte) (*ecdsa.PublicKey, binary.ByteOrder, error) {
+	if len(data) != sdfPublicKeyLen {
+		return nil, nil, fmt.Errorf("ECCrefPublicKey must be %d bytes, got %d", sdfPublicKeyLen, len(data))
+	}
+	bits, order, err := readSDFUlong(data, validSDFBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bits != sdfSM2Bits {
+		return nil, nil, fmt.Errorf("unsupported ECCrefPublicKey bits: %d", bits)
+	}
+	x, err := sdfField(data[4:])
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := sdfField(data[4+sdfECCMaxLen:])
+	if err != nil {
+		return nil, nil, err
+	}
+	curve := sm2.P256()
+	if !curve.IsOnCurve(x, y) {
+		return nil, nil, errors.New("ECCrefPublicKey point is not on the SM2 curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, order, nil
+}
+
+func encodeSDFPrivateKey(priv *sm2.PrivateKey, order binary.ByteOrder) []byte {
+	out := make([]byte, sdfPrivateKeyLen)
+	order.PutUint32(out, sdfSM2Bits)
+	putSDFField(out[4:], priv.D)
+	return out
+}
+
+func decodeSDFPrivateKey(data []byte) (*sm2.PrivateKey, binary.ByteOrder, error) {
+	if len(data) != sdfPrivateKeyLen {
+		return nil, nil, fmt.Errorf("ECCrefPrivateKey must be %d bytes, got %d", sdfPrivateKeyLen, len(data))
+	}
+	bits, order, err := readSDFUlong(data, validSDFBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bits != sdfSM2Bits {
+		return nil, nil, fmt.Errorf("unsupported ECCrefPrivateKey bits: %d", bits)
+	}
+	if !isZero(data[4 : 4+sdfECCMaxLen-sdfSM2Len]) {
+		return nil, nil, errors.New("SDF field exceeds 256 bits")
+	}
+	priv, err := sm2.NewPrivateKey(data[4+sdfECCMaxLen-sdfSM2Len:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, order, nil
+}
+
+func encodeSDFSignature(r, s *big.Int) []byte {
+	out := make([]byte, sdfSignatureLen)
+	putSDFField(out, r)
+	putSDFField(out[sdfECCMaxLen:], s)
+	return out
+}
+
+func decodeSDFSignature(data []byte) (*big.Int, *big.Int, error) {
+	if len(data) != sdfSignatureLen {
+		return nil, nil, fmt.Errorf("ECCSignature must be %d bytes, got %d", sdfSignatureLen, len(data))
+	}
+	r, err := sdfField(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := sdfField(data[sdfECCMaxLen:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, s, nil
+}
+
+// encode writes the ciphertext as an ECCCipher.
+func (c sdfCipher) encode(order binary.ByteOrder) []byte {
+	out := make([]byte, sdfCipherHeader+len(c.C))
+	putSDFField(out, c.X)
+	putSDFField(out[sdfECCMaxLen:], c.Y)
+	copy(out[2*sdfECCMaxLen:], c.Hash)
+	order.PutUint32(out[2*sdfECCMaxLen+sm3.Size:], uint32(len(c.C)))
+	copy(out[sdfCipherHeader:], c.C)
+	return out
+}
+
+// decodeSDFCipher parses an ECCCipher. Bytes after C[L] are accepted when they
+// are zero, which covers vendors that declare C as a fixed-size array.
+func decodeSDFCipher(data []byte) (sdfCipher, binary.ByteOrder, error) {
+	if len(data) < sdfCipherHeader+1 {
+		return sdfCipher{}, nil, errors.New("ECCCipher too short")
+	}
+	x, err := sdfField(data)
+	if err != nil {
+		return sdfCipher{}, nil, err
+	}
+	y, err := sdfField(data[sdfECCMaxLen:])
+	if err != nil {
+		return sdfCipher{}, nil, err
+	}
+	if !sm2.P256().IsOnCurve(x, y) {
+		return sdfCipher{}, nil, errors.New("ECCCipher C1 is not on the SM2 curve")
+	}
+	lenField := data[2*sdfECCMaxLen+sm3.Size:]
+	length, order, err := readSDFUlong(lenField, func(v uint32) bool {
+		return v > 0 && int64(v) <= int64(len(data)-sdfCipherHeader)
+	})
+	if err != nil {
+		return sdfCipher{}, nil, fmt.Errorf("invalid ECCCipher length: %w", err)
+	}
+	end := sdfCipherHeader + int(length)
+	if !isZero(data[end:]) {
+		return sdfCipher{}, nil, errors.New("unexpected data after ECCCipher")
+	}
+	return sdfCipher{
+		X:    x,
+		Y:    y,
+		Hash: append([]byte(nil), data[2*sdfECCMaxLen:2*sdfECCMaxLen+sm3.Size]...),
+		C:    append([]byte(nil), data[sdfCipherHeader:end]...),
+	}, order, nil
+}
+
+// looksLikeSDFCipher reports whether data is an ECCCipher rather than a
+// GB/T 32918 ciphertext, which starts with 0x30 or a point prefix.
+func looksLikeSDFCipher(data []byte) bool {
+	return len(data) > sdfCipherHeader && isZero(data[:sdfECCMaxLen-sdfSM2Len])
+}
+
+// sdfSignatureFromDER converts an ASN.1 SM2 signature to ECCSignature.
+func sdfSignatureFromDER(sig []byte) ([]byte, error) {
+	r, s, err := parseDERSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSDFSignature(r, s), nil
+}
+
+func parseDERSignature(sig []byte) (*big.Int, *big.Int, error) {
+	var inner cryptobyte.String
+	r, s := new(big.Int), new(big.Int)
+	input := cryptobyte.String(sig)
+	if !input.ReadASN1(&inner, cbasn1.SEQUENCE) || !input.Empty() ||
+		!inner.ReadASN1Integer(r) || !inner.ReadASN1Integer(s) || !inner.Empty() {
+		return nil, nil, errors.New("invalid ASN.1 signature")
+	}
+	return r, s, nil
+}
+
+func marshalDERSignature(r, s *big.Int) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1BigInt(r)
+		b.AddASN1BigInt(s)
+	})
+	return b.Bytes()
+}
+
+// sdfSignatureToDER accepts an ECCSignature and returns the ASN.1 form; other
+// input is returned unchanged.
+func sdfSignatureToDER(sig []byte) ([]byte, bool, error) {
+	if len(sig) != sdfSignatureLen || sig[0] == 0x30 {
+		return sig, false, nil
+	}
+	r, s, err := decodeSDFSignature(sig)
+	if err != nil {
+		return nil, false, err
+	}
+	der, err := marshalDERSignature(r, s)
+	return der, err == nil, err
+}
+
+// decodeSDFInput decodes hex (preferred, since hex text is often valid base64
+// too) or base64 input.
+func decodeSDFInput(data, format string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "auto":
+		trimmed := strings.Join(strings.Fields(strings.TrimPrefix(strings.TrimSpace(data), "0x")), "")
+		if raw, err := hex.DecodeString(trimmed); err == nil {
+			return raw, nil
+		}
+		return decodeFlexible(data)
+	default:
+		return decodeData(format, data)
+	}
+}
+
+// ConvertSDF converts between GM/T 0018 SDF structures and the standard SM2
+// encodings.
+//
+// req: The SDFConvertRequest naming the structure and direction.
+// Returns an OperationResult whose Details list every representation.
+func (c *CryptoService) ConvertSDF(req SDFConvertRequest) (OperationResult, error) {
+	data, err := decodeSDFInput(req.Data, req.Format)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	order, err := resolveSDFByteOrder(req.ByteOrder)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	toSDF := true
+	switch strings.ToLower(strings.TrimSpace(req.Direction)) {
+	case "", "tosdf", "to-sdf", "encode":
+	case "fromsdf", "from-sdf", "decode":
+		toSDF = false
+	default:
+		return OperationResult{}, fmt.Errorf("unsupported direction: %s", req.Direction)
+	}
+	outputFormat := normalizeOutputFormat(req.OutputFormat)
+	details := map[string]string{}
+	hexOf := func(b []byte) string { return strings.ToUpper(hex.EncodeToString(b)) }
+	var output []byte
+
+	switch strings.ToLower(strings.TrimSpace(req.Structure)) {
+	case "publickey", "public":
+		var pub *ecdsa.PublicKey
+		if toSDF {
+			pub, err = parseSM2PublicInput(data)
+		} else {
+			pub, order, err = decodeSDFPublicKey(data)
+		}
+		if err != nil {
+			return OperationResult{}, err
+		}
+		sdf := encodeSDFPublicKey(pub, order)
+		der, err := smx509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		pemText := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		details["sdf"] = hexOf(sdf)
+		details["der"] = hexOf(der)
+		details["point"] = hexOf(append([]byte{0x04}, encodeECCPointRS(pub)...))
+		details["pem"] = pemText
+		output = der
+		if toSDF {
+			output = sdf
+		}
+	case "privatekey", "private":
+		var priv *sm2.PrivateKey
+		if toSDF {
+			priv, err = parseSM2PrivateInput(data)
+		} else {
+			priv, order, err = decodeSDFPrivateKey(data)
+		}
+		if err != nil {
+			return OperationResult{}, err
+		}
+		sdf := encodeSDFPrivateKey(priv, order)
+		d := make([]byte, sdfSM2Len)
+		priv.D.FillBytes(d)
+		pubSDF := encodeSDFPublicKey(&priv.PublicKey, order)
+		details["sdf"] = hexOf(sdf)
+		details["d"] = hexOf(d)
+		details["sdfPublic"] = hexOf(pubSDF)
+		output = d
+		if toSDF {
+			output = sdf
+		}
+	case "cipher", "ecccipher", "ciphertext":
+		var ct sm2Ciphertext
+		if toSDF {
+			ct, _, err = parseSM2Ciphertext(data, "")
+		} else {
+			var parsed sdfCipher
+			parsed, order, err = decodeSDFCipher(data)
+			ct = sm2Ciphertext(parsed)
+		}
+		if err != nil {
+			return OperationResult{}, err
+		}
+		der, err := ct.asn1()
+		if err != nil {
+			return OperationResult{}, err
+		}
+		sdf := sdfCipher(ct).encode(order)
+		details["sdf"] = hexOf(sdf)
+		details["c1c3c2"] = hexOf(ct.c1c3c2())
+		details["asn1"] = hexOf(der)
+		details["length"] = strconv.Itoa(len(ct.C))
+		output = ct.c1c3c2()
+		if toSDF {
+			output = sdf
+		}
+	case "signature", "eccsignature":
+		var r, s *big.Int
+		if toSDF {
+			if len(data) == 2*sdfSM2Len {
+				r, s = new(big.Int).SetBytes(data[:sdfSM2Len]), new(big.Int).SetBytes(data[sdfSM2Len:])
+			} else {
+				r, s, err = parseDERSignature(data)
+			}
+		} else {
+			r, s, err = decodeSDFSignature(data)
+		}
+		if err != nil {
+			return OperationResult{}, err
+		}
+		der, err := marshalDERSignature(r, s)
+		if err != nil {
+			return OperationResult{}, err
+		}
+		rs := make([]byte, 2*sdfSM2Len)
+		r.FillBytes(rs[:sdfSM2Len])
+		s.FillBytes(rs[sdfSM2Len:])
+		sdf := encodeSDFSignature(r, s)
+		details["sdf"] = hexOf(sdf)
+		details["asn1"] = hexOf(der)
+		details["rs"] = hexOf(rs)
+		output = der
+		if toSDF {
+			output = sdf
+		}
+	default:
+		return OperationResult{}, fmt.Errorf("unsupported SDF structure: %s", req.Structure)
+	}
+	if order == binary.BigEndian {
+		details["byteOrder"] = "big"
+	} else {
+		details["byteOrder"] = "little"
+	}
+	return OperationResult{Output: encodeOutputBytes(output, outputFormat), Details: details}, nil
+}
+
+// parseSM2PublicInput accepts a PEM/DER SubjectPublicKeyInfo, an uncompressed
+// point or a raw X||Y pair.
+func parseSM2PublicInput(data []byte) (*ecdsa.PublicKey, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return ensureSM2Public(keyMaterial{publicPEM: string(data)})
+	}
+	if len(data) == 2*sdfSM2Len {
+		data = append([]byte{0x04}, data...)
+	}
+	if len(data) == 1+2*sdfSM2Len && data[0] == 0x04 {
+		x, y, err := decodeECPoint(sm2.P256(), data)
+		if err != nil {
+			return nil, err
+		}
+		if !sm2.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the SM2 curve")
+		}
+		return &ecdsa.PublicKey{Curve: sm2.P256(), X: x, Y: y}, nil
+	}
+	pemText := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: data}))
+	return ensureSM2Public(keyMaterial{publicPEM: pemText})
+}
+
+// parseSM2PrivateInput accepts a PEM/DER private key or the raw 32-byte scalar.
+func parseSM2PrivateInput(data []byte) (*sm2.PrivateKey, error) {
+	if len(data) == sdfSM2Len {
+		return sm2.NewPrivateKey(data)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return parseSM2Private(string(data))
+	}
+	if priv, err := parseSM2Private(string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: data}))); err == nil {
+		return priv, nil
+	}
+	return parseSM2Private(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: data})))
+}
@@ -7,17 +7,26 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 // CryptoService exposes key management, crypto primitives, and certificate utilities to the frontend.
 type CryptoService struct {
 	ctx context.Context
+
+	mu       sync.Mutex
+	storeKey []byte // key store data key, held only while unlocked
+
+	fileMu sync.Mutex // serialises JSON store rewrites within this process
+
+	p11 *pkcs11Provider // active PKCS#11 token, nil until configured
 }
 
 // NewCryptoService initializes a new CryptoService instance.
@@ -37,29 +46,68 @@ const (
 	caStoreFile          = "crypto_ca.json"
 )
 
+// StorageBackendRequest selects where keys and certificates are stored.
+type StorageBackendRequest struct {
+	Backend string `json:"backend"` // json, bolt
+}
+
+// StorageStatus describes the active storage backend.
+type StorageStatus struct {
+	Backend string `json:"backend"` // json, bolt
+	Path    string `json:"path"`    // data directory or database file
+}
+
 // StoredKey represents a cryptographic key persisted in storage.
 type StoredKey struct {
 	ID         string            `json:"id"`
 	Name       string            `json:"name"`
 	Algorithm  string            `json:"algorithm"` // RSA, ECC, SM2, SM9
 	KeyType    string            `json:"keyType"`   // private, public
-	Format     string            `json:"format"`    // pem, generated
+	Format     string            `json:"format"`    // pem, generated, derived (HD path in Extra), pkcs11 (token reference)
 	Usage      []string          `json:"usage"`     // sign, encrypt, etc.
 	PrivatePEM string            `json:"privatePem,omitempty"`
 	PublicPEM  string            `json:"publicPem,omitempty"`
 	Extra      map[string]string `json:"extra,omitempty"` // Curve name, variant, etc.
+	Tags       []string          `json:"tags,omitempty"`
 	CreatedAt  time.Time         `json:"createdAt" ts_type:"string"`
 }
 
+// KeyUpdateRequest edits stored key metadata. Nil slices and empty fields are left unchanged.
+type KeyUpdateRequest struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Usage      []string          `json:"usage"` // replaces the usage list when set
+	Tags       []string          `json:"tags"`  // replaces the tag list when set
+	AddTags    []string          `json:"addTags"`
+	RemoveTags []string          `json:"removeTags"`
+	Extra      map[string]string `json:"extra"` // merged into Extra; empty values delete entries
+}
+
+// KeyQuery filters and sorts stored keys. Empty fields match everything.
+type KeyQuery struct {
+	Algorithm     string   `json:"algorithm"`
+	KeyType       string   `json:"keyType"`       // private, public
+	Curve         string   `json:"curve"`         // matched against Extra["curve"]
+	Name          string   `json:"name"`          // case-insensitive substring
+	Usage         []string `json:"usage"`         // all must be present
+	Tags          []string `json:"tags"`          // all must be present
+	CreatedAfter  string   `json:"createdAfter"`  // RFC 3339 or YYYY-MM-DD
+	CreatedBefore string   `json:"createdBefore"` // RFC 3339 or YYYY-MM-DD (inclusive day)
+	Fingerprint   string   `json:"fingerprint"`   // SPKI SHA-256 hex prefix or OpenSSH SHA256:...
+	SortBy        string   `json:"sortBy"`        // createdAt, name, algorithm, curve
+	Order         string   `json:"order"`         // asc, desc (default)
+}
+
 // KeyParseRequest defines the input for parsing key material.
 type KeyParseRequest struct {
-	Name      string   `json:"name"`
-	Algorithm string   `json:"algorithm"`
-	Format    string   `json:"format"` // pem, hex, base64
-	Data      string   `json:"data"`
-	Usage     []string `json:"usage"`
-	Variant   string   `json:"variant"` // master/sign/encrypt etc. (for SM9)
-	Save      bool     `json:"save"`
+	Name       string   `json:"name"`
+	Algorithm  string   `json:"algorithm"`
+	Format     string   `json:"format"` // pem, hex, base64, jwk, openssh, ppk, sdf (SM2 ECCref key)
+	Data       string   `json:"data"`
+	Usage      []string `json:"usage"`
+	Variant    string   `json:"variant"`    // master/sign/encrypt etc. (for SM9); curve for raw EdDSA keys, e.g. x25519, ed448-public
+	Passphrase string   `json:"passphrase"` // for ENCRYPTED PRIVATE KEY input
+	Save       bool     `json:"save"`
 }
 
 // KeyParseResult contains the result of a key parsing operation.
@@ -71,6 +119,30 @@ type KeyParseResult struct {
 	Summary    map[string]string `json:"summary"`
 }
 
+// KeyExportRequest defines the parameters for exporting a stored key.
+type KeyExportRequest struct {
+	ID             string `json:"id"`
+	Format         string `json:"format"`     // pem, public, pkcs8, jwk, jwks, openssh, authorized_keys, ppk
+	Passphrase     string `json:"passphrase"` // encrypts PKCS#8, OpenSSH and PPK output when set
+	Scheme         string `json:"scheme"`     // pbes2-aes256-sha256, pbes2-sm4-sm3, smpbes, ...
+	Iterations     int    `json:"iterations"`
+	IncludePrivate bool   `json:"includePrivate"` // jwk/jwks: include private members
+	Comment        string `json:"comment"`        // openssh/ppk/authorized_keys comment, defaults to the key name
+}
+
+// JWKSExportRequest selects the stored keys to publish as a JWK Set.
+type JWKSExportRequest struct {
+	IDs            []string `json:"ids"`
+	IncludePrivate bool     `json:"includePrivate"`
+}
+
+// KeyExportResult contains an exported key encoding.
+type KeyExportResult struct {
+	Format  string            `json:"format"`
+	Data    string            `json:"data"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
 // KeyGenRequest defines the parameters for generating a new key pair.
 type KeyGenRequest struct {
 	Name           string   `json:"name"`
@@ -81,13 +153,28 @@ type KeyGenRequest struct {
 	Usage          []string `json:"usage"`
 	Variant        string   `json:"variant"` // For SM9
 	Save           bool     `json:"save"`
-	UID            string   `json:"uid"` // For SM2/SM9 identity
+	UID            string   `json:"uid"`        // For SM2/SM9 identity
+	Format         string   `json:"format"`     // "" or openssh (adds OpenSSH encodings to the summary)
+	Passphrase     string   `json:"passphrase"` // encrypts the OpenSSH private key when set
+	Comment        string   `json:"comment"`    // OpenSSH key comment
+}
+
+// HDDeriveRequest derives a key from a BIP39 mnemonic or seed.
+type HDDeriveRequest struct {
+	Name       string   `json:"name"`
+	Mnemonic   string   `json:"mnemonic"`   // BIP39 English mnemonic
+	Passphrase string   `json:"passphrase"` // optional BIP39 passphrase
+	Seed       string   `json:"seed"`       // hex seed, used when Mnemonic is empty
+	Curve      string   `json:"curve"`      // secp256k1 (BIP32, default), P-256 or Ed25519 (SLIP-10)
+	Path       string   `json:"path"`       // e.g. m/44'/60'/0'/0/0; Ed25519 needs hardened indexes
+	Usage      []string `json:"usage"`
+	Save       bool     `json:"save"`
 }
 
 // AsymmetricRequest defines the parameters for asymmetric crypto operations.
 type AsymmetricRequest struct {
-	Algorithm       string `json:"algorithm"` // RSA, ECC, SM2, SM9
-	Operation       string `json:"operation"` // encrypt, decrypt, sign, verify
+	Algorithm       string `json:"algorithm"` // RSA, ECC, EdDSA, SM2, SM9
+	Operation       string `json:"operation"` // encrypt, decrypt, sign, verify, derive, exchange
 	PayloadIsHash   bool   `json:"payloadIsHash"`
 	KeyID           string `json:"keyId"`
 	PeerKeyID       string `json:"peerKeyId"`
@@ -99,19 +186,33 @@ type AsymmetricRequest struct {
 	SignatureFmt    string `json:"signatureFormat"`
 	UID             string `json:"uid"` // User ID for SM2/SM9
 	Padding         string `json:"padding"`
+	Hash            string `json:"hash"` // RSA PKCS#1 v1.5, PSS and PKCS#11 token signature hash, default SHA256
 	OAEPHash        string `json:"oaepHash"`
 	MGF1Hash        string `json:"mgf1Hash"`
 	OutputFormat    string `json:"outputFormat"`
-	KDF             string `json:"kdf"`
+	KDF             string `json:"kdf"`             // ECIES hash; derive/exchange: none, x963-<hash>, hkdf-<hash>, concat-<hash>, sm3
 	SymmetricCipher string `json:"symmetricCipher"` // For ECIES
 	MacAlgorithm    string `json:"macAlgorithm"`    // For ECIES
-	EccMode         string `json:"eccMode"`         // C1C2C3 vs C1C3C2 etc.
+	EccMode         string `json:"eccMode"`         // ECC: dhaes, ecies, sec1, iso18033-2, bouncycastle, eciespy, eth-ecies; SM2: asn1, c1c3c2, c1c3c2-raw, c1c2c3, c1c2c3-raw, sdf
+	PointFormat     string `json:"pointFormat"`     // ECIES profiles: ephemeral key uncompressed (default) or compressed
+	Variant         string `json:"variant"`         // EdDSA: pure, ctx, ph
+	Context         string `json:"context"`         // EdDSA context, hex encoded
+	PeerKeyData     string `json:"peerKeyData"`     // inline peer public key for derive
+	PeerUID         string `json:"peerUid"`         // SM2 exchange: peer user ID
+	Role            string `json:"role"`            // SM2 exchange: initiator, responder
+	EphemeralKey    string `json:"ephemeralKey"`    // SM2 exchange: own ephemeral private key, hex
+	PeerEphemeral   string `json:"peerEphemeral"`   // SM2 exchange: peer ephemeral public key, hex
+	PeerConfirm     string `json:"peerConfirm"`     // SM2 exchange: peer S_A/S_B to check, hex
+	KeyLength       int    `json:"keyLength"`       // derive/exchange output length in bytes
+	SharedInfo      string `json:"sharedInfo"`      // KDF shared/other info, hex
+	Salt            string `json:"salt"`            // HKDF salt, hex
+	SDFByteOrder    string `json:"sdfByteOrder"`    // ULONG order of SDF structures in SM2 output: little (default), big
 }
 
 // SymmetricRequest defines the parameters for symmetric crypto operations.
 type SymmetricRequest struct {
-	Algorithm        string `json:"algorithm"` // AES, SM4, DES, ChaCha20
-	Mode             string `json:"mode"`      // CBC, ECB, GCM, CTR
+	Algorithm        string `json:"algorithm"` // AES, SM4, DES, 3DES, Camellia, ARIA, Blowfish, ChaCha20, ZUC, EEA3/EIA3
+	Mode             string `json:"mode"`      // CBC, ECB, GCM, CTR, CFB/CFB8/CFB1, OFB, XTS, XTS-GB, CCM, GCM-SIV, SIV
 	Padding          string `json:"padding"`   // PKCS7, Zero, None
 	Operation        string `json:"operation"` // encrypt, decrypt
 	Key              string `json:"key"`
@@ -122,22 +223,163 @@ type SymmetricRequest struct {
 	NonceFormat      string `json:"nonceFormat"`
 	Input            string `json:"input"`
 	InputFormat      string `json:"inputFormat"`
-	Additional       string `json:"additionalData"` // AAD for GCM/Poly1305
+	Additional       string `json:"additionalData"` // AAD for GCM/CCM/SIV/Poly1305
 	AdditionalFormat string `json:"additionalDataFormat"`
+	TagLength        int    `json:"tagLength,omitempty"`  // GCM/CCM tag bytes, default 16
+	Sector           uint64 `json:"sector,omitempty"`     // XTS sector number when no tweak IV is given
+	SectorSize       int    `json:"sectorSize,omitempty"` // XTS data unit size, 0 for one unit
+	Count            uint32 `json:"count,omitempty"`      // EEA3/EIA3 COUNT
+	Bearer           uint32 `json:"bearer,omitempty"`     // EEA3/EIA3 BEARER (5 bits)
+	Direction        uint32 `json:"direction,omitempty"`  // EEA3/EIA3 DIRECTION (1 bit)
+	BitLength        int    `json:"bitLength,omitempty"`  // ZUC message length in bits, 0 for the whole input
 	OutputFormat     string `json:"outputFormat"`
 }
 
 // HashRequest defines the parameters for hashing operations.
 type HashRequest struct {
-	Algorithm    string `json:"algorithm"` // SHA256, SM3, MD5, etc.
-	Mode         string `json:"mode"`      // hash, hmac
+	Algorithm     string `json:"algorithm"` // SHA256, SHA512/256, SM3, BLAKE2b-384, SHAKE128, KMAC256, BLAKE3, CRC32, etc.
+	Mode          string `json:"mode"`      // hash, hmac
+	Input         string `json:"input"`
+	InputFormat   string `json:"inputFormat"`
+	Key           string `json:"key"` // HMAC key, or the key of KMAC and keyed BLAKE2/BLAKE3
+	KeyFormat     string `json:"keyFormat"`
+	OutputLength  int    `json:"outputLength"`  // bytes, for SHAKE, cSHAKE, KMAC, BLAKE2 and BLAKE3
+	FunctionName  string `json:"functionName"`  // cSHAKE N
+	Customization string `json:"customization"` // cSHAKE and KMAC S
+	OutputFormat  string `json:"outputFormat"`
+}
+
+// MACRequest defines the parameters for cipher-based and one-time MACs.
+type MACRequest struct {
+	Algorithm    string `json:"algorithm"` // iso9797-1 (CBC-MAC), iso9797-3 (retail MAC), cmac, gmac, poly1305, siphash, siphash-128
+	Cipher       string `json:"cipher"`    // block cipher for ISO 9797-1, CMAC and GMAC: DES, 3DES, AES, SM4, ...
+	Padding      string `json:"padding"`   // ISO 9797-1 padding method 1 (default), 2 or 3
+	Operation    string `json:"operation"` // generate (default), verify
+	Key          string `json:"key"`       // ISO 9797-1 algorithm 3 takes K || K'
+	KeyFormat    string `json:"keyFormat"`
+	IV           string `json:"iv"` // ISO 9797-1 starting value, zero by default
+	IVFormat     string `json:"ivFormat"`
+	Nonce        string `json:"nonce"` // GMAC
+	NonceFormat  string `json:"nonceFormat"`
 	Input        string `json:"input"`
 	InputFormat  string `json:"inputFormat"`
-	Key          string `json:"key"` // HMAC key
-	KeyFormat    string `json:"keyFormat"`
+	MAC          string `json:"mac"` // expected MAC for verify
+	MACFormat    string `json:"macFormat"`
+	TagLength    int    `json:"tagLength,omitempty"` // leading bytes kept; verify defaults to the length of MAC
 	OutputFormat string `json:"outputFormat"`
 }
 
+// FileHashRequest defines the parameters for hashing a file from disk.
+type FileHashRequest struct {
+	Path              string   `json:"path"`
+	Algorithms        []string `json:"algorithms"`        // computed in one pass, default SHA256
+	Expected          string   `json:"expected"`          // optional digest, compared with the digests of the same length
+	ExpectedAlgorithm string   `json:"expectedAlgorithm"` // optional, the algorithm Expected belongs to
+	OutputFormat      string   `json:"outputFormat"`
+	JobID             string   `json:"jobId"` // echoed in progress events
+}
+
+// FileHashResult contains the digests of a file.
+type FileHashResult struct {
+	Path     string            `json:"path"`
+	Size     int64             `json:"size"`
+	Digests  map[string]string `json:"digests"` // algorithm -> digest
+	Verified bool              `json:"verified"`
+	Matched  string            `json:"matched"` // algorithm whose digest equals Expected
+}
+
+// ChecksumVerifyRequest defines a checksum file to verify.
+type ChecksumVerifyRequest struct {
+	ChecksumFile string `json:"checksumFile"` // sha256sum, BSD tagged or SM3SUMS style
+	Algorithm    string `json:"algorithm"`    // optional, inferred from the file name or entries
+	BaseDir      string `json:"baseDir"`      // default: the checksum file's directory
+	JobID        string `json:"jobId"`
+}
+
+// ChecksumEntryResult reports one line of a checksum file.
+type ChecksumEntryResult struct {
+	Path      string `json:"path"`
+	Algorithm string `json:"algorithm"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual,omitempty"`
+	Status    string `json:"status"` // ok, failed, missing, error
+	Error     string `json:"error,omitempty"`
+}
+
+// ChecksumVerifyResult summarises a checksum file verification.
+type ChecksumVerifyResult struct {
+	Entries  []ChecksumEntryResult `json:"entries"`
+	Passed   int                   `json:"passed"`
+	Failed   int                   `json:"failed"`
+	Verified bool                  `json:"verified"` // every entry matched
+}
+
+// HashProgress is emitted on the "crypto:hash-progress" event while hashing files.
+type HashProgress struct {
+	JobID     string `json:"jobId"`
+	Path      string `json:"path"`
+	Processed int64  `json:"processed"` // bytes hashed so far
+	Total     int64  `json:"total"`
+	File      int    `json:"file"`  // 1-based index when verifying several files
+	Files     int    `json:"files"` // number of files in the job
+	Done      bool   `json:"done"`
+}
+
+// KDFRequest defines the parameters for key derivation functions.
+type KDFRequest struct {
+	Algorithm     string `json:"algorithm"` // pbkdf2, hkdf, hkdf-extract, hkdf-expand, scrypt, argon2id, argon2i, argon2d, sm3-kdf, x963, sp800-108
+	Hash          string `json:"hash"`      // PBKDF2/HKDF/X9.63/SP 800-108 hash, default SHA256
+	PRF           string `json:"prf"`       // SP 800-108: hmac (default), hmac-<hash>, cmac-aes, cmac-sm4, ...
+	Secret        string `json:"secret"`    // password, input keying material or PRK
+	SecretFormat  string `json:"secretFormat"`
+	Salt          string `json:"salt"`
+	SaltFormat    string `json:"saltFormat"`
+	Info          string `json:"info"` // HKDF info, X9.63/SM3 shared info, Argon2 associated data
+	InfoFormat    string `json:"infoFormat"`
+	Label         string `json:"label"` // SP 800-108 label
+	LabelFormat   string `json:"labelFormat"`
+	Context       string `json:"context"` // SP 800-108 context
+	ContextFormat string `json:"contextFormat"`
+	Key           string `json:"key"` // Argon2 secret value
+	KeyFormat     string `json:"keyFormat"`
+	Iterations    int    `json:"iterations"`  // PBKDF2 iterations, Argon2 passes
+	Memory        int    `json:"memory"`      // Argon2 memory in KiB
+	Parallelism   int    `json:"parallelism"` // Argon2 lanes, scrypt p
+	Cost          int    `json:"cost"`        // scrypt N
+	BlockSize     int    `json:"blockSize"`   // scrypt r
+	Length        int    `json:"length"`      // output bytes, default 32
+	OutputFormat  string `json:"outputFormat"`
+}
+
+// PasswordHashRequest defines the parameters for creating a password hash string.
+type PasswordHashRequest struct {
+	Scheme      string `json:"scheme"` // bcrypt, scrypt, argon2id, argon2i, argon2d, pbkdf2-sha1/sha256/sha512, md5-crypt, apr1, sha256-crypt, sha512-crypt, ssha, ssha256, ssha512
+	Password    string `json:"password"`
+	Salt        string `json:"salt"` // optional, random when empty
+	SaltFormat  string `json:"saltFormat"`
+	Cost        int    `json:"cost"`        // bcrypt cost, scrypt log2(N)
+	Iterations  int    `json:"iterations"`  // PBKDF2 iterations, Argon2 passes, SHA-crypt rounds
+	Memory      int    `json:"memory"`      // Argon2 memory in KiB
+	Parallelism int    `json:"parallelism"` // Argon2 lanes, scrypt p
+	BlockSize   int    `json:"blockSize"`   // scrypt r
+	Length      int    `json:"length"`      // derived key bytes for scrypt, Argon2 and PBKDF2
+	Format      string `json:"format"`      // scrypt: phc (default) or mcf ($7$); PBKDF2: mcf (passlib, default), phc or django
+}
+
+// PasswordVerifyRequest checks a password against an encoded hash.
+type PasswordVerifyRequest struct {
+	Password string `json:"password"`
+	Hash     string `json:"hash"`
+}
+
+// PasswordHashResult describes an encoded password hash.
+type PasswordHashResult struct {
+	Hash     string            `json:"hash"`
+	Scheme   string            `json:"scheme"`
+	Params   map[string]string `json:"params"`
+	Verified bool              `json:"verified"` // VerifyPassword: the password matches
+}
+
 // OperationResult contains the output of a cryptographic operation.
 type OperationResult struct {
 	Output   string            `json:"output,omitempty"`
@@ -206,6 +448,343 @@ type CertParseResult struct {
 	RawHex             string            `json:"rawHex"`
 }
 
+// PKCS12ImportRequest defines the input for importing a PKCS#12/PFX bundle.
+type PKCS12ImportRequest struct {
+	Name     string   `json:"name"`
+	Data     string   `json:"data"`
+	Format   string   `json:"format"` // base64 (default), hex
+	Password string   `json:"password"`
+	Usage    []string `json:"usage"`
+}
+
+// PKCS12ImportResult lists the keys and certificates stored from a bundle.
+type PKCS12ImportResult struct {
+	Keys         []*StoredKey      `json:"keys"`
+	Certificates []CertRecord      `json:"certificates"`
+	Summary      map[string]string `json:"summary"`
+}
+
+// PKCS12ExportRequest defines the parameters for exporting a certificate as PKCS#12.
+type PKCS12ExportRequest struct {
+	CertID       string `json:"certId"`
+	Password     string `json:"password"`
+	Scheme       string `json:"scheme"` // pbes2-aes256-sha256, pbes2-sm4-sm3, legacy-3des, ...
+	Iterations   int    `json:"iterations"`
+	OutputFormat string `json:"outputFormat"` // base64 (default), hex
+}
+
+// KeyStoreStatus describes the protection state of the key store.
+type KeyStoreStatus struct {
+	Encrypted bool   `json:"encrypted"`
+	Locked    bool   `json:"locked"`
+	Cipher    string `json:"cipher,omitempty"` // aes-256-gcm, sm4-gcm
+	KDF       string `json:"kdf,omitempty"`    // argon2id, scrypt
+	KeyCount  int    `json:"keyCount"`
+}
+
+// KeyStorePassphraseRequest defines the parameters for setting or changing the key store passphrase.
+type KeyStorePassphraseRequest struct {
+	CurrentPassphrase string `json:"currentPassphrase"`
+	NewPassphrase     string `json:"newPassphrase"` // empty removes the passphrase
+	Cipher            string `json:"cipher"`        // aes-256-gcm, sm4-gcm
+	KDF               string `json:"kdf"`           // argon2id, scrypt
+}
+
+// BackupExportRequest defines the parameters for exporting a toolbox backup.
+type BackupExportRequest struct {
+	Passphrase string `json:"passphrase"` // empty writes a plaintext archive
+	Cipher     string `json:"cipher"`     // aes-256-gcm, sm4-gcm
+	KDF        string `json:"kdf"`        // argon2id, scrypt
+}
+
+// BackupExportResult contains the archive and what it holds.
+type BackupExportResult struct {
+	Data      string `json:"data"` // JSON archive
+	Version   int    `json:"version"`
+	Encrypted bool   `json:"encrypted"`
+	Keys      int    `json:"keys"`
+	Certs     int    `json:"certs"`
+	CACerts   int    `json:"caCerts"`
+}
+
+// BackupImportRequest defines the parameters for restoring a toolbox backup.
+type BackupImportRequest struct {
+	Data       string `json:"data"`
+	Passphrase string `json:"passphrase"`
+	Strategy   string `json:"strategy"` // merge, skip, overwrite; empty stops on conflicts
+	DryRun     bool   `json:"dryRun"`   // only report conflicts
+}
+
+// BackupConflict describes an archive entry whose ID already exists locally.
+type BackupConflict struct {
+	Kind         string `json:"kind"` // key, cert, ca
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	ExistingName string `json:"existingName"`
+	SameMaterial bool   `json:"sameMaterial"` // same public key or certificate
+}
+
+// BackupImportResult summarises a restore.
+type BackupImportResult struct {
+	Applied     bool             `json:"applied"`
+	Version     int              `json:"version"`
+	Strategy    string           `json:"strategy"`
+	Conflicts   []BackupConflict `json:"conflicts"`
+	Added       int              `json:"added"`
+	Skipped     int              `json:"skipped"`
+	Overwritten int              `json:"overwritten"`
+	Merged      int              `json:"merged"`  // identical entries whose metadata was combined
+	Renamed     int              `json:"renamed"` // differing entries imported under a new ID
+}
+
+// SecretSplitRequest splits a stored key or a symmetric key into Shamir shares.
+type SecretSplitRequest struct {
+	KeyID        string `json:"keyId"`        // stored key to split; the whole record is shared
+	Secret       string `json:"secret"`       // symmetric key, used when KeyID is empty
+	SecretFormat string `json:"secretFormat"` // hex, base64, utf8
+	Shares       int    `json:"shares"`       // N, at most 255
+	Threshold    int    `json:"threshold"`    // K, at least 2
+	OutputFormat string `json:"outputFormat"` // hex (default), base64
+}
+
+// SecretSplitResult holds the encoded shares of one split.
+type SecretSplitResult struct {
+	Shares    []string `json:"shares"`
+	Threshold int      `json:"threshold"`
+	SetID     string   `json:"setId"` // shared by every share of this split
+	KeyID     string   `json:"keyId,omitempty"`
+}
+
+// SecretCombineRequest rebuilds a secret from Shamir shares.
+type SecretCombineRequest struct {
+	Shares       []string `json:"shares"`
+	Format       string   `json:"format"` // hex, base64; empty auto-detects
+	Name         string   `json:"name"`   // renames a recovered key
+	Save         bool     `json:"save"`   // re-import a recovered key into the store
+	OutputFormat string   `json:"outputFormat"`
+}
+
+// SecretCombineResult is the recovered secret or key.
+type SecretCombineResult struct {
+	Kind       string     `json:"kind"` // secret, key
+	Verified   bool       `json:"verified"`
+	SharesUsed int        `json:"sharesUsed"`
+	SetID      string     `json:"setId"`
+	Output     string     `json:"output,omitempty"` // symmetric key
+	Key        *StoredKey `json:"key,omitempty"`
+	Stored     bool       `json:"stored"`
+}
+
+// KCVRequest computes a key check value.
+type KCVRequest struct {
+	Algorithm string `json:"algorithm"` // DES, 3DES, AES, SM4, ...
+	Key       string `json:"key"`
+	KeyFormat string `json:"keyFormat"` // default hex
+	Method    string `json:"method"`    // encrypt (zero block, default), cmac
+	Length    int    `json:"length"`    // KCV bytes, default 3 (5 for cmac)
+}
+
+// KeyComponentRequest combines clear key components by XOR.
+type KeyComponentRequest struct {
+	Algorithm    string   `json:"algorithm"`
+	Components   []string `json:"components"`
+	Format       string   `json:"format"`       // default hex
+	KCVs         []string `json:"kcvs"`         // expected component KCVs, optional
+	AdjustParity bool     `json:"adjustParity"` // force odd parity on DES keys
+}
+
+// KeyComponentCheck reports one component of a combination.
+type KeyComponentCheck struct {
+	Index     int    `json:"index"`
+	KCV       string `json:"kcv"`
+	Expected  string `json:"expected,omitempty"`
+	Matches   bool   `json:"matches"`
+	OddParity bool   `json:"oddParity"` // DES family only
+}
+
+// KeyComponentResult holds the combined key; Key is empty when a component KCV mismatches.
+type KeyComponentResult struct {
+	Key        string              `json:"key,omitempty"`
+	KCV        string              `json:"kcv,omitempty"`
+	Verified   bool                `json:"verified"`
+	Components []KeyComponentCheck `json:"components"`
+}
+
+// TR31Header is the clear header of an ANSI X9.143 / ASC TR-31 key block.
+type TR31Header struct {
+	Version        string              `json:"version"`       // A, B, C, D
+	Length         int                 `json:"length"`        // key block length in characters
+	KeyUsage       string              `json:"keyUsage"`      // e.g. B0, D0, K0, M3, P0
+	Algorithm      string              `json:"algorithm"`     // A (AES), D (DES), T (TDES), ...
+	ModeOfUse      string              `json:"modeOfUse"`     // B, C, D, E, G, N, S, V, X, Y
+	KeyVersion     string              `json:"keyVersion"`    // two characters, default 00
+	Exportability  string              `json:"exportability"` // E, N, S
+	OptionalBlocks []TR31OptionalBlock `json:"optionalBlocks,omitempty"`
+}
+
+// TR31OptionalBlock is one optional header block.
+type TR31OptionalBlock struct {
+	ID   string `json:"id"`
+	Data string `json:"data"`
+}
+
+// TR31Request wraps a key into, or unwraps it from, a TR-31 key block.
+type TR31Request struct {
+	KBPK       string     `json:"kbpk"` // key block protection key
+	KBPKFormat string     `json:"kbpkFormat"`
+	Key        string     `json:"key"` // wrap: clear key
+	KeyFormat  string     `json:"keyFormat"`
+	Header     TR31Header `json:"header"`   // wrap: header fields
+	KeyBlock   string     `json:"keyBlock"` // unwrap: key block
+}
+
+// TR31Result holds a key block and its parsed header.
+type TR31Result struct {
+	KeyBlock string     `json:"keyBlock"`
+	Header   TR31Header `json:"header"`
+	Key      string     `json:"key,omitempty"` // unwrap: clear key
+	KCV      string     `json:"kcv,omitempty"`
+	Verified bool       `json:"verified"`
+}
+
+// DUKPTRequest derives ANSI X9.24 DUKPT keys.
+type DUKPTRequest struct {
+	Scheme         string `json:"scheme"`         // tdes (X9.24-1, 10-byte KSN), aes (X9.24-3, 12-byte KSN)
+	BDK            string `json:"bdk"`            // base derivation key
+	InitialKey     string `json:"initialKey"`     // IPEK/IK, used instead of the BDK
+	KeyFormat      string `json:"keyFormat"`      // default hex
+	KSN            string `json:"ksn"`            // hex
+	Usage          string `json:"usage"`          // pin, mac, mac-response, data, data-response; AES also mac-generate, mac-verify, data-encrypt, data-decrypt, kek
+	WorkingKeyType string `json:"workingKeyType"` // AES: aes128, aes192, aes256, 2tdea, 3tdea; default the BDK type
+}
+
+// DUKPTResult holds the derived DUKPT keys.
+type DUKPTResult struct {
+	Scheme         string `json:"scheme"`
+	Counter        uint32 `json:"counter"`
+	InitialKey     string `json:"initialKey"`
+	InitialKeyKCV  string `json:"initialKeyKcv"`
+	TransactionKey string `json:"transactionKey"` // future key / derivation key for this counter
+	WorkingKey     string `json:"workingKey"`
+	Usage          string `json:"usage"`
+	KCV            string `json:"kcv"`
+}
+
+// SDFConvertRequest converts between GM/T 0018 structures and standard SM2 encodings.
+type SDFConvertRequest struct {
+	Structure    string `json:"structure"` // publicKey, privateKey, cipher, signature
+	Direction    string `json:"direction"` // toSdf, fromSdf
+	Data         string `json:"data"`
+	Format       string `json:"format"`    // hex, base64, pem; empty auto-detects
+	ByteOrder    string `json:"byteOrder"` // ULONG order when encoding: little (default), big
+	OutputFormat string `json:"outputFormat"`
+}
+
+// SM2CipherConvertRequest re-encodes an SM2 ciphertext without a key.
+type SM2CipherConvertRequest struct {
+	Data         string `json:"data"`
+	Format       string `json:"format"`       // hex, base64; empty auto-detects
+	InputLayout  string `json:"inputLayout"`  // empty detects; c1c2c3 is needed for plain C1C2C3 input
+	TargetLayout string `json:"targetLayout"` // asn1 (default), c1c3c2, c1c3c2-raw, c1c2c3, c1c2c3-raw, sdf
+	ByteOrder    string `json:"byteOrder"`    // ULONG order for sdf output: little (default), big
+	OutputFormat string `json:"outputFormat"`
+}
+
+// SignatureInspectRequest parses and converts an ECDSA or SM2 signature.
+type SignatureInspectRequest struct {
+	Signature      string `json:"signature"`
+	Format         string `json:"format"`         // hex, base64, base64url; empty auto-detects
+	Curve          string `json:"curve"`          // any registered curve or sm2; defaults to P-256
+	Encoding       string `json:"encoding"`       // der, raw, jose; empty auto-detects
+	TargetEncoding string `json:"targetEncoding"` // der (default), raw, jose
+	NormalizeLowS  bool   `json:"normalizeLowS"`  // rewrite s as n-s when it is high (ECDSA only)
+	OutputFormat   string `json:"outputFormat"`   // hex, base64 for der/raw targets
+}
+
+// PKCS11ConfigRequest selects a PKCS#11 module and token.
+type PKCS11ConfigRequest struct {
+	ModulePath string `json:"modulePath"` // e.g. /usr/lib/softhsm/libsofthsm2.so
+	Slot       string `json:"slot"`       // slot ID or token label; empty picks the first token
+	PIN        string `json:"pin"`        // user PIN; empty opens a public session
+}
+
+// PKCS11Status describes the configured PKCS#11 token.
+type PKCS11Status struct {
+	Configured  bool   `json:"configured"`
+	ModulePath  string `json:"modulePath,omitempty"`
+	Library     string `json:"library,omitempty"`
+	SlotID      uint   `json:"slotId"`
+	TokenLabel  string `json:"tokenLabel,omitempty"`
+	TokenSerial string `json:"tokenSerial,omitempty"`
+	LoggedIn    bool   `json:"loggedIn"`
+}
+
+// PKCS11Slot describes a slot reported by the module.
+type PKCS11Slot struct {
+	ID           uint   `json:"id"`
+	Description  string `json:"description"`
+	Manufacturer string `json:"manufacturer"`
+	TokenPresent bool   `json:"tokenPresent"`
+	TokenLabel   string `json:"tokenLabel,omitempty"`
+	TokenModel   string `json:"tokenModel,omitempty"`
+	TokenSerial  string `json:"tokenSerial,omitempty"`
+	Initialized  bool   `json:"initialized"`
+	LoginNeeded  bool   `json:"loginRequired"`
+}
+
+// PKCS11Object describes an object on the configured token.
+type PKCS11Object struct {
+	Handle    uint   `json:"handle"`
+	Class     string `json:"class"`             // private, public, secret-key, cert
+	KeyType   string `json:"keyType,omitempty"` // RSA, EC, AES, ...
+	Label     string `json:"label"`
+	ID        string `json:"id"`    // CKA_ID, hex encoded
+	KeyID     string `json:"keyId"` // PKCS#11 URI usable as AsymmetricRequest.KeyID
+	Bits      int    `json:"bits,omitempty"`
+	Curve     string `json:"curve,omitempty"`
+	PublicPEM string `json:"publicPem,omitempty"`
+	StoredID  string `json:"storedId,omitempty"` // key store reference created by Save
+}
+
+// PKCS11KeyGenRequest defines a key pair generated on the token.
+type PKCS11KeyGenRequest struct {
+	Algorithm string `json:"algorithm"` // RSA, ECC
+	KeySize   int    `json:"keySize"`   // RSA modulus bits
+	Curve     string `json:"curve"`     // ECC curve
+	Label     string `json:"label"`
+	ID        string `json:"id"`   // CKA_ID in hex; random when empty
+	Save      bool   `json:"save"` // add a reference to the key store
+}
+
+// KeyAnalysisRequest selects a key to check and, optionally, what it should pair with.
+type KeyAnalysisRequest struct {
+	KeyID         string `json:"keyId"`
+	KeyData       string `json:"keyData"`       // PEM key or certificate, or a raw hex EC scalar/point
+	Algorithm     string `json:"algorithm"`     // ECC, SM2; only needed for raw hex input
+	Curve         string `json:"curve"`         // curve of raw hex input
+	Passphrase    string `json:"passphrase"`    // for ENCRYPTED PRIVATE KEY input
+	CompareKeyID  string `json:"compareKeyId"`  // stored key expected to hold the same key pair
+	CompareCertID string `json:"compareCertId"` // stored certificate expected to carry the public key
+	CompareData   string `json:"compareData"`   // PEM key or certificate expected to match
+}
+
+// KeyCheck is one finding of a key analysis.
+type KeyCheck struct {
+	Name   string `json:"name"`   // e.g. modulusSize, roca, pointOnCurve, match:<name>
+	Status string `json:"status"` // pass, warn, fail, info
+	Detail string `json:"detail"`
+}
+
+// KeyAnalysisReport is the structured result of AnalyzeKey.
+type KeyAnalysisReport struct {
+	Algorithm string     `json:"algorithm"`
+	KeyType   string     `json:"keyType"` // private, public, certificate
+	Bits      int        `json:"bits"`
+	Curve     string     `json:"curve,omitempty"`
+	Passed    bool       `json:"passed"` // no check failed
+	Checks    []KeyCheck `json:"checks"`
+}
+
 // DerParseRequest defines the input for parsing ASN.1 DER data.
 type DerParseRequest struct {
 	Name      string `json:"name"`
@@ -245,67 +824,63 @@ func (c *CryptoService) ensureDataDir() string {
 	return root
 }
 
-// keyStorePath returns the file path for the key store.
-func (c *CryptoService) keyStorePath() string {
-	return filepath.Join(c.ensureDataDir(), keyStoreFile)
-}
-
-// certStorePath returns the file path for the certificate store.
-func (c *CryptoService) certStorePath() string {
-	return filepath.Join(c.ensureDataDir(), certStoreFile)
-}
-
-// caStorePath returns the file path for the CA store.
-func (c *CryptoService) caStorePath() string {
-	return filepath.Join(c.ensureDataDir(), caStoreFile)
-}
-
-// readKeys reads the list of stored keys from the file system.
+// readKeys reads the list of stored keys from the storage backend.
+// Private material is omitted while a passphrase-protected store is locked.
 func (c *CryptoService) readKeys() []StoredKey {
-	path := c.keyStorePath()
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return []StoredKey{}
-	}
-	var keys []StoredKey
-	_ = json.Unmarshal(data, &keys)
+	keys, _ := c.readKeyStore()
 	return keys
 }
 
-// writeKeys writes the list of keys to the file system.
-func (c *CryptoService) writeKeys(keys []StoredKey) {
-	data, err := json.MarshalIndent(keys, "", "  ")
-	if err != nil {
-		log.Printf("crypto: unable to marshal key store: %v", err)
-		return
-	}
-	if err := os.WriteFile(c.keyStorePath(), data, 0600); err != nil {
+// updateKeys applies fn to the stored keys as one atomic read-modify-write.
+func (c *CryptoService) updateKeys(fn func(keys []StoredKey) ([]StoredKey, error)) error {
+	if err := c.updateKeyStore(fn); err != nil {
 		log.Printf("crypto: unable to persist key store: %v", err)
+		return err
 	}
+	return nil
 }
 
-// readCerts reads the list of stored certificates from the file system.
+// readCerts reads the list of stored certificates from the storage backend.
 func (c *CryptoService) readCerts() []CertRecord {
-	path := c.certStorePath()
-	data, err := os.ReadFile(path)
+	return c.readCertStore(storeCerts)
+}
+
+// readCACerts reads the trusted CA certificates from the storage backend.
+func (c *CryptoService) readCACerts() []CertRecord {
+	return c.readCertStore(storeCA)
+}
+
+func (c *CryptoService) readCertStore(store string) []CertRecord {
+	data, err := c.storage().Load(store)
 	if err != nil {
-		return []CertRecord{}
+		log.Printf("crypto: unable to read %s store: %v", store, err)
+	}
+	certs := []CertRecord{}
+	if len(data) > 0 {
+		_ = json.Unmarshal(data, &certs)
 	}
-	var certs []CertRecord
-	_ = json.Unmarshal(data, &certs)
 	return certs
 }
 
-// writeCerts writes the list of certificates to the file system.
-func (c *CryptoService) writeCerts(certs []CertRecord) {
-	data, err := json.MarshalIndent(certs, "", "  ")
+// updateCerts applies fn to a certificate store as one atomic read-modify-write.
+func (c *CryptoService) updateCerts(store string, fn func(certs []CertRecord) ([]CertRecord, error)) error {
+	err := c.storage().Update(store, func(current []byte) ([]byte, error) {
+		certs := []CertRecord{}
+		if len(current) > 0 {
+			if err := json.Unmarshal(current, &certs); err != nil {
+				return nil, fmt.Errorf("unable to read %s store: %w", store, err)
+			}
+		}
+		next, err := fn(certs)
+		if err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(next, "", "  ")
+	})
 	if err != nil {
-		log.Printf("crypto: unable to marshal certificate store: %v", err)
-		return
-	}
-	if err := os.WriteFile(c.certStorePath(), data, 0644); err != nil {
-		log.Printf("crypto: unable to persist certificate store: %v", err)
+		log.Printf("crypto: unable to persist %s store: %v", store, err)
 	}
+	return err
 }
 
 // decodeData decodes a payload based on the specified format.
@@ -346,8 +921,12 @@ func (c *CryptoService) findKey(id string) (*StoredKey, error) {
 	if id == "" {
 		return nil, errors.New("missing key id")
 	}
-	for _, k := range c.readKeys() {
+	keys, locked := c.readKeyStore()
+	for _, k := range keys {
 		if k.ID == id {
+			if locked && k.KeyType != "public" {
+				return nil, errKeyStoreLocked
+			}
 			return &k, nil
 		}
 	}
@@ -355,24 +934,21 @@ func (c *CryptoService) findKey(id string) (*StoredKey, error) {
 }
 
 // saveKey persists a key to storage.
-func (c *CryptoService) saveKey(key StoredKey) StoredKey {
-	keys := c.readKeys()
-	found := false
-	for i, existing := range keys {
-		if existing.ID == key.ID {
-			keys[i] = key
-			found = true
-			break
+func (c *CryptoService) saveKey(key StoredKey) (StoredKey, error) {
+	err := c.updateKeys(func(keys []StoredKey) ([]StoredKey, error) {
+		for i, existing := range keys {
+			if existing.ID == key.ID {
+				keys[i] = key
+				return keys, nil
+			}
 		}
-	}
-	if !found {
-		keys = append(keys, key)
-	}
-	c.writeKeys(keys)
-	return key
+		return append(keys, key), nil
+	})
+	return key, err
 }
 
 // ListStoredKeys returns all keys stored in the local file system.
+// Private material is omitted while the key store is locked.
 //
 // Returns a slice of StoredKey sorted by creation date (newest first).
 func (c *CryptoService) ListStoredKeys() []StoredKey {
@@ -388,15 +964,20 @@ func (c *CryptoService) ListStoredKeys() []StoredKey {
 // id: The unique identifier of the key to delete.
 // Returns the updated list of stored keys.
 func (c *CryptoService) DeleteStoredKey(id string) []StoredKey {
-	keys := c.readKeys()
-	result := make([]StoredKey, 0, len(keys))
-	for _, k := range keys {
-		if k.ID == id {
-			continue
+	var result []StoredKey
+	err := c.updateKeys(func(keys []StoredKey) ([]StoredKey, error) {
+		result = make([]StoredKey, 0, len(keys))
+		for _, k := range keys {
+			if k.ID == id {
+				continue
+			}
+			result = append(result, k)
 		}
-		result = append(result, k)
+		return result, nil
+	})
+	if err != nil {
+		return c.readKeys()
 	}
-	c.writeKeys(result)
 	return result
 }
 
@@ -0,0 +1,309 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Shamir secret sharing over GF(2^8) with the AES polynomial x^8+x^4+x^3+x+1.
+// Each byte of the secret is the constant term of its own random polynomial of
+// degree threshold-1; share i holds the polynomials evaluated at x = i.
+//
+// A share is encoded as
+//
+//	version(1) kind(1) threshold(1) x(1) set(4) payload crc32(4)
+//
+// where set ties the shares of one split together and the CRC-32 catches a
+// mistyped or damaged share before it is used. The shared data is the secret
+// followed by its truncated SHA-256, so the digest only exists after
+// recombination and a single share reveals nothing about the secret.
+const (
+	shareVersion    = 2
+	shareHeaderLen  = 8
+	shareDigestLen  = 16
+	shareKindSecret = 0 // raw symmetric key bytes
+	shareKindKey    = 1 // JSON encoded StoredKey
+)
+
+var gfExp, gfLog [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		// Multiply by the generator 3 = x+1.
+		x ^= gfMulSlow(x, 2)
+	}
+	gfExp[255] = gfExp[0]
+}
+
+func gfMulSlow(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1B
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+int(gfLog[b]))%255]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+255-int(gfLog[b]))%255]
+}
+
+// shamirSplit returns n share payloads for x = 1..n.
+func shamirSplit(secret []byte, n, k int) ([][]byte, error) {
+	coeffs := make([]byte, k-1)
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	for pos, b := range secret {
+		if _, err := rand.Read(coeffs); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			x := byte(i + 1)
+			// Horner's rule from the highest coefficient down to the secret.
+			var y byte
+			for j := len(coeffs) - 1; j >= 0; j-- {
+				y = gfMul(y, x) ^ coeffs[j]
+			}
+			shares[i][pos] = gfMul(y, x) ^ b
+		}
+	}
+	return shares, nil
+}
+
+// shamirCombine interpolates the polynomials at x = 0.
+func shamirCombine(xs []byte, payloads [][]byte) []byte {
+	secret := make([]byte, len(payloads[0]))
+	for i, xi := range xs {
+		// Lagrange basis polynomial l_i(0) = prod x_j / (x_j - x_i).
+		basis := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xj, xj^xi))
+			}
+		}
+		for pos := range secret {
+			secret[pos] ^= gfMul(payloads[i][pos], basis)
+		}
+	}
+	return secret
+}
+
+type secretShare struct {
+	kind, threshold, x byte
+	set                []byte
+	payload            []byte // secret || digest, evaluated at x
+}
+
+func (s secretShare) encode() []byte {
+	out := []byte{shareVersion, s.kind, s.threshold, s.x}
+	out = concatBytes(out, s.set, s.payload)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out))
+}
+
+func decodeSecretShare(text, format string) (secretShare, error) {
+	text = strings.Join(strings.Fields(text), "")
+	var raw []byte
+	var err error
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "auto":
+		if raw, err = hex.DecodeString(text); err != nil {
+			raw, err = base64.StdEncoding.DecodeString(text)
+		}
+	default:
+		raw, err = decodeBlob(text, format)
+	}
+	if err != nil {
+		return secretShare{}, errors.New("share is neither hex nor base64")
+	}
+	if len(raw) < shareHeaderLen+shareDigestLen+1+4 {
+		return secretShare{}, errors.New("share is too short")
+	}
+	body, sum := raw[:len(raw)-4], binary.BigEndian.Uint32(raw[len(raw)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return secretShare{}, errors.New("share checksum mismatch")
+	}
+	if body[0] != shareVersion {
+		return secretShare{}, fmt.Errorf("unsupported share version %d", body[0])
+	}
+	if body[3] == 0 || body[2] < 2 {
+		return secretShare{}, errors.New("invalid share header")
+	}
+	return secretShare{
+		kind:      body[1],
+		threshold: body[2],
+		x:         body[3],
+		set:       body[4:shareHeaderLen],
+		payload:   body[shareHeaderLen:],
+	}, nil
+}
+
+// SplitSecret splits a stored key or a symmetric key into Shamir shares.
+//
+// req: The SecretSplitRequest with the key, share count and threshold.
+// Returns the encoded shares; any Threshold of them rebuild the secret.
+func (c *CryptoService) SplitSecret(req SecretSplitRequest) (SecretSplitResult, error) {
+	if req.Threshold < 2 || req.Shares < req.Threshold || req.Shares > 255 {
+		return SecretSplitResult{}, errors.New("need 2 <= threshold <= shares <= 255")
+	}
+	var secret []byte
+	kind := byte(shareKindSecret)
+	if req.KeyID != "" {
+		key, err := c.findKey(req.KeyID)
+		if err != nil {
+			return SecretSplitResult{}, err
+		}
+		if key.Format == "pkcs11" {
+			return SecretSplitResult{}, errors.New("token keys cannot be exported for secret sharing")
+		}
+		if secret, err = json.Marshal(key); err != nil {
+			return SecretSplitResult{}, err
+		}
+		kind = shareKindKey
+	} else {
+		var err error
+		if secret, err = decodeData(req.SecretFormat, req.Secret); err != nil {
+			return SecretSplitResult{}, err
+		}
+		if len(secret) == 0 {
+			return SecretSplitResult{}, errors.New("missing key id or secret")
+		}
+	}
+
+	digest := sha256.Sum256(secret)
+	payloads, err := shamirSplit(concatBytes(secret, digest[:shareDigestLen]), req.Shares, req.Threshold)
+	if err != nil {
+		return SecretSplitResult{}, err
+	}
+	set := make([]byte, 4)
+	if _, err := rand.Read(set); err != nil {
+		return SecretSplitResult{}, err
+	}
+	outputFormat := normalizeOutputFormat(req.OutputFormat)
+	result := SecretSplitResult{Threshold: req.Threshold, SetID: strings.ToUpper(hex.EncodeToString(set)), KeyID: req.KeyID}
+	for i, payload := range payloads {
+		share := secretShare{kind: kind, threshold: byte(req.Threshold), x: byte(i + 1), set: set, payload: payload}
+		result.Shares = append(result.Shares, encodeOutputBytes(share.encode(), outputFormat))
+	}
+	return result, nil
+}
+
+// CombineSecret rebuilds a secret from Shamir shares and checks it against
+// the digest recombined with it. Stored keys can be re-imported into the store.
+//
+// req: The SecretCombineRequest with at least threshold shares.
+// Returns the secret (symmetric keys) or the recovered key.
+func (c *CryptoService) CombineSecret(req SecretCombineRequest) (SecretCombineResult, error) {
+	var shares []secretShare
+	seen := map[byte]bool{}
+	for i, text := range req.Shares {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		share, err := decodeSecretShare(text, req.Format)
+		if err != nil {
+			return SecretCombineResult{}, fmt.Errorf("share %d: %w", i+1, err)
+		}
+		if len(shares) > 0 {
+			first := shares[0]
+			if share.kind != first.kind || share.threshold != first.threshold || !bytes.Equal(share.set, first.set) ||
+				len(share.payload) != len(first.payload) {
+				return SecretCombineResult{}, fmt.Errorf("share %d belongs to a different split", i+1)
+			}
+		}
+		if seen[share.x] {
+			continue
+		}
+		seen[share.x] = true
+		shares = append(shares, share)
+	}
+	if len(shares) == 0 {
+		return SecretCombineResult{}, errors.New("missing shares")
+	}
+	threshold := int(shares[0].threshold)
+	if len(shares) < threshold {
+		return SecretCombineResult{}, fmt.Errorf("need %d shares, got %d", threshold, len(shares))
+	}
+	shares = shares[:threshold]
+	xs := make([]byte, threshold)
+	payloads := make([][]byte, threshold)
+	for i, share := range shares {
+		xs[i], payloads[i] = share.x, share.payload
+	}
+	combined := shamirCombine(xs, payloads)
+	secret, check := combined[:len(combined)-shareDigestLen], combined[len(combined)-shareDigestLen:]
+	digest := sha256.Sum256(secret)
+	if subtle.ConstantTimeCompare(digest[:shareDigestLen], check) != 1 {
+		return SecretCombineResult{}, errors.New("recombined secret does not match its digest")
+	}
+
+	result := SecretCombineResult{Verified: true, SharesUsed: threshold, SetID: strings.ToUpper(hex.EncodeToString(shares[0].set))}
+	switch shares[0].kind {
+	case shareKindSecret:
+		result.Kind = "secret"
+		result.Output = encodeOutputBytes(secret, normalizeOutputFormat(req.OutputFormat))
+		return result, nil
+	case shareKindKey:
+		result.Kind = "key"
+	default:
+		return SecretCombineResult{}, fmt.Errorf("unknown share kind %d", shares[0].kind)
+	}
+	var key StoredKey
+	if err := json.Unmarshal(secret, &key); err != nil {
+		return SecretCombineResult{}, fmt.Errorf("invalid key in shares: %w", err)
+	}
+	if req.Name != "" {
+		key.Name = req.Name
+	}
+	result.Key = &key
+	if !req.Save {
+		return result, nil
+	}
+	// Keep the original ID so certificates still point at the key, unless
+	// that ID is already taken.
+	for _, existing := range c.readKeys() {
+		if existing.ID == key.ID {
+			key.ID = uuid.New().String()
+			break
+		}
+	}
+	stored, err := c.saveKey(key)
+	if err != nil {
+		return result, err
+	}
+	result.Key, result.Stored = &stored, true
+	return result, nil
+}
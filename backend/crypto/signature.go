@@ -0,0 +1,217 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// ECDSA/SM2 signature encodings: ASN.1 DER, fixed-width r||s and the JWS
+// form, which is r||s in unpadded base64url.
+const (
+	sigEncodingDER  = "der"
+	sigEncodingRaw  = "raw"
+	sigEncodingJOSE = "jose"
+)
+
+// resolveSignatureCurve looks up a registered curve or SM2. Unlike
+// resolveECCurve it rejects unknown names instead of falling back to P-256.
+func resolveSignatureCurve(name string) (elliptic.Curve, string, error) {
+	switch normalizeCurveName(name) {
+	case "":
+		return elliptic.P256(), "NIST P-256", nil
+	case "sm2", "sm2p256v1", "sm2p256":
+		return sm2.P256(), "SM2", nil
+	}
+	info, ok := lookupCurveLabel(name)
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported curve: %s", name)
+	}
+	return info.Curve, info.Display, nil
+}
+
+func normalizeSigEncoding(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "auto":
+		return "", nil
+	case "der", "asn1", "asn.1":
+		return sigEncodingDER, nil
+	case "raw", "rs", "r||s", "p1363", "plain":
+		return sigEncodingRaw, nil
+	case "jose", "jws", "jwt":
+		return sigEncodingJOSE, nil
+	default:
+		return "", fmt.Errorf("unsupported signature encoding: %s", name)
+	}
+}
+
+// decodeSignatureText turns the signature text into bytes. With no format it
+// tries hex, then base64url without padding (JOSE), then standard base64.
+func decodeSignatureText(text, format string) ([]byte, bool, error) {
+	text = strings.TrimSpace(text)
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "auto":
+	case "base64url", "jose", "jws":
+		raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(text, "="))
+		return raw, true, err
+	default:
+		raw, err := decodeBlob(text, format)
+		return raw, false, err
+	}
+	if raw, err := hex.DecodeString(strings.Join(strings.Fields(strings.TrimPrefix(text, "0x")), "")); err == nil {
+		return raw, false, nil
+	}
+	if !strings.ContainsAny(text, "+/=") {
+		if raw, err := base64.RawURLEncoding.DecodeString(text); err == nil {
+			return raw, true, nil
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, false, errors.New("signature is neither hex nor base64")
+	}
+	return raw, false, nil
+}
+
+// parseECSignature reads r and s from DER or fixed-width r||s. When encoding
+// is empty the form is detected: input that parses as DER is DER, and input
+// of exactly twice the scalar size is r||s.
+func parseECSignature(sig []byte, curve elliptic.Curve, encoding string) (*big.Int, *big.Int, string, error) {
+	size := (curve.Params().N.BitLen() + 7) / 8
+	if encoding == "" || encoding == sigEncodingDER {
+		if r, s, err := parseDERSignature(sig); err == nil {
+			return r, s, sigEncodingDER, nil
+		} else if encoding == sigEncodingDER {
+			return nil, nil, "", err
+		}
+	}
+	if len(sig) != 2*size {
+		return nil, nil, "", fmt.Errorf("signature is neither DER nor %d-byte r||s", 2*size)
+	}
+	r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+	if encoding == "" {
+		encoding = sigEncodingRaw
+	}
+	return r, s, encoding, nil
+}
+
+func encodeRawSignature(r, s *big.Int, curve elliptic.Curve) []byte {
+	size := (curve.Params().N.BitLen() + 7) / 8
+	out := make([]byte, 2*size)
+	r.FillBytes(out[:size])
+	s.FillBytes(out[size:])
+	return out
+}
+
+// normalizeVerifySignature accepts r||s for verification by APIs that expect DER.
+func normalizeVerifySignature(sig []byte, curve elliptic.Curve) []byte {
+	r, s, encoding, err := parseECSignature(sig, curve, "")
+	if err != nil || encoding == sigEncodingDER {
+		return sig
+	}
+	der, err := marshalDERSignature(r, s)
+	if err != nil {
+		return sig
+	}
+	return der
+}
+
+// InspectSignature parses an ECDSA or SM2 signature, checks r and s against
+// the curve order and converts it between DER, raw r||s and JOSE.
+//
+// req: The SignatureInspectRequest with the signature, curve and target encoding.
+// Returns an OperationResult whose Output is the signature in the target
+// encoding and whose Details hold r, s, the checks and every encoding.
+// Verified reports whether r and s both lie in [1, n-1].
+func (c *CryptoService) InspectSignature(req SignatureInspectRequest) (OperationResult, error) {
+	curve, display, err := resolveSignatureCurve(req.Curve)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	encoding, err := normalizeSigEncoding(req.Encoding)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	target, err := normalizeSigEncoding(req.TargetEncoding)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	sig, jose, err := decodeSignatureText(req.Signature, req.Format)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	if jose && encoding == "" {
+		encoding = sigEncodingJOSE
+	}
+	parseAs := encoding
+	if parseAs == sigEncodingJOSE {
+		parseAs = sigEncodingRaw
+	}
+	r, s, detected, err := parseECSignature(sig, curve, parseAs)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	if encoding == sigEncodingJOSE {
+		detected = sigEncodingJOSE
+	}
+
+	n := curve.Params().N
+	if r.BitLen() > n.BitLen() || s.BitLen() > n.BitLen() {
+		return OperationResult{}, errors.New("signature values are larger than the curve order")
+	}
+	half := new(big.Int).Rsh(n, 1)
+	inRange := func(v *big.Int) bool { return v.Sign() > 0 && v.Cmp(n) < 0 }
+	lowS := s.Cmp(half) <= 0
+	details := map[string]string{
+		"curve":    display,
+		"encoding": detected,
+		"r":        strings.ToUpper(hex.EncodeToString(r.Bytes())),
+		"s":        strings.ToUpper(hex.EncodeToString(s.Bytes())),
+		"order":    strings.ToUpper(n.Text(16)),
+		"rInRange": fmt.Sprintf("%t", inRange(r)),
+		"sInRange": fmt.Sprintf("%t", inRange(s)),
+		"lowS":     fmt.Sprintf("%t", lowS),
+	}
+	if req.NormalizeLowS && !lowS && inRange(s) {
+		// (r, n-s) is the other valid ECDSA signature for the same message.
+		// SM2 does not have this symmetry, so its signatures are left alone.
+		if display == "SM2" {
+			return OperationResult{}, errors.New("low-S normalisation does not apply to SM2 signatures")
+		}
+		s = new(big.Int).Sub(n, s)
+		details["normalized"] = "true"
+		details["s"] = strings.ToUpper(hex.EncodeToString(s.Bytes()))
+	}
+
+	der, err := marshalDERSignature(r, s)
+	if err != nil {
+		return OperationResult{}, err
+	}
+	raw := encodeRawSignature(r, s, curve)
+	details["der"] = strings.ToUpper(hex.EncodeToString(der))
+	details["raw"] = strings.ToUpper(hex.EncodeToString(raw))
+	details["jose"] = base64.RawURLEncoding.EncodeToString(raw)
+	if display == "SM2" {
+		details["sdf"] = strings.ToUpper(hex.EncodeToString(encodeSDFSignature(r, s)))
+	}
+
+	if target == "" {
+		target = sigEncodingDER
+	}
+	result := OperationResult{Verified: inRange(r) && inRange(s), Details: details}
+	switch target {
+	case sigEncodingDER:
+		result.Output = encodeOutputBytes(der, normalizeOutputFormat(req.OutputFormat))
+	case sigEncodingRaw:
+		result.Output = encodeOutputBytes(raw, normalizeOutputFormat(req.OutputFormat))
+	default:
+		result.Output = details["jose"]
+	}
+	return result, nil
+}
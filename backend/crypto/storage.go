@@ -0,0 +1,269 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	storeKeys  = "keys"
+	storeCerts = "certs"
+	storeCA    = "ca"
+
+	storageJSON = "json"
+	storageBolt = "bolt"
+
+	boltStoreFile    = "crypto_store.db"
+	boltBucket       = "stores"
+	boltLockTimeout  = 5 * time.Second
+	storeTempPattern = ".ctools-*.tmp"
+)
+
+var storeNames = []string{storeKeys, storeCerts, storeCA}
+
+// storeBackend persists the key, certificate and CA stores as whole documents.
+// Update must run fn and write its result as one atomic read-modify-write.
+type storeBackend interface {
+	Name() string
+	Path() string
+	Load(store string) ([]byte, error) // nil when the store does not exist yet
+	Update(store string, fn func(current []byte) ([]byte, error)) error
+}
+
+// jsonFileBackend keeps each store in its own JSON file in the data directory.
+type jsonFileBackend struct {
+	dir string
+	mu  *sync.Mutex
+}
+
+func (b jsonFileBackend) Name() string { return storageJSON }
+
+func (b jsonFileBackend) Path() string { return b.dir }
+
+func (b jsonFileBackend) file(store string) (string, os.FileMode) {
+	switch store {
+	case storeKeys:
+		return filepath.Join(b.dir, keyStoreFile), 0600
+	case storeCerts:
+		return filepath.Join(b.dir, certStoreFile), 0644
+	default:
+		return filepath.Join(b.dir, caStoreFile), 0644
+	}
+}
+
+func (b jsonFileBackend) Load(store string) ([]byte, error) {
+	path, _ := b.file(store)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (b jsonFileBackend) Update(store string, fn func([]byte) ([]byte, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current, err := b.Load(store)
+	if err != nil {
+		return err
+	}
+	next, err := fn(current)
+	if err != nil {
+		return err
+	}
+	path, perm := b.file(store)
+	return writeFileAtomic(path, next, perm)
+}
+
+// remove deletes the file of a store that has moved to another backend.
+func (b jsonFileBackend) remove(store string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	path, _ := b.file(store)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path via a synced temporary file and rename, so
+// readers never observe a half-written store.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), storeTempPattern)
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	defer os.Remove(name)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(name, perm); err != nil {
+		return err
+	}
+	return os.Rename(name, path)
+}
+
+// boltBackend keeps all stores in one bbolt database file. The database is
+// opened per operation: bbolt holds an exclusive file lock while open, so
+// several ctools instances take turns instead of overwriting each other, and
+// every Update is a single durable transaction.
+type boltBackend struct {
+	path string
+}
+
+func (b boltBackend) Name() string { return storageBolt }
+
+func (b boltBackend) Path() string { return b.path }
+
+func (b boltBackend) open() (*bolt.DB, error) {
+	db, err := bolt.Open(b.path, 0600, &bolt.Options{Timeout: boltLockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open store database: %w", err)
+	}
+	return db, nil
+}
+
+func (b boltBackend) Load(store string) ([]byte, error) {
+	if _, err := os.Stat(b.path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	db, err := b.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var data []byte
+	err = db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(boltBucket)); bucket != nil {
+			if value := bucket.Get([]byte(store)); value != nil {
+				data = append([]byte(nil), value...)
+			}
+		}
+		return nil
+	})
+	return data, err
+}
+
+func (b boltBackend) Update(store string, fn func([]byte) ([]byte, error)) error {
+	db, err := b.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(boltBucket))
+		if err != nil {
+			return err
+		}
+		var current []byte
+		if value := bucket.Get([]byte(store)); value != nil {
+			current = append([]byte(nil), value...)
+		}
+		next, err := fn(current)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(store), next)
+	})
+}
+
+// storage returns the backend for the current data directory. The database
+// backend is used once its file exists; otherwise the JSON files are used.
+func (c *CryptoService) storage() storeBackend {
+	dir := c.ensureDataDir()
+	dbPath := filepath.Join(dir, boltStoreFile)
+	if _, err := os.Stat(dbPath); err == nil {
+		return boltBackend{path: dbPath}
+	}
+	return jsonFileBackend{dir: dir, mu: &c.fileMu}
+}
+
+// GetStorageBackend reports which storage backend holds keys and certificates.
+//
+// Returns a StorageStatus with the backend name and location.
+func (c *CryptoService) GetStorageBackend() StorageStatus {
+	backend := c.storage()
+	return StorageStatus{Backend: backend.Name(), Path: backend.Path()}
+}
+
+// SetStorageBackend switches between the JSON files and the embedded database,
+// copying the key, certificate and CA stores into the new backend. The old
+// backend's files are deleted once the new one holds their contents, so no
+// second copy of the key store is left behind in either direction.
+//
+// req: The StorageBackendRequest naming the target backend.
+// Returns the new StorageStatus or an error.
+func (c *CryptoService) SetStorageBackend(req StorageBackendRequest) (StorageStatus, error) {
+	target := strings.ToLower(strings.TrimSpace(req.Backend))
+	switch target {
+	case storageJSON, "file":
+		target = storageJSON
+	case storageBolt, "bbolt", "db", "database":
+		target = storageBolt
+	default:
+		return StorageStatus{}, fmt.Errorf("unsupported storage backend: %s", req.Backend)
+	}
+	current := c.storage()
+	if current.Name() == target {
+		return c.GetStorageBackend(), nil
+	}
+
+	dir := c.ensureDataDir()
+	var next storeBackend = jsonFileBackend{dir: dir, mu: &c.fileMu}
+	if target == storageBolt {
+		next = boltBackend{path: filepath.Join(dir, boltStoreFile)}
+	}
+	for _, store := range storeNames {
+		data, err := current.Load(store)
+		if err != nil {
+			return StorageStatus{}, err
+		}
+		if data == nil {
+			continue
+		}
+		if err := next.Update(store, func([]byte) ([]byte, error) { return data, nil }); err != nil {
+			return StorageStatus{}, err
+		}
+	}
+	if target == storageBolt {
+		// Make sure the database file exists even for an empty toolbox.
+		if err := next.Update(storeKeys, func(cur []byte) ([]byte, error) {
+			if cur == nil {
+				return []byte("[]"), nil
+			}
+			return cur, nil
+		}); err != nil {
+			return StorageStatus{}, err
+		}
+		if files, ok := current.(jsonFileBackend); ok {
+			for _, store := range storeNames {
+				if err := files.remove(store); err != nil {
+					return StorageStatus{}, err
+				}
+			}
+		}
+	} else {
+		// The JSON files were synced by writeFileAtomic; removing the database
+		// also selects them as the backend.
+		if err := os.Remove(current.Path()); err != nil {
+			return StorageStatus{}, err
+		}
+	}
+	return c.GetStorageBackend(), nil
+}
@@ -1,14 +1,11 @@
 package crypto
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
-	"crypto/des"
 	"errors"
 	"fmt"
 	"strings"
 
-	"github.com/emmansun/gmsm/sm4"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
@@ -42,67 +39,16 @@ func (c *CryptoService) RunSymmetric(req SymmetricRequest) (OperationResult, err
 	padding := strings.ToLower(req.Padding)
 
 	switch algo {
-	case "aes":
-		return runAESCipher(op, mode, padding, key, input, req)
-	case "sm4":
-		return runSM4Cipher(op, mode, padding, key, input, req)
-	case "3des", "des3", "triple-des":
-		return runTripleDESCipher(op, mode, padding, key, input, req)
 	case "chacha20", "cha20", "chacha20-poly1305":
 		return runChaChaCipher(op, key, input, req)
-	default:
-		return OperationResult{}, fmt.Errorf("unsupported symmetric algorithm: %s", req.Algorithm)
-	}
-}
-
-func runAESCipher(op, mode, padding string, key, input []byte, req SymmetricRequest) (OperationResult, error) {
-	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
-		return OperationResult{}, errors.New("AES key must be 16/24/32 bytes")
-	}
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return OperationResult{}, err
-	}
-	switch mode {
-	case "gcm":
-		return runBlockGCM(op, block, input, req)
-	case "ctr":
-		return runStreamBlock(op, cipher.NewCTR, block, input, req)
-	case "ecb":
-		return runECB(op, block, input, padding, req)
-	default: // default to CBC
-		return runCBC(op, block, input, padding, req)
-	}
-}
-
-func runSM4Cipher(op, mode, padding string, key, input []byte, req SymmetricRequest) (OperationResult, error) {
-	if len(key) != 16 {
-		return OperationResult{}, errors.New("SM4 key must be 16 bytes")
-	}
-	block, err := sm4.NewCipher(key)
-	if err != nil {
-		return OperationResult{}, err
-	}
-	switch mode {
-	case "ctr":
-		return runStreamBlock(op, cipher.NewCTR, block, input, req)
-	default:
-		return runCBC(op, block, input, padding, req)
-	}
-}
-
-func runTripleDESCipher(op, mode, padding string, key, input []byte, req SymmetricRequest) (OperationResult, error) {
-	if len(key) != 24 {
-		return OperationResult{}, errors.New("3DES key must be 24 bytes")
+	case "zuc", "zuc-128", "zuc-256", "zuc128", "zuc256", "eea3", "128-eea3":
+		return runZUCCipher(op, algo, key, input, req)
 	}
-	block, err := des.NewTripleDESCipher(key)
-	if err != nil {
-		return OperationResult{}, err
-	}
-	if mode == "ctr" {
-		return runStreamBlock(op, cipher.NewCTR, block, input, req)
+	spec, ok := lookupBlockCipher(algo)
+	if !ok {
+		return OperationResult{}, fmt.Errorf("unsupported symmetric algorithm: %s", req.Algorithm)
 	}
-	return runCBC(op, block, input, padding, req)
+	return runBlockMode(op, mode, padding, spec, key, input, req)
 }
 
 func runChaChaCipher(op string, key, input []byte, req SymmetricRequest) (OperationResult, error) {
@@ -148,44 +94,6 @@ func runChaChaCipher(op string, key, input []byte, req SymmetricRequest) (Operat
 	}
 }
 
-func runBlockGCM(op string, block cipher.Block, input []byte, req SymmetricRequest) (OperationResult, error) {
-	aead, err := cipher.NewGCM(block)
-	if err != nil {
-		return OperationResult{}, err
-	}
-	nonce, err := decodeBlob(req.Nonce, req.NonceFormat)
-	if err != nil {
-		return OperationResult{}, fmt.Errorf("invalid nonce: %w", err)
-	}
-	if len(nonce) != aead.NonceSize() {
-		return OperationResult{}, fmt.Errorf("nonce must be %d bytes", aead.NonceSize())
-	}
-	ad, err := decodeBlob(req.Additional, req.AdditionalFormat)
-	if err != nil {
-		return OperationResult{}, fmt.Errorf("invalid additional data: %w", err)
-	}
-	if op == "encrypt" {
-		out := aead.Seal(nil, nonce, input, ad)
-		return OperationResult{
-			Output: encodeOutputBytes(out, req.OutputFormat),
-			Details: map[string]string{
-				"base64": encodeBase64(out),
-			},
-		}, nil
-	}
-	plain, err := aead.Open(nil, nonce, input, ad)
-	if err != nil {
-		return OperationResult{}, err
-	}
-	return OperationResult{
-		Output: encodeOutputBytes(plain, req.OutputFormat),
-		Details: map[string]string{
-			"text":   string(plain),
-			"base64": encodeBase64(plain),
-		},
-	}, nil
-}
-
 func runStreamBlock(op string, mode func(cipher.Block, []byte) cipher.Stream, block cipher.Block, input []byte, req SymmetricRequest) (OperationResult, error) {
 	iv, err := decodeBlob(req.IV, req.IVFormat)
 	if err != nil {
@@ -344,6 +252,10 @@ func bytesRepeat(b byte, count int) []byte {
 }
 
 func runCMACOperation(algo string, key, input []byte, req SymmetricRequest) (OperationResult, error) {
+	switch algo {
+	case "zuc", "zuc-128", "zuc-256", "zuc128", "zuc256", "eia3", "128-eia3":
+		return runZUCMAC(algo, key, input, req)
+	}
 	block, err := resolveBlockCipher(algo, key)
 	if err != nil {
 		return OperationResult{}, err
@@ -382,25 +294,11 @@ func runDiversifyOperation(algo string, blockSize int, key, diversifier []byte,
 }
 
 func resolveBlockCipher(algo string, key []byte) (cipher.Block, error) {
-	switch strings.ToLower(algo) {
-	case "aes":
-		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
-			return nil, errors.New("AES key must be 16/24/32 bytes")
-		}
-		return aes.NewCipher(key)
-	case "sm4":
-		if len(key) != 16 {
-			return nil, errors.New("SM4 key must be 16 bytes")
-		}
-		return sm4.NewCipher(key)
-	case "3des", "des3", "triple-des":
-		if len(key) != 24 {
-			return nil, errors.New("3DES key must be 24 bytes")
-		}
-		return des.NewTripleDESCipher(key)
-	default:
+	spec, ok := lookupBlockCipher(algo)
+	if !ok {
 		return nil, fmt.Errorf("unsupported block cipher for %s", algo)
 	}
+	return spec.newBlock(key)
 }
 
 func computeCMAC(block cipher.Block, msg []byte) ([]byte, error) {
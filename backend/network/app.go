@@ -1,15 +1,22 @@
 package network
 
-import "context"
+import (
+	"context"
+	"ctools/backend/crypto"
+)
 
 // NetworkService handles network-related operations such as pinging, HTTP requests, and SSH connections.
 type NetworkService struct {
-	ctx context.Context
+	ctx    context.Context
+	crypto *crypto.CryptoService
 }
 
 // NewNetworkService initializes a new NetworkService instance.
-func NewNetworkService() *NetworkService {
-	return &NetworkService{}
+// cryptoSvc provides stored SSH keys for the server manager and may be nil.
+func NewNetworkService(cryptoSvc *crypto.CryptoService) *NetworkService {
+	return &NetworkService{
+		crypto: cryptoSvc,
+	}
 }
 
 // SetContext sets the application context.
@@ -1,7 +1,12 @@
 package network
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"ctools/backend/crypto"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -9,6 +14,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestSendHttpRequestHandlesDefaultsHeadersAndErrors(t *testing.T) {
@@ -24,7 +31,7 @@ func TestSendHttpRequestHandlesDefaultsHeadersAndErrors(t *testing.T) {
 	}))
 	defer server.Close()
 
-	result := NewNetworkService().SendHttpRequest(RequestOption{
+	result := NewNetworkService(nil).SendHttpRequest(RequestOption{
 		URL:     server.URL,
 		Headers: map[string]string{"X-Test": "ok"},
 	})
@@ -38,17 +45,17 @@ func TestSendHttpRequestHandlesDefaultsHeadersAndErrors(t *testing.T) {
 		t.Fatalf("expected response header to be preserved")
 	}
 
-	if got := NewNetworkService().SendHttpRequest(RequestOption{}); got.Error == "" {
+	if got := NewNetworkService(nil).SendHttpRequest(RequestOption{}); got.Error == "" {
 		t.Fatalf("expected missing URL error")
 	}
-	if got := NewNetworkService().SendHttpRequest(RequestOption{URL: "example.com", Protocol: "ws"}); got.Error == "" {
+	if got := NewNetworkService(nil).SendHttpRequest(RequestOption{URL: "example.com", Protocol: "ws"}); got.Error == "" {
 		t.Fatalf("expected unsupported protocol error")
 	}
 }
 
 func TestRequestCollectionsPersistence(t *testing.T) {
 	t.Setenv("CTOOLS_CONFIG_DIR", t.TempDir())
-	service := NewNetworkService()
+	service := NewNetworkService(nil)
 
 	created := service.SaveReqCollection(CollectionItem{
 		Name: " first ",
@@ -78,7 +85,7 @@ func TestRequestCollectionsPersistence(t *testing.T) {
 
 func TestPingHistoryPersistence(t *testing.T) {
 	t.Setenv("CTOOLS_CONFIG_DIR", t.TempDir())
-	service := NewNetworkService()
+	service := NewNetworkService(nil)
 
 	for i := 0; i < 55; i++ {
 		service.AddPingHistory(fmt.Sprintf("192.168.%d", i))
@@ -106,7 +113,7 @@ func TestPingHistoryPersistence(t *testing.T) {
 
 func TestServerPersistenceAndValidation(t *testing.T) {
 	t.Setenv("CTOOLS_CONFIG_DIR", t.TempDir())
-	service := NewNetworkService()
+	service := NewNetworkService(nil)
 
 	list := service.SaveServer(ServerConfig{
 		Name: " box ",
@@ -137,7 +144,7 @@ func TestServerPersistenceAndValidation(t *testing.T) {
 }
 
 func TestNetworkToolsDNSPortsTCPAndPrometheus(t *testing.T) {
-	service := NewNetworkService()
+	service := NewNetworkService(nil)
 
 	dns := service.LookupDNS(DNSLookupRequest{Host: "localhost"})
 	if dns.Host != "localhost" || len(dns.Addresses) == 0 {
@@ -198,3 +205,89 @@ func TestNetworkToolsDNSPortsTCPAndPrometheus(t *testing.T) {
 		t.Fatalf("unexpected prometheus result: %+v", prom)
 	}
 }
+
+func TestCheckServerStatusWithStoredKey(t *testing.T) {
+	t.Setenv("CTOOLS_CONFIG_DIR", t.TempDir())
+	cryptoService := crypto.NewCryptoService()
+	allowed, err := cryptoService.GenerateKeyPair(crypto.KeyGenRequest{Algorithm: "ed25519", Save: true})
+	if err != nil {
+		t.Fatalf("generate allowed key: %v", err)
+	}
+	other, err := cryptoService.GenerateKeyPair(crypto.KeyGenRequest{Algorithm: "ed25519", Save: true})
+	if err != nil {
+		t.Fatalf("generate other key: %v", err)
+	}
+	allowedSigner, err := ssh.ParsePrivateKey([]byte(allowed.PrivatePEM))
+	if err != nil {
+		t.Fatalf("parse allowed key: %v", err)
+	}
+
+	// An SSH server that only accepts the allowed key and refuses sessions,
+	// so the status commands come back empty.
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate host key: %v", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("host signer: %v", err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "deploy" && bytes.Equal(key.Marshal(), allowedSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown public key")
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					_ = ch.Reject(ssh.Prohibited, "no sessions")
+				}
+			}()
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("split listener address: %v", err)
+	}
+
+	service := NewNetworkService(cryptoService)
+	config := ServerConfig{ID: "srv", Host: host, Port: port, User: "deploy", AuthType: "stored-key", KeyID: allowed.Key.ID}
+	if status := service.CheckServerStatus(config); !status.IsOnline || status.Error != "" {
+		t.Fatalf("expected stored key to authenticate: %+v", status)
+	}
+
+	config.KeyID = other.Key.ID
+	if status := service.CheckServerStatus(config); status.IsOnline || !strings.Contains(status.Error, "Connection failed") {
+		t.Fatalf("expected a key the server does not know to be refused: %+v", status)
+	}
+
+	config.KeyID = "missing"
+	if status := service.CheckServerStatus(config); status.IsOnline || !strings.Contains(status.Error, "Failed to load stored key") {
+		t.Fatalf("expected unknown stored key ID to be rejected: %+v", status)
+	}
+
+	config.KeyID = allowed.Key.ID
+	if status := NewNetworkService(nil).CheckServerStatus(config); status.IsOnline || !strings.Contains(status.Error, "key store is not available") {
+		t.Fatalf("expected stored key auth without a key store to fail: %+v", status)
+	}
+}
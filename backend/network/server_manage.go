@@ -3,6 +3,7 @@ package network
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -21,9 +22,10 @@ type ServerConfig struct {
 	Host     string `json:"host"`
 	Port     string `json:"port"`
 	User     string `json:"user"`
-	AuthType string `json:"authType"` // "password" or "key"
+	AuthType string `json:"authType"` // "password", "key" or "stored-key"
 	Password string `json:"password"`
 	KeyPath  string `json:"keyPath"`
+	KeyID    string `json:"keyId"` // crypto key store ID used by "stored-key"
 }
 
 // ServerStatus contains real-time status information of a server.
@@ -123,6 +125,24 @@ func (n *NetworkService) saveServerFile(list []ServerConfig) {
 
 // --- SSH Core Logic ---
 
+// storedKeySigner builds an SSH signer from a private key in the crypto key store.
+func (n *NetworkService) storedKeySigner(id string) (ssh.Signer, error) {
+	if n.crypto == nil {
+		return nil, errors.New("key store is not available")
+	}
+	if strings.TrimSpace(id) == "" {
+		return nil, errors.New("no stored key selected")
+	}
+	key, err := n.crypto.ExportStoredKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if key.PrivatePEM == "" {
+		return nil, errors.New("stored key has no private component")
+	}
+	return ssh.ParsePrivateKey([]byte(key.PrivatePEM))
+}
+
 // CheckServerStatus connects to the server via SSH and retrieves detailed status information.
 //
 // config: The ServerConfig containing connection details.
@@ -154,6 +174,13 @@ func (n *NetworkService) CheckServerStatus(config ServerConfig) ServerStatus {
 			return status
 		}
 		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	} else if config.AuthType == "stored-key" {
+		signer, err := n.storedKeySigner(config.KeyID)
+		if err != nil {
+			status.Error = fmt.Sprintf("Failed to load stored key: %v", err)
+			return status
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
 	} else {
 		authMethods = append(authMethods, ssh.Password(config.Password))
 	}
@@ -7,11 +7,16 @@ toolchain go1.24.10
 require (
 	gitee.com/Trisia/gotlcp v1.4.3
 	github.com/ProtonMail/go-crypto v1.1.5
+	github.com/cloudflare/circl v1.6.1
 	github.com/emmansun/gmsm v0.40.0
 	github.com/go-ping/ping v1.2.0
 	github.com/google/uuid v1.6.0
+	github.com/miekg/pkcs11 v1.1.2
+	github.com/tyler-smith/go-bip39 v1.1.0
 	github.com/wailsapp/wails/v2 v2.12.0
+	go.etcd.io/bbolt v1.4.3
 	golang.org/x/crypto v0.43.0
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (
@@ -21,6 +26,7 @@ require (
 	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/jchv/go-winloader v0.0.0-20210711035445-715c2860da7e // indirect
+	github.com/klauspost/cpuid/v2 v2.2.10 // indirect
 	github.com/labstack/echo/v4 v4.13.3 // indirect
 	github.com/labstack/gommon v0.4.2 // indirect
 	github.com/leaanthony/go-ansi-parser v1.6.1 // indirect
@@ -19,8 +19,8 @@ var assets embed.FS
 // It initializes services, creates the application instance, and starts the Wails runtime.
 func main() {
 	// Create an instance of the app structure
-	netService := network.NewNetworkService()
 	cryptoService := crypto.NewCryptoService()
+	netService := network.NewNetworkService(cryptoService)
 	otherService := other.NewOtherService(cryptoService)
 	app := NewApp(netService, cryptoService, otherService)
 